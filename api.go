@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/proxycore"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+const API_UNIX_SOCKET_PREFIX = "unix:"
+
+// API_EVENTS_BUFFER_SIZE is the amount of events buffered for every events
+// stream client. When a client falls behind by more than that, events are
+// dropped for it instead of blocking the proxy.
+const API_EVENTS_BUFFER_SIZE = 64
+
+// ListenAPI listens on the given address, which is either a TCP address
+// (host:port) or a unix socket path prefixed with API_UNIX_SOCKET_PREFIX.
+func ListenAPI(address string) (net.Listener, error) {
+	if path, isUnix := strings.CutPrefix(address, API_UNIX_SOCKET_PREFIX); isUnix {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", address)
+}
+
+// removeStaleSocket removes the unix socket at path if it was left over by a
+// previous run, refusing to remove anything else, or a socket on which
+// something still listens.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s already exists and isn't a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// APIServer exposes the proxy over HTTP, allowing to drive it remotely.
+type APIServer struct {
+	proxy *proxycore.Proxy
+	mux   *http.ServeMux
+}
+
+func NewAPIServer(proxy *proxycore.Proxy) *APIServer {
+	s := &APIServer{
+		proxy: proxy,
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /connections", s.handleListConnections)
+	s.mux.HandleFunc("POST /connections/{id}/inject", s.handleInject)
+	s.mux.HandleFunc("GET /messages", s.handleListMessages)
+	s.mux.HandleFunc("GET /messages/{id}", s.handleGetMessage)
+	s.mux.HandleFunc("GET /messages/{id}/content", s.handleGetMessageContent)
+	s.mux.HandleFunc("PUT /messages/{id}/content", s.handleSetMessageContent)
+	s.mux.HandleFunc("POST /messages/{id}/transmit", s.handleTransmit)
+	s.mux.HandleFunc("POST /messages/{id}/drop", s.handleDrop)
+	s.mux.HandleFunc("GET /auto-transmit", s.handleGetAutoTransmit)
+	s.mux.HandleFunc("PUT /auto-transmit", s.handleSetAutoTransmit)
+	s.mux.HandleFunc("GET /events", s.handleEvents)
+
+	return s
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve serves the API on the listener until it's closed.
+func (s *APIServer) Serve(listener net.Listener) error {
+	return http.Serve(listener, s)
+}
+
+type apiConnection struct {
+	ID         int       `json:"id"`
+	Time       time.Time `json:"time"`
+	ClientAddr string    `json:"client_addr"`
+	ServerAddr string    `json:"server_addr"`
+}
+
+func newAPIConnection(connection *proxycore.Connection) apiConnection {
+	return apiConnection{
+		ID:         connection.ID(),
+		Time:       connection.Time(),
+		ClientAddr: connection.ClientAddr().String(),
+		ServerAddr: connection.ServerAddr().String(),
+	}
+}
+
+type apiMessage struct {
+	ID           int                              `json:"id"`
+	ConnectionID int                              `json:"connection_id"`
+	Time         time.Time                        `json:"time"`
+	Direction    tcpmessage.TransmittionDirection `json:"direction"`
+	Status       string                           `json:"status"`
+	Edited       bool                             `json:"edited"`
+	Length       int                              `json:"length"`
+	Protocol     string                           `json:"protocol,omitempty"`
+}
+
+func newAPIMessage(id int, message *tcpmessage.TCPMessage) apiMessage {
+	return apiMessage{
+		ID:           id,
+		ConnectionID: message.ConnectionID(),
+		Time:         message.Time(),
+		Direction:    message.Direction(),
+		Status:       string(Must(message.Status().MarshalText())),
+		Edited:       message.Edited(),
+		Length:       len(message.Content()),
+		Protocol:     ProtocolName(message),
+	}
+}
+
+type apiAutoTransmit struct {
+	AutoTransmit bool `json:"auto_transmit"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("API response write failed: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{err.Error()})
+}
+
+func pathID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", r.PathValue("id"))
+	}
+
+	return id, nil
+}
+
+// pathMessage returns the message whose ID is in the request path, writing
+// an error response if there's no such message.
+func (s *APIServer) pathMessage(w http.ResponseWriter, r *http.Request) (int, *tcpmessage.TCPMessage, bool) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return 0, nil, false
+	}
+
+	message, err := s.proxy.Message(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return 0, nil, false
+	}
+
+	return id, message, true
+}
+
+func (s *APIServer) handleListConnections(w http.ResponseWriter, r *http.Request) {
+	connections := []apiConnection{}
+	for _, connection := range s.proxy.Connections() {
+		connections = append(connections, newAPIConnection(connection))
+	}
+
+	writeJSON(w, http.StatusOK, connections)
+}
+
+// handleInject sends the request body on the connection. The direction is
+// given by the direction query parameter, either to_server or to_client.
+func (s *APIServer) handleInject(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	connection, err := s.proxy.Connection(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	var direction tcpmessage.TransmittionDirection
+	switch r.URL.Query().Get("direction") {
+	case "to_server":
+		direction = tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER
+	case "to_client":
+		direction = tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("direction must be either to_server or to_client"))
+		return
+	}
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	messageID, err := s.proxy.Inject(connection, direction, content)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMessage(messageID, Must(s.proxy.Message(messageID))))
+}
+
+func (s *APIServer) handleListMessages(w http.ResponseWriter, r *http.Request) {
+	messages := []apiMessage{}
+	for id, message := range s.proxy.Messages() {
+		messages = append(messages, newAPIMessage(id, message))
+	}
+
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *APIServer) handleGetMessage(w http.ResponseWriter, r *http.Request) {
+	id, message, ok := s.pathMessage(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMessage(id, message))
+}
+
+func (s *APIServer) handleGetMessageContent(w http.ResponseWriter, r *http.Request) {
+	_, message, ok := s.pathMessage(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(message.Content())
+}
+
+func (s *APIServer) handleSetMessageContent(w http.ResponseWriter, r *http.Request) {
+	id, message, ok := s.pathMessage(w, r)
+	if !ok {
+		return
+	}
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := message.SetContent(content); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMessage(id, message))
+}
+
+func (s *APIServer) handleTransmit(w http.ResponseWriter, r *http.Request) {
+	id, message, ok := s.pathMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if err := message.Transmit(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMessage(id, message))
+}
+
+func (s *APIServer) handleDrop(w http.ResponseWriter, r *http.Request) {
+	id, message, ok := s.pathMessage(w, r)
+	if !ok {
+		return
+	}
+
+	if err := message.Drop(); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMessage(id, message))
+}
+
+func (s *APIServer) handleGetAutoTransmit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiAutoTransmit{s.proxy.AutoTransmit()})
+}
+
+func (s *APIServer) handleSetAutoTransmit(w http.ResponseWriter, r *http.Request) {
+	var autoTransmit apiAutoTransmit
+	if err := json.NewDecoder(r.Body).Decode(&autoTransmit); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.proxy.SetAutoTransmit(autoTransmit.AutoTransmit)
+
+	writeJSON(w, http.StatusOK, autoTransmit)
+}
+
+// handleEvents streams every new message as a server-sent event.
+func (s *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	events := make(chan apiMessage, API_EVENTS_BUFFER_SIZE)
+	unsubscribe := s.proxy.Subscribe(func(id int, message *tcpmessage.TCPMessage) {
+		select {
+		case events <- newAPIMessage(id, message):
+		default:
+			log.Printf("API events client is too slow, dropping message %d", id)
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("API event marshal failed: %v", err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
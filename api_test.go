@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/proxycore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startAPI starts a proxy to an echo server, and an API server for it.
+func startAPI(t *testing.T) (*proxycore.Proxy, *httptest.Server) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	proxy := proxycore.New(proxycore.Config{
+		ListenAddress: "127.0.0.1:0",
+		ServerAddress: server.Addr().String(),
+	})
+	require.NoError(t, proxy.Start())
+	t.Cleanup(func() { proxy.Stop() })
+
+	api := httptest.NewServer(NewAPIServer(proxy))
+	t.Cleanup(api.Close)
+
+	return proxy, api
+}
+
+// apiRequest sends a request to the API, returning the status and the body of
+// the response.
+func apiRequest(t *testing.T, api *httptest.Server, method, path, body string) (int, string) {
+	request, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	response, err := api.Client().Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	return response.StatusCode, string(responseBody)
+}
+
+func TestAPIEditAndTransmit(t *testing.T) {
+	proxy, api := startAPI(t)
+
+	client, err := net.Dial("tcp", proxy.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(proxy.Messages()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	status, body := apiRequest(t, api, "GET", "/messages/0/content", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hello", body)
+
+	status, body = apiRequest(t, api, "PUT", "/messages/0/content", "HELLO")
+	require.Equal(t, http.StatusOK, status)
+
+	var message struct {
+		Status string `json:"status"`
+		Edited bool   `json:"edited"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &message))
+	assert.True(t, message.Edited)
+	assert.Equal(t, "pending", message.Status)
+
+	// The echoed message is transmitted automatically
+	status, _ = apiRequest(t, api, "PUT", "/auto-transmit", `{"auto_transmit": true}`)
+	require.Equal(t, http.StatusOK, status)
+
+	status, body = apiRequest(t, api, "POST", "/messages/0/transmit", "")
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal([]byte(body), &message))
+	assert.Equal(t, "transmitted", message.Status)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	echoed := make([]byte, 5)
+	_, err = io.ReadFull(client, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(echoed))
+
+	status, _ = apiRequest(t, api, "PUT", "/messages/0/content", "hello")
+	assert.Equal(t, http.StatusConflict, status)
+	status, _ = apiRequest(t, api, "POST", "/messages/0/drop", "")
+	assert.Equal(t, http.StatusConflict, status)
+}
+
+func TestAPIErrors(t *testing.T) {
+	_, api := startAPI(t)
+
+	status, body := apiRequest(t, api, "GET", "/messages/abc", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, `"error"`)
+
+	status, _ = apiRequest(t, api, "GET", "/messages/3", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = apiRequest(t, api, "POST", "/connections/0/inject?direction=to_server", "hello")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = apiRequest(t, api, "PUT", "/auto-transmit", "yes")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, body = apiRequest(t, api, "GET", "/messages", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, "[]", body)
+}
+
+func TestListenAPIUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+
+	listener, err := ListenAPI(API_UNIX_SOCKET_PREFIX + path)
+	require.NoError(t, err)
+
+	_, err = ListenAPI(API_UNIX_SOCKET_PREFIX + path)
+	assert.Error(t, err, "A socket which is still listened on is removed")
+
+	// Leave the socket behind, as a crashed run would
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	listener, err = ListenAPI(API_UNIX_SOCKET_PREFIX + path)
+	require.NoError(t, err)
+	listener.Close()
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(filePath, []byte("data"), 0o600))
+
+	_, err = ListenAPI(API_UNIX_SOCKET_PREFIX + filePath)
+	assert.Error(t, err)
+	assert.FileExists(t, filePath)
+}
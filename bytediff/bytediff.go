@@ -0,0 +1,170 @@
+// Package bytediff computes byte-level differences between two buffers.
+package bytediff
+
+type OpKind int
+
+const (
+	OP_EQUAL OpKind = iota
+	OP_INSERT
+	OP_DELETE
+)
+
+// Op is a run of bytes which are either equal in both buffers, inserted to
+// the new buffer or deleted from the old one.
+type Op struct {
+	Kind OpKind
+	Data []byte
+}
+
+// MAX_EDIT_DISTANCE bounds the work done by Diff. When the buffers differ by
+// more edits than that, the differing middle of the buffers is reported as
+// deleted and then inserted as a whole.
+const MAX_EDIT_DISTANCE = 1024
+
+// Diff returns the ops transforming a into b, using the Myers algorithm.
+func Diff(a, b []byte) []Op {
+	prefix := commonPrefixLength(a, b)
+	suffix := commonSuffixLength(a[prefix:], b[prefix:])
+
+	var ops []Op
+	ops = appendOp(ops, OP_EQUAL, a[:prefix])
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	ops = appendOp(ops, OP_EQUAL, a[len(a)-suffix:])
+
+	return ops
+}
+
+func commonPrefixLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
+
+func commonSuffixLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[len(a)-1-i] == b[len(b)-1-i] {
+		i++
+	}
+
+	return i
+}
+
+// appendOp appends the op to ops, merging it with the last op if they are of
+// the same kind.
+func appendOp(ops []Op, kind OpKind, data []byte) []Op {
+	if len(data) == 0 {
+		return ops
+	}
+
+	if len(ops) > 0 && ops[len(ops)-1].Kind == kind {
+		last := &ops[len(ops)-1]
+		last.Data = append(last.Data[:len(last.Data):len(last.Data)], data...)
+		return ops
+	}
+
+	return append(ops, Op{kind, data})
+}
+
+func myers(a, b []byte) []Op {
+	n, m := len(a), len(b)
+	maxDistance := min(n+m, MAX_EDIT_DISTANCE)
+
+	// trace[d] holds the furthest x reached on every diagonal k (at index
+	// k+d) after d edits.
+	var trace [][]int
+	previous := []int{0} // Diagonal 1 of the virtual step before the first
+
+	for d := 0; d <= maxDistance; d++ {
+		current := make([]int, 2*d+1)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && previousX(previous, d, k-1) < previousX(previous, d, k+1)) {
+				x = previousX(previous, d, k+1) // Move down, inserting b[y]
+			} else {
+				x = previousX(previous, d, k-1) + 1 // Move right, deleting a[x]
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			current[k+d] = x
+
+			if x >= n && y >= m {
+				trace = append(trace, current)
+				return backtrack(trace, a, b)
+			}
+		}
+
+		trace = append(trace, current)
+		previous = current
+	}
+
+	var ops []Op
+	ops = appendOp(ops, OP_DELETE, a)
+	ops = appendOp(ops, OP_INSERT, b)
+
+	return ops
+}
+
+// previousX returns the furthest x on diagonal k after d-1 edits, given the
+// trace entry of d-1 edits.
+func previousX(previous []int, d, k int) int {
+	if d == 0 {
+		return 0
+	}
+
+	return previous[k+d-1]
+}
+
+func backtrack(trace [][]int, a, b []byte) []Op {
+	var reversed []Op
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d > 0; d-- {
+		previous := trace[d-1]
+		k := x - y
+
+		var previousK int
+		if k == -d || (k != d && previous[k-1+d-1] < previous[k+1+d-1]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+
+		previousXValue := previous[previousK+d-1]
+		previousYValue := previousXValue - previousK
+
+		// The snake of equal bytes after the edit
+		snakeBeginX := previousXValue
+		if previousK == k-1 {
+			snakeBeginX++
+		}
+		snakeLength := x - snakeBeginX
+		reversed = append(reversed, Op{OP_EQUAL, a[x-snakeLength : x]})
+
+		if previousK == k+1 {
+			reversed = append(reversed, Op{OP_INSERT, b[previousYValue : previousYValue+1]})
+		} else {
+			reversed = append(reversed, Op{OP_DELETE, a[previousXValue : previousXValue+1]})
+		}
+
+		x, y = previousXValue, previousYValue
+	}
+
+	// The snake of the first step
+	reversed = append(reversed, Op{OP_EQUAL, a[:x]})
+
+	var ops []Op
+	for i := len(reversed) - 1; i >= 0; i-- {
+		ops = appendOp(ops, reversed[i].Kind, reversed[i].Data)
+	}
+
+	return ops
+}
@@ -0,0 +1,86 @@
+package bytediff
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// apply applies ops, returning the old and the new buffers.
+func apply(ops []Op) (a, b []byte) {
+	for _, op := range ops {
+		switch op.Kind {
+		case OP_EQUAL:
+			a = append(a, op.Data...)
+			b = append(b, op.Data...)
+		case OP_DELETE:
+			a = append(a, op.Data...)
+		case OP_INSERT:
+			b = append(b, op.Data...)
+		}
+	}
+
+	return a, b
+}
+
+func editCount(ops []Op) int {
+	count := 0
+	for _, op := range ops {
+		if op.Kind != OP_EQUAL {
+			count += len(op.Data)
+		}
+	}
+
+	return count
+}
+
+func TestDiff(t *testing.T) {
+	ops := Diff([]byte("hello world"), []byte("hello, brave world"))
+	assert.Equal(t, []Op{
+		{OP_EQUAL, []byte("hello")},
+		{OP_INSERT, []byte(", brave")},
+		{OP_EQUAL, []byte(" world")},
+	}, ops)
+
+	ops = Diff([]byte("abcabba"), []byte("cbabac"))
+	assert.Equal(t, 5, editCount(ops))
+
+	assert.Empty(t, Diff(nil, nil))
+}
+
+func TestDiffRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		a := make([]byte, random.Intn(50))
+		random.Read(a)
+		for j := range a {
+			a[j] %= 4
+		}
+
+		b := append([]byte(nil), a...)
+		for j := 0; j < random.Intn(10); j++ {
+			position := random.Intn(len(b) + 1)
+			b = append(b[:position], append([]byte{byte(random.Intn(4))}, b[position:]...)...)
+		}
+
+		ops := Diff(a, b)
+		oldBuffer, newBuffer := apply(ops)
+		assert.Equal(t, string(a), string(oldBuffer))
+		assert.Equal(t, string(b), string(newBuffer))
+		assert.Equal(t, len(b)-len(a), editCount(ops), "only insertions were made")
+	}
+}
+
+func TestDiffTooDistant(t *testing.T) {
+	a := make([]byte, 2*MAX_EDIT_DISTANCE)
+	b := make([]byte, 2*MAX_EDIT_DISTANCE)
+	for i := range b {
+		b[i] = 1
+	}
+
+	oldBuffer, newBuffer := apply(Diff(a, b))
+	assert.Equal(t, a, oldBuffer)
+	assert.Equal(t, b, newBuffer)
+}
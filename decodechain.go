@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/transform"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// DecodeChainMsg sets the decode chain by which the payloads of messages are
+// viewed and edited.
+type DecodeChainMsg struct {
+	chain transform.Chain
+}
+
+func CreateDecodeChainCmd(chain transform.Chain) tea.Cmd {
+	return func() tea.Msg {
+		return DecodeChainMsg{chain}
+	}
+}
+
+type editDecodeChainInEditorMsg struct {
+	text       []byte
+	editedText []byte
+	err        error
+}
+
+// editDecodeChainInEditor opens text, a decode chain, in $EDITOR.
+func editDecodeChainInEditor(text []byte) (tea.Cmd, error) {
+	return editBufferInEditor(text, ".txt", func(editedText []byte, err error) tea.Msg {
+		return editDecodeChainInEditorMsg{text, editedText, err}
+	})
+}
+
+// StartEditDecodeChain opens chain in $EDITOR.
+func StartEditDecodeChain(chain transform.Chain) tea.Cmd {
+	header := EDIT_COMMENT_PREFIX + " The steps by which payloads are decoded, in order, e.g. base64,gzip. Empty to show them as is.\n" +
+		EDIT_COMMENT_PREFIX + " Steps: " + strings.Join(transform.Names(), ", ") + "\n"
+
+	cmd, err := editDecodeChainInEditor([]byte(header + chain.String() + "\n"))
+	if err != nil {
+		log.Printf("Edit in editor error: %s\n", err)
+	}
+
+	return cmd
+}
+
+// FinishEditDecodeChain sets the edited decode chain. If it can't be parsed,
+// the editor is reopened with the error.
+func FinishEditDecodeChain(msg editDecodeChainInEditorMsg) tea.Cmd {
+	if msg.err != nil {
+		log.Printf("error during decode chain editing: %v\n", msg.err)
+		return nil
+	}
+
+	if IsEditCanceled(msg.text, msg.editedText) {
+		log.Println("Decode chain edit canceled")
+		return nil
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(msg.editedText), "\n") {
+		if !strings.HasPrefix(line, EDIT_COMMENT_PREFIX) {
+			lines = append(lines, line)
+		}
+	}
+
+	chain, err := transform.ParseChain(strings.Join(lines, "\n"))
+	if err != nil {
+		log.Printf("Failed to parse the decode chain: %v\n", err)
+
+		cmd, err := editDecodeChainInEditor(AddEditError(msg.editedText, err))
+		if err != nil {
+			log.Printf("Edit in editor error: %s\n", err)
+		}
+
+		return cmd
+	}
+
+	return CreateDecodeChainCmd(chain)
+}
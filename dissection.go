@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/styles"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/Denloob/protocol-proxy/transform"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// DISSECTION_INDENT is the indentation of every level of the field tree.
+const DISSECTION_INDENT = "  "
+
+// SelectFieldMsg moves the selected field of the field tree of the dissected
+// display method, or of the template field table, by the given delta.
+type SelectFieldMsg int
+
+func CreateSelectFieldCmd(delta int) tea.Cmd {
+	return func() tea.Msg {
+		return SelectFieldMsg(delta)
+	}
+}
+
+// EditFieldMsg opens the selected field of the field tree of the dissected
+// display method, or of the template field table, in $EDITOR.
+type EditFieldMsg struct{}
+
+func EditFieldCmd() tea.Msg {
+	return EditFieldMsg{}
+}
+
+// RenderDissection renders the fields of the message as a tree, wrapping the
+// field values to the given width. The field at selected, in the order of
+// the tree, is highlighted.
+func RenderDissection(message *tcpmessage.TCPMessage, width int, selected int) string {
+	text, _ := renderDissection(message, width, selected)
+	return text
+}
+
+// renderDissection renders the dissection of the message (see
+// RenderDissection), returning the line of the selected field.
+func renderDissection(message *tcpmessage.TCPMessage, width int, selected int) (string, int) {
+	dissection, err := dissector.Dissect(message)
+	if err != nil {
+		return fmt.Sprintf("Failed to dissect the message: %v", err), 0
+	}
+	if dissection == nil {
+		return "The protocol of the message is unknown", 0
+	}
+
+	lines := []string{styles.FieldName.Render(message.Protocol().Name()+": ") + styles.FieldValue.Render(dissection.Summary)}
+	rows := flattenTemplateFields(nil, dissection.Fields, 0)
+	selected = Clamp(selected, 0, max(len(rows)-1, 0))
+
+	selectedLine := 0
+	for i, row := range rows {
+		if i == selected {
+			selectedLine = len(lines)
+		}
+		lines = append(lines, fieldLines(row.field, strings.Repeat(DISSECTION_INDENT, row.depth+1), width, i == selected)...)
+	}
+
+	return strings.Join(lines, "\n"), selectedLine
+}
+
+// appendFieldLines appends the lines of field and its children, indented by
+// indent, to lines.
+func appendFieldLines(lines []string, field *dissector.Field, indent string, width int) []string {
+	lines = append(lines, fieldLines(field, indent, width, false)...)
+	for _, child := range field.Children {
+		lines = appendFieldLines(lines, child, indent+DISSECTION_INDENT, width)
+	}
+
+	return lines
+}
+
+// fieldLines returns the lines of the name and value of field, without its
+// children, indented by indent. The first line of a selected field is
+// highlighted.
+func fieldLines(field *dissector.Field, indent string, width int, selected bool) []string {
+	name := field.Name
+	if field.Value != "" {
+		name += ": "
+	}
+
+	// Values are wrapped before they're styled, as the styles break the wrap
+	valueLines := strings.Split(field.Value, "\n")
+	valueIndent := indent + strings.Repeat(" ", len(name))
+
+	var wrappedValueLines []string
+	for i, valueLine := range valueLines {
+		lineIndent := valueIndent
+		if i == 0 {
+			lineIndent = indent + name
+		}
+		wrappedValueLines = append(wrappedValueLines, WrapLine(valueLine, max(width-len(lineIndent), 1))...)
+	}
+
+	first := indent + styles.FieldName.Render(name) + styles.FieldValue.Render(wrappedValueLines[0])
+	if selected {
+		first = indent + styles.Cursor.Render(name+wrappedValueLines[0])
+	}
+
+	lines := []string{first}
+	for _, valueLine := range wrappedValueLines[1:] {
+		lines = append(lines, valueIndent+styles.FieldValue.Render(valueLine))
+	}
+
+	return lines
+}
+
+// DissectionFieldCount returns the number of fields of the field tree of the
+// message.
+func DissectionFieldCount(message *tcpmessage.TCPMessage) int {
+	dissection, err := dissector.Dissect(message)
+	if err != nil || dissection == nil {
+		return 0
+	}
+
+	return len(flattenTemplateFields(nil, dissection.Fields, 0))
+}
+
+// DissectionFieldRange returns the offsets of the bytes of the field at index
+// of the field tree of the message, and whether it has bytes.
+func DissectionFieldRange(message *tcpmessage.TCPMessage, index int) (int, int, bool) {
+	dissection, err := dissector.Dissect(message)
+	if err != nil || dissection == nil {
+		return 0, 0, false
+	}
+
+	rows := flattenTemplateFields(nil, dissection.Fields, 0)
+	if index < 0 || index >= len(rows) || rows[index].field.Length == 0 {
+		return 0, 0, false
+	}
+
+	return rows[index].field.Offset, rows[index].field.Offset + rows[index].field.Length, true
+}
+
+type editDissectionFieldInEditorMsg struct {
+	message *tcpmessage.TCPMessage
+	index   int
+	// integer is set if the field is edited as an integer, see
+	// dissector.FieldType.
+	integer    bool
+	text       []byte
+	editedText []byte
+	err        error
+}
+
+// editDissectionFieldInEditor opens text, the field at index of the
+// dissection of the message, in $EDITOR.
+func editDissectionFieldInEditor(message *tcpmessage.TCPMessage, index int, integer bool, text []byte) (tea.Cmd, error) {
+	fileSuffix := EDIT_FORMAT_ESCAPED.FileSuffix()
+	if integer {
+		fileSuffix = ".txt"
+	}
+
+	return editBufferInEditor(text, fileSuffix, func(editedText []byte, err error) tea.Msg {
+		return editDissectionFieldInEditorMsg{message, index, integer, text, editedText, err}
+	})
+}
+
+// dissectionFieldText returns the text by which field, whose bytes are value,
+// is edited: integers by their value (see dissector.FieldType.Text), and
+// other fields by their bytes, as quoted strings.
+func dissectionFieldText(field *dissector.Field, value []byte) (string, error) {
+	if field.Type == nil {
+		return EDIT_COMMENT_PREFIX + " The bytes of " + field.Name + "\n" + string(EncodeForEdit(value, EDIT_FORMAT_ESCAPED)), nil
+	}
+
+	integerText, err := field.Type.Text(value)
+	if err != nil {
+		return "", err
+	}
+
+	header := EDIT_COMMENT_PREFIX + " The value of " + field.Name
+	if names := field.Type.EnumNames(); len(names) > 0 {
+		header += ", a number or one of: " + strings.Join(names, ", ")
+	}
+
+	return header + "\n" + integerText + "\n", nil
+}
+
+// uncommentedText returns the edited text without the lines beginning with
+// EDIT_COMMENT_PREFIX.
+func uncommentedText(editedText []byte) string {
+	var lines []string
+	for _, line := range strings.Split(string(editedText), "\n") {
+		if !strings.HasPrefix(line, EDIT_COMMENT_PREFIX) {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// StartEditDissectionField opens the field at index of the dissection of the
+// message in $EDITOR: integers as numbers (or by their enum names), and other
+// fields by their bytes, as quoted strings.
+func StartEditDissectionField(message *tcpmessage.TCPMessage, index int) tea.Cmd {
+	if message.Status() != tcpmessage.STATUS_PENDING {
+		log.Println("The message can no longer be edited.")
+		return nil
+	}
+
+	decoder, ok := message.Protocol().(dissector.Decoder)
+	if !ok {
+		log.Println("The protocol of the message is unknown")
+		return nil
+	}
+
+	content := message.Content()
+	field, err := dissector.EditedField(decoder, content, index)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	text, err := dissectionFieldText(field, content[field.Offset:field.Offset+field.Length])
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	cmd, err := editDissectionFieldInEditor(message, index, field.Type != nil, []byte(text))
+	if err != nil {
+		log.Printf("Edit in editor error: %s\n", err)
+	}
+
+	return cmd
+}
+
+// FinishEditDissectionField sets the edited field of the message, re-encoding
+// the message if the field is in its payload. If the field can't be set, the
+// editor is reopened with the error.
+func FinishEditDissectionField(msg editDissectionFieldInEditorMsg) tea.Cmd {
+	if msg.err != nil {
+		log.Printf("error during field editing: %v\n", msg.err)
+		return nil
+	}
+
+	if IsEditCanceled(msg.text, msg.editedText) {
+		log.Println("Field edit canceled")
+		return nil
+	}
+
+	decoder, ok := msg.message.Protocol().(dissector.Decoder)
+	if !ok {
+		log.Println("The protocol of the message is unknown")
+		return nil
+	}
+
+	var content []byte
+	var err error
+	if msg.integer {
+		content, err = dissector.SetFieldText(decoder, msg.message.Content(), msg.index, uncommentedText(msg.editedText))
+	} else {
+		var value []byte
+		value, err = DecodeEdited(msg.editedText, EDIT_FORMAT_ESCAPED)
+		if err == nil {
+			content, err = dissector.SetFieldBytes(decoder, msg.message.Content(), msg.index, value)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to set the field: %v\n", err)
+
+		cmd, err := editDissectionFieldInEditor(msg.message, msg.index, msg.integer, AddEditError(msg.editedText, err))
+		if err != nil {
+			log.Printf("Edit in editor error: %s\n", err)
+		}
+
+		return cmd
+	}
+
+	if err := msg.message.SetContent(content); err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
+
+// MessagePayload returns the payload of the message if its protocol has
+// payloads, and its whole content otherwise.
+func MessagePayload(message *tcpmessage.TCPMessage) []byte {
+	return contentPayload(message, message.Content())
+}
+
+// contentPayload returns the payload of content, a content of the message, if
+// its protocol has payloads, and the whole content otherwise.
+func contentPayload(message *tcpmessage.TCPMessage, content []byte) []byte {
+	if editor, ok := message.Protocol().(dissector.PayloadEditor); ok {
+		if payload, err := editor.Payload(content); err == nil {
+			return payload
+		}
+	}
+
+	return content
+}
+
+// DecodedPayload returns the payload of the message (see MessagePayload)
+// decoded by chain.
+func DecodedPayload(message *tcpmessage.TCPMessage, chain transform.Chain) ([]byte, error) {
+	payload, err := chain.Decode(MessagePayload(message))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the payload by the decode chain: %w", err)
+	}
+
+	return payload, nil
+}
+
+// EditableContent returns the part of the message which is edited, which is
+// its payload if its protocol is a dissector.PayloadEditor, and its whole
+// content otherwise. With a decode chain, the payload decoded by it is edited.
+func EditableContent(message *tcpmessage.TCPMessage, chain transform.Chain) ([]byte, error) {
+	if len(chain) > 0 {
+		return DecodedPayload(message, chain)
+	}
+
+	editor, ok := message.Protocol().(dissector.PayloadEditor)
+	if !ok {
+		return message.Content(), nil
+	}
+
+	payload, err := editor.Payload(message.Content())
+	if err != nil {
+		log.Printf("Failed to get the payload, editing the whole message: %v", err)
+		return message.Content(), nil
+	}
+
+	return payload, nil
+}
+
+// SetEditedContent sets the content of the message from the edited content
+// returned by EditableContent, re-encoding it (by chain in reverse, and by the
+// protocol) if needed.
+func SetEditedContent(message *tcpmessage.TCPMessage, chain transform.Chain, edited []byte) error {
+	edited, err := chain.Encode(edited)
+	if err != nil {
+		return fmt.Errorf("failed to re-encode by the decode chain: %w", err)
+	}
+
+	editor, ok := message.Protocol().(dissector.PayloadEditor)
+	if !ok {
+		return message.SetContent(edited)
+	}
+
+	if _, err := editor.Payload(message.Content()); err != nil { // The whole message was edited
+		return message.SetContent(edited)
+	}
+
+	content, err := editor.SetPayload(message.Content(), edited)
+	if err != nil {
+		return fmt.Errorf("failed to re-encode the message: %w", err)
+	}
+
+	return message.SetContent(content)
+}
+
+// ProtocolName returns the name of the protocol of the message, or an empty
+// string if it's unknown.
+func ProtocolName(message *tcpmessage.TCPMessage) string {
+	if message.Protocol() == nil {
+		return ""
+	}
+
+	return message.Protocol().Name()
+}
+
+type summaryCacheEntry struct {
+	content []byte
+	summary string
+}
+
+// SummaryCache caches the dissection summaries of messages, as dissecting
+// every listed message on every render is too slow.
+type SummaryCache map[*tcpmessage.TCPMessage]summaryCacheEntry
+
+// Summary returns the dissection summary of the message, or an empty string if
+// it can't be dissected.
+func (cache SummaryCache) Summary(message *tcpmessage.TCPMessage) string {
+	content := message.Content()
+	if entry, ok := cache[message]; ok && bytes.Equal(entry.content, content) {
+		return entry.summary
+	}
+
+	var summary string
+	if dissection, err := dissector.Dissect(message); err == nil && dissection != nil {
+		summary = dissection.Summary
+	}
+
+	cache[message] = summaryCacheEntry{content, summary}
+	return summary
+}
+
+// MessageStream identifies a stream of a connection which multiplexes
+// streams, such as an HTTP/2 stream.
+type MessageStream struct {
+	connectionID int
+	stream       uint32
+}
+
+// StreamOf returns the stream of the message, if its protocol multiplexes
+// streams.
+func StreamOf(message *tcpmessage.TCPMessage) (MessageStream, bool) {
+	decoder, ok := message.Protocol().(dissector.StreamDecoder)
+	if !ok {
+		return MessageStream{}, false
+	}
+
+	stream, err := decoder.Stream(message.Content())
+	if err != nil {
+		return MessageStream{}, false
+	}
+
+	return MessageStream{message.ConnectionID(), stream}, true
+}
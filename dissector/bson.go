@@ -0,0 +1,297 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	BSON_TYPE_DOUBLE      = 0x01
+	BSON_TYPE_STRING      = 0x02
+	BSON_TYPE_DOCUMENT    = 0x03
+	BSON_TYPE_ARRAY       = 0x04
+	BSON_TYPE_BINARY      = 0x05
+	BSON_TYPE_UNDEFINED   = 0x06
+	BSON_TYPE_OBJECT_ID   = 0x07
+	BSON_TYPE_BOOLEAN     = 0x08
+	BSON_TYPE_DATE_TIME   = 0x09
+	BSON_TYPE_NULL        = 0x0a
+	BSON_TYPE_REGEX       = 0x0b
+	BSON_TYPE_DB_POINTER  = 0x0c
+	BSON_TYPE_JAVASCRIPT  = 0x0d
+	BSON_TYPE_SYMBOL      = 0x0e
+	BSON_TYPE_CODE_SCOPE  = 0x0f
+	BSON_TYPE_INT32       = 0x10
+	BSON_TYPE_TIMESTAMP   = 0x11
+	BSON_TYPE_INT64       = 0x12
+	BSON_TYPE_DECIMAL128  = 0x13
+	BSON_TYPE_MIN_KEY     = 0xff
+	BSON_TYPE_MAX_KEY     = 0x7f
+	BSON_OBJECT_ID_LENGTH = 12
+)
+
+// BSON_MIN_DOCUMENT_LENGTH is the length of an empty document.
+const BSON_MIN_DOCUMENT_LENGTH = 5
+
+// readBSONInt32 reads the little endian int32 at offset.
+func readBSONInt32(data []byte, offset int) (int, error) {
+	bytes, err := readStructured(data, offset, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(int32(binary.LittleEndian.Uint32(bytes))), nil
+}
+
+// readBSONCString reads the null terminated string at offset, returning it
+// along with the offset after it.
+func readBSONCString(data []byte, offset int) (string, int, error) {
+	end := bytes.IndexByte(data[offset:], 0)
+	if end == -1 {
+		return "", 0, structuredTruncated(len(data))
+	}
+
+	return string(data[offset : offset+end]), offset + end + 1, nil
+}
+
+// readBSONString reads the length prefixed and null terminated string at
+// offset, returning it along with the offset after it.
+func readBSONString(data []byte, offset int) (string, int, error) {
+	length, err := readBSONInt32(data, offset)
+	if err != nil {
+		return "", 0, err
+	}
+	if length < 1 {
+		return "", 0, structuredError(offset, "invalid BSON string length %d", length)
+	}
+
+	content, err := readStructured(data, offset+4, uint64(length))
+	if err != nil {
+		return "", 0, err
+	}
+	if content[length-1] != 0 {
+		return "", 0, structuredError(offset+4+length-1, "BSON string isn't null terminated")
+	}
+
+	return string(content[:length-1]), offset + 4 + length, nil
+}
+
+// decodeBSONDocument decodes the BSON document at offset.
+func decodeBSONDocument(data []byte, offset int, depth int) (*StructuredValue, int, error) {
+	return decodeBSONContainer(data, offset, STRUCTURED_KIND_MAP, depth)
+}
+
+// decodeBSONContainer decodes the document at offset, as a map or an array.
+func decodeBSONContainer(data []byte, offset int, kind StructuredKind, depth int) (*StructuredValue, int, error) {
+	if depth >= STRUCTURED_MAX_DEPTH {
+		return nil, 0, structuredError(offset, "too deeply nested")
+	}
+
+	length, err := readBSONInt32(data, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if length < BSON_MIN_DOCUMENT_LENGTH || length > len(data)-offset {
+		return nil, 0, structuredError(offset, "invalid BSON document length %d", length)
+	}
+
+	begin := offset
+	end := offset + length
+	document := &StructuredValue{Kind: kind, Offset: begin, Length: length}
+
+	// The elements are decoded within the document, so their lengths can't
+	// exceed it
+	content := data[:end-1]
+	offset += 4
+	for offset < len(content) {
+		elementType := content[offset]
+		name, next, err := readBSONCString(content, offset+1)
+		if err != nil {
+			return document, 0, err
+		}
+
+		value, next, err := decodeBSONValue(content, offset, next, elementType, depth)
+		if value != nil {
+			if kind == STRUCTURED_KIND_MAP {
+				document.Keys = append(document.Keys, strconv.Quote(name))
+			}
+			document.Elements = append(document.Elements, value)
+		}
+		if err != nil {
+			return document, 0, err
+		}
+
+		offset = next
+	}
+
+	if data[end-1] != 0 {
+		return document, 0, structuredError(end-1, "BSON document isn't null terminated")
+	}
+
+	return document, end, nil
+}
+
+// decodeBSONValue decodes the value of the element at begin, of the given
+// type, which is at offset.
+func decodeBSONValue(data []byte, begin, offset int, elementType byte, depth int) (*StructuredValue, int, error) {
+	scalar := func(text string, end int) (*StructuredValue, int, error) {
+		return structuredScalar(text, begin, end), end, nil
+	}
+	tagged := func(tag, text string, end int) (*StructuredValue, int, error) {
+		value := structuredScalar(text, begin, end)
+		value.Tag = tag
+		return value, end, nil
+	}
+	fixed := func(length uint64) ([]byte, error) {
+		return readStructured(data, offset, length)
+	}
+
+	switch elementType {
+	case BSON_TYPE_DOUBLE:
+		bytes, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredFloat(math.Float64frombits(binary.LittleEndian.Uint64(bytes))), offset+8)
+	case BSON_TYPE_STRING, BSON_TYPE_JAVASCRIPT, BSON_TYPE_SYMBOL:
+		text, end, err := readBSONString(data, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch elementType {
+		case BSON_TYPE_JAVASCRIPT:
+			return tagged("Code", strconv.Quote(text), end)
+		case BSON_TYPE_SYMBOL:
+			return tagged("Symbol", strconv.Quote(text), end)
+		}
+		return scalar(strconv.Quote(text), end)
+	case BSON_TYPE_DOCUMENT, BSON_TYPE_ARRAY:
+		kind := STRUCTURED_KIND_MAP
+		if elementType == BSON_TYPE_ARRAY {
+			kind = STRUCTURED_KIND_ARRAY
+		}
+
+		value, end, err := decodeBSONContainer(data, offset, kind, depth+1)
+		if value != nil {
+			value.Offset, value.Length = begin, value.Offset+value.Length-begin
+		}
+		return value, end, err
+	case BSON_TYPE_BINARY:
+		length, err := readBSONInt32(data, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if length < 0 {
+			return nil, 0, structuredError(offset, "invalid BSON binary length %d", length)
+		}
+
+		bytes, err := readStructured(data, offset+4, uint64(length)+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return tagged(fmt.Sprintf("Binary %d", bytes[0]), formatStructuredBytes(bytes[1:]), offset+4+len(bytes))
+	case BSON_TYPE_UNDEFINED:
+		return scalar("undefined", offset)
+	case BSON_TYPE_OBJECT_ID:
+		bytes, err := fixed(BSON_OBJECT_ID_LENGTH)
+		if err != nil {
+			return nil, 0, err
+		}
+		return tagged("ObjectId", fmt.Sprintf("%q", fmt.Sprintf("%x", bytes)), offset+BSON_OBJECT_ID_LENGTH)
+	case BSON_TYPE_BOOLEAN:
+		bytes, err := fixed(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(strconv.FormatBool(bytes[0] != 0), offset+1)
+	case BSON_TYPE_DATE_TIME:
+		bytes, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		date := time.UnixMilli(int64(binary.LittleEndian.Uint64(bytes))).UTC()
+		return tagged("Date", strconv.Quote(date.Format(time.RFC3339Nano)), offset+8)
+	case BSON_TYPE_NULL:
+		return scalar("null", offset)
+	case BSON_TYPE_REGEX:
+		pattern, next, err := readBSONCString(data, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		options, end, err := readBSONCString(data, next)
+		if err != nil {
+			return nil, 0, err
+		}
+		return tagged("Regex", "/"+pattern+"/"+options, end)
+	case BSON_TYPE_DB_POINTER:
+		namespace, next, err := readBSONString(data, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		id, err := readStructured(data, next, BSON_OBJECT_ID_LENGTH)
+		if err != nil {
+			return nil, 0, err
+		}
+		return tagged("DBPointer", fmt.Sprintf("%q, %x", namespace, id), next+BSON_OBJECT_ID_LENGTH)
+	case BSON_TYPE_CODE_SCOPE:
+		length, err := readBSONInt32(data, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		code, next, err := readBSONString(data, offset+4)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		scope, end, err := decodeBSONDocument(data, next, depth+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		if end != offset+length {
+			return nil, 0, structuredError(offset, "invalid BSON code with scope length %d", length)
+		}
+		return tagged("Code", fmt.Sprintf("%q with a scope of %d", code, len(scope.Elements)), end)
+	case BSON_TYPE_INT32:
+		value, err := readBSONInt32(data, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(strconv.Itoa(value), offset+4)
+	case BSON_TYPE_TIMESTAMP:
+		bytes, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		increment := binary.LittleEndian.Uint32(bytes)
+		seconds := binary.LittleEndian.Uint32(bytes[4:])
+		return tagged("Timestamp", fmt.Sprintf("%d, %d", seconds, increment), offset+8)
+	case BSON_TYPE_INT64:
+		bytes, err := fixed(8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(strconv.FormatInt(int64(binary.LittleEndian.Uint64(bytes)), 10), offset+8)
+	case BSON_TYPE_DECIMAL128:
+		bytes, err := fixed(16)
+		if err != nil {
+			return nil, 0, err
+		}
+		return tagged("Decimal128", formatStructuredBytes(bytes), offset+16)
+	case BSON_TYPE_MIN_KEY:
+		return scalar("MinKey", offset)
+	case BSON_TYPE_MAX_KEY:
+		return scalar("MaxKey", offset)
+	}
+
+	return nil, 0, structuredError(begin, "invalid BSON type 0x%02x", elementType)
+}
@@ -0,0 +1,288 @@
+package dissector
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+const (
+	CBOR_MAJOR_UNSIGNED = 0
+	CBOR_MAJOR_NEGATIVE = 1
+	CBOR_MAJOR_BYTES    = 2
+	CBOR_MAJOR_TEXT     = 3
+	CBOR_MAJOR_ARRAY    = 4
+	CBOR_MAJOR_MAP      = 5
+	CBOR_MAJOR_TAG      = 6
+	CBOR_MAJOR_SIMPLE   = 7
+)
+
+// CBOR_INDEFINITE is the additional information of indefinite length items.
+const CBOR_INDEFINITE = 31
+
+// CBOR_BREAK ends indefinite length items.
+const CBOR_BREAK = 0xff
+
+// CBOR_TAG_EPOCH_TIME tags times in seconds since the epoch.
+const CBOR_TAG_EPOCH_TIME = 1
+
+// readCBORArgument reads the argument of the item whose additional
+// information is info, at offset.
+func readCBORArgument(data []byte, offset int, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), offset, nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		value, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return 0, 0, err
+		}
+		return value, offset + size, nil
+	}
+
+	return 0, 0, structuredError(offset-1, "invalid CBOR additional information %d", info)
+}
+
+// halfFloat converts an IEEE 754 half precision float to a float64.
+func halfFloat(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1f
+	mantissa := float64(bits & 0x3ff)
+
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 0x1f:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+
+	if bits&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
+
+// decodeCBOR decodes the CBOR item at offset.
+func decodeCBOR(data []byte, offset int, depth int) (*StructuredValue, int, error) {
+	if offset >= len(data) {
+		return nil, 0, structuredTruncated(offset)
+	}
+
+	begin := offset
+	major := data[offset] >> 5
+	info := data[offset] & 0x1f
+	offset++
+
+	if info == CBOR_INDEFINITE {
+		switch major {
+		case CBOR_MAJOR_BYTES, CBOR_MAJOR_TEXT:
+			return decodeCBORChunks(data, begin, offset, major)
+		case CBOR_MAJOR_ARRAY, CBOR_MAJOR_MAP:
+			return decodeCBORContainer(data, begin, offset, major, 0, true, depth)
+		case CBOR_MAJOR_SIMPLE:
+			return nil, 0, structuredError(begin, "unexpected CBOR break")
+		}
+		return nil, 0, structuredError(begin, "invalid indefinite length CBOR major type %d", major)
+	}
+
+	if major == CBOR_MAJOR_SIMPLE {
+		return decodeCBORSimple(data, begin, offset, info)
+	}
+
+	argument, offset, err := readCBORArgument(data, offset, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case CBOR_MAJOR_UNSIGNED:
+		return structuredScalar(strconv.FormatUint(argument, 10), begin, offset), offset, nil
+	case CBOR_MAJOR_NEGATIVE:
+		// The value is -1 - argument, which may not fit in an int64
+		value := new(big.Int).SetUint64(argument)
+		value.Neg(value.Add(value, big.NewInt(1)))
+		return structuredScalar(value.String(), begin, offset), offset, nil
+	case CBOR_MAJOR_BYTES, CBOR_MAJOR_TEXT:
+		bytes, err := readStructured(data, offset, argument)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		end := offset + len(bytes)
+		text := formatStructuredBytes(bytes)
+		if major == CBOR_MAJOR_TEXT {
+			text = strconv.Quote(string(bytes))
+		}
+		return structuredScalar(text, begin, end), end, nil
+	case CBOR_MAJOR_ARRAY, CBOR_MAJOR_MAP:
+		return decodeCBORContainer(data, begin, offset, major, argument, false, depth)
+	}
+
+	// CBOR_MAJOR_TAG
+	if depth >= STRUCTURED_MAX_DEPTH {
+		return nil, 0, structuredError(begin, "too deeply nested")
+	}
+
+	value, end, err := decodeCBOR(data, offset, depth+1)
+	if value == nil {
+		return nil, 0, err
+	}
+
+	value.Tag = strconv.FormatUint(argument, 10)
+	value.Offset, value.Length = begin, value.Offset+value.Length-begin
+	if err == nil && value.Kind == STRUCTURED_KIND_SCALAR && argument == CBOR_TAG_EPOCH_TIME {
+		if seconds, parseErr := strconv.ParseFloat(value.Text, 64); parseErr == nil {
+			value.Text = strconv.Quote(time.Unix(0, int64(seconds*float64(time.Second))).UTC().Format(time.RFC3339Nano))
+		}
+	}
+
+	return value, end, err
+}
+
+func decodeCBORSimple(data []byte, begin, offset int, info byte) (*StructuredValue, int, error) {
+	scalar := func(text string, end int) (*StructuredValue, int, error) {
+		return structuredScalar(text, begin, end), end, nil
+	}
+
+	switch info {
+	case 20:
+		return scalar("false", offset)
+	case 21:
+		return scalar("true", offset)
+	case 22:
+		return scalar("null", offset)
+	case 23:
+		return scalar("undefined", offset)
+	case 24:
+		if offset >= len(data) {
+			return nil, 0, structuredTruncated(offset)
+		}
+		return scalar("simple("+strconv.Itoa(int(data[offset]))+")", offset+1)
+	case 25:
+		bits, err := readStructuredUint(data, offset, 2)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredFloat(halfFloat(uint16(bits))), offset+2)
+	case 26:
+		bits, err := readStructuredUint(data, offset, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredFloat(float64(math.Float32frombits(uint32(bits)))), offset+4)
+	case 27:
+		bits, err := readStructuredUint(data, offset, 8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredFloat(math.Float64frombits(bits)), offset+8)
+	}
+
+	if info < 20 {
+		return scalar("simple("+strconv.Itoa(int(info))+")", offset)
+	}
+	return nil, 0, structuredError(begin, "invalid CBOR simple value %d", info)
+}
+
+// decodeCBORChunks decodes an indefinite length byte or text string, whose
+// chunks begin at offset.
+func decodeCBORChunks(data []byte, begin, offset int, major byte) (*StructuredValue, int, error) {
+	var content []byte
+	for {
+		if offset >= len(data) {
+			return nil, 0, structuredTruncated(offset)
+		}
+		if data[offset] == CBOR_BREAK {
+			offset++
+			break
+		}
+
+		if data[offset]>>5 != major || data[offset]&0x1f == CBOR_INDEFINITE {
+			return nil, 0, structuredError(offset, "invalid CBOR string chunk")
+		}
+
+		length, next, err := readCBORArgument(data, offset+1, data[offset]&0x1f)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		chunk, err := readStructured(data, next, length)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		content = append(content, chunk...)
+		offset = next + len(chunk)
+	}
+
+	text := formatStructuredBytes(content)
+	if major == CBOR_MAJOR_TEXT {
+		text = strconv.Quote(string(content))
+	}
+	return structuredScalar(text, begin, offset), offset, nil
+}
+
+// decodeCBORContainer decodes the array or map whose elements begin at
+// offset. Indefinite length containers end with a break.
+func decodeCBORContainer(data []byte, begin, offset int, major byte, length uint64, indefinite bool, depth int) (*StructuredValue, int, error) {
+	if depth >= STRUCTURED_MAX_DEPTH {
+		return nil, 0, structuredError(begin, "too deeply nested")
+	}
+
+	container := &StructuredValue{Kind: STRUCTURED_KIND_ARRAY, Offset: begin}
+	if major == CBOR_MAJOR_MAP {
+		container.Kind = STRUCTURED_KIND_MAP
+	}
+
+	fail := func(err error) (*StructuredValue, int, error) {
+		container.Length = offset - begin
+		return container, 0, err
+	}
+
+	for i := uint64(0); indefinite || i < length; i++ {
+		if indefinite {
+			if offset >= len(data) {
+				return fail(structuredTruncated(offset))
+			}
+			if data[offset] == CBOR_BREAK {
+				offset++
+				break
+			}
+		}
+
+		var key *StructuredValue
+		if container.Kind == STRUCTURED_KIND_MAP {
+			var next int
+			var err error
+			key, next, err = decodeCBOR(data, offset, depth+1)
+			if err != nil {
+				return fail(err)
+			}
+			offset = next
+		}
+
+		element, next, err := decodeCBOR(data, offset, depth+1)
+		if element != nil {
+			container.Elements = append(container.Elements, element)
+			if key != nil {
+				container.Keys = append(container.Keys, structuredKey(key))
+			}
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		offset = next
+	}
+
+	container.Length = offset - begin
+	return container, offset, nil
+}
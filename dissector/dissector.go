@@ -0,0 +1,225 @@
+// Package dissector frames the streams of connections into protocol messages,
+// and decodes those messages into fields for display.
+package dissector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// Field is a decoded field of a message.
+type Field struct {
+	Name  string
+	Value string
+
+	// Offset and Length are the range of the field's bytes in the message.
+	// Length is 0 when the field doesn't map to a range, for example when
+	// it's decoded from compressed data.
+	Offset int
+	Length int
+
+	// Type is the type of integer fields, by which they're edited as numbers,
+	// nil for other fields.
+	Type *FieldType
+	// Payload is set on the field holding the payload of a PayloadEditor, if
+	// it's written as is in the message (not encoded, e.g. masked).
+	Payload bool
+
+	Children []*Field
+}
+
+// FieldType is the type of an integer field.
+type FieldType struct {
+	// Size is the number of bytes of the integer: 1, 2, 4 or 8.
+	Size   int
+	Signed bool
+	Order  binary.ByteOrder
+	// Enum names values of the field, nil if it has no names.
+	Enum map[int64]string
+}
+
+// Dissection is a decoded message.
+type Dissection struct {
+	// Summary is a one line summary of the message, for example "GET /".
+	Summary string
+	Fields  []*Field
+}
+
+// Decoder decodes messages of a protocol. Every message framed by a Session
+// has the Decoder of its frame set as its tcpmessage.Protocol.
+type Decoder interface {
+	tcpmessage.Protocol
+
+	Decode(content []byte) (*Dissection, error)
+}
+
+// PayloadEditor is implemented by decoders of protocols whose frames wrap a
+// payload, so the payload is edited instead of the encoded frame.
+type PayloadEditor interface {
+	Payload(content []byte) ([]byte, error)
+
+	// SetPayload returns content with its payload replaced by payload.
+	SetPayload(content, payload []byte) ([]byte, error)
+}
+
+// StreamDecoder is implemented by decoders of protocols which multiplex
+// streams over a connection, so messages can be grouped by their stream.
+type StreamDecoder interface {
+	Stream(content []byte) (uint32, error)
+}
+
+// Session frames the streams of a single connection. Frame is called with the
+// data of both directions, in the order in which it was read, but never
+// concurrently.
+type Session interface {
+	// Frame returns the length of the first complete frame at the beginning of
+	// data, which was sent in the given direction, and the Decoder of the
+	// frame (nil if it can't be decoded). A length of 0 means more data is
+	// needed. An error means the stream can no longer be framed.
+	Frame(direction tcpmessage.TransmittionDirection, data []byte) (length int, decoder Decoder, err error)
+}
+
+type Dissector interface {
+	Name() string
+
+	// Detect reports whether a connection, whose first data was sent in the
+	// given direction, speaks the protocol of the dissector.
+	Detect(direction tcpmessage.TransmittionDirection, data []byte) bool
+
+	NewSession() Session
+}
+
+// PrefixDetector is implemented by dissectors whose Detect may need more data
+// than the first read of a connection holds.
+type PrefixDetector interface {
+	// DetectPrefix reports whether data, which Detect rejects, is the
+	// beginning of data which Detect may accept once more of it arrives.
+	DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool
+}
+
+// Dissectors holds the known dissectors, in the order in which they're tried
+// by Detect.
+var Dissectors = []Dissector{
+	HTTPDissector{},
+	HTTP2Dissector{},
+	RESPDissector{},
+	PostgresDissector{},
+	MySQLDissector{},
+	MQTTDissector{},
+	DNSDissector{},
+	TLSDissector{},
+}
+
+// offsetFields moves fields decoded from a part of a message by delta.
+func offsetFields(fields []*Field, delta int) {
+	for _, field := range fields {
+		field.Offset += delta
+		offsetFields(field.Children, delta)
+	}
+}
+
+// groupField returns a field holding children, spanning them.
+func groupField(name string, children []*Field) *Field {
+	field := &Field{Name: name, Children: children}
+	if len(children) > 0 {
+		last := children[len(children)-1]
+		field.Offset = children[0].Offset
+		field.Length = last.Offset + last.Length - field.Offset
+	}
+
+	return field
+}
+
+// SUMMARY_TEXT_LENGTH is the maximal length of text, such as a query, shown in
+// a summary.
+const SUMMARY_TEXT_LENGTH = 60
+
+// summaryText collapses the whitespace of text and truncates it to
+// SUMMARY_TEXT_LENGTH, for display in a summary.
+func summaryText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > SUMMARY_TEXT_LENGTH {
+		return string(runes[:SUMMARY_TEXT_LENGTH]) + "…"
+	}
+
+	return text
+}
+
+// Lookup returns the dissector with the given name.
+func Lookup(name string) (Dissector, error) {
+	for _, dissector := range Dissectors {
+		if dissector.Name() == name {
+			return dissector, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown protocol %q, known protocols are %s", name, strings.Join(Names(), ", "))
+}
+
+// Detect returns the dissector of a connection whose first data, sent in the
+// given direction, is data. It returns nil if the protocol is unknown.
+func Detect(direction tcpmessage.TransmittionDirection, data []byte) Dissector {
+	for _, dissector := range Dissectors {
+		if dissector.Detect(direction, data) {
+			return dissector
+		}
+	}
+
+	return nil
+}
+
+// DetectPrefix reports whether the protocol of a connection whose first data,
+// sent in the given direction, is data, may be detected once more data
+// arrives, in which case detection should wait for it.
+func DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	for _, dissector := range Dissectors {
+		if prefixDetector, ok := dissector.(PrefixDetector); ok && prefixDetector.DetectPrefix(direction, data) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isShorterPrefix reports whether data is a prefix of s which is shorter than
+// s.
+func isShorterPrefix(data []byte, s string) bool {
+	return len(data) < len(s) && strings.HasPrefix(s, string(data))
+}
+
+func Names() []string {
+	var names []string
+	for _, dissector := range Dissectors {
+		names = append(names, dissector.Name())
+	}
+
+	return slices.Clip(names)
+}
+
+// Dissect decodes the message if its protocol is known, returning nil
+// otherwise.
+func Dissect(message *tcpmessage.TCPMessage) (*Dissection, error) {
+	decoder, ok := message.Protocol().(Decoder)
+	if !ok {
+		return nil, nil
+	}
+
+	return safeDecode(decoder, message.Content())
+}
+
+// safeDecode decodes content, turning the panics of the decoder into errors,
+// so that a message which crashes its decoder is shown as undecodable instead
+// of crashing the proxy.
+func safeDecode(decoder Decoder, content []byte) (dissection *Dissection, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			dissection, err = nil, fmt.Errorf("the dissector panicked: %v", r)
+		}
+	}()
+
+	return decoder.Decode(content)
+}
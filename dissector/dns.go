@@ -0,0 +1,523 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// DNS_HEADER_LENGTH is the length of the header of a DNS message.
+const DNS_HEADER_LENGTH = 12
+
+// DNS_MAX_NAME_LENGTH is the maximal length of an encoded domain name.
+const DNS_MAX_NAME_LENGTH = 255
+
+// DNS_MAX_NAME_POINTERS is the maximal number of pointers followed when
+// reading a domain name.
+const DNS_MAX_NAME_POINTERS = 64
+
+const (
+	DNS_TYPE_A     = 1
+	DNS_TYPE_NS    = 2
+	DNS_TYPE_CNAME = 5
+	DNS_TYPE_SOA   = 6
+	DNS_TYPE_PTR   = 12
+	DNS_TYPE_MX    = 15
+	DNS_TYPE_TXT   = 16
+	DNS_TYPE_AAAA  = 28
+	DNS_TYPE_SRV   = 33
+	DNS_TYPE_OPT   = 41
+	DNS_TYPE_CAA   = 257
+)
+
+var dnsTypeNames = map[int]string{
+	DNS_TYPE_A:     "A",
+	DNS_TYPE_NS:    "NS",
+	DNS_TYPE_CNAME: "CNAME",
+	DNS_TYPE_SOA:   "SOA",
+	DNS_TYPE_PTR:   "PTR",
+	DNS_TYPE_MX:    "MX",
+	DNS_TYPE_TXT:   "TXT",
+	DNS_TYPE_AAAA:  "AAAA",
+	DNS_TYPE_SRV:   "SRV",
+	DNS_TYPE_OPT:   "OPT",
+	DNS_TYPE_CAA:   "CAA",
+	13:             "HINFO",
+	35:             "NAPTR",
+	39:             "DNAME",
+	43:             "DS",
+	46:             "RRSIG",
+	47:             "NSEC",
+	48:             "DNSKEY",
+	50:             "NSEC3",
+	51:             "NSEC3PARAM",
+	52:             "TLSA",
+	64:             "SVCB",
+	65:             "HTTPS",
+	99:             "SPF",
+	251:            "IXFR",
+	252:            "AXFR",
+	255:            "ANY",
+}
+
+var dnsTypeEnum = fieldEnum(dnsTypeNames)
+
+func dnsTypeName(recordType int) string {
+	name, ok := dnsTypeNames[recordType]
+	if !ok {
+		return fmt.Sprintf("TYPE%d", recordType)
+	}
+
+	return name
+}
+
+var dnsClassNames = map[int]string{
+	1:   "IN",
+	3:   "CH",
+	4:   "HS",
+	254: "NONE",
+	255: "ANY",
+}
+
+var dnsClassEnum = fieldEnum(dnsClassNames)
+
+func dnsClassName(class int) string {
+	name, ok := dnsClassNames[class]
+	if !ok {
+		return fmt.Sprintf("CLASS%d", class)
+	}
+
+	return name
+}
+
+var dnsOpcodeNames = map[int]string{
+	0: "QUERY",
+	1: "IQUERY",
+	2: "STATUS",
+	4: "NOTIFY",
+	5: "UPDATE",
+	6: "DSO",
+}
+
+var dnsRcodeNames = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADVERS",
+}
+
+func dnsRcodeName(rcode int) string {
+	name, ok := dnsRcodeNames[rcode]
+	if !ok {
+		return fmt.Sprintf("RCODE%d", rcode)
+	}
+
+	return name
+}
+
+// DNSDissector dissects DNS over TCP, in which every message is prefixed by
+// its 2 byte length.
+type DNSDissector struct{}
+
+func (DNSDissector) Name() string {
+	return "dns"
+}
+
+func (DNSDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(data) < 2+DNS_HEADER_LENGTH {
+		return false
+	}
+
+	// A query of a single question, without records other than EDNS
+	length := int(binary.BigEndian.Uint16(data))
+	message := data[2:]
+	flags := binary.BigEndian.Uint16(message[2:])
+	return length >= DNS_HEADER_LENGTH && flags&0x8000 == 0 &&
+		binary.BigEndian.Uint16(message[4:]) == 1 &&
+		binary.BigEndian.Uint16(message[6:]) == 0 &&
+		binary.BigEndian.Uint16(message[8:]) == 0 &&
+		binary.BigEndian.Uint16(message[10:]) <= 1
+}
+
+func (DNSDissector) NewSession() Session {
+	return dnsSession{}
+}
+
+type dnsSession struct{}
+
+func (dnsSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	if len(data) < 2 {
+		return 0, nil, nil
+	}
+
+	length := int(binary.BigEndian.Uint16(data))
+	if length < DNS_HEADER_LENGTH {
+		return 0, nil, fmt.Errorf("invalid DNS message length %d", length)
+	}
+
+	if 2+length > len(data) {
+		return 0, nil, nil
+	}
+
+	return 2 + length, DNSDecoder{}, nil
+}
+
+// DNSDecoder decodes DNS messages prefixed by their 2 byte length, as sent
+// over TCP.
+type DNSDecoder struct{}
+
+func (DNSDecoder) Name() string {
+	return "dns"
+}
+
+func (DNSDecoder) Decode(content []byte) (*Dissection, error) {
+	r := newReader(content, binary.BigEndian)
+	length := r.uint16Field("Length")
+	if r.err != nil {
+		return nil, r.err
+	}
+	if int(r.order.Uint16(content)) != r.remaining() {
+		return nil, fmt.Errorf("the DNS message length %s doesn't match its %d bytes", length.Value, r.remaining())
+	}
+
+	dissection, err := DecodeDNS(content[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	offsetFields(dissection.Fields, 2)
+	dissection.Fields = append([]*Field{length}, dissection.Fields...)
+	return dissection, nil
+}
+
+// DecodeDNS decodes a DNS message, as sent over UDP.
+func DecodeDNS(message []byte) (*Dissection, error) {
+	r := newReader(message, binary.BigEndian)
+
+	fields := []*Field{r.uint16Field("ID")}
+	begin := r.offset
+	flags := int(r.uint16())
+	isResponse := flags&0x8000 != 0
+	opcode := flags >> 11 & 0x0f
+	rcode := flags & 0x0f
+
+	opcodeName, ok := dnsOpcodeNames[opcode]
+	if !ok {
+		opcodeName = fmt.Sprintf("OPCODE%d", opcode)
+	}
+
+	flagsField := r.field("Flags", fmt.Sprintf("0x%04x", flags), begin)
+	for _, flag := range []struct {
+		name string
+		bit  int
+	}{{"AA", 10}, {"TC", 9}, {"RD", 8}, {"RA", 7}, {"AD", 5}, {"CD", 4}} {
+		if flags&(1<<flag.bit) != 0 {
+			flagsField.Value += " " + flag.name
+		}
+	}
+
+	flagsField.Children = []*Field{
+		{Name: "Response", Value: fmt.Sprint(isResponse), Offset: begin, Length: 2},
+		{Name: "Opcode", Value: opcodeName, Offset: begin, Length: 2},
+		{Name: "Authoritative answer", Value: fmt.Sprint(flags&0x0400 != 0), Offset: begin, Length: 2},
+		{Name: "Truncated", Value: fmt.Sprint(flags&0x0200 != 0), Offset: begin, Length: 2},
+		{Name: "Recursion desired", Value: fmt.Sprint(flags&0x0100 != 0), Offset: begin, Length: 2},
+		{Name: "Recursion available", Value: fmt.Sprint(flags&0x0080 != 0), Offset: begin, Length: 2},
+		{Name: "Authentic data", Value: fmt.Sprint(flags&0x0020 != 0), Offset: begin, Length: 2},
+		{Name: "Checking disabled", Value: fmt.Sprint(flags&0x0010 != 0), Offset: begin, Length: 2},
+		{Name: "Response code", Value: dnsRcodeName(rcode), Offset: begin, Length: 2},
+	}
+	fields = append(fields, flagsField)
+
+	var counts [4]int
+	for i, name := range []string{"Questions", "Answer records", "Authority records", "Additional records"} {
+		count := r.uint16Field(name)
+		counts[i], _ = strconv.Atoi(count.Value)
+		fields = append(fields, count)
+	}
+
+	var questions []*Field
+	var question string
+	for i := 0; i < counts[0] && r.err == nil; i++ {
+		begin := r.offset
+		name := readDNSName(r)
+		recordType := int(r.uint16())
+		class := int(r.uint16())
+
+		field := r.field(fmt.Sprintf("[%d] %s", i, name), dnsTypeName(recordType)+" "+dnsClassName(class), begin)
+		questions = append(questions, field)
+		if i == 0 {
+			question = name + " " + dnsTypeName(recordType)
+		}
+	}
+	fields = append(fields, groupField(fmt.Sprintf("Questions (%d)", len(questions)), questions))
+
+	extendedRcode := rcode
+	for i, section := range []string{"Answers", "Authority", "Additional"} {
+		var records []*Field
+		for j := 0; j < counts[i+1] && r.err == nil; j++ {
+			record, upperRcode := readDNSRecord(r, j)
+			records = append(records, record)
+			if upperRcode != 0 {
+				extendedRcode |= upperRcode << 4
+			}
+		}
+
+		fields = append(fields, groupField(fmt.Sprintf("%s (%d)", section, len(records)), records))
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if r.remaining() > 0 {
+		fields = append(fields, &Field{Name: "Data", Value: DescribeBody(r.data[r.offset:]), Offset: r.offset, Length: r.remaining()})
+	}
+
+	summary := "Query " + question
+	if opcode != 0 {
+		summary = opcodeName + " " + question
+	}
+	if isResponse {
+		summary = fmt.Sprintf("Response %s %s, %d answers", dnsRcodeName(extendedRcode), question, counts[1])
+	}
+
+	return &Dissection{Summary: summary, Fields: fields}, nil
+}
+
+// readDNSName reads a domain name, which may end with a pointer to a name
+// earlier in the message.
+func readDNSName(r *reader) string {
+	var labels []string
+
+	offset := r.offset
+	jumped := false
+	pointers := 0
+	nameLength := 0
+	previousTarget := len(r.data)
+	for r.err == nil {
+		if offset >= len(r.data) {
+			r.err = fmt.Errorf("unterminated DNS name at offset %d", offset)
+			break
+		}
+
+		length := int(r.data[offset])
+		switch {
+		case length == 0:
+			if !jumped {
+				r.offset = offset + 1
+			}
+
+			if len(labels) == 0 {
+				return "."
+			}
+			return strings.Join(labels, ".")
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(r.data) {
+				r.err = fmt.Errorf("truncated DNS name pointer at offset %d", offset)
+				break
+			}
+
+			// Pointers may only point backwards, below the previous one, so
+			// they can't loop
+			pointer := (length&0x3f)<<8 | int(r.data[offset+1])
+			if pointer >= offset || pointer >= previousTarget {
+				r.err = fmt.Errorf("DNS name pointer at offset %d doesn't point backwards", offset)
+				break
+			}
+
+			pointers++
+			if pointers > DNS_MAX_NAME_POINTERS {
+				r.err = fmt.Errorf("DNS name at offset %d has more than %d pointers", r.offset, DNS_MAX_NAME_POINTERS)
+				break
+			}
+			previousTarget = pointer
+
+			if !jumped {
+				r.offset = offset + 2
+				jumped = true
+			}
+			offset = pointer
+		case length&0xc0 != 0:
+			r.err = fmt.Errorf("invalid DNS label length 0x%02x at offset %d", length, offset)
+		default:
+			if offset+1+length > len(r.data) {
+				r.err = fmt.Errorf("truncated DNS label at offset %d", offset)
+				break
+			}
+
+			// The length of the name includes its terminating zero length
+			nameLength += 1 + length
+			if nameLength+1 > DNS_MAX_NAME_LENGTH {
+				r.err = fmt.Errorf("DNS name at offset %d is longer than %d bytes", r.offset, DNS_MAX_NAME_LENGTH)
+				break
+			}
+
+			labels = append(labels, string(r.data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+
+	return ""
+}
+
+// readDNSRecord reads a resource record into a field. It returns the upper
+// bits of the response code if the record is an EDNS OPT record.
+func readDNSRecord(r *reader, index int) (*Field, int) {
+	begin := r.offset
+	name := readDNSName(r)
+	nameField := r.field("Name", name, begin)
+
+	begin = r.offset
+	recordType := int(r.uint16())
+	typeField := r.field("Type", dnsTypeName(recordType), begin)
+	typeField.Type = r.integerType(2, false, dnsTypeEnum)
+
+	if recordType == DNS_TYPE_OPT {
+		return readDNSOptRecord(r, index, nameField, typeField)
+	}
+
+	begin = r.offset
+	class := int(r.uint16())
+	classField := r.field("Class", dnsClassName(class), begin)
+	classField.Type = r.integerType(2, false, dnsClassEnum)
+	children := []*Field{nameField, typeField, classField, r.uint32Field("TTL")}
+
+	dataLength := r.uint16Field("Data length")
+	children = append(children, dataLength)
+	length, _ := strconv.Atoi(dataLength.Value)
+
+	begin = r.offset
+	data, dataChildren := readDNSRecordData(r, recordType, length)
+	dataField := r.field("Data", data, begin)
+	dataField.Children = dataChildren
+	children = append(children, dataField)
+
+	return &Field{
+		Name:     fmt.Sprintf("[%d] %s %s", index, name, dnsTypeName(recordType)),
+		Value:    data,
+		Offset:   nameField.Offset,
+		Length:   r.offset - nameField.Offset,
+		Children: children,
+	}, 0
+}
+
+// readDNSRecordData reads the data of a record of the given type, returning
+// its text form along with its parts, if it has multiple.
+func readDNSRecordData(r *reader, recordType int, length int) (string, []*Field) {
+	if length > r.remaining() {
+		r.err = fmt.Errorf("DNS record data at offset %d is longer than the message", r.offset)
+		return "", nil
+	}
+
+	end := r.offset + length
+	var data string
+	var children []*Field
+	switch recordType {
+	case DNS_TYPE_A, DNS_TYPE_AAAA:
+		address, ok := netip.AddrFromSlice(r.read(length))
+		if !ok {
+			r.err = fmt.Errorf("invalid %s address length %d", dnsTypeName(recordType), length)
+			return "", nil
+		}
+
+		data = address.String()
+	case DNS_TYPE_NS, DNS_TYPE_CNAME, DNS_TYPE_PTR:
+		data = readDNSName(r)
+	case DNS_TYPE_MX:
+		children = []*Field{r.uint16Field("Preference")}
+
+		begin := r.offset
+		children = append(children, r.field("Exchange", readDNSName(r), begin))
+		data = children[0].Value + " " + children[1].Value
+	case DNS_TYPE_SRV:
+		children = []*Field{r.uint16Field("Priority"), r.uint16Field("Weight"), r.uint16Field("Port")}
+
+		begin := r.offset
+		children = append(children, r.field("Target", readDNSName(r), begin))
+
+		var parts []string
+		for _, child := range children {
+			parts = append(parts, child.Value)
+		}
+		data = strings.Join(parts, " ")
+	case DNS_TYPE_SOA:
+		begin := r.offset
+		children = []*Field{r.field("Primary name server", readDNSName(r), begin)}
+
+		begin = r.offset
+		children = append(children, r.field("Mailbox", readDNSName(r), begin))
+		children = append(children, r.uint32Field("Serial"), r.uint32Field("Refresh"), r.uint32Field("Retry"),
+			r.uint32Field("Expire"), r.uint32Field("Minimum TTL"))
+		data = fmt.Sprintf("%s %s %s", children[0].Value, children[1].Value, children[2].Value)
+	case DNS_TYPE_TXT:
+		var texts []string
+		for r.err == nil && r.offset < end {
+			texts = append(texts, strconv.Quote(string(r.read(int(r.byte())))))
+		}
+		data = strings.Join(texts, " ")
+	case DNS_TYPE_CAA:
+		flags := r.byte()
+		tag := r.read(int(r.byte()))
+		data = fmt.Sprintf("%d %s %q", flags, tag, r.read(end-r.offset))
+	default:
+		data = fmt.Sprintf("%x", r.read(length))
+	}
+
+	if r.err == nil && r.offset != end {
+		r.err = fmt.Errorf("DNS %s record data at offset %d doesn't match its length %d", dnsTypeName(recordType), end-length, length)
+	}
+
+	return data, children
+}
+
+// readDNSOptRecord reads the rest of an EDNS OPT record, whose class and TTL
+// hold EDNS parameters.
+func readDNSOptRecord(r *reader, index int, nameField, typeField *Field) (*Field, int) {
+	children := []*Field{nameField, typeField, r.uint16Field("UDP payload size")}
+
+	begin := r.offset
+	upperRcode := int(r.byte())
+	children = append(children, r.field("Extended response code", upperRcode, begin), r.byteField("EDNS version"))
+
+	begin = r.offset
+	dnssecOK := r.uint16()&0x8000 != 0
+	children = append(children, r.field("DNSSEC OK", dnssecOK, begin))
+
+	dataLength := r.uint16Field("Data length")
+	children = append(children, dataLength)
+	length, _ := strconv.Atoi(dataLength.Value)
+	if length > r.remaining() {
+		r.err = fmt.Errorf("EDNS options at offset %d are longer than the message", r.offset)
+		return nil, 0
+	}
+
+	var options []*Field
+	for end := r.offset + length; r.err == nil && r.offset < end; {
+		begin := r.offset
+		code := r.uint16()
+		data := r.read(int(r.uint16()))
+		options = append(options, r.field(fmt.Sprintf("Option %d", code), fmt.Sprintf("%x", data), begin))
+	}
+	children = append(children, groupField("Options", options))
+
+	return &Field{
+		Name:     fmt.Sprintf("[%d] OPT", index),
+		Value:    "UDP payload size " + children[2].Value,
+		Offset:   nameField.Offset,
+		Length:   r.offset - nameField.Offset,
+		Children: children,
+	}, upperRcode
+}
@@ -0,0 +1,82 @@
+package dissector
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dnsQuery = "\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x07example\x03com\x00\x00\x01\x00\x01"
+
+// dnsField returns the top-level field of dissection with the given name.
+func dnsField(t *testing.T, dissection *Dissection, name string) *Field {
+	index := slices.IndexFunc(dissection.Fields, func(field *Field) bool { return field.Name == name })
+	require.NotEqual(t, -1, index, "No field named %q", name)
+
+	return dissection.Fields[index]
+}
+
+func TestDNSSession(t *testing.T) {
+	query := "\x00\x1d" + dnsQuery
+	require.True(t, DNSDissector{}.Detect(toServer, []byte(query)))
+
+	session := DNSDissector{}.NewSession()
+	frames, rest := frameAll(t, session, toServer, query+query[:5])
+	require.Len(t, frames, 1)
+	assert.Equal(t, query[:5], rest)
+
+	length, decoder, err := session.Frame(toServer, []byte(query))
+	require.NoError(t, err)
+	require.Equal(t, len(query), length)
+
+	dissection, err := decoder.Decode([]byte(query))
+	require.NoError(t, err)
+	assert.Equal(t, "Query example.com A", dissection.Summary)
+
+	questions := dnsField(t, dissection, "Questions (1)")
+	require.Len(t, questions.Children, 1)
+	assert.Equal(t, 14, questions.Children[0].Offset)
+}
+
+func TestDecodeDNSResponse(t *testing.T) {
+	// Answers with a CNAME to a compressed name and its A record
+	response := "\x12\x34\x81\x80\x00\x01\x00\x02\x00\x00\x00\x00" +
+		"\x03www\x07example\x03com\x00\x00\x01\x00\x01" +
+		"\xc0\x0c\x00\x05\x00\x01\x00\x00\x0e\x10\x00\x02\xc0\x10" +
+		"\xc0\x10\x00\x01\x00\x01\x00\x00\x0e\x10\x00\x04\x5d\xb8\xd8\x22"
+
+	dissection, err := DecodeDNS([]byte(response))
+	require.NoError(t, err)
+	assert.Equal(t, "Response NOERROR www.example.com A, 2 answers", dissection.Summary)
+	assert.Equal(t, "0x8180 RD RA", dnsField(t, dissection, "Flags").Value)
+
+	answers := dnsField(t, dissection, "Answers (2)")
+	require.Len(t, answers.Children, 2)
+	assert.Equal(t, "[0] www.example.com CNAME", answers.Children[0].Name)
+	assert.Equal(t, "example.com", answers.Children[0].Value)
+	assert.Equal(t, "93.184.216.34", answers.Children[1].Value)
+
+	nxdomain := "\x12\x34\x81\x83" + dnsQuery[4:]
+	dissection, err = DecodeDNS([]byte(nxdomain))
+	require.NoError(t, err)
+	assert.Equal(t, "Response NXDOMAIN example.com A, 0 answers", dissection.Summary)
+}
+
+func TestDecodeDNSRejectsForwardPointers(t *testing.T) {
+	_, err := DecodeDNS([]byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\xc0\x0c\x00\x01\x00\x01"))
+	assert.Error(t, err)
+}
+
+func TestDecodeDNSRejectsPointerLoops(t *testing.T) {
+	// A label followed by a pointer to itself
+	_, err := DecodeDNS([]byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00\x01a\xc0\x0c\x00\x01\x00\x01"))
+	assert.Error(t, err)
+
+	// A name of 4 labels of 63 bytes is 257 bytes long
+	longName := strings.Repeat("\x3f"+strings.Repeat("a", 63), 4) + "\x00"
+	_, err = DecodeDNS([]byte("\x12\x34\x01\x00\x00\x01\x00\x00\x00\x00\x00\x00" + longName + "\x00\x01\x00\x01"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,200 @@
+package dissector
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EditedField decodes content and returns the field at index of its
+// dissection, in the order of the field tree (each field before its
+// children).
+func EditedField(decoder Decoder, content []byte, index int) (*Field, error) {
+	field, _, err := dissectedField(decoder, content, index)
+	return field, err
+}
+
+// dissectedField decodes content and returns the field at index, along with
+// all the fields, in the order of flattenFields.
+func dissectedField(decoder Decoder, content []byte, index int) (*Field, []*Field, error) {
+	dissection, err := safeDecode(decoder, content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("only messages dissected without errors can be edited: %w", err)
+	}
+
+	flattened := flattenFields(nil, dissection.Fields)
+	if index < 0 || index >= len(flattened) {
+		return nil, nil, fmt.Errorf("there's no field %d", index)
+	}
+
+	field := flattened[index]
+	if field.Length == 0 || field.Offset < 0 || field.Offset+field.Length > len(content) {
+		return nil, nil, fmt.Errorf("%s has no bytes in the message to edit", field.Name)
+	}
+
+	return field, flattened, nil
+}
+
+// FieldBytes returns the name and the bytes of the field at index of the
+// dissection of content (see EditedField).
+func FieldBytes(decoder Decoder, content []byte, index int) (string, []byte, error) {
+	field, err := EditedField(decoder, content, index)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return field.Name, content[field.Offset : field.Offset+field.Length], nil
+}
+
+// SetFieldBytes returns content with the bytes of the field at index (see
+// EditedField) replaced by value. Fields in the payload field of a
+// PayloadEditor can change their length, as the message is re-encoded by
+// SetPayload, which updates the lengths depending on it. Other fields must
+// keep their length.
+func SetFieldBytes(decoder Decoder, content []byte, index int, value []byte) ([]byte, error) {
+	field, fields, err := dissectedField(decoder, content, index)
+	if err != nil {
+		return nil, err
+	}
+
+	end := field.Offset + field.Length
+	if len(value) == field.Length {
+		return slices.Concat(content[:field.Offset], value, content[end:]), nil
+	}
+
+	if editor, ok := decoder.(PayloadEditor); ok {
+		payloadIndex := slices.IndexFunc(fields, func(field *Field) bool { return field.Payload })
+		if payloadIndex != -1 {
+			payload := fields[payloadIndex]
+			if payload.Offset <= field.Offset && end <= payload.Offset+payload.Length {
+				return editor.SetPayload(content, slices.Concat(
+					content[payload.Offset:field.Offset], value, content[end:payload.Offset+payload.Length]))
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%s isn't in the payload of the message, so its length of %d bytes can't change", field.Name, field.Length)
+}
+
+// SetFieldText returns content with the integer field at index (see
+// EditedField) set to text, in the format of FieldType.Text.
+func SetFieldText(decoder Decoder, content []byte, index int, text string) ([]byte, error) {
+	field, err := EditedField(decoder, content, index)
+	if err != nil {
+		return nil, err
+	}
+	if field.Type == nil {
+		return nil, fmt.Errorf("%s isn't an integer, so it's edited by its bytes", field.Name)
+	}
+
+	value, err := field.Type.Encode(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field.Name, err)
+	}
+
+	return SetFieldBytes(decoder, content, index, value)
+}
+
+// Text returns the integer in data, the bytes of a field of the type, as it's
+// edited: by its enum name if it has one, and in decimal otherwise.
+func (t *FieldType) Text(data []byte) (string, error) {
+	if len(data) != t.Size {
+		return "", fmt.Errorf("expected a %d byte integer, found %d bytes", t.Size, len(data))
+	}
+
+	var value uint64
+	switch t.Size {
+	case 1:
+		value = uint64(data[0])
+	case 2:
+		value = uint64(t.Order.Uint16(data))
+	case 4:
+		value = uint64(t.Order.Uint32(data))
+	case 8:
+		value = t.Order.Uint64(data)
+	}
+
+	if t.Signed {
+		shift := 64 - 8*t.Size
+		value = uint64(int64(value<<shift) >> shift)
+	}
+
+	if name, ok := t.Enum[int64(value)]; ok {
+		return name, nil
+	}
+
+	if t.Signed {
+		return strconv.FormatInt(int64(value), 10), nil
+	}
+	return strconv.FormatUint(value, 10), nil
+}
+
+// Encode encodes text, in the format of Text, as an integer of the type,
+// failing if it doesn't fit in it.
+func (t *FieldType) Encode(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+
+	var value uint64
+	if enumValue, ok := enumValueByName(t.Enum, text); ok {
+		value = uint64(enumValue)
+	} else if signed, err := strconv.ParseInt(text, 0, 64); err == nil {
+		value = uint64(signed)
+	} else if unsigned, err := strconv.ParseUint(text, 0, 64); err == nil {
+		value = unsigned
+	} else {
+		return nil, fmt.Errorf("invalid integer %q", text)
+	}
+
+	if bits := 8 * t.Size; bits < 64 {
+		signed := int64(value)
+		fits := value < 1<<bits
+		if t.Signed {
+			fits = signed >= -1<<(bits-1) && signed < 1<<(bits-1)
+		}
+		if !fits {
+			return nil, fmt.Errorf("%d doesn't fit in %d bytes", signed, t.Size)
+		}
+	}
+
+	encoded := make([]byte, t.Size)
+	switch t.Size {
+	case 1:
+		encoded[0] = byte(value)
+	case 2:
+		t.Order.PutUint16(encoded, uint16(value))
+	case 4:
+		t.Order.PutUint32(encoded, uint32(value))
+	case 8:
+		t.Order.PutUint64(encoded, value)
+	}
+
+	return encoded, nil
+}
+
+// EnumNames returns the names of the values of the type, ordered by their
+// values.
+func (t *FieldType) EnumNames() []string {
+	values := make([]int64, 0, len(t.Enum))
+	for value := range t.Enum {
+		values = append(values, value)
+	}
+	slices.Sort(values)
+
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = t.Enum[value]
+	}
+
+	return names
+}
+
+// fieldEnum returns the names of values as the Enum of a FieldType.
+func fieldEnum[T ~int | ~uint8 | ~uint16](names map[T]string) map[int64]string {
+	enum := make(map[int64]string, len(names))
+	for value, name := range names {
+		enum[int64(value)] = name
+	}
+
+	return enum
+}
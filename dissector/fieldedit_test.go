@@ -0,0 +1,127 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fieldIndex returns the index of the field with the given name in the
+// dissection of content, in the order of the field tree.
+func fieldIndex(t *testing.T, decoder Decoder, content string, name string) int {
+	dissection, err := decoder.Decode([]byte(content))
+	require.NoError(t, err)
+
+	index := slices.IndexFunc(flattenFields(nil, dissection.Fields), func(field *Field) bool { return field.Name == name })
+	require.NotEqual(t, -1, index, "No field named %q", name)
+	return index
+}
+
+func TestSetFieldBytes(t *testing.T) {
+	decoder := MQTTDecoder{version: MQTT_VERSION_3_1_1}
+	publish := mqttPacket(0x30, "\x00\x05alarmoff")
+
+	name, value, err := FieldBytes(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Payload"))
+	require.NoError(t, err)
+	assert.Equal(t, "Payload", name)
+	assert.Equal(t, "off", string(value))
+
+	// The payload is re-encoded with its new length
+	edited, err := SetFieldBytes(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Payload"), []byte("on and on"))
+	require.NoError(t, err)
+	assert.Equal(t, mqttPacket(0x30, "\x00\x05alarmon and on"), string(edited))
+
+	// The topic, along with its length prefix, is outside of the payload
+	_, err = SetFieldBytes(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Topic"), []byte("\x00\x06sirens"))
+	assert.ErrorContains(t, err, "can't change")
+
+	edited, err = SetFieldBytes(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Topic"), []byte("\x00\x05siren"))
+	require.NoError(t, err)
+	assert.Equal(t, mqttPacket(0x30, "\x00\x05sirenoff"), string(edited))
+}
+
+func TestSetFieldBytesByPayloadField(t *testing.T) {
+	// The data is also found in the padding, after it
+	decoder := HTTP2Decoder{}
+	data := http2Frame(HTTP2_FRAME_DATA, HTTP2_FLAG_PADDED, 1, "\x02\x00\x00\x00")
+
+	edited, err := SetFieldBytes(decoder, []byte(data), fieldIndex(t, decoder, data, "Data"), []byte("\x00\x00\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, http2Frame(HTTP2_FRAME_DATA, HTTP2_FLAG_PADDED, 1, "\x02\x00\x00\x00\x00\x00"), string(edited))
+}
+
+func TestSetFieldText(t *testing.T) {
+	decoder := MQTTDecoder{version: MQTT_VERSION_3_1_1}
+	publish := mqttPacket(0x32, "\x00\x05alarm\x01\x02off")
+
+	field, err := EditedField(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Packet ID"))
+	require.NoError(t, err)
+	require.NotNil(t, field.Type)
+	text, err := field.Type.Text([]byte(publish)[field.Offset : field.Offset+field.Length])
+	require.NoError(t, err)
+	assert.Equal(t, "258", text)
+
+	edited, err := SetFieldText(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Packet ID"), " 0x0304\n")
+	require.NoError(t, err)
+	assert.Equal(t, mqttPacket(0x32, "\x00\x05alarm\x03\x04off"), string(edited))
+
+	_, err = SetFieldText(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Packet ID"), "65536")
+	assert.ErrorContains(t, err, "doesn't fit in 2 bytes")
+
+	_, err = SetFieldText(decoder, []byte(publish), fieldIndex(t, decoder, publish, "Payload"), "1")
+	assert.ErrorContains(t, err, "isn't an integer")
+
+	// Enums are edited by their names
+	tlsDecoder := TLSDecoder{}
+	record := "\x17\x03\x03\x00\x02hi"
+	field, err = EditedField(tlsDecoder, []byte(record), fieldIndex(t, tlsDecoder, record, "Content type"))
+	require.NoError(t, err)
+	text, err = field.Type.Text([]byte(record)[:1])
+	require.NoError(t, err)
+	assert.Equal(t, "Application data", text)
+	assert.Contains(t, field.Type.EnumNames(), "Alert")
+
+	edited, err = SetFieldText(tlsDecoder, []byte(record), fieldIndex(t, tlsDecoder, record, "Content type"), "Alert")
+	require.NoError(t, err)
+	assert.Equal(t, "\x15\x03\x03\x00\x02hi", string(edited))
+}
+
+func TestFieldTypeSignedIntegers(t *testing.T) {
+	fieldType := &FieldType{Size: 2, Signed: true, Order: binary.LittleEndian}
+
+	text, err := fieldType.Text([]byte("\xfe\xff"))
+	require.NoError(t, err)
+	assert.Equal(t, "-2", text)
+
+	encoded, err := fieldType.Encode("-3")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\xfd\xff"), encoded)
+
+	_, err = fieldType.Encode("40000")
+	assert.Error(t, err)
+}
+
+type panickingDecoder struct{}
+
+func (panickingDecoder) Name() string {
+	return "panicking"
+}
+
+func (panickingDecoder) Decode([]byte) (*Dissection, error) {
+	panic("index out of range")
+}
+
+func TestDecoderPanicIsAnError(t *testing.T) {
+	message := tcpmessage.New(0, toServer, []byte("hello"))
+	message.SetProtocol(panickingDecoder{})
+
+	_, err := Dissect(message)
+	assert.ErrorContains(t, err, "the dissector panicked: index out of range")
+
+	_, _, err = FieldBytes(panickingDecoder{}, []byte("hello"), 0)
+	assert.ErrorContains(t, err, "the dissector panicked")
+}
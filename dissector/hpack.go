@@ -0,0 +1,375 @@
+package dissector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HPACK_DEFAULT_TABLE_SIZE is the initial size of the HPACK dynamic table.
+const HPACK_DEFAULT_TABLE_SIZE = 4096
+
+// HPACK_ENTRY_OVERHEAD is added to the length of the name and value of every
+// dynamic table entry to get its size.
+const HPACK_ENTRY_OVERHEAD = 32
+
+type hpackHeader struct {
+	name, value string
+}
+
+// hpackStaticTable is the static table of RFC 7541, indexed from 1.
+var hpackStaticTable = []hpackHeader{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// hpackHuffmanCodes are the codes and their lengths in bits of the HPACK
+// Huffman code of every byte.
+var hpackHuffmanCodes = [256]struct {
+	code   uint32
+	length int
+}{
+	{0x1ff8, 13}, {0x7fffd8, 23}, {0xfffffe2, 28}, {0xfffffe3, 28}, {0xfffffe4, 28}, {0xfffffe5, 28}, {0xfffffe6, 28}, {0xfffffe7, 28},
+	{0xfffffe8, 28}, {0xffffea, 24}, {0x3ffffffc, 30}, {0xfffffe9, 28}, {0xfffffea, 28}, {0x3ffffffd, 30}, {0xfffffeb, 28}, {0xfffffec, 28},
+	{0xfffffed, 28}, {0xfffffee, 28}, {0xfffffef, 28}, {0xffffff0, 28}, {0xffffff1, 28}, {0xffffff2, 28}, {0x3ffffffe, 30}, {0xffffff3, 28},
+	{0xffffff4, 28}, {0xffffff5, 28}, {0xffffff6, 28}, {0xffffff7, 28}, {0xffffff8, 28}, {0xffffff9, 28}, {0xffffffa, 28}, {0xffffffb, 28},
+	{0x14, 6}, {0x3f8, 10}, {0x3f9, 10}, {0xffa, 12}, {0x1ff9, 13}, {0x15, 6}, {0xf8, 8}, {0x7fa, 11},
+	{0x3fa, 10}, {0x3fb, 10}, {0xf9, 8}, {0x7fb, 11}, {0xfa, 8}, {0x16, 6}, {0x17, 6}, {0x18, 6},
+	{0x0, 5}, {0x1, 5}, {0x2, 5}, {0x19, 6}, {0x1a, 6}, {0x1b, 6}, {0x1c, 6}, {0x1d, 6},
+	{0x1e, 6}, {0x1f, 6}, {0x5c, 7}, {0xfb, 8}, {0x7ffc, 15}, {0x20, 6}, {0xffb, 12}, {0x3fc, 10},
+	{0x1ffa, 13}, {0x21, 6}, {0x5d, 7}, {0x5e, 7}, {0x5f, 7}, {0x60, 7}, {0x61, 7}, {0x62, 7},
+	{0x63, 7}, {0x64, 7}, {0x65, 7}, {0x66, 7}, {0x67, 7}, {0x68, 7}, {0x69, 7}, {0x6a, 7},
+	{0x6b, 7}, {0x6c, 7}, {0x6d, 7}, {0x6e, 7}, {0x6f, 7}, {0x70, 7}, {0x71, 7}, {0x72, 7},
+	{0xfc, 8}, {0x73, 7}, {0xfd, 8}, {0x1ffb, 13}, {0x7fff0, 19}, {0x1ffc, 13}, {0x3ffc, 14}, {0x22, 6},
+	{0x7ffd, 15}, {0x3, 5}, {0x23, 6}, {0x4, 5}, {0x24, 6}, {0x5, 5}, {0x25, 6}, {0x26, 6},
+	{0x27, 6}, {0x6, 5}, {0x74, 7}, {0x75, 7}, {0x28, 6}, {0x29, 6}, {0x2a, 6}, {0x7, 5},
+	{0x2b, 6}, {0x76, 7}, {0x2c, 6}, {0x8, 5}, {0x9, 5}, {0x2d, 6}, {0x77, 7}, {0x78, 7},
+	{0x79, 7}, {0x7a, 7}, {0x7b, 7}, {0x7ffe, 15}, {0x7fc, 11}, {0x3ffd, 14}, {0x1ffd, 13}, {0xffffffc, 28},
+	{0xfffe6, 20}, {0x3fffd2, 22}, {0xfffe7, 20}, {0xfffe8, 20}, {0x3fffd3, 22}, {0x3fffd4, 22}, {0x3fffd5, 22}, {0x7fffd9, 23},
+	{0x3fffd6, 22}, {0x7fffda, 23}, {0x7fffdb, 23}, {0x7fffdc, 23}, {0x7fffdd, 23}, {0x7fffde, 23}, {0xffffeb, 24}, {0x7fffdf, 23},
+	{0xffffec, 24}, {0xffffed, 24}, {0x3fffd7, 22}, {0x7fffe0, 23}, {0xffffee, 24}, {0x7fffe1, 23}, {0x7fffe2, 23}, {0x7fffe3, 23},
+	{0x7fffe4, 23}, {0x1fffdc, 21}, {0x3fffd8, 22}, {0x7fffe5, 23}, {0x3fffd9, 22}, {0x7fffe6, 23}, {0x7fffe7, 23}, {0xffffef, 24},
+	{0x3fffda, 22}, {0x1fffdd, 21}, {0xfffe9, 20}, {0x3fffdb, 22}, {0x3fffdc, 22}, {0x7fffe8, 23}, {0x7fffe9, 23}, {0x1fffde, 21},
+	{0x7fffea, 23}, {0x3fffdd, 22}, {0x3fffde, 22}, {0xfffff0, 24}, {0x1fffdf, 21}, {0x3fffdf, 22}, {0x7fffeb, 23}, {0x7fffec, 23},
+	{0x1fffe0, 21}, {0x1fffe1, 21}, {0x3fffe0, 22}, {0x1fffe2, 21}, {0x7fffed, 23}, {0x3fffe1, 22}, {0x7fffee, 23}, {0x7fffef, 23},
+	{0xfffea, 20}, {0x3fffe2, 22}, {0x3fffe3, 22}, {0x3fffe4, 22}, {0x7ffff0, 23}, {0x3fffe5, 22}, {0x3fffe6, 22}, {0x7ffff1, 23},
+	{0x3ffffe0, 26}, {0x3ffffe1, 26}, {0xfffeb, 20}, {0x7fff1, 19}, {0x3fffe7, 22}, {0x7ffff2, 23}, {0x3fffe8, 22}, {0x1ffffec, 25},
+	{0x3ffffe2, 26}, {0x3ffffe3, 26}, {0x3ffffe4, 26}, {0x7ffffde, 27}, {0x7ffffdf, 27}, {0x3ffffe5, 26}, {0xfffff1, 24}, {0x1ffffed, 25},
+	{0x7fff2, 19}, {0x1fffe3, 21}, {0x3ffffe6, 26}, {0x7ffffe0, 27}, {0x7ffffe1, 27}, {0x3ffffe7, 26}, {0x7ffffe2, 27}, {0xfffff2, 24},
+	{0x1fffe4, 21}, {0x1fffe5, 21}, {0x3ffffe8, 26}, {0x3ffffe9, 26}, {0xffffffd, 28}, {0x7ffffe3, 27}, {0x7ffffe4, 27}, {0x7ffffe5, 27},
+	{0xfffec, 20}, {0xfffff3, 24}, {0xfffed, 20}, {0x1fffe6, 21}, {0x3fffe9, 22}, {0x1fffe7, 21}, {0x1fffe8, 21}, {0x7ffff3, 23},
+	{0x3fffea, 22}, {0x3fffeb, 22}, {0x1ffffee, 25}, {0x1ffffef, 25}, {0xfffff4, 24}, {0xfffff5, 24}, {0x3ffffea, 26}, {0x7ffff4, 23},
+	{0x3ffffeb, 26}, {0x7ffffe6, 27}, {0x3ffffec, 26}, {0x3ffffed, 26}, {0x7ffffe7, 27}, {0x7ffffe8, 27}, {0x7ffffe9, 27}, {0x7ffffea, 27},
+	{0x7ffffeb, 27}, {0xffffffe, 28}, {0x7ffffec, 27}, {0x7ffffed, 27}, {0x7ffffee, 27}, {0x7ffffef, 27}, {0x7fffff0, 27}, {0x3ffffee, 26},
+}
+
+// hpackHuffmanNode is a node of the HPACK Huffman code tree. Leaves have no
+// children.
+type hpackHuffmanNode struct {
+	children [2]*hpackHuffmanNode
+	symbol   byte
+}
+
+var hpackHuffmanTree = newHPACKHuffmanTree()
+
+func newHPACKHuffmanTree() *hpackHuffmanNode {
+	root := &hpackHuffmanNode{}
+	for symbol, code := range hpackHuffmanCodes {
+		node := root
+		for bit := code.length - 1; bit >= 0; bit-- {
+			child := &node.children[code.code>>bit&1]
+			if *child == nil {
+				*child = &hpackHuffmanNode{}
+			}
+			node = *child
+		}
+		node.symbol = byte(symbol)
+	}
+
+	return root
+}
+
+var errInvalidHPACKHuffman = errors.New("invalid HPACK Huffman string")
+
+// decodeHPACKHuffman decodes a Huffman encoded string, which is padded by the
+// most significant bits of the EOS code (all ones).
+func decodeHPACKHuffman(data []byte) (string, error) {
+	var decoded strings.Builder
+
+	node := hpackHuffmanTree
+	depth := 0 // The number of bits read since the last symbol
+	padded := true
+	for _, b := range data {
+		for bit := 7; bit >= 0; bit-- {
+			value := b >> bit & 1
+			padded = padded && value == 1
+
+			node = node.children[value]
+			depth++
+			if node == nil {
+				return "", errInvalidHPACKHuffman
+			}
+
+			if node.children[0] == nil {
+				decoded.WriteByte(node.symbol)
+				node = hpackHuffmanTree
+				depth = 0
+				padded = true
+			}
+		}
+	}
+
+	if depth > 7 || !padded {
+		return "", errInvalidHPACKHuffman
+	}
+
+	return decoded.String(), nil
+}
+
+// hpackTable is the HPACK dynamic table of the header blocks sent in one
+// direction.
+type hpackTable struct {
+	// entries are oldest first. They're only appended to and evicted from
+	// the front, so snapshots of the table can share them.
+	entries []hpackHeader
+	size    int
+	maxSize int
+
+	// limit is the largest size the table can be updated to, the
+	// SETTINGS_HEADER_TABLE_SIZE of the receiver of the header blocks.
+	limit int
+}
+
+func newHPACKTable() *hpackTable {
+	return &hpackTable{maxSize: HPACK_DEFAULT_TABLE_SIZE, limit: HPACK_DEFAULT_TABLE_SIZE}
+}
+
+// snapshot returns a copy of the table which is updated separately from it.
+// The entries are shared until the copy adds an entry.
+func (t *hpackTable) snapshot() *hpackTable {
+	snapshot := *t
+	snapshot.entries = t.entries[:len(t.entries):len(t.entries)]
+	return &snapshot
+}
+
+func (t *hpackTable) evict() {
+	for t.size > t.maxSize {
+		oldest := t.entries[0]
+		t.size -= len(oldest.name) + len(oldest.value) + HPACK_ENTRY_OVERHEAD
+		t.entries = t.entries[1:]
+	}
+}
+
+func (t *hpackTable) add(header hpackHeader) {
+	t.entries = append(t.entries, header)
+	t.size += len(header.name) + len(header.value) + HPACK_ENTRY_OVERHEAD
+	t.evict()
+}
+
+func (t *hpackTable) lookup(index int) (hpackHeader, error) {
+	switch {
+	case index <= 0:
+		return hpackHeader{}, fmt.Errorf("invalid HPACK index %d", index)
+	case index <= len(hpackStaticTable):
+		return hpackStaticTable[index-1], nil
+	case index-len(hpackStaticTable) <= len(t.entries):
+		return t.entries[len(t.entries)-(index-len(hpackStaticTable))], nil
+	default:
+		return hpackHeader{}, fmt.Errorf("HPACK index %d is out of the table", index)
+	}
+}
+
+// hpackField is a decoded representation of a header block.
+type hpackField struct {
+	header hpackHeader
+	// representation is how the header was encoded, for example "indexed".
+	representation string
+	// isSizeUpdate is set for dynamic table size updates, which have no
+	// header.
+	isSizeUpdate bool
+
+	offset, length int
+}
+
+// readHPACKInteger reads an integer with a prefix of the given number of bits,
+// returning it along with the offset after it.
+func readHPACKInteger(block []byte, offset int, prefixBits int) (int, int, error) {
+	if offset >= len(block) {
+		return 0, 0, fmt.Errorf("truncated HPACK integer at offset %d", offset)
+	}
+
+	maxPrefix := 1<<prefixBits - 1
+	value := int(block[offset]) & maxPrefix
+	offset++
+	if value < maxPrefix {
+		return value, offset, nil
+	}
+
+	for shift := 0; ; shift += 7 {
+		if offset >= len(block) {
+			return 0, 0, fmt.Errorf("truncated HPACK integer at offset %d", offset)
+		}
+		if shift > 28 {
+			return 0, 0, fmt.Errorf("HPACK integer at offset %d is too large", offset)
+		}
+
+		b := block[offset]
+		offset++
+		value += int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, offset, nil
+		}
+	}
+}
+
+func readHPACKString(block []byte, offset int) (string, int, error) {
+	if offset >= len(block) {
+		return "", 0, fmt.Errorf("truncated HPACK string at offset %d", offset)
+	}
+
+	huffman := block[offset]&0x80 != 0
+	length, offset, err := readHPACKInteger(block, offset, 7)
+	if err != nil {
+		return "", 0, err
+	}
+	if length > len(block)-offset {
+		return "", 0, fmt.Errorf("truncated HPACK string at offset %d", offset)
+	}
+
+	data := block[offset : offset+length]
+	if !huffman {
+		return string(data), offset + length, nil
+	}
+
+	decoded, err := decodeHPACKHuffman(data)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w at offset %d", err, offset)
+	}
+
+	return decoded, offset + length, nil
+}
+
+// decode decodes a header block, updating the table by it.
+func (t *hpackTable) decode(block []byte) ([]hpackField, error) {
+	var fields []hpackField
+	for offset := 0; offset < len(block); {
+		field := hpackField{offset: offset}
+		b := block[offset]
+
+		var err error
+		switch {
+		case b&0x80 != 0:
+			var index int
+			index, offset, err = readHPACKInteger(block, offset, 7)
+			if err == nil {
+				field.header, err = t.lookup(index)
+			}
+			field.representation = "indexed"
+		case b&0xe0 == 0x20:
+			var size int
+			size, offset, err = readHPACKInteger(block, offset, 5)
+			if err == nil && size > t.limit {
+				err = fmt.Errorf("HPACK dynamic table size update to %d exceeds the limit of %d", size, t.limit)
+			} else if err == nil {
+				t.maxSize = size
+				t.evict()
+			}
+			field.isSizeUpdate = true
+			field.header.value = fmt.Sprint(size)
+			field.representation = "dynamic table size update"
+		default:
+			prefixBits := 4
+			field.representation = "literal without indexing"
+			if b&0xc0 == 0x40 {
+				prefixBits = 6
+				field.representation = "literal with incremental indexing"
+			} else if b&0xf0 == 0x10 {
+				field.representation = "literal never indexed"
+			}
+
+			var index int
+			index, offset, err = readHPACKInteger(block, offset, prefixBits)
+			if err == nil && index == 0 {
+				field.header.name, offset, err = readHPACKString(block, offset)
+			} else if err == nil {
+				var indexed hpackHeader
+				indexed, err = t.lookup(index)
+				field.header.name = indexed.name
+			}
+			if err == nil {
+				field.header.value, offset, err = readHPACKString(block, offset)
+			}
+			if err == nil && prefixBits == 6 {
+				t.add(field.header)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		field.length = offset - field.offset
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
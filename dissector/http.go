@@ -0,0 +1,451 @@
+package dissector
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// HTTP_MAX_HEAD_LENGTH is the maximal length of the start line and the headers
+// of an HTTP message. Longer heads fail the framing.
+const HTTP_MAX_HEAD_LENGTH = 1 << 16
+
+var httpMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
+var httpHeadTerminator = []byte("\r\n\r\n")
+
+type HTTPDissector struct{}
+
+func (HTTPDissector) Name() string {
+	return "http"
+}
+
+func (HTTPDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	switch direction {
+	case tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER:
+		for _, method := range httpMethods {
+			if bytes.HasPrefix(data, []byte(method+" ")) {
+				return true
+			}
+		}
+		return false
+	case tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT:
+		return bytes.HasPrefix(data, []byte("HTTP/1."))
+	default:
+		panic("Invalid direction")
+	}
+}
+
+func (HTTPDissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT {
+		return isShorterPrefix(data, "HTTP/1.")
+	}
+
+	for _, method := range httpMethods {
+		if isShorterPrefix(data, method+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (HTTPDissector) NewSession() Session {
+	return &httpSession{}
+}
+
+type httpHeader struct {
+	name  string
+	value string
+
+	// offset and length are the range of the header line in the message.
+	offset int
+	length int
+}
+
+// httpHead is the start line and the headers of an HTTP message.
+type httpHead struct {
+	startLine []string // The start line split to its 3 parts
+	headers   []httpHeader
+
+	// length is the length of the head, including the terminating empty line.
+	length int
+}
+
+func (head *httpHead) isResponse() bool {
+	return strings.HasPrefix(head.startLine[0], "HTTP/")
+}
+
+// Header returns the value of the first header with the given name.
+func (head *httpHead) Header(name string) (string, bool) {
+	for _, header := range head.headers {
+		if strings.EqualFold(header.name, name) {
+			return header.value, true
+		}
+	}
+
+	return "", false
+}
+
+func (head *httpHead) isChunked() bool {
+	transferEncoding, _ := head.Header("Transfer-Encoding")
+	return strings.Contains(strings.ToLower(transferEncoding), "chunked")
+}
+
+// contentLength returns the value of the Content-Length header, -1 if there's
+// none.
+func (head *httpHead) contentLength() (int, error) {
+	value, ok := head.Header("Content-Length")
+	if !ok {
+		return -1, nil
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || length < 0 {
+		return 0, fmt.Errorf("invalid Content-Length %q", value)
+	}
+
+	return length, nil
+}
+
+// statusCode returns the status code of a response.
+func (head *httpHead) statusCode() (int, error) {
+	code, err := strconv.Atoi(head.startLine[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid status code %q", head.startLine[1])
+	}
+
+	return code, nil
+}
+
+// parseHTTPHead parses the head at the beginning of data. It returns nil if
+// the head isn't complete.
+func parseHTTPHead(data []byte) (*httpHead, error) {
+	headEnd := bytes.Index(data, httpHeadTerminator)
+	if headEnd == -1 {
+		if len(data) > HTTP_MAX_HEAD_LENGTH {
+			return nil, fmt.Errorf("HTTP head is longer than %d bytes", HTTP_MAX_HEAD_LENGTH)
+		}
+
+		return nil, nil
+	}
+
+	head := &httpHead{length: headEnd + len(httpHeadTerminator)}
+	lines := strings.Split(string(data[:headEnd]), "\r\n")
+
+	head.startLine = strings.SplitN(lines[0], " ", 3)
+	if len(head.startLine) < 2 {
+		return nil, fmt.Errorf("invalid HTTP start line %q", lines[0])
+	}
+	if len(head.startLine) == 2 { // A response without a reason phrase
+		head.startLine = append(head.startLine, "")
+	}
+
+	offset := len(lines[0]) + len("\r\n")
+	for _, line := range lines[1:] {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid HTTP header %q", line)
+		}
+
+		head.headers = append(head.headers, httpHeader{
+			name:   name,
+			value:  strings.TrimSpace(value),
+			offset: offset,
+			length: len(line),
+		})
+		offset += len(line) + len("\r\n")
+	}
+
+	return head, nil
+}
+
+// chunkedBodyLength returns the length of the chunked body at the beginning
+// of data, including the trailers, and the dechunked body. It returns -1 if
+// the body isn't complete.
+func chunkedBodyLength(data []byte) (int, []byte, error) {
+	var body []byte
+	offset := 0
+
+	for {
+		lineEnd := bytes.Index(data[offset:], []byte("\r\n"))
+		if lineEnd == -1 {
+			return -1, nil, nil
+		}
+
+		sizeText, _, _ := strings.Cut(string(data[offset:offset+lineEnd]), ";") // Ignore chunk extensions
+		size, err := strconv.ParseUint(strings.TrimSpace(sizeText), 16, 31)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid chunk size %q", sizeText)
+		}
+		offset += lineEnd + len("\r\n")
+
+		if size == 0 {
+			break
+		}
+
+		chunkEnd := offset + int(size)
+		if chunkEnd+len("\r\n") > len(data) {
+			return -1, nil, nil
+		}
+
+		if !bytes.HasPrefix(data[chunkEnd:], []byte("\r\n")) {
+			return 0, nil, fmt.Errorf("chunk of size %d isn't followed by CRLF", size)
+		}
+
+		body = append(body, data[offset:chunkEnd]...)
+		offset = chunkEnd + len("\r\n")
+	}
+
+	// Skip the trailers, terminated by an empty line
+	for {
+		lineEnd := bytes.Index(data[offset:], []byte("\r\n"))
+		if lineEnd == -1 {
+			return -1, nil, nil
+		}
+
+		offset += lineEnd + len("\r\n")
+		if lineEnd == 0 {
+			return offset, body, nil
+		}
+	}
+}
+
+type httpSession struct {
+	// requestMethods holds the methods of the requests which weren't answered
+	// yet, as the framing of a response depends on its request's method.
+	requestMethods []string
+
+	// bodyUntilClose is set when the body of the last response lasts until
+	// the connection is closed.
+	bodyUntilClose bool
+
+	// tunnel is set once the connection no longer speaks HTTP, after a
+	// protocol switch or a CONNECT.
+	tunnel bool
+
+	// webSocket frames the connection once it's upgraded to WebSocket.
+	webSocket *webSocketSession
+
+	// http2 frames the connection once it's upgraded to h2c.
+	http2 *http2Session
+}
+
+func (s *httpSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	if s.webSocket != nil {
+		return s.webSocket.Frame(direction, data)
+	}
+
+	if s.http2 != nil {
+		return s.http2.Frame(direction, data)
+	}
+
+	if s.tunnel || (s.bodyUntilClose && direction == tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT) {
+		return len(data), nil, nil
+	}
+
+	head, err := parseHTTPHead(data)
+	if err != nil || head == nil {
+		return 0, nil, err
+	}
+
+	var bodyLength int
+	if head.isResponse() {
+		bodyLength, err = s.responseBodyLength(head, data)
+	} else {
+		bodyLength, err = s.requestBodyLength(head, data)
+	}
+
+	if err != nil || bodyLength == -1 {
+		return 0, nil, err
+	}
+
+	return head.length + bodyLength, HTTPDecoder{}, nil
+}
+
+// bodyLength returns the length of the body following head in data, -1 if
+// it's not complete, or if it has no length, the length of the rest of data.
+func bodyLength(head *httpHead, data []byte) (length int, untilClose bool, err error) {
+	if head.isChunked() {
+		length, _, err := chunkedBodyLength(data[head.length:])
+		return length, false, err
+	}
+
+	contentLength, err := head.contentLength()
+	if err != nil {
+		return 0, false, err
+	}
+
+	if contentLength == -1 {
+		return len(data) - head.length, true, nil
+	}
+
+	if head.length+contentLength > len(data) {
+		return -1, false, nil
+	}
+
+	return contentLength, false, nil
+}
+
+func (s *httpSession) requestBodyLength(head *httpHead, data []byte) (int, error) {
+	length, untilClose, err := bodyLength(head, data)
+	if err != nil || length == -1 {
+		return length, err
+	}
+
+	if untilClose { // Requests without a length have no body
+		length = 0
+	}
+
+	s.requestMethods = append(s.requestMethods, head.startLine[0])
+
+	return length, nil
+}
+
+func (s *httpSession) responseBodyLength(head *httpHead, data []byte) (int, error) {
+	statusCode, err := head.statusCode()
+	if err != nil {
+		return 0, err
+	}
+
+	if statusCode == 101 { // Switching Protocols
+		upgrade, _ := head.Header("Upgrade")
+		switch strings.ToLower(strings.TrimSpace(upgrade)) {
+		case "websocket":
+			extensions, _ := head.Header("Sec-WebSocket-Extensions")
+			s.webSocket = newWebSocketSession(parseWebSocketExtensions(extensions))
+		case "h2c":
+			s.http2 = newHTTP2Session()
+		default:
+			s.tunnel = true
+		}
+		return 0, nil
+	}
+
+	if 100 <= statusCode && statusCode < 200 { // Informational, the final response is yet to come
+		return 0, nil
+	}
+
+	var requestMethod string
+	if len(s.requestMethods) > 0 {
+		requestMethod = s.requestMethods[0]
+	}
+
+	length := 0
+	switch {
+	case requestMethod == "CONNECT" && statusCode < 300:
+		s.tunnel = true
+	case requestMethod == "HEAD" || statusCode == 204 || statusCode == 304:
+		// No body
+	default:
+		var untilClose bool
+		length, untilClose, err = bodyLength(head, data)
+		if err != nil || length == -1 {
+			return length, err
+		}
+
+		s.bodyUntilClose = untilClose
+	}
+
+	if len(s.requestMethods) > 0 {
+		s.requestMethods = s.requestMethods[1:]
+	}
+
+	return length, nil
+}
+
+// HTTPDecoder decodes HTTP/1.x requests and responses.
+type HTTPDecoder struct{}
+
+func (HTTPDecoder) Name() string {
+	return "http"
+}
+
+// DescribeBody returns body as text if it's printable, and a description of
+// it otherwise.
+func DescribeBody(body []byte) string {
+	if !utf8.Valid(body) {
+		return fmt.Sprintf("<%d bytes of binary data>", len(body))
+	}
+
+	for _, char := range string(body) {
+		if char < ' ' && char != '\n' && char != '\r' && char != '\t' {
+			return fmt.Sprintf("<%d bytes of binary data>", len(body))
+		}
+	}
+
+	return string(body)
+}
+
+func (HTTPDecoder) Decode(content []byte) (*Dissection, error) {
+	head, err := parseHTTPHead(content)
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		return nil, fmt.Errorf("incomplete HTTP head")
+	}
+
+	dissection := &Dissection{}
+	startLineLength := len(strings.Join(head.startLine, " "))
+
+	if head.isResponse() {
+		dissection.Summary = head.startLine[1] + " " + head.startLine[2]
+		dissection.Fields = append(dissection.Fields, &Field{
+			Name: "Status line", Length: startLineLength,
+			Children: []*Field{
+				{Name: "Version", Value: head.startLine[0]},
+				{Name: "Status", Value: head.startLine[1]},
+				{Name: "Reason", Value: head.startLine[2]},
+			},
+		})
+	} else {
+		dissection.Summary = head.startLine[0] + " " + head.startLine[1]
+		dissection.Fields = append(dissection.Fields, &Field{
+			Name: "Request line", Length: startLineLength,
+			Children: []*Field{
+				{Name: "Method", Value: head.startLine[0]},
+				{Name: "Target", Value: head.startLine[1]},
+				{Name: "Version", Value: head.startLine[2]},
+			},
+		})
+	}
+
+	headers := &Field{Name: "Headers", Offset: startLineLength + len("\r\n"), Length: head.length - startLineLength - len("\r\n")}
+	for _, header := range head.headers {
+		headers.Children = append(headers.Children, &Field{
+			Name:   header.name,
+			Value:  header.value,
+			Offset: header.offset,
+			Length: header.length,
+		})
+	}
+	dissection.Fields = append(dissection.Fields, headers)
+
+	rawBody := content[head.length:]
+	body := rawBody
+	if head.isChunked() {
+		length, dechunked, err := chunkedBodyLength(rawBody)
+		if err != nil {
+			return nil, err
+		}
+		if length != -1 {
+			body = dechunked
+		}
+	}
+
+	if len(body) > 0 {
+		dissection.Fields = append(dissection.Fields, &Field{
+			Name:   fmt.Sprintf("Body (%d bytes)", len(body)),
+			Value:  DescribeBody(body),
+			Offset: head.length,
+			Length: len(rawBody),
+		})
+	}
+
+	return dissection, nil
+}
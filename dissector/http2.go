@@ -0,0 +1,717 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// HTTP2_PREFACE is the connection preface sent by HTTP/2 clients before their
+// frames.
+const HTTP2_PREFACE = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const HTTP2_FRAME_HEADER_LENGTH = 9
+
+// HTTP2_DEFAULT_MAX_FRAME_SIZE is the maximal frame payload length a peer
+// accepts until it sets SETTINGS_MAX_FRAME_SIZE.
+const HTTP2_DEFAULT_MAX_FRAME_SIZE = 1 << 14
+
+const (
+	HTTP2_FRAME_DATA          = 0x0
+	HTTP2_FRAME_HEADERS       = 0x1
+	HTTP2_FRAME_PRIORITY      = 0x2
+	HTTP2_FRAME_RST_STREAM    = 0x3
+	HTTP2_FRAME_SETTINGS      = 0x4
+	HTTP2_FRAME_PUSH_PROMISE  = 0x5
+	HTTP2_FRAME_PING          = 0x6
+	HTTP2_FRAME_GOAWAY        = 0x7
+	HTTP2_FRAME_WINDOW_UPDATE = 0x8
+	HTTP2_FRAME_CONTINUATION  = 0x9
+)
+
+var http2FrameTypeNames = map[byte]string{
+	HTTP2_FRAME_DATA:          "DATA",
+	HTTP2_FRAME_HEADERS:       "HEADERS",
+	HTTP2_FRAME_PRIORITY:      "PRIORITY",
+	HTTP2_FRAME_RST_STREAM:    "RST_STREAM",
+	HTTP2_FRAME_SETTINGS:      "SETTINGS",
+	HTTP2_FRAME_PUSH_PROMISE:  "PUSH_PROMISE",
+	HTTP2_FRAME_PING:          "PING",
+	HTTP2_FRAME_GOAWAY:        "GOAWAY",
+	HTTP2_FRAME_WINDOW_UPDATE: "WINDOW_UPDATE",
+	HTTP2_FRAME_CONTINUATION:  "CONTINUATION",
+	0x10:                      "PRIORITY_UPDATE",
+}
+
+var http2FrameTypeEnum = fieldEnum(http2FrameTypeNames)
+
+func http2FrameTypeName(frameType byte) string {
+	name, ok := http2FrameTypeNames[frameType]
+	if !ok {
+		return fmt.Sprintf("UNKNOWN(0x%02x)", frameType)
+	}
+
+	return name
+}
+
+const (
+	HTTP2_FLAG_END_STREAM  = 0x1
+	HTTP2_FLAG_ACK         = 0x1
+	HTTP2_FLAG_END_HEADERS = 0x4
+	HTTP2_FLAG_PADDED      = 0x8
+	HTTP2_FLAG_PRIORITY    = 0x20
+)
+
+// http2FlagNames returns the names of the flags, which depend on the type of
+// the frame.
+func http2FlagNames(frameType, flags byte) []string {
+	var names []string
+	switch frameType {
+	case HTTP2_FRAME_SETTINGS, HTTP2_FRAME_PING:
+		if flags&HTTP2_FLAG_ACK != 0 {
+			names = append(names, "ACK")
+		}
+	case HTTP2_FRAME_DATA, HTTP2_FRAME_HEADERS, HTTP2_FRAME_PUSH_PROMISE, HTTP2_FRAME_CONTINUATION:
+		if flags&HTTP2_FLAG_END_STREAM != 0 && frameType != HTTP2_FRAME_PUSH_PROMISE && frameType != HTTP2_FRAME_CONTINUATION {
+			names = append(names, "END_STREAM")
+		}
+		if flags&HTTP2_FLAG_END_HEADERS != 0 && frameType != HTTP2_FRAME_DATA {
+			names = append(names, "END_HEADERS")
+		}
+		if flags&HTTP2_FLAG_PADDED != 0 && frameType != HTTP2_FRAME_CONTINUATION {
+			names = append(names, "PADDED")
+		}
+		if flags&HTTP2_FLAG_PRIORITY != 0 && frameType == HTTP2_FRAME_HEADERS {
+			names = append(names, "PRIORITY")
+		}
+	}
+
+	return names
+}
+
+var http2SettingNames = map[uint16]string{
+	0x1: "SETTINGS_HEADER_TABLE_SIZE",
+	0x2: "SETTINGS_ENABLE_PUSH",
+	0x3: "SETTINGS_MAX_CONCURRENT_STREAMS",
+	0x4: "SETTINGS_INITIAL_WINDOW_SIZE",
+	0x5: "SETTINGS_MAX_FRAME_SIZE",
+	0x6: "SETTINGS_MAX_HEADER_LIST_SIZE",
+	0x8: "SETTINGS_ENABLE_CONNECT_PROTOCOL",
+	0x9: "SETTINGS_NO_RFC7540_PRIORITIES",
+}
+
+const (
+	HTTP2_SETTINGS_HEADER_TABLE_SIZE = 0x1
+	HTTP2_SETTINGS_MAX_FRAME_SIZE    = 0x5
+)
+
+var http2ErrorCodeNames = []string{
+	"NO_ERROR",
+	"PROTOCOL_ERROR",
+	"INTERNAL_ERROR",
+	"FLOW_CONTROL_ERROR",
+	"SETTINGS_TIMEOUT",
+	"STREAM_CLOSED",
+	"FRAME_SIZE_ERROR",
+	"REFUSED_STREAM",
+	"CANCEL",
+	"COMPRESSION_ERROR",
+	"CONNECT_ERROR",
+	"ENHANCE_YOUR_CALM",
+	"INADEQUATE_SECURITY",
+	"HTTP_1_1_REQUIRED",
+}
+
+func http2ErrorCodeName(code uint32) string {
+	if int(code) < len(http2ErrorCodeNames) {
+		return http2ErrorCodeNames[code]
+	}
+
+	return fmt.Sprintf("0x%x", code)
+}
+
+// HTTP2Dissector dissects HTTP/2 connections with prior knowledge, which
+// begin with the connection preface. Connections upgraded from HTTP/1.1 to
+// h2c are dissected by HTTPDissector.
+type HTTP2Dissector struct{}
+
+func (HTTP2Dissector) Name() string {
+	return "http2"
+}
+
+func (HTTP2Dissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	return direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER && bytes.HasPrefix(data, []byte(HTTP2_PREFACE[:len("PRI * HTTP/2.0")]))
+}
+
+func (HTTP2Dissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	return direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER && isShorterPrefix(data, HTTP2_PREFACE[:len("PRI * HTTP/2.0")])
+}
+
+func (HTTP2Dissector) NewSession() Session {
+	return newHTTP2Session()
+}
+
+type http2FrameHeader struct {
+	length    int
+	frameType byte
+	flags     byte
+	stream    uint32
+}
+
+func parseHTTP2FrameHeader(data []byte) http2FrameHeader {
+	return http2FrameHeader{
+		length:    int(binary.BigEndian.Uint32(data) >> 8),
+		frameType: data[3],
+		flags:     data[4],
+		stream:    binary.BigEndian.Uint32(data[5:]) & 0x7fffffff,
+	}
+}
+
+func (header http2FrameHeader) payload(frame []byte) []byte {
+	return frame[HTTP2_FRAME_HEADER_LENGTH : HTTP2_FRAME_HEADER_LENGTH+header.length]
+}
+
+type http2Session struct {
+	// prefaceReceived is set once the client connection preface was framed.
+	prefaceReceived bool
+
+	// tables are the HPACK dynamic tables of the header blocks sent in each
+	// direction.
+	tables map[tcpmessage.TransmittionDirection]*hpackTable
+
+	// maxFrameSizes are the maximal payload lengths of the frames sent in
+	// each direction, as set by the SETTINGS of their receiver.
+	maxFrameSizes map[tcpmessage.TransmittionDirection]int
+
+	// paths are the :path of the requests of the open streams.
+	paths map[uint32]string
+}
+
+func newHTTP2Session() *http2Session {
+	return &http2Session{
+		tables: map[tcpmessage.TransmittionDirection]*hpackTable{
+			tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER: newHPACKTable(),
+			tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT: newHPACKTable(),
+		},
+		maxFrameSizes: map[tcpmessage.TransmittionDirection]int{
+			tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER: HTTP2_DEFAULT_MAX_FRAME_SIZE,
+			tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT: HTTP2_DEFAULT_MAX_FRAME_SIZE,
+		},
+		paths: make(map[uint32]string),
+	}
+}
+
+func oppositeDirection(direction tcpmessage.TransmittionDirection) tcpmessage.TransmittionDirection {
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+		return tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT
+	}
+
+	return tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER
+}
+
+// frameLength returns the length of the frame at the beginning of data,
+// including the CONTINUATION frames of a header block, or 0 if it's not
+// complete.
+func (s *http2Session) frameLength(data []byte) (int, error) {
+	length := 0
+	for {
+		if len(data)-length < HTTP2_FRAME_HEADER_LENGTH {
+			return 0, nil
+		}
+
+		header := parseHTTP2FrameHeader(data[length:])
+		if length > 0 && header.frameType != HTTP2_FRAME_CONTINUATION {
+			return 0, fmt.Errorf("expected a CONTINUATION frame, got %s", http2FrameTypeName(header.frameType))
+		}
+
+		length += HTTP2_FRAME_HEADER_LENGTH + header.length
+		if length > len(data) {
+			return 0, nil
+		}
+
+		hasHeaderBlock := header.frameType == HTTP2_FRAME_HEADERS || header.frameType == HTTP2_FRAME_PUSH_PROMISE || header.frameType == HTTP2_FRAME_CONTINUATION
+		if !hasHeaderBlock || header.flags&HTTP2_FLAG_END_HEADERS != 0 {
+			return length, nil
+		}
+	}
+}
+
+func (s *http2Session) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER && !s.prefaceReceived {
+		if len(data) < len(HTTP2_PREFACE) {
+			if !strings.HasPrefix(HTTP2_PREFACE, string(data)) {
+				return 0, nil, fmt.Errorf("invalid HTTP/2 connection preface")
+			}
+
+			return 0, nil, nil
+		}
+
+		if string(data[:len(HTTP2_PREFACE)]) != HTTP2_PREFACE {
+			return 0, nil, fmt.Errorf("invalid HTTP/2 connection preface")
+		}
+
+		s.prefaceReceived = true
+		return len(HTTP2_PREFACE), HTTP2Decoder{}, nil
+	}
+
+	length, err := s.frameLength(data)
+	if err != nil || length == 0 {
+		return 0, nil, err
+	}
+
+	header := parseHTTP2FrameHeader(data)
+	if header.padLength(data) == 1 && header.length < 1 {
+		return 0, nil, fmt.Errorf("the padded %s frame has no pad length", http2FrameTypeName(header.frameType))
+	}
+
+	decoder := HTTP2Decoder{
+		path:         s.paths[header.stream],
+		maxFrameSize: s.maxFrameSizes[direction],
+	}
+
+	switch header.frameType {
+	case HTTP2_FRAME_HEADERS, HTTP2_FRAME_PUSH_PROMISE:
+		decoder.table = s.tables[direction].snapshot()
+
+		// The dissection of the frame reports decoding errors. A valid header
+		// block of a PUSH_PROMISE frame follows the promised stream ID.
+		block, _, err := readHTTP2HeaderBlock(data[:length])
+		fields, _ := s.tables[direction].decode(block)
+		if header.frameType == HTTP2_FRAME_PUSH_PROMISE && err == nil {
+			promisedStream := binary.BigEndian.Uint32(header.payload(data)[header.padLength(data):]) & 0x7fffffff
+			s.paths[promisedStream] = hpackPath(fields)
+		} else if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+			s.paths[header.stream] = hpackPath(fields)
+			decoder.path = s.paths[header.stream]
+		}
+	case HTTP2_FRAME_SETTINGS:
+		if header.flags&HTTP2_FLAG_ACK == 0 {
+			payload := header.payload(data)
+			for i := 0; i+6 <= len(payload); i += 6 {
+				// The sender of the settings receives the frames of the
+				// opposite direction
+				value := int(binary.BigEndian.Uint32(payload[i+2:]))
+				switch binary.BigEndian.Uint16(payload[i:]) {
+				case HTTP2_SETTINGS_MAX_FRAME_SIZE:
+					s.maxFrameSizes[oppositeDirection(direction)] = value
+				case HTTP2_SETTINGS_HEADER_TABLE_SIZE:
+					s.tables[oppositeDirection(direction)].limit = value
+				}
+			}
+		}
+	case HTTP2_FRAME_RST_STREAM:
+		delete(s.paths, header.stream)
+	}
+
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT && header.flags&HTTP2_FLAG_END_STREAM != 0 &&
+		(header.frameType == HTTP2_FRAME_DATA || header.frameType == HTTP2_FRAME_HEADERS) {
+		delete(s.paths, header.stream)
+	}
+
+	return length, decoder, nil
+}
+
+// padLength returns the length of the pad length field of the frame, which
+// is 1 if it's padded.
+func (header http2FrameHeader) padLength(frame []byte) int {
+	padded := header.frameType == HTTP2_FRAME_DATA || header.frameType == HTTP2_FRAME_HEADERS || header.frameType == HTTP2_FRAME_PUSH_PROMISE
+	if padded && header.flags&HTTP2_FLAG_PADDED != 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// http2Fragment is the part of a header block in a frame, at offset of the
+// frames.
+type http2Fragment struct {
+	offset, length int
+}
+
+// readHTTP2HeaderBlock returns the header block of a HEADERS or PUSH_PROMISE
+// frame and its CONTINUATION frames, along with the fragments of which it
+// consists.
+func readHTTP2HeaderBlock(frames []byte) ([]byte, []http2Fragment, error) {
+	var block []byte
+	var fragments []http2Fragment
+	for offset := 0; offset < len(frames); {
+		header := parseHTTP2FrameHeader(frames[offset:])
+		payload := header.payload(frames[offset:])
+
+		begin := 0
+		end := len(payload)
+		if header.padLength(frames[offset:]) == 1 {
+			if len(payload) == 0 || int(payload[0]) >= len(payload) {
+				return nil, nil, fmt.Errorf("invalid padding of the %s frame at offset %d", http2FrameTypeName(header.frameType), offset)
+			}
+
+			begin = 1
+			end -= int(payload[0])
+		}
+
+		switch {
+		case header.frameType == HTTP2_FRAME_HEADERS && header.flags&HTTP2_FLAG_PRIORITY != 0:
+			begin += 5
+		case header.frameType == HTTP2_FRAME_PUSH_PROMISE:
+			begin += 4
+		}
+		if begin > end {
+			return nil, nil, fmt.Errorf("the %s frame at offset %d is too short", http2FrameTypeName(header.frameType), offset)
+		}
+
+		block = append(block, payload[begin:end]...)
+		fragments = append(fragments, http2Fragment{offset + HTTP2_FRAME_HEADER_LENGTH + begin, end - begin})
+		offset += HTTP2_FRAME_HEADER_LENGTH + header.length
+	}
+
+	return block, fragments, nil
+}
+
+// contentOffset maps an offset in a header block to its offset in the frames.
+func contentOffset(fragments []http2Fragment, blockOffset int) int {
+	for _, fragment := range fragments {
+		if blockOffset < fragment.length {
+			return fragment.offset + blockOffset
+		}
+
+		blockOffset -= fragment.length
+	}
+
+	if len(fragments) == 0 {
+		return 0
+	}
+
+	last := fragments[len(fragments)-1]
+	return last.offset + last.length + blockOffset
+}
+
+func hpackPath(fields []hpackField) string {
+	for _, field := range fields {
+		if field.header.name == ":path" {
+			return field.header.value
+		}
+	}
+
+	return ""
+}
+
+// HTTP2Decoder decodes the connection preface and HTTP/2 frames. HEADERS and
+// PUSH_PROMISE frames are decoded along with their CONTINUATION frames.
+type HTTP2Decoder struct {
+	// table is the HPACK dynamic table before the header block of the frame,
+	// nil for frames without a header block.
+	table *hpackTable
+
+	// path is the :path of the request of the frame's stream.
+	path string
+
+	// maxFrameSize is the maximal payload length the receiver of the frame
+	// accepts.
+	maxFrameSize int
+}
+
+func (HTTP2Decoder) Name() string {
+	return "http2"
+}
+
+// Path returns the :path of the request of the frame's stream, or an empty
+// string if it's unknown.
+func (d HTTP2Decoder) Path() string {
+	return d.path
+}
+
+func (HTTP2Decoder) Stream(content []byte) (uint32, error) {
+	if bytes.Equal(content, []byte(HTTP2_PREFACE)) {
+		return 0, nil
+	}
+	if len(content) < HTTP2_FRAME_HEADER_LENGTH {
+		return 0, fmt.Errorf("HTTP/2 frame is too short")
+	}
+
+	return parseHTTP2FrameHeader(content).stream, nil
+}
+
+func (d HTTP2Decoder) Decode(content []byte) (*Dissection, error) {
+	if bytes.Equal(content, []byte(HTTP2_PREFACE)) {
+		return &Dissection{
+			Summary: "Connection preface",
+			Fields:  []*Field{{Name: "Preface", Value: fmt.Sprintf("%q", content), Length: len(content)}},
+		}, nil
+	}
+
+	var fields []*Field
+	var first http2FrameHeader
+	for offset := 0; offset < len(content); {
+		if len(content)-offset < HTTP2_FRAME_HEADER_LENGTH {
+			return nil, fmt.Errorf("truncated HTTP/2 frame header at offset %d", offset)
+		}
+
+		header := parseHTTP2FrameHeader(content[offset:])
+		if HTTP2_FRAME_HEADER_LENGTH+header.length > len(content)-offset {
+			return nil, fmt.Errorf("truncated HTTP/2 frame at offset %d", offset)
+		}
+		if offset == 0 {
+			first = header
+		}
+
+		frame, err := decodeHTTP2Frame(content[offset:offset+HTTP2_FRAME_HEADER_LENGTH+header.length], header)
+		if err != nil {
+			return nil, err
+		}
+
+		offsetFields([]*Field{frame}, offset)
+		fields = append(fields, frame)
+		offset += HTTP2_FRAME_HEADER_LENGTH + header.length
+	}
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty HTTP/2 frame")
+	}
+
+	summary := http2FrameTypeName(first.frameType)
+	if first.stream != 0 {
+		summary = fmt.Sprintf("stream %d: %s", first.stream, summary)
+	}
+
+	switch first.frameType {
+	case HTTP2_FRAME_HEADERS, HTTP2_FRAME_PUSH_PROMISE:
+		if d.table == nil {
+			return nil, fmt.Errorf("the header block can't be decoded without the HPACK state")
+		}
+
+		block, fragments, err := readHTTP2HeaderBlock(content)
+		if err != nil {
+			return nil, err
+		}
+
+		// Decoding a snapshot keeps the decoder reusable
+		hpackFields, err := d.table.snapshot().decode(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the header block: %w", err)
+		}
+
+		var headers []*Field
+		var pseudoHeaders []string
+		for _, hpackField := range hpackFields {
+			field := &Field{
+				Name:   hpackField.header.name,
+				Value:  hpackField.header.value,
+				Offset: contentOffset(fragments, hpackField.offset),
+			}
+			field.Length = contentOffset(fragments, hpackField.offset+hpackField.length-1) + 1 - field.Offset
+			if hpackField.isSizeUpdate {
+				field.Name = "Dynamic table size update"
+			} else {
+				field.Children = []*Field{{Name: "Representation", Value: hpackField.representation, Offset: field.Offset, Length: field.Length}}
+			}
+			headers = append(headers, field)
+
+			switch hpackField.header.name {
+			case ":method", ":path", ":status":
+				pseudoHeaders = append(pseudoHeaders, hpackField.header.value)
+			}
+		}
+		fields = append(fields, groupField(fmt.Sprintf("Headers (%d)", len(headers)), headers))
+
+		if len(pseudoHeaders) > 0 {
+			summary += " " + strings.Join(pseudoHeaders, " ")
+		}
+	case HTTP2_FRAME_DATA:
+		payload, err := d.Payload(content)
+		if err != nil {
+			return nil, err
+		}
+
+		summary += fmt.Sprintf(" %d bytes", len(payload))
+	case HTTP2_FRAME_RST_STREAM, HTTP2_FRAME_GOAWAY:
+		summary += " " + childValue(fields[0], "Error code")
+	case HTTP2_FRAME_WINDOW_UPDATE:
+		summary += " " + childValue(fields[0], "Window size increment")
+	}
+
+	for _, flag := range http2FlagNames(first.frameType, first.flags) {
+		if flag == "END_STREAM" || flag == "ACK" {
+			summary += " " + flag
+		}
+	}
+
+	return &Dissection{Summary: summary, Fields: fields}, nil
+}
+
+func childValue(field *Field, name string) string {
+	for _, child := range field.Children {
+		if child.Name == name {
+			return child.Value
+		}
+	}
+
+	return ""
+}
+
+// decodeHTTP2Frame decodes a single frame, except for its header block.
+func decodeHTTP2Frame(frame []byte, header http2FrameHeader) (*Field, error) {
+	r := newReader(frame, binary.BigEndian)
+
+	begin := r.offset
+	r.read(3)
+	children := []*Field{r.field("Length", header.length, begin)}
+
+	begin = r.offset
+	r.byte()
+	typeField := r.field("Type", http2FrameTypeName(header.frameType), begin)
+	typeField.Type = r.integerType(1, false, http2FrameTypeEnum)
+	children = append(children, typeField)
+
+	begin = r.offset
+	flags := fmt.Sprintf("0x%02x", r.byte())
+	if names := http2FlagNames(header.frameType, header.flags); len(names) > 0 {
+		flags += " " + strings.Join(names, " ")
+	}
+	children = append(children, r.field("Flags", flags, begin))
+
+	begin = r.offset
+	r.uint32()
+	children = append(children, r.field("Stream ID", header.stream, begin))
+
+	padding := 0
+	if header.padLength(frame) == 1 {
+		if header.length < 1 {
+			return nil, fmt.Errorf("the padded %s frame has no pad length", http2FrameTypeName(header.frameType))
+		}
+
+		padLength := r.byteField("Pad length")
+		children = append(children, padLength)
+		padding = int(frame[padLength.Offset])
+		if padding >= header.length {
+			return nil, fmt.Errorf("the padding of the %s frame is longer than it", http2FrameTypeName(header.frameType))
+		}
+	}
+	end := len(frame) - padding
+
+	switch header.frameType {
+	case HTTP2_FRAME_DATA:
+		begin := r.offset
+		data := r.read(end - r.offset)
+		dataField := r.field("Data", DescribeBody(data), begin)
+		dataField.Payload = true
+		children = append(children, dataField)
+	case HTTP2_FRAME_HEADERS, HTTP2_FRAME_PRIORITY:
+		if header.frameType == HTTP2_FRAME_PRIORITY || header.flags&HTTP2_FLAG_PRIORITY != 0 {
+			begin := r.offset
+			dependency := r.uint32()
+			children = append(children,
+				r.field("Exclusive", dependency&0x80000000 != 0, begin),
+				r.field("Stream dependency", dependency&0x7fffffff, begin))
+
+			begin = r.offset
+			children = append(children, r.field("Weight", int(r.byte())+1, begin))
+		}
+		if header.frameType == HTTP2_FRAME_HEADERS {
+			children = append(children, &Field{Name: "Header block fragment", Value: fmt.Sprintf("<%d bytes>", end-r.offset), Offset: r.offset, Length: end - r.offset})
+		}
+	case HTTP2_FRAME_RST_STREAM:
+		begin := r.offset
+		children = append(children, r.field("Error code", http2ErrorCodeName(r.uint32()), begin))
+	case HTTP2_FRAME_SETTINGS:
+		var settings []*Field
+		for r.err == nil && r.offset < end {
+			begin := r.offset
+			identifier := r.uint16()
+			name, ok := http2SettingNames[identifier]
+			if !ok {
+				name = fmt.Sprintf("0x%x", identifier)
+			}
+
+			settings = append(settings, r.field(name, r.uint32(), begin))
+		}
+		children = append(children, groupField(fmt.Sprintf("Settings (%d)", len(settings)), settings))
+	case HTTP2_FRAME_PUSH_PROMISE:
+		begin := r.offset
+		children = append(children, r.field("Promised stream ID", r.uint32()&0x7fffffff, begin))
+		children = append(children, &Field{Name: "Header block fragment", Value: fmt.Sprintf("<%d bytes>", end-r.offset), Offset: r.offset, Length: end - r.offset})
+	case HTTP2_FRAME_PING:
+		begin := r.offset
+		children = append(children, r.field("Opaque data", fmt.Sprintf("%x", r.read(8)), begin))
+	case HTTP2_FRAME_GOAWAY:
+		begin := r.offset
+		children = append(children, r.field("Last stream ID", r.uint32()&0x7fffffff, begin))
+
+		begin = r.offset
+		children = append(children, r.field("Error code", http2ErrorCodeName(r.uint32()), begin))
+
+		begin = r.offset
+		children = append(children, r.field("Debug data", DescribeBody(r.read(end-r.offset)), begin))
+	case HTTP2_FRAME_WINDOW_UPDATE:
+		begin := r.offset
+		children = append(children, r.field("Window size increment", fmt.Sprintf("+%d", r.uint32()&0x7fffffff), begin))
+	case HTTP2_FRAME_CONTINUATION:
+		children = append(children, &Field{Name: "Header block fragment", Value: fmt.Sprintf("<%d bytes>", end-r.offset), Offset: r.offset, Length: end - r.offset})
+	default:
+		begin := r.offset
+		children = append(children, r.field("Payload", fmt.Sprintf("%x", r.read(end-r.offset)), begin))
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid %s frame: %w", http2FrameTypeName(header.frameType), r.err)
+	}
+
+	if padding > 0 {
+		children = append(children, &Field{Name: "Padding", Value: fmt.Sprintf("<%d bytes>", padding), Offset: end, Length: padding})
+	}
+
+	return &Field{
+		Name:     http2FrameTypeName(header.frameType) + " frame",
+		Value:    fmt.Sprintf("stream %d", header.stream),
+		Length:   len(frame),
+		Children: children,
+	}, nil
+}
+
+// Payload returns the data of a DATA frame, without its padding.
+func (d HTTP2Decoder) Payload(content []byte) ([]byte, error) {
+	if len(content) < HTTP2_FRAME_HEADER_LENGTH {
+		return nil, fmt.Errorf("HTTP/2 frame is too short")
+	}
+
+	header := parseHTTP2FrameHeader(content)
+	if header.frameType != HTTP2_FRAME_DATA {
+		return nil, fmt.Errorf("only DATA frames have a payload")
+	}
+	if HTTP2_FRAME_HEADER_LENGTH+header.length != len(content) {
+		return nil, fmt.Errorf("the HTTP/2 frame length doesn't match its content")
+	}
+
+	payload := header.payload(content)
+	if header.padLength(content) == 0 {
+		return payload, nil
+	}
+
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, fmt.Errorf("invalid padding of the DATA frame")
+	}
+
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+// SetPayload replaces the data of a DATA frame, keeping its padding.
+func (d HTTP2Decoder) SetPayload(content, payload []byte) ([]byte, error) {
+	old, err := d.Payload(content)
+	if err != nil {
+		return nil, err
+	}
+
+	header := parseHTTP2FrameHeader(content)
+	length := header.length - len(old) + len(payload)
+	if length > d.maxFrameSize && d.maxFrameSize != 0 || length >= 1<<24 {
+		return nil, fmt.Errorf("the DATA frame payload length %d exceeds the receiver's maximal frame size %d", length, d.maxFrameSize)
+	}
+
+	padLength := header.padLength(content)
+	frame := binary.BigEndian.AppendUint32(nil, uint32(length)<<8|uint32(header.frameType))
+	frame = append(frame, content[4:HTTP2_FRAME_HEADER_LENGTH+padLength]...)
+	frame = append(frame, payload...)
+	return append(frame, content[len(content)-(header.length-padLength-len(old)):]...), nil
+}
@@ -0,0 +1,157 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func http2Frame(frameType, flags byte, stream uint32, payload string) string {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(payload))<<8|uint32(frameType))
+	frame = append(frame, flags)
+	frame = binary.BigEndian.AppendUint32(frame, stream)
+	return string(frame) + payload
+}
+
+func mustDecodeHex(t *testing.T, s string) string {
+	data, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestHTTP2Session(t *testing.T) {
+	// The requests of RFC 7541 C.4, the second depending on the dynamic table
+	// of the first
+	firstBlock := mustDecodeHex(t, "828684418cf1e3c2e5f23a6ba0ab90f4ff")
+	secondBlock := mustDecodeHex(t, "828684be5886a8eb10649cbf")
+
+	client := HTTP2_PREFACE +
+		http2Frame(HTTP2_FRAME_SETTINGS, 0, 0, "\x00\x03\x00\x00\x00\x64") +
+		http2Frame(HTTP2_FRAME_HEADERS, HTTP2_FLAG_END_HEADERS|HTTP2_FLAG_END_STREAM, 1, firstBlock) +
+		http2Frame(HTTP2_FRAME_HEADERS, HTTP2_FLAG_END_STREAM, 3, secondBlock[:5]) +
+		http2Frame(HTTP2_FRAME_CONTINUATION, HTTP2_FLAG_END_HEADERS, 3, secondBlock[5:])
+	require.True(t, HTTP2Dissector{}.Detect(toServer, []byte(client)))
+
+	session := HTTP2Dissector{}.NewSession()
+	frames, rest := frameAll(t, session, toServer, client)
+	assert.Empty(t, rest)
+	require.Len(t, frames, 4)
+
+	// A new session, as the HPACK state of the frames depends on the ones
+	// before them
+	session = HTTP2Dissector{}.NewSession()
+
+	var summaries []string
+	var decoders []Decoder
+	for _, frame := range frames {
+		_, decoder, err := session.Frame(toServer, []byte(frame))
+		require.NoError(t, err)
+
+		dissection, err := decoder.Decode([]byte(frame))
+		require.NoError(t, err)
+		summaries = append(summaries, dissection.Summary)
+		decoders = append(decoders, decoder)
+	}
+
+	assert.Equal(t, []string{
+		"Connection preface",
+		"SETTINGS",
+		"stream 1: HEADERS GET / END_STREAM",
+		"stream 3: HEADERS GET / END_STREAM",
+	}, summaries)
+
+	dissection, err := decoders[3].Decode([]byte(frames[3]))
+	require.NoError(t, err)
+	headers := dissection.Fields[len(dissection.Fields)-1]
+	require.Len(t, headers.Children, 5)
+	assert.Equal(t, ":authority", headers.Children[3].Name)
+	assert.Equal(t, "www.example.com", headers.Children[3].Value)
+	assert.Equal(t, "no-cache", headers.Children[4].Value)
+
+	stream, err := decoders[3].(StreamDecoder).Stream([]byte(frames[3]))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), stream)
+	assert.Equal(t, "/", decoders[3].(HTTP2Decoder).Path())
+}
+
+func TestHTTP2SetDataPayload(t *testing.T) {
+	session := newHTTP2Session()
+	session.prefaceReceived = true
+
+	// Padded by 2 bytes
+	data := http2Frame(HTTP2_FRAME_DATA, HTTP2_FLAG_PADDED|HTTP2_FLAG_END_STREAM, 1, "\x02hello\x00\x00")
+	length, decoder, err := session.Frame(toClient, []byte(data))
+	require.NoError(t, err)
+	require.Equal(t, len(data), length)
+
+	dissection, err := decoder.Decode([]byte(data))
+	require.NoError(t, err)
+	assert.Equal(t, "stream 1: DATA 5 bytes END_STREAM", dissection.Summary)
+
+	edited, err := decoder.(PayloadEditor).SetPayload([]byte(data), []byte("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, http2Frame(HTTP2_FRAME_DATA, HTTP2_FLAG_PADDED|HTTP2_FLAG_END_STREAM, 1, "\x02hi\x00\x00"), string(edited))
+}
+
+func TestHTTPUpgradeToH2C(t *testing.T) {
+	session := HTTPDissector{}.NewSession()
+	frameAll(t, session, toServer, "GET / HTTP/1.1\r\nHost: a\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQCAAAAAAIAAAAA\r\n\r\n")
+
+	response := "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n" +
+		http2Frame(HTTP2_FRAME_SETTINGS, 0, 0, "")
+	frames, rest := frameAll(t, session, toClient, response)
+	assert.Empty(t, rest)
+	require.Len(t, frames, 2)
+
+	_, decoder, err := session.Frame(toClient, []byte(frames[1]))
+	require.NoError(t, err)
+	assert.IsType(t, HTTP2Decoder{}, decoder)
+}
+
+func TestHTTP2MalformedHeaderBlocks(t *testing.T) {
+	session := newHTTP2Session()
+	session.prefaceReceived = true
+
+	// Padded, but too short for the promised stream ID
+	pushPromise := http2Frame(HTTP2_FRAME_PUSH_PROMISE, HTTP2_FLAG_PADDED|HTTP2_FLAG_END_HEADERS, 1, "\x00\x00\x00\x02")
+	length, decoder, err := session.Frame(toClient, []byte(pushPromise))
+	require.NoError(t, err)
+	require.Equal(t, len(pushPromise), length)
+	_, err = decoder.Decode([]byte(pushPromise))
+	assert.Error(t, err)
+
+	// A dynamic table size update above SETTINGS_HEADER_TABLE_SIZE
+	headers := http2Frame(HTTP2_FRAME_HEADERS, HTTP2_FLAG_END_HEADERS, 1, "\x3f\xe1\x3f\x82")
+	_, decoder, err = session.Frame(toServer, []byte(headers))
+	require.NoError(t, err)
+	_, err = decoder.Decode([]byte(headers))
+	assert.ErrorContains(t, err, "exceeds the limit of 4096")
+
+	// Allowed once the server raises the setting
+	settings := http2Frame(HTTP2_FRAME_SETTINGS, 0, 0, "\x00\x01\x00\x00\x20\x00")
+	_, _, err = session.Frame(toClient, []byte(settings))
+	require.NoError(t, err)
+	_, decoder, err = session.Frame(toServer, []byte(headers))
+	require.NoError(t, err)
+	dissection, err := decoder.Decode([]byte(headers))
+	require.NoError(t, err)
+	assert.Equal(t, "stream 1: HEADERS GET", dissection.Summary)
+}
+
+func TestHTTP2PaddedFrameWithoutPadLength(t *testing.T) {
+	session := newHTTP2Session()
+	session.prefaceReceived = true
+
+	data := http2Frame(HTTP2_FRAME_DATA, HTTP2_FLAG_PADDED, 1, "")
+	_, _, err := session.Frame(toServer, []byte(data))
+	assert.Error(t, err)
+
+	_, err = HTTP2Decoder{}.Decode([]byte(data))
+	assert.Error(t, err)
+
+	_, err = HTTP2Decoder{}.Payload([]byte(data))
+	assert.Error(t, err)
+}
@@ -0,0 +1,83 @@
+package dissector
+
+import (
+	"testing"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	toServer = tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER
+	toClient = tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT
+)
+
+// frameAll frames data with the session, returning the frames and the rest of
+// data.
+func frameAll(t *testing.T, session Session, direction tcpmessage.TransmittionDirection, data string) ([]string, string) {
+	var frames []string
+	for len(data) > 0 {
+		length, _, err := session.Frame(direction, []byte(data))
+		require.NoError(t, err)
+		if length == 0 {
+			break
+		}
+
+		frames = append(frames, data[:length])
+		data = data[length:]
+	}
+
+	return frames, data
+}
+
+func TestHTTPFraming(t *testing.T) {
+	session := HTTPDissector{}.NewSession()
+
+	requests := "HEAD / HTTP/1.1\r\n\r\nPOST /x HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET / HTTP/1.1\r\n"
+	frames, rest := frameAll(t, session, toServer, requests)
+	assert.Equal(t, []string{"HEAD / HTTP/1.1\r\n\r\n", "POST /x HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"}, frames)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	// The response to HEAD has no body, despite its Content-Length
+	responses := "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n"
+	frames, rest = frameAll(t, session, toClient, responses)
+	assert.Equal(t, []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+	}, frames)
+	assert.Empty(t, rest)
+}
+
+func TestHTTPDecode(t *testing.T) {
+	dissection, err := HTTPDecoder{}.Decode([]byte("HTTP/1.1 404 Not Found\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "404 Not Found", dissection.Summary)
+	require.Len(t, dissection.Fields, 3)
+	assert.Equal(t, "Transfer-Encoding", dissection.Fields[1].Children[0].Name)
+	assert.Equal(t, "hi", dissection.Fields[2].Value)
+}
+
+func TestChunkedBodyLength(t *testing.T) {
+	length, body, err := chunkedBodyLength([]byte("3\r\nabc\r\n0\r\n\r\nrest"))
+	require.NoError(t, err)
+	assert.Equal(t, len("3\r\nabc\r\n0\r\n\r\n"), length)
+	assert.Equal(t, []byte("abc"), body)
+
+	length, _, err = chunkedBodyLength([]byte("3\r\nabc\r"))
+	require.NoError(t, err)
+	assert.Equal(t, -1, length)
+
+	_, _, err = chunkedBodyLength([]byte("3\r\nabcde\r\n0\r\n\r\n"))
+	assert.Error(t, err)
+}
+
+func TestHTTPDetectPrefix(t *testing.T) {
+	assert.True(t, HTTPDissector{}.DetectPrefix(toServer, []byte("GE")))
+	assert.True(t, HTTPDissector{}.DetectPrefix(toClient, []byte("HTTP/")))
+	assert.False(t, HTTPDissector{}.DetectPrefix(toServer, []byte("GET ")))
+	assert.False(t, HTTPDissector{}.DetectPrefix(toServer, []byte("hello")))
+	assert.True(t, DetectPrefix(toServer, []byte("\x16\x03")))
+}
@@ -0,0 +1,800 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+const (
+	MQTT_PACKET_CONNECT     = 1
+	MQTT_PACKET_CONNACK     = 2
+	MQTT_PACKET_PUBLISH     = 3
+	MQTT_PACKET_PUBACK      = 4
+	MQTT_PACKET_PUBREC      = 5
+	MQTT_PACKET_PUBREL      = 6
+	MQTT_PACKET_PUBCOMP     = 7
+	MQTT_PACKET_SUBSCRIBE   = 8
+	MQTT_PACKET_SUBACK      = 9
+	MQTT_PACKET_UNSUBSCRIBE = 10
+	MQTT_PACKET_UNSUBACK    = 11
+	MQTT_PACKET_PINGREQ     = 12
+	MQTT_PACKET_PINGRESP    = 13
+	MQTT_PACKET_DISCONNECT  = 14
+	MQTT_PACKET_AUTH        = 15
+)
+
+var mqttPacketNames = []string{
+	"Reserved",
+	"CONNECT",
+	"CONNACK",
+	"PUBLISH",
+	"PUBACK",
+	"PUBREC",
+	"PUBREL",
+	"PUBCOMP",
+	"SUBSCRIBE",
+	"SUBACK",
+	"UNSUBSCRIBE",
+	"UNSUBACK",
+	"PINGREQ",
+	"PINGRESP",
+	"DISCONNECT",
+	"AUTH",
+}
+
+// The protocol levels of the MQTT versions.
+const (
+	MQTT_VERSION_3_1   = 3
+	MQTT_VERSION_3_1_1 = 4
+	MQTT_VERSION_5     = 5
+)
+
+var mqttVersionNames = map[byte]string{
+	MQTT_VERSION_3_1:   "3.1",
+	MQTT_VERSION_3_1_1: "3.1.1",
+	MQTT_VERSION_5:     "5.0",
+}
+
+// MQTT_MAX_REMAINING_LENGTH is the maximal remaining length, which is encoded
+// in up to 4 bytes.
+const MQTT_MAX_REMAINING_LENGTH = 268435455
+
+// mqttConnectReturnCodes names the return codes of CONNACK before MQTT 5.0.
+var mqttConnectReturnCodes = map[byte]string{
+	0: "Connection accepted",
+	1: "Unacceptable protocol version",
+	2: "Identifier rejected",
+	3: "Server unavailable",
+	4: "Bad username or password",
+	5: "Not authorized",
+}
+
+var mqttReasonCodes = map[byte]string{
+	0x00: "Success",
+	0x01: "Granted QoS 1",
+	0x02: "Granted QoS 2",
+	0x04: "Disconnect with Will Message",
+	0x10: "No matching subscribers",
+	0x11: "No subscription existed",
+	0x18: "Continue authentication",
+	0x19: "Re-authenticate",
+	0x80: "Unspecified error",
+	0x81: "Malformed Packet",
+	0x82: "Protocol Error",
+	0x83: "Implementation specific error",
+	0x84: "Unsupported Protocol Version",
+	0x85: "Client Identifier not valid",
+	0x86: "Bad User Name or Password",
+	0x87: "Not authorized",
+	0x88: "Server unavailable",
+	0x89: "Server busy",
+	0x8a: "Banned",
+	0x8b: "Server shutting down",
+	0x8c: "Bad authentication method",
+	0x8d: "Keep Alive timeout",
+	0x8e: "Session taken over",
+	0x8f: "Topic Filter invalid",
+	0x90: "Topic Name invalid",
+	0x91: "Packet Identifier in use",
+	0x92: "Packet Identifier not found",
+	0x93: "Receive Maximum exceeded",
+	0x94: "Topic Alias invalid",
+	0x95: "Packet too large",
+	0x96: "Message rate too high",
+	0x97: "Quota exceeded",
+	0x98: "Administrative action",
+	0x99: "Payload format invalid",
+	0x9a: "Retain not supported",
+	0x9b: "QoS not supported",
+	0x9c: "Use another server",
+	0x9d: "Server moved",
+	0x9e: "Shared Subscriptions not supported",
+	0x9f: "Connection rate exceeded",
+	0xa0: "Maximum connect time",
+	0xa1: "Subscription Identifiers not supported",
+	0xa2: "Wildcard Subscriptions not supported",
+}
+
+func mqttReasonCodeName(code byte) string {
+	name, ok := mqttReasonCodes[code]
+	if !ok {
+		return fmt.Sprintf("Unknown (0x%02x)", code)
+	}
+
+	return name
+}
+
+type mqttPropertyType int
+
+const (
+	MQTT_PROPERTY_BYTE mqttPropertyType = iota
+	MQTT_PROPERTY_UINT16
+	MQTT_PROPERTY_UINT32
+	MQTT_PROPERTY_VARINT
+	MQTT_PROPERTY_STRING
+	MQTT_PROPERTY_BINARY
+	MQTT_PROPERTY_STRING_PAIR
+)
+
+// MQTT_PROPERTY_TOPIC_ALIAS is the property of PUBLISH packets which stands
+// for their topic.
+const MQTT_PROPERTY_TOPIC_ALIAS = 0x23
+
+var mqttProperties = map[int]struct {
+	name         string
+	propertyType mqttPropertyType
+}{
+	0x01: {"Payload Format Indicator", MQTT_PROPERTY_BYTE},
+	0x02: {"Message Expiry Interval", MQTT_PROPERTY_UINT32},
+	0x03: {"Content Type", MQTT_PROPERTY_STRING},
+	0x08: {"Response Topic", MQTT_PROPERTY_STRING},
+	0x09: {"Correlation Data", MQTT_PROPERTY_BINARY},
+	0x0b: {"Subscription Identifier", MQTT_PROPERTY_VARINT},
+	0x11: {"Session Expiry Interval", MQTT_PROPERTY_UINT32},
+	0x12: {"Assigned Client Identifier", MQTT_PROPERTY_STRING},
+	0x13: {"Server Keep Alive", MQTT_PROPERTY_UINT16},
+	0x15: {"Authentication Method", MQTT_PROPERTY_STRING},
+	0x16: {"Authentication Data", MQTT_PROPERTY_BINARY},
+	0x17: {"Request Problem Information", MQTT_PROPERTY_BYTE},
+	0x18: {"Will Delay Interval", MQTT_PROPERTY_UINT32},
+	0x19: {"Request Response Information", MQTT_PROPERTY_BYTE},
+	0x1a: {"Response Information", MQTT_PROPERTY_STRING},
+	0x1c: {"Server Reference", MQTT_PROPERTY_STRING},
+	0x1f: {"Reason String", MQTT_PROPERTY_STRING},
+	0x21: {"Receive Maximum", MQTT_PROPERTY_UINT16},
+	0x22: {"Topic Alias Maximum", MQTT_PROPERTY_UINT16},
+	0x23: {"Topic Alias", MQTT_PROPERTY_UINT16},
+	0x24: {"Maximum QoS", MQTT_PROPERTY_BYTE},
+	0x25: {"Retain Available", MQTT_PROPERTY_BYTE},
+	0x26: {"User Property", MQTT_PROPERTY_STRING_PAIR},
+	0x27: {"Maximum Packet Size", MQTT_PROPERTY_UINT32},
+	0x28: {"Wildcard Subscription Available", MQTT_PROPERTY_BYTE},
+	0x29: {"Subscription Identifier Available", MQTT_PROPERTY_BYTE},
+	0x2a: {"Shared Subscription Available", MQTT_PROPERTY_BYTE},
+}
+
+type MQTTDissector struct{}
+
+func (MQTTDissector) Name() string {
+	return "mqtt"
+}
+
+func (MQTTDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(data) < 2 || data[0] != MQTT_PACKET_CONNECT<<4 {
+		return false
+	}
+
+	// The CONNECT packet begins with the protocol name after the remaining
+	// length
+	for i := 1; i < len(data) && i <= 4; i++ {
+		if data[i]&0x80 == 0 {
+			name := data[i+1:]
+			return bytes.HasPrefix(name, []byte("\x00\x04MQTT")) || bytes.HasPrefix(name, []byte("\x00\x06MQIsdp"))
+		}
+	}
+
+	return false
+}
+
+func (MQTTDissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(data) == 0 || data[0] != MQTT_PACKET_CONNECT<<4 {
+		return false
+	}
+
+	for i := 1; i < len(data) && i <= 4; i++ {
+		if data[i]&0x80 == 0 {
+			name := data[i+1:]
+			return isShorterPrefix(name, "\x00\x04MQTT") || isShorterPrefix(name, "\x00\x06MQIsdp")
+		}
+	}
+
+	// The remaining length isn't complete
+	return len(data) <= 4
+}
+
+func (MQTTDissector) NewSession() Session {
+	return &mqttSession{
+		version: MQTT_VERSION_3_1_1,
+		topicAliases: map[tcpmessage.TransmittionDirection]map[int]string{
+			tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER: {},
+			tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT: {},
+		},
+	}
+}
+
+type mqttSession struct {
+	// version is the protocol level of the connection, as sent in CONNECT.
+	version byte
+
+	// topicAliases maps the topic aliases of each direction to their topics.
+	topicAliases map[tcpmessage.TransmittionDirection]map[int]string
+}
+
+// mqttPacketLength returns the length of the packet at the beginning of data,
+// along with the length of its fixed header. It returns 0 if the packet isn't
+// complete.
+func mqttPacketLength(data []byte) (int, int, error) {
+	if len(data) > 0 && data[0]>>4 == 0 {
+		return 0, 0, fmt.Errorf("invalid MQTT packet type 0")
+	}
+
+	remainingLength, multiplier := 0, 1
+	for i := 1; i <= 4; i++ {
+		if i >= len(data) {
+			return 0, 0, nil
+		}
+
+		remainingLength += int(data[i]&0x7f) * multiplier
+		if data[i]&0x80 == 0 {
+			headerLength := i + 1
+			if headerLength+remainingLength > len(data) {
+				return 0, 0, nil
+			}
+
+			return headerLength + remainingLength, headerLength, nil
+		}
+
+		multiplier *= 128
+	}
+
+	return 0, 0, fmt.Errorf("invalid MQTT remaining length")
+}
+
+func (s *mqttSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	length, headerLength, err := mqttPacketLength(data)
+	if err != nil || length == 0 {
+		return 0, nil, err
+	}
+
+	decoder := MQTTDecoder{version: s.version}
+	switch data[0] >> 4 {
+	case MQTT_PACKET_CONNECT:
+		// The protocol level follows the protocol name
+		r := newReader(data[headerLength:length], binary.BigEndian)
+		r.read(int(r.uint16()))
+		if version := r.byte(); r.err == nil {
+			s.version = version
+			decoder.version = version
+		}
+	case MQTT_PACKET_PUBLISH:
+		if s.version != MQTT_VERSION_5 {
+			break
+		}
+
+		r := newReader(data[:length], binary.BigEndian)
+		r.offset = headerLength
+		_, topic, alias := decoder.readPublishHeader(r, data[0]&0x0f)
+		if r.err != nil || alias == 0 {
+			break
+		}
+
+		// A topic sets its alias, and an empty topic is replaced by the
+		// alias
+		if topic != "" {
+			s.topicAliases[direction][alias] = topic
+		} else {
+			decoder.aliasedTopic = s.topicAliases[direction][alias]
+		}
+	}
+
+	return length, decoder, nil
+}
+
+// MQTTDecoder decodes MQTT 3.1, 3.1.1 and 5.0 control packets.
+type MQTTDecoder struct {
+	version byte
+
+	// aliasedTopic is the topic of a PUBLISH which has a topic alias instead
+	// of a topic.
+	aliasedTopic string
+}
+
+func (MQTTDecoder) Name() string {
+	return "mqtt"
+}
+
+func readMQTTVarint(r *reader) int {
+	value, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		digit := r.byte()
+		value += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			return value
+		}
+
+		multiplier *= 128
+	}
+
+	if r.err == nil {
+		r.err = fmt.Errorf("invalid variable byte integer at offset %d", r.offset-4)
+	}
+	return 0
+}
+
+// readMQTTBinary reads data prefixed by its 2 byte length.
+func readMQTTBinary(r *reader) []byte {
+	return r.read(int(r.uint16()))
+}
+
+func mqttStringField(r *reader, name string) *Field {
+	begin := r.offset
+	return r.field(name, string(readMQTTBinary(r)), begin)
+}
+
+func mqttBinaryField(r *reader, name string) *Field {
+	begin := r.offset
+	return r.field(name, DescribeBody(readMQTTBinary(r)), begin)
+}
+
+// readMQTTProperties reads the properties of MQTT 5.0 packets into a field,
+// returning it along with the topic alias, if any.
+func readMQTTProperties(r *reader) (*Field, int) {
+	field := &Field{Offset: r.offset}
+	length := readMQTTVarint(r)
+	field.Name = fmt.Sprintf("Properties (%d bytes)", length)
+	if length > r.remaining() {
+		r.err = fmt.Errorf("properties at offset %d are longer than the packet", field.Offset)
+		return field, 0
+	}
+
+	topicAlias := 0
+	end := r.offset + length
+	for r.err == nil && r.offset < end {
+		begin := r.offset
+		identifier := readMQTTVarint(r)
+
+		property, ok := mqttProperties[identifier]
+		if !ok {
+			r.err = fmt.Errorf("unknown MQTT property 0x%02x at offset %d", identifier, begin)
+			break
+		}
+
+		var value any
+		switch property.propertyType {
+		case MQTT_PROPERTY_BYTE:
+			value = r.byte()
+		case MQTT_PROPERTY_UINT16:
+			value = r.uint16()
+			if identifier == MQTT_PROPERTY_TOPIC_ALIAS {
+				topicAlias = int(value.(uint16))
+			}
+		case MQTT_PROPERTY_UINT32:
+			value = r.uint32()
+		case MQTT_PROPERTY_VARINT:
+			value = readMQTTVarint(r)
+		case MQTT_PROPERTY_STRING:
+			value = string(readMQTTBinary(r))
+		case MQTT_PROPERTY_BINARY:
+			value = DescribeBody(readMQTTBinary(r))
+		case MQTT_PROPERTY_STRING_PAIR:
+			name := readMQTTBinary(r)
+			value = fmt.Sprintf("%s=%s", name, readMQTTBinary(r))
+		}
+
+		field.Children = append(field.Children, r.field(property.name, value, begin))
+	}
+
+	if r.err == nil && r.offset != end {
+		r.err = fmt.Errorf("the last property overflows the properties at offset %d", field.Offset)
+	}
+
+	field.Length = r.offset - field.Offset
+	return field, topicAlias
+}
+
+func (d MQTTDecoder) Decode(content []byte) (*Dissection, error) {
+	length, headerLength, err := mqttPacketLength(content)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 || length != len(content) { // An empty or incomplete packet has no length
+		return nil, fmt.Errorf("expected a single MQTT packet")
+	}
+
+	packetType, flags := content[0]>>4, content[0]&0x0f
+	dissection := &Dissection{Summary: mqttPacketNames[packetType]}
+
+	r := newReader(content, binary.BigEndian)
+	r.byte()
+	fields := []*Field{{Name: "Type", Value: dissection.Summary, Length: 1}}
+	if packetType == MQTT_PACKET_PUBLISH {
+		fields = append(fields,
+			&Field{Name: "DUP", Value: fmt.Sprint(flags&0x08 != 0), Length: 1},
+			&Field{Name: "QoS", Value: fmt.Sprint(flags >> 1 & 0x03), Length: 1},
+			&Field{Name: "Retain", Value: fmt.Sprint(flags&0x01 != 0), Length: 1},
+		)
+	} else {
+		fields = append(fields, &Field{Name: "Flags", Value: fmt.Sprintf("0x%x", flags), Length: 1})
+	}
+
+	begin := r.offset
+	r.read(headerLength - 1)
+	fields = append(fields, r.field("Remaining length", length-headerLength, begin))
+
+	switch packetType {
+	case MQTT_PACKET_CONNECT:
+		fields = append(fields, decodeMQTTConnect(r, dissection)...)
+	case MQTT_PACKET_CONNACK:
+		fields = append(fields, d.decodeConnack(r, dissection)...)
+	case MQTT_PACKET_PUBLISH:
+		fields = append(fields, d.decodePublish(r, flags, dissection)...)
+	case MQTT_PACKET_PUBACK, MQTT_PACKET_PUBREC, MQTT_PACKET_PUBREL, MQTT_PACKET_PUBCOMP:
+		id := r.uint16Field("Packet ID")
+		dissection.Summary += " " + id.Value
+		fields = append(fields, id)
+		fields = append(fields, d.decodeReason(r, dissection)...)
+	case MQTT_PACKET_SUBSCRIBE, MQTT_PACKET_UNSUBSCRIBE:
+		fields = append(fields, d.decodeSubscribe(r, packetType, dissection)...)
+	case MQTT_PACKET_SUBACK, MQTT_PACKET_UNSUBACK:
+		fields = append(fields, d.decodeSuback(r, packetType, dissection)...)
+	case MQTT_PACKET_DISCONNECT, MQTT_PACKET_AUTH:
+		fields = append(fields, d.decodeReason(r, dissection)...)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if r.remaining() > 0 {
+		fields = append(fields, &Field{Name: "Data", Value: DescribeBody(r.data[r.offset:]), Offset: r.offset, Length: r.remaining()})
+	}
+
+	dissection.Fields = fields
+	return dissection, nil
+}
+
+func decodeMQTTConnect(r *reader, dissection *Dissection) []*Field {
+	fields := []*Field{mqttStringField(r, "Protocol name")}
+
+	begin := r.offset
+	version := r.byte()
+	versionName, ok := mqttVersionNames[version]
+	if !ok {
+		versionName = fmt.Sprintf("Unknown (%d)", version)
+	}
+	fields = append(fields, r.field("Protocol version", versionName, begin))
+
+	begin = r.offset
+	flags := r.byte()
+	connectFlags := r.field("Connect flags", fmt.Sprintf("0x%02x", flags), begin)
+	connectFlags.Children = []*Field{
+		{Name: "Username", Value: fmt.Sprint(flags&0x80 != 0), Offset: begin, Length: 1},
+		{Name: "Password", Value: fmt.Sprint(flags&0x40 != 0), Offset: begin, Length: 1},
+		{Name: "Will retain", Value: fmt.Sprint(flags&0x20 != 0), Offset: begin, Length: 1},
+		{Name: "Will QoS", Value: fmt.Sprint(flags >> 3 & 0x03), Offset: begin, Length: 1},
+		{Name: "Will", Value: fmt.Sprint(flags&0x04 != 0), Offset: begin, Length: 1},
+		{Name: "Clean session", Value: fmt.Sprint(flags&0x02 != 0), Offset: begin, Length: 1},
+	}
+	fields = append(fields, connectFlags, r.uint16Field("Keep alive"))
+
+	if version == MQTT_VERSION_5 {
+		properties, _ := readMQTTProperties(r)
+		fields = append(fields, properties)
+	}
+
+	clientID := mqttStringField(r, "Client ID")
+	dissection.Summary += " client_id=" + clientID.Value
+	fields = append(fields, clientID)
+
+	if flags&0x04 != 0 {
+		if version == MQTT_VERSION_5 {
+			properties, _ := readMQTTProperties(r)
+			properties.Name = "Will " + properties.Name
+			fields = append(fields, properties)
+		}
+		fields = append(fields, mqttStringField(r, "Will topic"), mqttBinaryField(r, "Will payload"))
+	}
+
+	if flags&0x80 != 0 {
+		username := mqttStringField(r, "Username")
+		dissection.Summary += " user=" + username.Value
+		fields = append(fields, username)
+	}
+	if flags&0x40 != 0 {
+		fields = append(fields, mqttBinaryField(r, "Password"))
+	}
+
+	dissection.Summary += fmt.Sprintf(" (MQTT %s)", versionName)
+	return fields
+}
+
+func (d MQTTDecoder) decodeConnack(r *reader, dissection *Dissection) []*Field {
+	begin := r.offset
+	sessionPresent := r.byte()&0x01 != 0
+	fields := []*Field{r.field("Session present", sessionPresent, begin)}
+
+	begin = r.offset
+	code := r.byte()
+	if d.version != MQTT_VERSION_5 {
+		name, ok := mqttConnectReturnCodes[code]
+		if !ok {
+			name = fmt.Sprintf("Unknown (%d)", code)
+		}
+
+		dissection.Summary += " " + name
+		return append(fields, r.field("Return code", name, begin))
+	}
+
+	dissection.Summary += " " + mqttReasonCodeName(code)
+	fields = append(fields, r.field("Reason code", mqttReasonCodeName(code), begin))
+
+	properties, _ := readMQTTProperties(r)
+	return append(fields, properties)
+}
+
+// decodeReason decodes the optional reason code and properties of MQTT 5.0
+// packets.
+func (d MQTTDecoder) decodeReason(r *reader, dissection *Dissection) []*Field {
+	if d.version != MQTT_VERSION_5 || r.remaining() == 0 {
+		return nil
+	}
+
+	begin := r.offset
+	code := mqttReasonCodeName(r.byte())
+	fields := []*Field{r.field("Reason code", code, begin)}
+	dissection.Summary += " " + code
+
+	if r.remaining() > 0 {
+		properties, _ := readMQTTProperties(r)
+		fields = append(fields, properties)
+	}
+
+	return fields
+}
+
+// readPublishHeader reads the variable header of a PUBLISH packet with the
+// given flags, returning its fields along with its topic and topic alias.
+func (d MQTTDecoder) readPublishHeader(r *reader, flags byte) ([]*Field, string, int) {
+	topic := mqttStringField(r, "Topic")
+	fields := []*Field{topic}
+
+	if qos := flags >> 1 & 0x03; qos > 0 {
+		fields = append(fields, r.uint16Field("Packet ID"))
+	}
+
+	topicAlias := 0
+	if d.version == MQTT_VERSION_5 {
+		var properties *Field
+		properties, topicAlias = readMQTTProperties(r)
+		fields = append(fields, properties)
+	}
+
+	return fields, topic.Value, topicAlias
+}
+
+func (d MQTTDecoder) decodePublish(r *reader, flags byte, dissection *Dissection) []*Field {
+	fields, topic, _ := d.readPublishHeader(r, flags)
+	if topic == "" && d.aliasedTopic != "" {
+		topic = d.aliasedTopic
+		fields = append(fields, &Field{Name: "Aliased topic", Value: topic, Offset: fields[0].Offset, Length: fields[0].Length})
+	}
+
+	dissection.Summary += " " + topic
+
+	var attributes []string
+	if qos := flags >> 1 & 0x03; qos > 0 {
+		attributes = append(attributes, fmt.Sprintf("QoS %d", qos))
+	}
+	if flags&0x01 != 0 {
+		attributes = append(attributes, "retain")
+	}
+	if flags&0x08 != 0 {
+		attributes = append(attributes, "dup")
+	}
+	if len(attributes) > 0 {
+		dissection.Summary += fmt.Sprintf(" (%s)", strings.Join(attributes, ", "))
+	}
+
+	begin := r.offset
+	payload := DescribeBody(r.rest())
+	dissection.Summary += ": " + summaryText(payload)
+	payloadField := r.field("Payload", payload, begin)
+	payloadField.Payload = true
+	return append(fields, payloadField)
+}
+
+func (d MQTTDecoder) decodeSubscribe(r *reader, packetType byte, dissection *Dissection) []*Field {
+	fields := []*Field{r.uint16Field("Packet ID")}
+	if d.version == MQTT_VERSION_5 {
+		properties, _ := readMQTTProperties(r)
+		fields = append(fields, properties)
+	}
+
+	var filters []string
+	topics := &Field{Offset: r.offset}
+	for r.err == nil && r.remaining() > 0 {
+		filter := mqttStringField(r, "Topic filter")
+		filters = append(filters, filter.Value)
+
+		if packetType == MQTT_PACKET_SUBSCRIBE {
+			begin := r.offset
+			options := r.byte()
+			filter.Children = append(filter.Children, r.field("Maximum QoS", options&0x03, begin))
+			if d.version == MQTT_VERSION_5 {
+				filter.Children = append(filter.Children,
+					r.field("No local", options&0x04 != 0, begin),
+					r.field("Retain as published", options&0x08 != 0, begin),
+					r.field("Retain handling", options>>4&0x03, begin),
+				)
+			}
+			filter.Length = r.offset - filter.Offset
+		}
+
+		topics.Children = append(topics.Children, filter)
+	}
+
+	topics.Name = fmt.Sprintf("Topic filters (%d)", len(filters))
+	topics.Length = r.offset - topics.Offset
+	dissection.Summary += " " + summaryText(strings.Join(filters, ", "))
+	return append(fields, topics)
+}
+
+func (d MQTTDecoder) decodeSuback(r *reader, packetType byte, dissection *Dissection) []*Field {
+	id := r.uint16Field("Packet ID")
+	dissection.Summary += " " + id.Value
+	fields := []*Field{id}
+
+	// Before MQTT 5.0, UNSUBACK has no reason codes
+	if d.version != MQTT_VERSION_5 && packetType == MQTT_PACKET_UNSUBACK {
+		return fields
+	}
+
+	if d.version == MQTT_VERSION_5 {
+		properties, _ := readMQTTProperties(r)
+		fields = append(fields, properties)
+	}
+
+	var names []string
+	codes := &Field{Offset: r.offset}
+	for i := 0; r.err == nil && r.remaining() > 0; i++ {
+		begin := r.offset
+		code := r.byte()
+
+		name := mqttReasonCodeName(code)
+		if d.version != MQTT_VERSION_5 && code < 0x80 {
+			name = fmt.Sprintf("Granted QoS %d", code)
+		} else if d.version != MQTT_VERSION_5 {
+			name = "Failure"
+		}
+
+		names = append(names, name)
+		codes.Children = append(codes.Children, r.field(fmt.Sprintf("[%d]", i), name, begin))
+	}
+
+	codes.Name = fmt.Sprintf("Reason codes (%d)", len(names))
+	codes.Length = r.offset - codes.Offset
+	dissection.Summary += ": " + summaryText(strings.Join(names, ", "))
+	return append(fields, codes)
+}
+
+// readPublish reads a PUBLISH packet, returning its topic, the offset of its
+// payload and the length of its fixed header.
+func (d MQTTDecoder) readPublish(content []byte) (string, int, int, error) {
+	length, headerLength, err := mqttPacketLength(content)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	if length == 0 || length != len(content) { // An empty or incomplete packet has no length
+		return "", 0, 0, fmt.Errorf("expected a single MQTT packet")
+	}
+
+	if content[0]>>4 != MQTT_PACKET_PUBLISH {
+		return "", 0, 0, fmt.Errorf("not a PUBLISH packet")
+	}
+
+	r := newReader(content, binary.BigEndian)
+	r.offset = headerLength
+	_, topic, _ := d.readPublishHeader(r, content[0]&0x0f)
+	if topic == "" {
+		topic = d.aliasedTopic
+	}
+
+	return topic, r.offset, headerLength, r.err
+}
+
+// Payload returns the application message of PUBLISH packets.
+func (d MQTTDecoder) Payload(content []byte) ([]byte, error) {
+	_, offset, _, err := d.readPublish(content)
+	if err != nil {
+		return nil, fmt.Errorf("only the payload of PUBLISH packets can be edited: %w", err)
+	}
+
+	return content[offset:], nil
+}
+
+// SetPayload encodes the PUBLISH packet with the given application message,
+// recomputing its remaining length.
+func (d MQTTDecoder) SetPayload(content, payload []byte) ([]byte, error) {
+	_, offset, headerLength, err := d.readPublish(content)
+	if err != nil {
+		return nil, fmt.Errorf("only the payload of PUBLISH packets can be edited: %w", err)
+	}
+
+	variableHeader := content[headerLength:offset]
+	remainingLength := len(variableHeader) + len(payload)
+	if remainingLength > MQTT_MAX_REMAINING_LENGTH {
+		return nil, fmt.Errorf("the packet is longer than the maximal remaining length %d", MQTT_MAX_REMAINING_LENGTH)
+	}
+
+	encoded := appendMQTTVarint([]byte{content[0]}, remainingLength)
+	encoded = append(encoded, variableHeader...)
+	return append(encoded, payload...), nil
+}
+
+func appendMQTTVarint(data []byte, value int) []byte {
+	for {
+		digit := byte(value % 128)
+		value /= 128
+		if value == 0 {
+			return append(data, digit)
+		}
+
+		data = append(data, digit|0x80)
+	}
+}
+
+// Topic returns the topic of a PUBLISH packet, resolving its topic alias.
+func (d MQTTDecoder) Topic(content []byte) (string, error) {
+	topic, _, _, err := d.readPublish(content)
+	return topic, err
+}
+
+// MQTTTopic returns the topic of a message if it's an MQTT PUBLISH packet,
+// for example for interceptors which match topics with MatchMQTTTopic.
+func MQTTTopic(message *tcpmessage.TCPMessage) (string, bool) {
+	decoder, ok := message.Protocol().(MQTTDecoder)
+	if !ok {
+		return "", false
+	}
+
+	topic, err := decoder.Topic(message.Content())
+	return topic, err == nil
+}
+
+// MatchMQTTTopic reports whether the topic matches the topic filter, in which
+// "+" matches a single level and a trailing "#" matches any number of levels.
+func MatchMQTTTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	// Topics beginning with "$" aren't matched by wildcards at the first level
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+
+		if i >= len(topicLevels) || level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
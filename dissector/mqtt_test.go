@@ -0,0 +1,86 @@
+package dissector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mqttPacket encodes a packet with the given first byte and body.
+func mqttPacket(header byte, body string) string {
+	return string(appendMQTTVarint([]byte{header}, len(body))) + body
+}
+
+func TestMQTTSession(t *testing.T) {
+	session := MQTTDissector{}.NewSession()
+
+	// MQTT 5.0 with a clean start, no properties and client ID "dev-1"
+	connect := mqttPacket(0x10, "\x00\x04MQTT\x05\x02\x00\x3c\x00\x00\x05dev-1")
+	require.True(t, MQTTDissector{}.Detect(toServer, []byte(connect)))
+
+	// The first PUBLISH sets the alias 1, which the second one uses instead
+	// of its topic
+	publish := mqttPacket(0x32, "\x00\x0csensors/temp\x00\x07\x03\x23\x00\x0121.5")
+	aliased := mqttPacket(0x30, "\x00\x00\x03\x23\x00\x0122.0")
+
+	data := connect + publish + aliased
+	frames, rest := frameAll(t, session, toServer, data[:len(data)-2])
+	assert.Equal(t, []string{connect, publish}, frames)
+
+	length, decoder, err := session.Frame(toServer, []byte(rest+data[len(data)-2:]))
+	require.NoError(t, err)
+	require.Equal(t, len(aliased), length)
+
+	dissection, err := decoder.Decode([]byte(aliased))
+	require.NoError(t, err)
+	assert.Equal(t, "PUBLISH sensors/temp: 22.0", dissection.Summary)
+
+	dissection, err = MQTTDecoder{version: MQTT_VERSION_5}.Decode([]byte(publish))
+	require.NoError(t, err)
+	assert.Equal(t, "PUBLISH sensors/temp (QoS 1): 21.5", dissection.Summary)
+
+	dissection, err = MQTTDecoder{version: MQTT_VERSION_5}.Decode([]byte(connect))
+	require.NoError(t, err)
+	assert.Equal(t, "CONNECT client_id=dev-1 (MQTT 5.0)", dissection.Summary)
+}
+
+func TestMQTTDecodeIncompletePacket(t *testing.T) {
+	decoder := MQTTDecoder{version: MQTT_VERSION_3_1_1}
+	for _, content := range []string{"", "\x30", mqttPacket(0x30, "\x00\x01a")[:3]} {
+		_, err := decoder.Decode([]byte(content))
+		assert.Error(t, err, "%q", content)
+
+		_, err = decoder.Payload([]byte(content))
+		assert.Error(t, err, "%q", content)
+	}
+}
+
+func TestMQTTSetPayloadRecomputesLength(t *testing.T) {
+	decoder := MQTTDecoder{version: MQTT_VERSION_3_1_1}
+	publish := mqttPacket(0x30, "\x00\x01aoff")
+
+	payload := strings.Repeat("x", 200)
+	edited, err := decoder.SetPayload([]byte(publish), []byte(payload))
+	require.NoError(t, err)
+	assert.Equal(t, mqttPacket(0x30, "\x00\x01a"+payload), string(edited))
+	assert.Len(t, edited, 1+2+3+200)
+}
+
+func TestMatchMQTTTopic(t *testing.T) {
+	for _, test := range []struct {
+		filter, topic string
+		matches       bool
+	}{
+		{"sensors/temp", "sensors/temp", true},
+		{"sensors/+", "sensors/temp", true},
+		{"sensors/+", "sensors/temp/1", false},
+		{"sensors/#", "sensors", true},
+		{"sensors/#", "sensors/temp/1", true},
+		{"+/+", "/temp", true},
+		{"#", "$SYS/uptime", false},
+	} {
+		assert.Equal(t, test.matches, MatchMQTTTopic(test.filter, test.topic), "%s matching %s", test.filter, test.topic)
+	}
+}
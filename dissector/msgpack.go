@@ -0,0 +1,223 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"time"
+)
+
+// MESSAGEPACK_EXT_TIMESTAMP is the extension type of MessagePack timestamps.
+const MESSAGEPACK_EXT_TIMESTAMP = -1
+
+// decodeMessagePack decodes the MessagePack value at offset.
+func decodeMessagePack(data []byte, offset int, depth int) (*StructuredValue, int, error) {
+	if offset >= len(data) {
+		return nil, 0, structuredTruncated(offset)
+	}
+
+	begin := offset
+	typ := data[offset]
+	offset++
+
+	scalar := func(text string, end int) (*StructuredValue, int, error) {
+		return structuredScalar(text, begin, end), end, nil
+	}
+
+	switch {
+	case typ <= 0x7f:
+		return scalar(strconv.Itoa(int(typ)), offset)
+	case typ >= 0xe0:
+		return scalar(strconv.Itoa(int(int8(typ))), offset)
+	case typ&0xf0 == 0x80:
+		return decodeMessagePackMap(data, begin, offset, uint64(typ&0x0f), depth)
+	case typ&0xf0 == 0x90:
+		return decodeMessagePackArray(data, begin, offset, uint64(typ&0x0f), depth)
+	case typ&0xe0 == 0xa0:
+		return decodeMessagePackString(data, begin, offset, uint64(typ&0x1f))
+	}
+
+	switch typ {
+	case 0xc0:
+		return scalar("null", offset)
+	case 0xc2:
+		return scalar("false", offset)
+	case 0xc3:
+		return scalar("true", offset)
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		size := 1 << (typ - 0xc4)
+		length, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		bytes, err := readStructured(data, offset+size, length)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredBytes(bytes), offset+size+len(bytes))
+	case 0xc7, 0xc8, 0xc9: // ext 8, 16, 32
+		size := 1 << (typ - 0xc7)
+		length, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMessagePackExt(data, begin, offset+size, length)
+	case 0xca:
+		bits, err := readStructuredUint(data, offset, 4)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredFloat(float64(math.Float32frombits(uint32(bits)))), offset+4)
+	case 0xcb:
+		bits, err := readStructuredUint(data, offset, 8)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(formatStructuredFloat(math.Float64frombits(bits)), offset+8)
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8, 16, 32, 64
+		size := 1 << (typ - 0xcc)
+		value, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return scalar(strconv.FormatUint(value, 10), offset+size)
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8, 16, 32, 64
+		size := 1 << (typ - 0xd0)
+		value, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Sign extends the value
+		shift := 64 - 8*size
+		return scalar(strconv.FormatInt(int64(value<<shift)>>shift, 10), offset+size)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1, 2, 4, 8, 16
+		return decodeMessagePackExt(data, begin, offset, 1<<(typ-0xd4))
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		size := 1 << (typ - 0xd9)
+		length, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMessagePackString(data, begin, offset+size, length)
+	case 0xdc, 0xdd: // array 16, 32
+		size := 2 << (typ - 0xdc)
+		length, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMessagePackArray(data, begin, offset+size, length, depth)
+	case 0xde, 0xdf: // map 16, 32
+		size := 2 << (typ - 0xde)
+		length, err := readStructuredUint(data, offset, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		return decodeMessagePackMap(data, begin, offset+size, length, depth)
+	}
+
+	return nil, 0, structuredError(begin, "invalid MessagePack type 0x%02x", typ)
+}
+
+func decodeMessagePackString(data []byte, begin, offset int, length uint64) (*StructuredValue, int, error) {
+	bytes, err := readStructured(data, offset, length)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	end := offset + len(bytes)
+	return structuredScalar(strconv.Quote(string(bytes)), begin, end), end, nil
+}
+
+// decodeMessagePackExt decodes the type and data of the extension at offset.
+func decodeMessagePackExt(data []byte, begin, offset int, length uint64) (*StructuredValue, int, error) {
+	if offset >= len(data) {
+		return nil, 0, structuredTruncated(offset)
+	}
+	extType := int(int8(data[offset]))
+
+	bytes, err := readStructured(data, offset+1, length)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	end := offset + 1 + len(bytes)
+	value := structuredScalar(formatStructuredBytes(bytes), begin, end)
+	value.Tag = "ext " + strconv.Itoa(extType)
+	if extType == MESSAGEPACK_EXT_TIMESTAMP {
+		if timestamp, ok := decodeMessagePackTimestamp(bytes); ok {
+			value.Tag = "timestamp"
+			value.Text = strconv.Quote(timestamp.UTC().Format(time.RFC3339Nano))
+		}
+	}
+
+	return value, end, nil
+}
+
+func decodeMessagePackTimestamp(data []byte) (time.Time, bool) {
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0), true
+	case 8:
+		value := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(value&0x3ffffffff), int64(value>>34)), true
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))), true
+	}
+
+	return time.Time{}, false
+}
+
+func decodeMessagePackArray(data []byte, begin, offset int, length uint64, depth int) (*StructuredValue, int, error) {
+	if depth >= STRUCTURED_MAX_DEPTH {
+		return nil, 0, structuredError(begin, "too deeply nested")
+	}
+
+	array := &StructuredValue{Kind: STRUCTURED_KIND_ARRAY, Offset: begin}
+	for i := uint64(0); i < length; i++ {
+		element, next, err := decodeMessagePack(data, offset, depth+1)
+		if element != nil {
+			array.Elements = append(array.Elements, element)
+		}
+		if err != nil {
+			array.Length = offset - begin
+			return array, 0, err
+		}
+
+		offset = next
+	}
+
+	array.Length = offset - begin
+	return array, offset, nil
+}
+
+func decodeMessagePackMap(data []byte, begin, offset int, length uint64, depth int) (*StructuredValue, int, error) {
+	if depth >= STRUCTURED_MAX_DEPTH {
+		return nil, 0, structuredError(begin, "too deeply nested")
+	}
+
+	entries := &StructuredValue{Kind: STRUCTURED_KIND_MAP, Offset: begin}
+	for i := uint64(0); i < length; i++ {
+		key, next, err := decodeMessagePack(data, offset, depth+1)
+		if err != nil {
+			entries.Length = offset - begin
+			return entries, 0, err
+		}
+
+		value, next, err := decodeMessagePack(data, next, depth+1)
+		if value != nil {
+			entries.Keys = append(entries.Keys, structuredKey(key))
+			entries.Elements = append(entries.Elements, value)
+		}
+		if err != nil {
+			entries.Length = offset - begin
+			return entries, 0, err
+		}
+
+		offset = next
+	}
+
+	entries.Length = offset - begin
+	return entries, offset, nil
+}
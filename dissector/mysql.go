@@ -0,0 +1,1899 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+const (
+	// MYSQL_HEADER_LENGTH is the length of the header of a packet, which is
+	// the 3 byte length of the payload and the sequence ID.
+	MYSQL_HEADER_LENGTH = 4
+	// MYSQL_MAX_PAYLOAD_LENGTH is the maximal length of the payload of a
+	// packet. Longer payloads are split, and a payload of exactly this length
+	// is continued by the next packet.
+	MYSQL_MAX_PAYLOAD_LENGTH = 0xffffff
+
+	MYSQL_PROTOCOL_VERSION_10 = 10
+)
+
+const (
+	MYSQL_CLIENT_LONG_PASSWORD                  = 1 << 0
+	MYSQL_CLIENT_FOUND_ROWS                     = 1 << 1
+	MYSQL_CLIENT_LONG_FLAG                      = 1 << 2
+	MYSQL_CLIENT_CONNECT_WITH_DB                = 1 << 3
+	MYSQL_CLIENT_NO_SCHEMA                      = 1 << 4
+	MYSQL_CLIENT_COMPRESS                       = 1 << 5
+	MYSQL_CLIENT_ODBC                           = 1 << 6
+	MYSQL_CLIENT_LOCAL_FILES                    = 1 << 7
+	MYSQL_CLIENT_IGNORE_SPACE                   = 1 << 8
+	MYSQL_CLIENT_PROTOCOL_41                    = 1 << 9
+	MYSQL_CLIENT_INTERACTIVE                    = 1 << 10
+	MYSQL_CLIENT_SSL                            = 1 << 11
+	MYSQL_CLIENT_IGNORE_SIGPIPE                 = 1 << 12
+	MYSQL_CLIENT_TRANSACTIONS                   = 1 << 13
+	MYSQL_CLIENT_RESERVED                       = 1 << 14
+	MYSQL_CLIENT_SECURE_CONNECTION              = 1 << 15
+	MYSQL_CLIENT_MULTI_STATEMENTS               = 1 << 16
+	MYSQL_CLIENT_MULTI_RESULTS                  = 1 << 17
+	MYSQL_CLIENT_PS_MULTI_RESULTS               = 1 << 18
+	MYSQL_CLIENT_PLUGIN_AUTH                    = 1 << 19
+	MYSQL_CLIENT_CONNECT_ATTRS                  = 1 << 20
+	MYSQL_CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA = 1 << 21
+	MYSQL_CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS   = 1 << 22
+	MYSQL_CLIENT_SESSION_TRACK                  = 1 << 23
+	MYSQL_CLIENT_DEPRECATE_EOF                  = 1 << 24
+	MYSQL_CLIENT_OPTIONAL_RESULTSET_METADATA    = 1 << 25
+	MYSQL_CLIENT_ZSTD_COMPRESSION_ALGORITHM     = 1 << 26
+	MYSQL_CLIENT_QUERY_ATTRIBUTES               = 1 << 27
+)
+
+var mysqlCapabilityNames = []string{
+	"CLIENT_LONG_PASSWORD",
+	"CLIENT_FOUND_ROWS",
+	"CLIENT_LONG_FLAG",
+	"CLIENT_CONNECT_WITH_DB",
+	"CLIENT_NO_SCHEMA",
+	"CLIENT_COMPRESS",
+	"CLIENT_ODBC",
+	"CLIENT_LOCAL_FILES",
+	"CLIENT_IGNORE_SPACE",
+	"CLIENT_PROTOCOL_41",
+	"CLIENT_INTERACTIVE",
+	"CLIENT_SSL",
+	"CLIENT_IGNORE_SIGPIPE",
+	"CLIENT_TRANSACTIONS",
+	"CLIENT_RESERVED",
+	"CLIENT_SECURE_CONNECTION",
+	"CLIENT_MULTI_STATEMENTS",
+	"CLIENT_MULTI_RESULTS",
+	"CLIENT_PS_MULTI_RESULTS",
+	"CLIENT_PLUGIN_AUTH",
+	"CLIENT_CONNECT_ATTRS",
+	"CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA",
+	"CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS",
+	"CLIENT_SESSION_TRACK",
+	"CLIENT_DEPRECATE_EOF",
+	"CLIENT_OPTIONAL_RESULTSET_METADATA",
+	"CLIENT_ZSTD_COMPRESSION_ALGORITHM",
+	"CLIENT_QUERY_ATTRIBUTES",
+}
+
+const (
+	MYSQL_SERVER_MORE_RESULTS_EXISTS   = 0x0008
+	MYSQL_SERVER_STATUS_CURSOR_EXISTS  = 0x0040
+	MYSQL_SERVER_SESSION_STATE_CHANGED = 0x4000
+)
+
+const (
+	MYSQL_COM_SLEEP               = 0x00
+	MYSQL_COM_QUIT                = 0x01
+	MYSQL_COM_INIT_DB             = 0x02
+	MYSQL_COM_QUERY               = 0x03
+	MYSQL_COM_FIELD_LIST          = 0x04
+	MYSQL_COM_CREATE_DB           = 0x05
+	MYSQL_COM_DROP_DB             = 0x06
+	MYSQL_COM_REFRESH             = 0x07
+	MYSQL_COM_SHUTDOWN            = 0x08
+	MYSQL_COM_STATISTICS          = 0x09
+	MYSQL_COM_PROCESS_INFO        = 0x0a
+	MYSQL_COM_CONNECT             = 0x0b
+	MYSQL_COM_PROCESS_KILL        = 0x0c
+	MYSQL_COM_DEBUG               = 0x0d
+	MYSQL_COM_PING                = 0x0e
+	MYSQL_COM_TIME                = 0x0f
+	MYSQL_COM_DELAYED_INSERT      = 0x10
+	MYSQL_COM_CHANGE_USER         = 0x11
+	MYSQL_COM_BINLOG_DUMP         = 0x12
+	MYSQL_COM_TABLE_DUMP          = 0x13
+	MYSQL_COM_CONNECT_OUT         = 0x14
+	MYSQL_COM_REGISTER_SLAVE      = 0x15
+	MYSQL_COM_STMT_PREPARE        = 0x16
+	MYSQL_COM_STMT_EXECUTE        = 0x17
+	MYSQL_COM_STMT_SEND_LONG_DATA = 0x18
+	MYSQL_COM_STMT_CLOSE          = 0x19
+	MYSQL_COM_STMT_RESET          = 0x1a
+	MYSQL_COM_SET_OPTION          = 0x1b
+	MYSQL_COM_STMT_FETCH          = 0x1c
+	MYSQL_COM_DAEMON              = 0x1d
+	MYSQL_COM_BINLOG_DUMP_GTID    = 0x1e
+	MYSQL_COM_RESET_CONNECTION    = 0x1f
+	MYSQL_COM_CLONE               = 0x20
+)
+
+var mysqlCommandNames = []string{
+	"COM_SLEEP",
+	"COM_QUIT",
+	"COM_INIT_DB",
+	"COM_QUERY",
+	"COM_FIELD_LIST",
+	"COM_CREATE_DB",
+	"COM_DROP_DB",
+	"COM_REFRESH",
+	"COM_SHUTDOWN",
+	"COM_STATISTICS",
+	"COM_PROCESS_INFO",
+	"COM_CONNECT",
+	"COM_PROCESS_KILL",
+	"COM_DEBUG",
+	"COM_PING",
+	"COM_TIME",
+	"COM_DELAYED_INSERT",
+	"COM_CHANGE_USER",
+	"COM_BINLOG_DUMP",
+	"COM_TABLE_DUMP",
+	"COM_CONNECT_OUT",
+	"COM_REGISTER_SLAVE",
+	"COM_STMT_PREPARE",
+	"COM_STMT_EXECUTE",
+	"COM_STMT_SEND_LONG_DATA",
+	"COM_STMT_CLOSE",
+	"COM_STMT_RESET",
+	"COM_SET_OPTION",
+	"COM_STMT_FETCH",
+	"COM_DAEMON",
+	"COM_BINLOG_DUMP_GTID",
+	"COM_RESET_CONNECTION",
+	"COM_CLONE",
+}
+
+func mysqlCommandName(command byte) string {
+	if int(command) < len(mysqlCommandNames) {
+		return mysqlCommandNames[command]
+	}
+
+	return fmt.Sprintf("Unknown (0x%02x)", command)
+}
+
+const (
+	MYSQL_TYPE_DECIMAL     = 0x00
+	MYSQL_TYPE_TINY        = 0x01
+	MYSQL_TYPE_SHORT       = 0x02
+	MYSQL_TYPE_LONG        = 0x03
+	MYSQL_TYPE_FLOAT       = 0x04
+	MYSQL_TYPE_DOUBLE      = 0x05
+	MYSQL_TYPE_NULL        = 0x06
+	MYSQL_TYPE_TIMESTAMP   = 0x07
+	MYSQL_TYPE_LONGLONG    = 0x08
+	MYSQL_TYPE_INT24       = 0x09
+	MYSQL_TYPE_DATE        = 0x0a
+	MYSQL_TYPE_TIME        = 0x0b
+	MYSQL_TYPE_DATETIME    = 0x0c
+	MYSQL_TYPE_YEAR        = 0x0d
+	MYSQL_TYPE_NEWDATE     = 0x0e
+	MYSQL_TYPE_VARCHAR     = 0x0f
+	MYSQL_TYPE_BIT         = 0x10
+	MYSQL_TYPE_TIMESTAMP2  = 0x11
+	MYSQL_TYPE_DATETIME2   = 0x12
+	MYSQL_TYPE_TIME2       = 0x13
+	MYSQL_TYPE_VECTOR      = 0xf2
+	MYSQL_TYPE_JSON        = 0xf5
+	MYSQL_TYPE_NEWDECIMAL  = 0xf6
+	MYSQL_TYPE_ENUM        = 0xf7
+	MYSQL_TYPE_SET         = 0xf8
+	MYSQL_TYPE_TINY_BLOB   = 0xf9
+	MYSQL_TYPE_MEDIUM_BLOB = 0xfa
+	MYSQL_TYPE_LONG_BLOB   = 0xfb
+	MYSQL_TYPE_BLOB        = 0xfc
+	MYSQL_TYPE_VAR_STRING  = 0xfd
+	MYSQL_TYPE_STRING      = 0xfe
+	MYSQL_TYPE_GEOMETRY    = 0xff
+)
+
+var mysqlTypeNames = map[byte]string{
+	MYSQL_TYPE_DECIMAL:     "DECIMAL",
+	MYSQL_TYPE_TINY:        "TINY",
+	MYSQL_TYPE_SHORT:       "SHORT",
+	MYSQL_TYPE_LONG:        "LONG",
+	MYSQL_TYPE_FLOAT:       "FLOAT",
+	MYSQL_TYPE_DOUBLE:      "DOUBLE",
+	MYSQL_TYPE_NULL:        "NULL",
+	MYSQL_TYPE_TIMESTAMP:   "TIMESTAMP",
+	MYSQL_TYPE_LONGLONG:    "LONGLONG",
+	MYSQL_TYPE_INT24:       "INT24",
+	MYSQL_TYPE_DATE:        "DATE",
+	MYSQL_TYPE_TIME:        "TIME",
+	MYSQL_TYPE_DATETIME:    "DATETIME",
+	MYSQL_TYPE_YEAR:        "YEAR",
+	MYSQL_TYPE_NEWDATE:     "NEWDATE",
+	MYSQL_TYPE_VARCHAR:     "VARCHAR",
+	MYSQL_TYPE_BIT:         "BIT",
+	MYSQL_TYPE_TIMESTAMP2:  "TIMESTAMP2",
+	MYSQL_TYPE_DATETIME2:   "DATETIME2",
+	MYSQL_TYPE_TIME2:       "TIME2",
+	MYSQL_TYPE_VECTOR:      "VECTOR",
+	MYSQL_TYPE_JSON:        "JSON",
+	MYSQL_TYPE_NEWDECIMAL:  "NEWDECIMAL",
+	MYSQL_TYPE_ENUM:        "ENUM",
+	MYSQL_TYPE_SET:         "SET",
+	MYSQL_TYPE_TINY_BLOB:   "TINY_BLOB",
+	MYSQL_TYPE_MEDIUM_BLOB: "MEDIUM_BLOB",
+	MYSQL_TYPE_LONG_BLOB:   "LONG_BLOB",
+	MYSQL_TYPE_BLOB:        "BLOB",
+	MYSQL_TYPE_VAR_STRING:  "VAR_STRING",
+	MYSQL_TYPE_STRING:      "STRING",
+	MYSQL_TYPE_GEOMETRY:    "GEOMETRY",
+}
+
+func mysqlTypeName(fieldType byte, unsigned bool) string {
+	name, ok := mysqlTypeNames[fieldType]
+	if !ok {
+		name = fmt.Sprintf("Unknown (0x%02x)", fieldType)
+	}
+
+	if unsigned {
+		name += " UNSIGNED"
+	}
+
+	return name
+}
+
+// MYSQL_UNSIGNED_FLAG is the flag of unsigned columns. Parameters are
+// unsigned if MYSQL_UNSIGNED_PARAMETER is set in their type.
+const (
+	MYSQL_UNSIGNED_FLAG      = 0x0020
+	MYSQL_UNSIGNED_PARAMETER = 0x8000
+)
+
+// MYSQL_PARAMETER_COUNT_AVAILABLE is the flag of COM_STMT_EXECUTE set when
+// the parameter count is sent.
+const MYSQL_PARAMETER_COUNT_AVAILABLE = 0x08
+
+// MYSQL_MIN_HANDSHAKE_LENGTH is the length of the shortest handshake: the
+// protocol version, an empty null terminated server version, the connection
+// ID, the first part of the auth data, a filler and the capability flags.
+const MYSQL_MIN_HANDSHAKE_LENGTH = 1 + 1 + 4 + 8 + 1 + 2
+
+type MySQLDissector struct{}
+
+func (MySQLDissector) Name() string {
+	return "mysql"
+}
+
+func (MySQLDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	// The server speaks first, with a handshake packet of sequence ID 0
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT || len(data) < MYSQL_HEADER_LENGTH+1 {
+		return false
+	}
+
+	length := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	return length <= 1024 && data[3] == 0 && data[4] == MYSQL_PROTOCOL_VERSION_10
+}
+
+func (MySQLDissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT || len(data) >= MYSQL_HEADER_LENGTH+1 {
+		return false
+	}
+
+	// The length is between MYSQL_MIN_HANDSHAKE_LENGTH and 1024, so its high
+	// byte is 0, and the sequence ID is 0
+	if len(data) >= 2 {
+		length := int(data[0]) | int(data[1])<<8
+		if length < MYSQL_MIN_HANDSHAKE_LENGTH || length > 1024 {
+			return false
+		}
+	}
+
+	return (len(data) < 3 || data[2] == 0) && (len(data) < 4 || data[3] == 0)
+}
+
+func (MySQLDissector) NewSession() Session {
+	return &mysqlSession{statements: make(map[uint32]*mysqlStatement)}
+}
+
+// mysqlPacket is a packet, along with the packets continuing its payload.
+type mysqlPacket struct {
+	// offset and length are the range of the packets in the message.
+	offset   int
+	length   int
+	sequence byte
+
+	payload []byte
+}
+
+// readMySQLPacket reads the packet at the given offset of data. It returns
+// nil if the packet isn't complete.
+func readMySQLPacket(data []byte, offset int) *mysqlPacket {
+	packet := &mysqlPacket{offset: offset}
+
+	for {
+		if offset+MYSQL_HEADER_LENGTH > len(data) {
+			return nil
+		}
+
+		length := int(data[offset]) | int(data[offset+1])<<8 | int(data[offset+2])<<16
+		if packet.length == 0 {
+			packet.sequence = data[offset+3]
+		}
+
+		payloadOffset := offset + MYSQL_HEADER_LENGTH
+		if payloadOffset+length > len(data) {
+			return nil
+		}
+
+		if packet.length == 0 && length < MYSQL_MAX_PAYLOAD_LENGTH {
+			packet.payload = data[payloadOffset : payloadOffset+length]
+		} else {
+			packet.payload = append(packet.payload, data[payloadOffset:payloadOffset+length]...)
+		}
+
+		offset = payloadOffset + length
+		packet.length = offset - packet.offset
+		if length < MYSQL_MAX_PAYLOAD_LENGTH {
+			return packet
+		}
+	}
+}
+
+// readMySQLPackets splits the content of a message into its packets.
+func readMySQLPackets(content []byte) ([]*mysqlPacket, error) {
+	var packets []*mysqlPacket
+	for offset := 0; offset < len(content); {
+		packet := readMySQLPacket(content, offset)
+		if packet == nil {
+			return nil, fmt.Errorf("incomplete MySQL packet at offset %d", offset)
+		}
+
+		packets = append(packets, packet)
+		offset += packet.length
+	}
+
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("empty MySQL message")
+	}
+
+	return packets, nil
+}
+
+// isMySQLTerminator checks if the packet ends a sequence of rows or column
+// definitions, which is an ERR packet or an EOF packet. When the EOF packet
+// is deprecated, it's replaced by an OK packet with the EOF header.
+func isMySQLTerminator(payload []byte, deprecateEOF bool) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	if deprecateEOF {
+		return payload[0] == 0xff || payload[0] == 0xfe && len(payload) < MYSQL_MAX_PAYLOAD_LENGTH
+	}
+
+	return payload[0] == 0xff || payload[0] == 0xfe && len(payload) < 9
+}
+
+// mysqlStatus returns the status flags of an OK or an EOF packet.
+func mysqlStatus(payload []byte, capabilities uint32) uint16 {
+	r := newReader(payload, binary.LittleEndian)
+	if r.byte() == 0xfe && len(payload) < 9 { // EOF
+		r.uint16() // Warnings
+		return r.uint16()
+	}
+
+	readMySQLLengthEncoded(r) // Affected rows
+	readMySQLLengthEncoded(r) // Last insert ID
+	if capabilities&(MYSQL_CLIENT_PROTOCOL_41|MYSQL_CLIENT_TRANSACTIONS) == 0 {
+		return 0
+	}
+
+	return r.uint16()
+}
+
+type mysqlPhase int
+
+const (
+	// MYSQL_PHASE_HANDSHAKE is the phase before the server's handshake.
+	MYSQL_PHASE_HANDSHAKE mysqlPhase = iota
+	// MYSQL_PHASE_HANDSHAKE_RESPONSE is the phase before the client's
+	// response to the handshake.
+	MYSQL_PHASE_HANDSHAKE_RESPONSE
+	// MYSQL_PHASE_AUTH is the exchange of authentication data, which ends
+	// with an OK packet from the server.
+	MYSQL_PHASE_AUTH
+	MYSQL_PHASE_COMMAND
+	// MYSQL_PHASE_ENCRYPTED is the phase after an SSLRequest, in which the
+	// connection can no longer be dissected.
+	MYSQL_PHASE_ENCRYPTED
+)
+
+type mysqlStatement struct {
+	parameters int
+	// parameterTypes are the types bound by the last COM_STMT_EXECUTE, which
+	// apply to following executions that don't bind types.
+	parameterTypes []uint16
+}
+
+type mysqlSession struct {
+	phase mysqlPhase
+
+	serverCapabilities uint32
+	// capabilities are the capabilities of both the client and the server.
+	capabilities uint32
+
+	// command is the command whose response is expected, if
+	// awaitingResponse is set.
+	command          byte
+	awaitingResponse bool
+
+	// infile is set while the client sends the file requested by a LOCAL
+	// INFILE request.
+	infile bool
+
+	statements map[uint32]*mysqlStatement
+}
+
+func (s *mysqlSession) deprecateEOF() bool {
+	return s.capabilities&MYSQL_CLIENT_DEPRECATE_EOF != 0
+}
+
+func (s *mysqlSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	if s.phase == MYSQL_PHASE_ENCRYPTED {
+		return len(data), nil, nil
+	}
+
+	packet := readMySQLPacket(data, 0)
+	if packet == nil {
+		return 0, nil, nil
+	}
+
+	decoder := MySQLDecoder{direction: direction, capabilities: s.capabilities}
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+		return s.frameClient(packet, decoder)
+	}
+
+	return s.frameServer(data, packet, decoder)
+}
+
+func (s *mysqlSession) frameClient(packet *mysqlPacket, decoder MySQLDecoder) (int, Decoder, error) {
+	switch s.phase {
+	case MYSQL_PHASE_HANDSHAKE:
+		return 0, nil, fmt.Errorf("the MySQL client sent data before the server's handshake")
+	case MYSQL_PHASE_HANDSHAKE_RESPONSE:
+		decoder.kind = MYSQL_PACKET_HANDSHAKE_RESPONSE
+
+		r := newReader(packet.payload, binary.LittleEndian)
+		s.capabilities = s.serverCapabilities & readMySQLClientCapabilities(r)
+		if s.capabilities&MYSQL_CLIENT_SSL != 0 && len(packet.payload) == 32 {
+			s.phase = MYSQL_PHASE_ENCRYPTED
+		} else {
+			s.phase = MYSQL_PHASE_AUTH
+		}
+	case MYSQL_PHASE_AUTH:
+		decoder.kind = MYSQL_PACKET_AUTH
+	case MYSQL_PHASE_COMMAND:
+		if s.infile {
+			decoder.kind = MYSQL_PACKET_INFILE_DATA
+			s.infile = len(packet.payload) != 0 // An empty packet ends the file
+			break
+		}
+
+		decoder.kind = MYSQL_PACKET_COMMAND
+		s.handleCommand(packet.payload, &decoder)
+	}
+
+	return packet.length, decoder, nil
+}
+
+// handleCommand updates the session with a command sent by the client.
+func (s *mysqlSession) handleCommand(payload []byte, decoder *MySQLDecoder) {
+	if len(payload) == 0 {
+		return
+	}
+
+	s.command, s.awaitingResponse = payload[0], true
+	switch payload[0] {
+	case MYSQL_COM_QUIT, MYSQL_COM_STMT_SEND_LONG_DATA:
+		s.awaitingResponse = false
+	case MYSQL_COM_STMT_CLOSE:
+		s.awaitingResponse = false
+		if len(payload) >= 5 {
+			delete(s.statements, binary.LittleEndian.Uint32(payload[1:]))
+		}
+	case MYSQL_COM_CHANGE_USER:
+		s.awaitingResponse = false
+		s.phase = MYSQL_PHASE_AUTH
+	case MYSQL_COM_STMT_EXECUTE:
+		if len(payload) < 5 {
+			break
+		}
+
+		statement, ok := s.statements[binary.LittleEndian.Uint32(payload[1:])]
+		if !ok {
+			break
+		}
+
+		decoder.statement = &mysqlStatement{statement.parameters, statement.parameterTypes}
+
+		r := newReader(payload[1:], binary.LittleEndian)
+		if _, types := decoder.decodeExecute(r); r.err == nil && types != nil {
+			statement.parameterTypes = types
+		}
+	}
+}
+
+func (s *mysqlSession) frameServer(data []byte, packet *mysqlPacket, decoder MySQLDecoder) (int, Decoder, error) {
+	switch s.phase {
+	case MYSQL_PHASE_HANDSHAKE:
+		decoder.kind = MYSQL_PACKET_HANDSHAKE
+		s.serverCapabilities = readMySQLServerCapabilities(packet.payload)
+		s.capabilities = s.serverCapabilities
+		s.phase = MYSQL_PHASE_HANDSHAKE_RESPONSE
+	case MYSQL_PHASE_HANDSHAKE_RESPONSE, MYSQL_PHASE_AUTH:
+		decoder.kind = MYSQL_PACKET_AUTH
+		if len(packet.payload) > 0 && packet.payload[0] == 0x00 {
+			s.phase = MYSQL_PHASE_COMMAND
+		}
+	case MYSQL_PHASE_COMMAND:
+		decoder.kind = MYSQL_PACKET_RESPONSE
+		if !s.awaitingResponse || len(packet.payload) == 0 {
+			break
+		}
+
+		decoder.command = s.command
+		length, moreResults := s.responseLength(data, packet)
+		if length == 0 {
+			return 0, nil, nil
+		}
+
+		s.awaitingResponse = moreResults
+		return length, decoder, nil
+	}
+
+	return packet.length, decoder, nil
+}
+
+// responseLength returns the length of the response to the pending command,
+// which begins with the given packet, and whether more results follow it. It
+// returns 0 if the response isn't complete.
+func (s *mysqlSession) responseLength(data []byte, first *mysqlPacket) (int, bool) {
+	header := first.payload[0]
+	if header == 0xff {
+		return first.length, false
+	}
+
+	switch s.command {
+	case MYSQL_COM_QUERY, MYSQL_COM_STMT_EXECUTE:
+		switch header {
+		case 0x00:
+			return first.length, mysqlStatus(first.payload, s.capabilities)&MYSQL_SERVER_MORE_RESULTS_EXISTS != 0
+		case 0xfb: // LOCAL INFILE request, answered once the client sends the file
+			s.infile = true
+			return first.length, true
+		}
+
+		length, status := s.resultSetLength(data, first)
+		return length, status&MYSQL_SERVER_MORE_RESULTS_EXISTS != 0
+	case MYSQL_COM_STMT_PREPARE:
+		if header != 0x00 {
+			return first.length, false
+		}
+
+		return s.prepareResponseLength(data, first), false
+	case MYSQL_COM_FIELD_LIST, MYSQL_COM_STMT_FETCH:
+		end, _ := readMySQLPacketsUntilTerminator(data, 0, s.deprecateEOF())
+		return end, false
+	default:
+		return first.length, false
+	}
+}
+
+// readMySQLPacketsUntilTerminator reads the packets beginning at the given
+// offset of data until a terminator, returning the end of the terminator and
+// the terminator. It returns 0 if the terminator wasn't read yet.
+func readMySQLPacketsUntilTerminator(data []byte, offset int, deprecateEOF bool) (int, *mysqlPacket) {
+	for {
+		packet := readMySQLPacket(data, offset)
+		if packet == nil {
+			return 0, nil
+		}
+
+		offset += packet.length
+		if isMySQLTerminator(packet.payload, deprecateEOF) {
+			return offset, packet
+		}
+	}
+}
+
+// resultSetLength returns the length of the result set beginning with the
+// given packet, which holds the column count, and the status flags of its
+// terminator. It returns 0 if the result set isn't complete.
+func (s *mysqlSession) resultSetLength(data []byte, first *mysqlPacket) (int, uint16) {
+	columns, _ := readMySQLLengthEncoded(newReader(first.payload, binary.LittleEndian))
+
+	offset := first.length
+	for range columns {
+		packet := readMySQLPacket(data, offset)
+		if packet == nil {
+			return 0, 0
+		}
+
+		offset += packet.length
+	}
+
+	if !s.deprecateEOF() {
+		eof := readMySQLPacket(data, offset)
+		if eof == nil {
+			return 0, 0
+		}
+
+		offset += eof.length
+
+		// A result set of a cursor has no rows, which are fetched later
+		status := mysqlStatus(eof.payload, s.capabilities)
+		if status&MYSQL_SERVER_STATUS_CURSOR_EXISTS != 0 {
+			return offset, status
+		}
+	}
+
+	end, terminator := readMySQLPacketsUntilTerminator(data, offset, s.deprecateEOF())
+	if end == 0 {
+		return 0, 0
+	}
+
+	if terminator.payload[0] == 0xff {
+		return end, 0
+	}
+
+	return end, mysqlStatus(terminator.payload, s.capabilities)
+}
+
+// prepareResponseLength returns the length of the response to a
+// COM_STMT_PREPARE, beginning with the given COM_STMT_PREPARE_OK packet,
+// recording the prepared statement. It returns 0 if the response isn't
+// complete.
+func (s *mysqlSession) prepareResponseLength(data []byte, first *mysqlPacket) int {
+	r := newReader(first.payload, binary.LittleEndian)
+	r.byte()
+	id := r.uint32()
+	columns := int(r.uint16())
+	parameters := int(r.uint16())
+	if r.err != nil {
+		return first.length
+	}
+
+	offset := first.length
+	for _, count := range []int{parameters, columns} {
+		if count == 0 {
+			continue
+		}
+
+		if !s.deprecateEOF() {
+			count++
+		}
+
+		for range count {
+			packet := readMySQLPacket(data, offset)
+			if packet == nil {
+				return 0
+			}
+
+			offset += packet.length
+		}
+	}
+
+	s.statements[id] = &mysqlStatement{parameters: parameters}
+	return offset
+}
+
+// readMySQLServerCapabilities reads the capabilities of the server from its
+// handshake.
+func readMySQLServerCapabilities(payload []byte) uint32 {
+	r := newReader(payload, binary.LittleEndian)
+	r.byte()    // Protocol version
+	r.cstring() // Server version
+	r.read(4 + 8 + 1)
+	capabilities := uint32(r.uint16())
+	if r.remaining() >= 5 {
+		r.read(3)
+		capabilities |= uint32(r.uint16()) << 16
+	}
+
+	return capabilities
+}
+
+// readMySQLClientCapabilities reads the capabilities of the client, which are
+// a 4 byte integer in the protocol version 4.1 and a 2 byte integer before
+// it.
+func readMySQLClientCapabilities(r *reader) uint32 {
+	capabilities := uint32(r.uint16())
+	if capabilities&MYSQL_CLIENT_PROTOCOL_41 == 0 {
+		return capabilities
+	}
+
+	return capabilities | uint32(r.uint16())<<16
+}
+
+type mysqlPacketKind int
+
+const (
+	MYSQL_PACKET_HANDSHAKE mysqlPacketKind = iota
+	// MYSQL_PACKET_HANDSHAKE_RESPONSE is the client's response to the
+	// handshake, or an SSLRequest sent instead of it.
+	MYSQL_PACKET_HANDSHAKE_RESPONSE
+	// MYSQL_PACKET_AUTH is a packet of the authentication exchange that
+	// follows the handshake or a COM_CHANGE_USER.
+	MYSQL_PACKET_AUTH
+	MYSQL_PACKET_COMMAND
+	// MYSQL_PACKET_RESPONSE is the response to a command, which may span
+	// multiple packets, such as a result set.
+	MYSQL_PACKET_RESPONSE
+	// MYSQL_PACKET_INFILE_DATA is a part of a file sent by the client for a
+	// LOCAL INFILE request.
+	MYSQL_PACKET_INFILE_DATA
+)
+
+// MySQLDecoder decodes MySQL packets, and the responses made of multiple
+// packets.
+type MySQLDecoder struct {
+	direction tcpmessage.TransmittionDirection
+	kind      mysqlPacketKind
+
+	capabilities uint32
+	// command is the command answered by a response.
+	command byte
+	// statement is the statement executed by a COM_STMT_EXECUTE, if known.
+	statement *mysqlStatement
+}
+
+func (MySQLDecoder) Name() string {
+	return "mysql"
+}
+
+func (d MySQLDecoder) deprecateEOF() bool {
+	return d.capabilities&MYSQL_CLIENT_DEPRECATE_EOF != 0
+}
+
+// mysqlPayloadDecoder decodes the payload of a packet into fields, setting
+// the summary of the dissection.
+type mysqlPayloadDecoder func(r *reader, dissection *Dissection) []*Field
+
+// decode decodes the payload of the packet, returning its fields and its
+// summary.
+func (packet *mysqlPacket) decode(decodePayload mysqlPayloadDecoder) ([]*Field, string, error) {
+	r := newReader(packet.payload, binary.LittleEndian)
+	dissection := &Dissection{}
+
+	fields := decodePayload(r, dissection)
+	if r.err != nil {
+		return nil, "", r.err
+	}
+
+	if r.remaining() > 0 {
+		fields = append(fields, &Field{Name: "Data", Value: DescribeBody(r.data[r.offset:]), Offset: r.offset, Length: r.remaining()})
+	}
+
+	offsetFields(fields, packet.offset+MYSQL_HEADER_LENGTH)
+	return fields, dissection.Summary, nil
+}
+
+// field decodes the packet into a field spanning it, whose value is the
+// summary of the packet.
+func (packet *mysqlPacket) field(name string, decodePayload mysqlPayloadDecoder) (*Field, error) {
+	children, summary, err := packet.decode(decodePayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Field{Name: name, Value: summary, Offset: packet.offset, Length: packet.length, Children: children}, nil
+}
+
+func (packet *mysqlPacket) headerFields() []*Field {
+	return []*Field{
+		{Name: "Payload length", Value: strconv.Itoa(len(packet.payload)), Offset: packet.offset, Length: 3},
+		{Name: "Sequence ID", Value: strconv.Itoa(int(packet.sequence)), Offset: packet.offset + 3, Length: 1},
+	}
+}
+
+func (d MySQLDecoder) Decode(content []byte) (*Dissection, error) {
+	packets, err := readMySQLPackets(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.kind == MYSQL_PACKET_RESPONSE && len(packets) > 1 {
+		return d.decodeResponse(packets)
+	}
+
+	if len(packets) != 1 {
+		return nil, fmt.Errorf("expected a single MySQL packet, found %d", len(packets))
+	}
+
+	var decodePayload mysqlPayloadDecoder
+	switch d.kind {
+	case MYSQL_PACKET_HANDSHAKE:
+		decodePayload = decodeMySQLHandshake
+	case MYSQL_PACKET_HANDSHAKE_RESPONSE:
+		decodePayload = decodeMySQLHandshakeResponse
+	case MYSQL_PACKET_AUTH:
+		decodePayload = d.decodeAuth
+	case MYSQL_PACKET_COMMAND:
+		decodePayload = d.decodeCommand
+	case MYSQL_PACKET_RESPONSE:
+		decodePayload = d.decodeResponsePacket
+	case MYSQL_PACKET_INFILE_DATA:
+		decodePayload = decodeMySQLInfileData
+	default:
+		panic("invalid MySQL packet kind")
+	}
+
+	fields, summary, err := packets[0].decode(decodePayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dissection{Summary: summary, Fields: append(packets[0].headerFields(), fields...)}, nil
+}
+
+func readMySQLUint24(r *reader) int {
+	data := r.read(3)
+	if data == nil {
+		return 0
+	}
+
+	return int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+}
+
+// readMySQLLengthEncoded reads a length encoded integer, returning whether
+// it's the NULL marker instead.
+func readMySQLLengthEncoded(r *reader) (uint64, bool) {
+	first := r.byte()
+	switch first {
+	case 0xfb:
+		return 0, true
+	case 0xfc:
+		return uint64(r.uint16()), false
+	case 0xfd:
+		return uint64(readMySQLUint24(r)), false
+	case 0xfe:
+		return r.uint64(), false
+	case 0xff:
+		r.err = fmt.Errorf("invalid length encoded integer at offset %d", r.offset-1)
+		return 0, false
+	default:
+		return uint64(first), false
+	}
+}
+
+// readMySQLLengthEncodedString reads a string prefixed by its length encoded
+// length, returning whether it's NULL instead.
+func readMySQLLengthEncodedString(r *reader) ([]byte, bool) {
+	length, isNull := readMySQLLengthEncoded(r)
+	if isNull || r.err != nil {
+		return nil, isNull
+	}
+
+	if length > uint64(r.remaining()) {
+		r.err = fmt.Errorf("string at offset %d is longer than the packet", r.offset)
+		return nil, false
+	}
+
+	return r.read(int(length)), false
+}
+
+func mysqlLengthEncodedField(r *reader, name string) *Field {
+	begin := r.offset
+	value, isNull := readMySQLLengthEncoded(r)
+	if isNull {
+		return r.field(name, "NULL", begin)
+	}
+
+	return r.field(name, value, begin)
+}
+
+func mysqlLengthEncodedStringField(r *reader, name string) *Field {
+	begin := r.offset
+	value, isNull := readMySQLLengthEncodedString(r)
+	if isNull {
+		return r.field(name, "NULL", begin)
+	}
+
+	return r.field(name, DescribeBody(value), begin)
+}
+
+// mysqlCapabilitiesField returns a field of capabilities read since begin,
+// with a child per capability.
+func mysqlCapabilitiesField(r *reader, name string, capabilities uint32, begin int) *Field {
+	field := r.field(name, fmt.Sprintf("0x%08x", capabilities), begin)
+	for i, capability := range mysqlCapabilityNames {
+		if capabilities&(1<<i) != 0 {
+			field.Children = append(field.Children, &Field{Name: capability, Offset: begin})
+		}
+	}
+
+	return field
+}
+
+func decodeMySQLHandshake(r *reader, dissection *Dissection) []*Field {
+	if len(r.data) > 0 && r.data[0] == 0xff { // The server refused the connection
+		return decodeMySQLError(r, dissection)
+	}
+
+	fields := []*Field{r.byteField("Protocol version"), r.cstringField("Server version"), r.uint32Field("Connection ID")}
+	dissection.Summary = "Handshake " + fields[1].Value
+
+	begin := r.offset
+	fields = append(fields, r.field("Auth plugin data (part 1)", fmt.Sprintf("%x", r.read(8)), begin))
+	r.byte() // Filler
+
+	begin = r.offset
+	capabilities := uint32(r.uint16())
+	fields = append(fields, mysqlCapabilitiesField(r, "Capabilities (lower)", capabilities, begin))
+	if r.remaining() == 0 {
+		return fields
+	}
+
+	fields = append(fields, r.byteField("Character set"))
+
+	begin = r.offset
+	fields = append(fields, r.field("Status", fmt.Sprintf("0x%04x", r.uint16()), begin))
+
+	begin = r.offset
+	upper := uint32(r.uint16()) << 16
+	capabilities |= upper
+	fields = append(fields, mysqlCapabilitiesField(r, "Capabilities (upper)", upper, begin))
+
+	begin = r.offset
+	authDataLength := int(r.byte())
+	fields = append(fields, r.field("Auth plugin data length", authDataLength, begin))
+	r.read(10) // Reserved
+
+	if capabilities&MYSQL_CLIENT_SECURE_CONNECTION != 0 {
+		begin = r.offset
+		fields = append(fields, r.field("Auth plugin data (part 2)", fmt.Sprintf("%x", r.read(max(13, authDataLength-8))), begin))
+	}
+
+	if capabilities&MYSQL_CLIENT_PLUGIN_AUTH != 0 {
+		// Some servers don't terminate the name
+		begin = r.offset
+		fields = append(fields, r.field("Auth plugin name", string(bytes.TrimSuffix(r.rest(), []byte{0})), begin))
+	}
+
+	return fields
+}
+
+func decodeMySQLHandshakeResponse(r *reader, dissection *Dissection) []*Field {
+	begin := r.offset
+	capabilities := readMySQLClientCapabilities(r)
+	fields := []*Field{mysqlCapabilitiesField(r, "Capabilities", capabilities, begin)}
+
+	if capabilities&MYSQL_CLIENT_PROTOCOL_41 == 0 {
+		begin = r.offset
+		fields = append(fields, r.field("Max packet size", readMySQLUint24(r), begin))
+
+		username := r.cstringField("Username")
+		dissection.Summary = "HandshakeResponse320 user=" + username.Value
+		return append(fields, username)
+	}
+
+	fields = append(fields, r.uint32Field("Max packet size"), r.byteField("Character set"))
+	r.read(23) // Filler
+	if r.remaining() == 0 {
+		dissection.Summary = "SSLRequest"
+		return fields
+	}
+
+	username := r.cstringField("Username")
+	dissection.Summary = "HandshakeResponse user=" + username.Value
+	fields = append(fields, username)
+
+	begin = r.offset
+	var authResponse []byte
+	switch {
+	case capabilities&MYSQL_CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+		authResponse, _ = readMySQLLengthEncodedString(r)
+	case capabilities&MYSQL_CLIENT_SECURE_CONNECTION != 0:
+		authResponse = r.read(int(r.byte()))
+	default:
+		authResponse = []byte(r.cstring())
+	}
+	fields = append(fields, r.field("Auth response", fmt.Sprintf("%x", authResponse), begin))
+
+	if capabilities&MYSQL_CLIENT_CONNECT_WITH_DB != 0 && r.remaining() > 0 {
+		database := r.cstringField("Database")
+		dissection.Summary += " database=" + database.Value
+		fields = append(fields, database)
+	}
+
+	if capabilities&MYSQL_CLIENT_PLUGIN_AUTH != 0 && r.remaining() > 0 {
+		fields = append(fields, r.cstringField("Auth plugin name"))
+	}
+
+	if capabilities&MYSQL_CLIENT_CONNECT_ATTRS != 0 && r.remaining() > 0 {
+		begin = r.offset
+		length, _ := readMySQLLengthEncoded(r)
+		if length > uint64(r.remaining()) {
+			r.err = fmt.Errorf("connection attributes at offset %d are longer than the packet", begin)
+			return fields
+		}
+
+		attributesOffset := r.offset
+		attributesReader := newReader(r.read(int(length)), binary.LittleEndian)
+
+		var attributes []*Field
+		for attributesReader.err == nil && attributesReader.remaining() > 0 {
+			begin := attributesReader.offset
+			key, _ := readMySQLLengthEncodedString(attributesReader)
+			value, _ := readMySQLLengthEncodedString(attributesReader)
+			attributes = append(attributes, attributesReader.field(string(key), DescribeBody(value), begin))
+		}
+		if attributesReader.err != nil {
+			r.err = attributesReader.err
+		}
+
+		offsetFields(attributes, attributesOffset)
+		fields = append(fields, &Field{Name: "Connection attributes", Offset: begin, Length: r.offset - begin, Children: attributes})
+	}
+
+	if capabilities&MYSQL_CLIENT_ZSTD_COMPRESSION_ALGORITHM != 0 && r.remaining() > 0 {
+		fields = append(fields, r.byteField("Compression level"))
+	}
+
+	return fields
+}
+
+func (d MySQLDecoder) decodeAuth(r *reader, dissection *Dissection) []*Field {
+	dissection.Summary = "Auth data"
+	if d.direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(r.data) == 0 {
+		begin := r.offset
+		return []*Field{r.field("Auth data", fmt.Sprintf("%x", r.rest()), begin)}
+	}
+
+	begin := r.offset
+	switch r.byte() {
+	case 0x00:
+		r.offset = begin
+		return decodeMySQLOK(r, d.capabilities, dissection)
+	case 0xff:
+		r.offset = begin
+		return decodeMySQLError(r, dissection)
+	case 0xfe:
+		dissection.Summary = "AuthSwitchRequest"
+		fields := []*Field{r.field("Header", dissection.Summary, begin)}
+		if r.remaining() == 0 { // The old request, for pre-4.1 passwords
+			return fields
+		}
+
+		plugin := r.cstringField("Auth plugin name")
+		dissection.Summary += " " + plugin.Value
+
+		begin = r.offset
+		return append(fields, plugin, r.field("Auth plugin data", fmt.Sprintf("%x", r.rest()), begin))
+	case 0x01:
+		dissection.Summary = "AuthMoreData"
+		fields := []*Field{r.field("Header", dissection.Summary, begin)}
+
+		begin = r.offset
+		data := r.rest()
+		if len(data) == 1 { // caching_sha2_password reports the result of the fast authentication
+			switch data[0] {
+			case 3:
+				dissection.Summary += " (fast auth success)"
+			case 4:
+				dissection.Summary += " (full auth required)"
+			}
+		}
+
+		return append(fields, r.field("Data", DescribeBody(data), begin))
+	case 0x02:
+		dissection.Summary = "AuthNextFactor"
+		fields := []*Field{r.field("Header", dissection.Summary, begin), r.cstringField("Auth plugin name")}
+
+		begin = r.offset
+		return append(fields, r.field("Auth plugin data", fmt.Sprintf("%x", r.rest()), begin))
+	default:
+		r.offset = begin
+		return nil
+	}
+}
+
+func decodeMySQLOK(r *reader, capabilities uint32, dissection *Dissection) []*Field {
+	begin := r.offset
+	r.byte()
+	fields := []*Field{
+		r.field("Header", "OK", begin),
+		mysqlLengthEncodedField(r, "Affected rows"),
+		mysqlLengthEncodedField(r, "Last insert ID"),
+	}
+
+	dissection.Summary = "OK"
+	if affectedRows := fields[1].Value; affectedRows != "0" {
+		dissection.Summary += fmt.Sprintf(", %s rows affected", affectedRows)
+	}
+
+	var status uint16
+	if capabilities&(MYSQL_CLIENT_PROTOCOL_41|MYSQL_CLIENT_TRANSACTIONS) != 0 {
+		begin = r.offset
+		status = r.uint16()
+		fields = append(fields, r.field("Status", fmt.Sprintf("0x%04x", status), begin))
+	}
+	if capabilities&MYSQL_CLIENT_PROTOCOL_41 != 0 {
+		fields = append(fields, r.uint16Field("Warnings"))
+	}
+
+	var info *Field
+	if capabilities&MYSQL_CLIENT_SESSION_TRACK != 0 {
+		if r.remaining() > 0 {
+			info = mysqlLengthEncodedStringField(r, "Info")
+			fields = append(fields, info)
+		}
+		if status&MYSQL_SERVER_SESSION_STATE_CHANGED != 0 {
+			fields = append(fields, mysqlLengthEncodedStringField(r, "Session state changes"))
+		}
+	} else if r.remaining() > 0 {
+		begin = r.offset
+		info = r.field("Info", DescribeBody(r.rest()), begin)
+		fields = append(fields, info)
+	}
+
+	if info != nil && info.Value != "" {
+		dissection.Summary += ": " + info.Value
+	}
+
+	return fields
+}
+
+func decodeMySQLError(r *reader, dissection *Dissection) []*Field {
+	begin := r.offset
+	r.byte()
+	code := r.uint16Field("Error code")
+	fields := []*Field{r.field("Header", "ERR", begin), code}
+
+	dissection.Summary = "ERR " + code.Value
+	if r.remaining() > 0 && r.data[r.offset] == '#' {
+		begin = r.offset
+		r.byte()
+		state := r.field("SQL state", string(r.read(5)), begin)
+		dissection.Summary += fmt.Sprintf(" (%s)", state.Value)
+		fields = append(fields, state)
+	}
+
+	begin = r.offset
+	message := r.field("Message", string(r.rest()), begin)
+	dissection.Summary += ": " + message.Value
+	return append(fields, message)
+}
+
+func decodeMySQLEOF(r *reader, dissection *Dissection) []*Field {
+	dissection.Summary = "EOF"
+
+	begin := r.offset
+	r.byte()
+	fields := []*Field{r.field("Header", "EOF", begin), r.uint16Field("Warnings")}
+
+	begin = r.offset
+	return append(fields, r.field("Status", fmt.Sprintf("0x%04x", r.uint16()), begin))
+}
+
+// decodeTerminator decodes the packet ending a sequence of rows or column
+// definitions.
+func (d MySQLDecoder) decodeTerminator(r *reader, dissection *Dissection) []*Field {
+	switch {
+	case len(r.data) > 0 && r.data[0] == 0xff:
+		return decodeMySQLError(r, dissection)
+	case d.deprecateEOF():
+		return decodeMySQLOK(r, d.capabilities, dissection)
+	default:
+		return decodeMySQLEOF(r, dissection)
+	}
+}
+
+func decodeMySQLInfileData(r *reader, dissection *Dissection) []*Field {
+	if len(r.data) == 0 {
+		dissection.Summary = "LOCAL INFILE end"
+		return nil
+	}
+
+	dissection.Summary = fmt.Sprintf("LOCAL INFILE data (%d bytes)", len(r.data))
+	return nil
+}
+
+func (d MySQLDecoder) decodeCommand(r *reader, dissection *Dissection) []*Field {
+	begin := r.offset
+	command := r.byte()
+	dissection.Summary = mysqlCommandName(command)
+	fields := []*Field{r.field("Command", dissection.Summary, begin)}
+
+	switch command {
+	case MYSQL_COM_QUERY, MYSQL_COM_STMT_PREPARE:
+		if command == MYSQL_COM_QUERY && d.capabilities&MYSQL_CLIENT_QUERY_ATTRIBUTES != 0 {
+			fields = append(fields, d.decodeQueryAttributes(r)...)
+		}
+
+		begin = r.offset
+		query := r.field("Query", string(r.rest()), begin)
+		query.Payload = true
+		if command == MYSQL_COM_QUERY {
+			dissection.Summary = summaryText(query.Value)
+		} else {
+			dissection.Summary = "Prepare: " + summaryText(query.Value)
+		}
+		return append(fields, query)
+	case MYSQL_COM_INIT_DB, MYSQL_COM_CREATE_DB, MYSQL_COM_DROP_DB:
+		begin = r.offset
+		schema := r.field("Schema", string(r.rest()), begin)
+		dissection.Summary += " " + schema.Value
+		return append(fields, schema)
+	case MYSQL_COM_FIELD_LIST:
+		table := r.cstringField("Table")
+		dissection.Summary += " " + table.Value
+
+		begin = r.offset
+		return append(fields, table, r.field("Wildcard", string(r.rest()), begin))
+	case MYSQL_COM_PROCESS_KILL:
+		return append(fields, r.uint32Field("Connection ID"))
+	case MYSQL_COM_SET_OPTION:
+		return append(fields, r.uint16Field("Option"))
+	case MYSQL_COM_CHANGE_USER:
+		username := r.cstringField("Username")
+		dissection.Summary += " user=" + username.Value
+		fields = append(fields, username)
+
+		begin = r.offset
+		var authResponse []byte
+		if d.capabilities&MYSQL_CLIENT_SECURE_CONNECTION != 0 {
+			authResponse = r.read(int(r.byte()))
+		} else {
+			authResponse = []byte(r.cstring())
+		}
+		return append(fields, r.field("Auth response", fmt.Sprintf("%x", authResponse), begin), r.cstringField("Schema"))
+	case MYSQL_COM_STMT_EXECUTE:
+		executeFields, _ := d.decodeExecute(r)
+		dissection.Summary += " statement " + executeFields[0].Value
+		return append(fields, executeFields...)
+	case MYSQL_COM_STMT_CLOSE, MYSQL_COM_STMT_RESET:
+		id := r.uint32Field("Statement ID")
+		dissection.Summary += " statement " + id.Value
+		return append(fields, id)
+	case MYSQL_COM_STMT_FETCH:
+		id := r.uint32Field("Statement ID")
+		dissection.Summary += " statement " + id.Value
+		return append(fields, id, r.uint32Field("Rows"))
+	case MYSQL_COM_STMT_SEND_LONG_DATA:
+		id := r.uint32Field("Statement ID")
+		dissection.Summary += " statement " + id.Value
+		return append(fields, id, r.uint16Field("Parameter ID"))
+	default:
+		return fields
+	}
+}
+
+// decodeQueryAttributes decodes the query attributes preceding the query of
+// a COM_QUERY.
+func (d MySQLDecoder) decodeQueryAttributes(r *reader) []*Field {
+	begin := r.offset
+	count, _ := readMySQLLengthEncoded(r)
+	fields := []*Field{r.field("Parameter count", count, begin), mysqlLengthEncodedField(r, "Parameter set count")}
+	if count == 0 {
+		return fields
+	}
+
+	if count > uint64(r.remaining()) {
+		r.err = fmt.Errorf("invalid parameter count %d", count)
+		return fields
+	}
+
+	parameters, _ := readMySQLParameters(r, int(count), nil, true)
+	return append(fields, parameters...)
+}
+
+// decodeExecute decodes the fields of a COM_STMT_EXECUTE following the
+// command, returning them along with the types of the parameters.
+func (d MySQLDecoder) decodeExecute(r *reader) ([]*Field, []uint16) {
+	fields := []*Field{r.uint32Field("Statement ID")}
+
+	begin := r.offset
+	flags := r.byte()
+	fields = append(fields, r.field("Flags", fmt.Sprintf("0x%02x", flags), begin), r.uint32Field("Iteration count"))
+
+	var count int
+	var types []uint16
+	if d.statement != nil {
+		count, types = d.statement.parameters, d.statement.parameterTypes
+	}
+
+	withNames := d.capabilities&MYSQL_CLIENT_QUERY_ATTRIBUTES != 0
+	if withNames && flags&MYSQL_PARAMETER_COUNT_AVAILABLE != 0 {
+		begin = r.offset
+		parameterCount, _ := readMySQLLengthEncoded(r)
+		if parameterCount > uint64(r.remaining()) {
+			r.err = fmt.Errorf("invalid parameter count %d", parameterCount)
+			return fields, nil
+		}
+
+		count = int(parameterCount)
+		fields = append(fields, r.field("Parameter count", count, begin))
+	}
+
+	if count == 0 || r.remaining() == 0 {
+		return fields, nil
+	}
+
+	parameters, types := readMySQLParameters(r, count, types, withNames)
+	return append(fields, parameters...), types
+}
+
+// readMySQLParameters reads the parameters of a COM_STMT_EXECUTE, or the
+// query attributes of a COM_QUERY. types are the types bound by a previous
+// execution, used unless the parameters bind new types. It returns the types
+// of the parameters.
+func readMySQLParameters(r *reader, count int, types []uint16, withNames bool) ([]*Field, []uint16) {
+	begin := r.offset
+	nullBitmap := r.read((count + 7) / 8)
+	fields := []*Field{r.field("NULL bitmap", fmt.Sprintf("%x", nullBitmap), begin)}
+
+	begin = r.offset
+	bound := r.byte() == 1
+	fields = append(fields, r.field("New parameters bound", bound, begin))
+
+	var names []string
+	if bound {
+		types = nil
+
+		var typeFields []*Field
+		for i := 0; i < count && r.err == nil; i++ {
+			begin := r.offset
+			parameterType := r.uint16()
+			types = append(types, parameterType)
+
+			name := fmt.Sprintf("[%d]", i)
+			if withNames {
+				parameterName, _ := readMySQLLengthEncodedString(r)
+				names = append(names, string(parameterName))
+				name += " " + string(parameterName)
+			}
+
+			typeName := mysqlTypeName(byte(parameterType), parameterType&MYSQL_UNSIGNED_PARAMETER != 0)
+			typeFields = append(typeFields, r.field(name, typeName, begin))
+		}
+		fields = append(fields, groupField("Types", typeFields))
+	}
+
+	var values []*Field
+	for i := 0; i < count && r.err == nil; i++ {
+		name := fmt.Sprintf("[%d]", i)
+		if i < len(names) {
+			name += " " + names[i]
+		}
+
+		if nullBitmap[i/8]&(1<<(i%8)) != 0 {
+			values = append(values, &Field{Name: name, Value: "NULL", Offset: r.offset})
+			continue
+		}
+
+		if i >= len(types) { // The values can't be decoded without their types
+			break
+		}
+
+		begin := r.offset
+		value := readMySQLBinaryValue(r, byte(types[i]), types[i]&MYSQL_UNSIGNED_PARAMETER != 0)
+		values = append(values, r.field(name, value, begin))
+	}
+
+	return append(fields, groupField("Values", values)), types
+}
+
+// readMySQLBinaryValue reads a value of the given type, encoded by the binary
+// protocol of prepared statements.
+func readMySQLBinaryValue(r *reader, fieldType byte, unsigned bool) string {
+	switch fieldType {
+	case MYSQL_TYPE_NULL:
+		return "NULL"
+	case MYSQL_TYPE_TINY:
+		value := r.byte()
+		if unsigned {
+			return strconv.Itoa(int(value))
+		}
+		return strconv.Itoa(int(int8(value)))
+	case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+		value := r.uint16()
+		if unsigned || fieldType == MYSQL_TYPE_YEAR {
+			return strconv.Itoa(int(value))
+		}
+		return strconv.Itoa(int(int16(value)))
+	case MYSQL_TYPE_LONG, MYSQL_TYPE_INT24:
+		value := r.uint32()
+		if unsigned {
+			return strconv.FormatUint(uint64(value), 10)
+		}
+		return strconv.Itoa(int(int32(value)))
+	case MYSQL_TYPE_LONGLONG:
+		value := r.uint64()
+		if unsigned {
+			return strconv.FormatUint(value, 10)
+		}
+		return strconv.FormatInt(int64(value), 10)
+	case MYSQL_TYPE_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(r.uint32())), 'g', -1, 32)
+	case MYSQL_TYPE_DOUBLE:
+		return strconv.FormatFloat(math.Float64frombits(r.uint64()), 'g', -1, 64)
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
+		return readMySQLDateTime(r, fieldType == MYSQL_TYPE_DATE)
+	case MYSQL_TYPE_TIME:
+		return readMySQLTime(r)
+	default:
+		value, isNull := readMySQLLengthEncodedString(r)
+		if isNull {
+			return "NULL"
+		}
+
+		return DescribeBody(value)
+	}
+}
+
+// readMySQLDateTime reads a date, which is prefixed by its length as trailing
+// zero parts are omitted.
+func readMySQLDateTime(r *reader, isDate bool) string {
+	date := newReader(r.read(int(r.byte())), binary.LittleEndian)
+
+	year := date.uint16()
+	month, day := date.byte(), date.byte()
+	hour, minute, second := date.byte(), date.byte(), date.byte()
+	microsecond := date.uint32()
+
+	formatted := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+	if !isDate {
+		formatted += fmt.Sprintf(" %02d:%02d:%02d", hour, minute, second)
+		if microsecond != 0 {
+			formatted += fmt.Sprintf(".%06d", microsecond)
+		}
+	}
+
+	return formatted
+}
+
+// readMySQLTime reads a time interval, which is prefixed by its length as
+// trailing zero parts are omitted.
+func readMySQLTime(r *reader) string {
+	interval := newReader(r.read(int(r.byte())), binary.LittleEndian)
+
+	sign := ""
+	if interval.byte() == 1 {
+		sign = "-"
+	}
+
+	days := interval.uint32()
+	hour, minute, second := interval.byte(), interval.byte(), interval.byte()
+	microsecond := interval.uint32()
+
+	formatted := fmt.Sprintf("%s%02d:%02d:%02d", sign, uint64(days)*24+uint64(hour), minute, second)
+	if microsecond != 0 {
+		formatted += fmt.Sprintf(".%06d", microsecond)
+	}
+
+	return formatted
+}
+
+// decodeResponsePacket decodes a response of a single packet.
+func (d MySQLDecoder) decodeResponsePacket(r *reader, dissection *Dissection) []*Field {
+	if len(r.data) == 0 {
+		dissection.Summary = "Empty packet"
+		return nil
+	}
+
+	switch header := r.data[0]; {
+	case header == 0xff:
+		return decodeMySQLError(r, dissection)
+	case header == 0x00 && d.command == MYSQL_COM_STMT_PREPARE:
+		return decodeMySQLPrepareOK(r, dissection)
+	case header == 0x00:
+		return decodeMySQLOK(r, d.capabilities, dissection)
+	case header == 0xfe:
+		return d.decodeTerminator(r, dissection)
+	case header == 0xfb && d.command == MYSQL_COM_QUERY:
+		begin := r.offset
+		r.byte()
+		fields := []*Field{r.field("Header", "LOCAL INFILE", begin)}
+
+		begin = r.offset
+		filename := r.field("Filename", string(r.rest()), begin)
+		dissection.Summary = "LOCAL INFILE " + filename.Value
+		return append(fields, filename)
+	case d.command == MYSQL_COM_STATISTICS:
+		begin := r.offset
+		statistics := r.field("Statistics", string(r.rest()), begin)
+		dissection.Summary = statistics.Value
+		return []*Field{statistics}
+	default:
+		dissection.Summary = "Response"
+		return nil
+	}
+}
+
+func decodeMySQLPrepareOK(r *reader, dissection *Dissection) []*Field {
+	begin := r.offset
+	r.byte()
+	fields := []*Field{
+		r.field("Header", "OK", begin),
+		r.uint32Field("Statement ID"),
+		r.uint16Field("Column count"),
+		r.uint16Field("Parameter count"),
+	}
+	r.byte() // Filler
+
+	if r.remaining() > 0 {
+		fields = append(fields, r.uint16Field("Warnings"))
+	}
+	if r.remaining() > 0 {
+		fields = append(fields, r.byteField("Metadata follows"))
+	}
+
+	dissection.Summary = fmt.Sprintf("Prepared statement %s: %s parameters, %s columns", fields[1].Value, fields[3].Value, fields[2].Value)
+	return fields
+}
+
+// decodeResponse decodes a response of multiple packets.
+func (d MySQLDecoder) decodeResponse(packets []*mysqlPacket) (*Dissection, error) {
+	switch d.command {
+	case MYSQL_COM_STMT_PREPARE:
+		return d.decodePrepareResponse(packets)
+	case MYSQL_COM_FIELD_LIST:
+		columns, _, err := decodeMySQLColumns(packets[:len(packets)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		terminator, err := packets[len(packets)-1].field("End", d.decodeTerminator)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Dissection{
+			Summary: fmt.Sprintf("Field list: %d columns", len(packets)-1),
+			Fields:  []*Field{columns, terminator},
+		}, nil
+	case MYSQL_COM_STMT_FETCH:
+		var rows []*Field
+		for i, packet := range packets[:len(packets)-1] {
+			// The columns of the rows were sent with the execution, so the
+			// rows aren't decoded
+			row, err := packet.field(fmt.Sprintf("[%d]", i), func(*reader, *Dissection) []*Field { return nil })
+			if err != nil {
+				return nil, err
+			}
+
+			rows = append(rows, row)
+		}
+
+		terminator, err := packets[len(packets)-1].field("End", d.decodeTerminator)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Dissection{
+			Summary: fmt.Sprintf("Fetched %d rows", len(rows)),
+			Fields:  []*Field{groupField(fmt.Sprintf("Rows (%d)", len(rows)), rows), terminator},
+		}, nil
+	default:
+		return d.decodeResultSet(packets)
+	}
+}
+
+func (d MySQLDecoder) decodePrepareResponse(packets []*mysqlPacket) (*Dissection, error) {
+	fields, summary, err := packets[0].decode(decodeMySQLPrepareOK)
+	if err != nil {
+		return nil, err
+	}
+
+	fields = append(packets[0].headerFields(), fields...)
+	columnCount := int(binary.LittleEndian.Uint16(packets[0].payload[5:]))
+	parameterCount := int(binary.LittleEndian.Uint16(packets[0].payload[7:]))
+
+	rest := packets[1:]
+	for _, definitions := range []struct {
+		name  string
+		count int
+	}{{"Parameters", parameterCount}, {"Columns", columnCount}} {
+		if definitions.count == 0 {
+			continue
+		}
+
+		definitionFields, err := d.decodeDefinitions(rest, definitions.name, definitions.count)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, definitionFields...)
+		rest = rest[len(definitionFields)-1+definitions.count:]
+	}
+
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%d unexpected packets after the prepared statement", len(rest))
+	}
+
+	return &Dissection{Summary: summary, Fields: fields}, nil
+}
+
+// decodeDefinitions decodes count column definitions at the beginning of
+// packets, along with the EOF packet following them unless it's deprecated.
+// It returns the field of the definitions, followed by the field of the EOF
+// packet.
+func (d MySQLDecoder) decodeDefinitions(packets []*mysqlPacket, name string, count int) ([]*Field, error) {
+	if count > len(packets) {
+		return nil, fmt.Errorf("expected %d column definitions, found %d packets", count, len(packets))
+	}
+
+	columns, _, err := decodeMySQLColumns(packets[:count])
+	if err != nil {
+		return nil, err
+	}
+
+	columns.Name = fmt.Sprintf("%s (%d)", name, count)
+	if d.deprecateEOF() {
+		return []*Field{columns}, nil
+	}
+
+	if count == len(packets) {
+		return nil, fmt.Errorf("missing EOF after the column definitions")
+	}
+
+	eof, err := packets[count].field("EOF", d.decodeTerminator)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Field{columns, eof}, nil
+}
+
+type mysqlColumn struct {
+	name      string
+	fieldType byte
+	flags     uint16
+}
+
+// decodeMySQLColumns decodes a packet of a column definition per column.
+func decodeMySQLColumns(packets []*mysqlPacket) (*Field, []mysqlColumn, error) {
+	columns := make([]mysqlColumn, len(packets))
+
+	var fields []*Field
+	for i, packet := range packets {
+		field, err := packet.field(fmt.Sprintf("[%d]", i), func(r *reader, dissection *Dissection) []*Field {
+			return decodeMySQLColumnDefinition(r, &columns[i], dissection)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		field.Name += " " + columns[i].name
+		fields = append(fields, field)
+	}
+
+	return groupField(fmt.Sprintf("Columns (%d)", len(columns)), fields), columns, nil
+}
+
+func decodeMySQLColumnDefinition(r *reader, column *mysqlColumn, dissection *Dissection) []*Field {
+	fields := []*Field{
+		mysqlLengthEncodedStringField(r, "Catalog"),
+		mysqlLengthEncodedStringField(r, "Schema"),
+		mysqlLengthEncodedStringField(r, "Table"),
+		mysqlLengthEncodedStringField(r, "Original table"),
+		mysqlLengthEncodedStringField(r, "Name"),
+		mysqlLengthEncodedStringField(r, "Original name"),
+		mysqlLengthEncodedField(r, "Length of fixed fields"),
+		r.uint16Field("Character set"),
+		r.uint32Field("Column length"),
+	}
+	column.name = fields[4].Value
+
+	typeOffset := r.offset
+	column.fieldType = r.byte()
+	flagsOffset := r.offset
+	column.flags = r.uint16()
+
+	dissection.Summary = mysqlTypeName(column.fieldType, column.flags&MYSQL_UNSIGNED_FLAG != 0)
+	fields = append(fields,
+		&Field{Name: "Type", Value: dissection.Summary, Offset: typeOffset, Length: 1},
+		r.field("Flags", fmt.Sprintf("0x%04x", column.flags), flagsOffset),
+		r.byteField("Decimals"),
+	)
+	r.read(2) // Filler
+
+	return fields
+}
+
+func (d MySQLDecoder) decodeResultSet(packets []*mysqlPacket) (*Dissection, error) {
+	var columnCount uint64
+	count, err := packets[0].field("Column count", func(r *reader, dissection *Dissection) []*Field {
+		columnCount, _ = readMySQLLengthEncoded(r)
+		dissection.Summary = strconv.FormatUint(columnCount, 10)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if columnCount > uint64(len(packets)-1) {
+		return nil, fmt.Errorf("expected %d column definitions, found %d packets", columnCount, len(packets)-1)
+	}
+
+	columnsField, columns, err := decodeMySQLColumns(packets[1 : 1+columnCount])
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []*Field{count, columnsField}
+	rest := packets[1+columnCount:]
+	if !d.deprecateEOF() && len(rest) > 0 {
+		eof, err := rest[0].field("EOF", d.decodeTerminator)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, eof)
+		rest = rest[1:]
+	}
+
+	var terminator *mysqlPacket
+	if len(rest) > 0 && isMySQLTerminator(rest[len(rest)-1].payload, d.deprecateEOF()) {
+		terminator = rest[len(rest)-1]
+		rest = rest[:len(rest)-1]
+	}
+
+	var rows []*Field
+	for i, packet := range rest {
+		row, err := packet.field(fmt.Sprintf("[%d]", i), func(r *reader, dissection *Dissection) []*Field {
+			var values []*Field
+			if d.command == MYSQL_COM_STMT_EXECUTE {
+				values = readMySQLBinaryRow(r, columns)
+			} else {
+				values = readMySQLTextRow(r, columns)
+			}
+
+			var summary []string
+			for _, value := range values {
+				summary = append(summary, value.Value)
+			}
+			dissection.Summary = summaryText(strings.Join(summary, ", "))
+
+			return values
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, row)
+	}
+	fields = append(fields, groupField(fmt.Sprintf("Rows (%d)", len(rows)), rows))
+
+	dissection := &Dissection{
+		Summary: fmt.Sprintf("Result set: %d columns, %d rows", len(columns), len(rows)),
+	}
+
+	if terminator != nil {
+		end, err := terminator.field("End", d.decodeTerminator)
+		if err != nil {
+			return nil, err
+		}
+
+		if terminator.payload[0] == 0xff {
+			dissection.Summary += ", " + end.Value
+		}
+		fields = append(fields, end)
+	}
+
+	dissection.Fields = fields
+	return dissection, nil
+}
+
+func readMySQLTextRow(r *reader, columns []mysqlColumn) []*Field {
+	var values []*Field
+	for _, column := range columns {
+		values = append(values, mysqlLengthEncodedStringField(r, column.name))
+	}
+
+	return values
+}
+
+func readMySQLBinaryRow(r *reader, columns []mysqlColumn) []*Field {
+	r.byte() // Header
+
+	// The NULL bitmap begins at its third bit
+	nullBitmap := r.read((len(columns) + 7 + 2) / 8)
+
+	var values []*Field
+	for i, column := range columns {
+		if r.err != nil {
+			break
+		}
+
+		if bit := i + 2; nullBitmap[bit/8]&(1<<(bit%8)) != 0 {
+			values = append(values, &Field{Name: column.name, Value: "NULL", Offset: r.offset})
+			continue
+		}
+
+		begin := r.offset
+		value := readMySQLBinaryValue(r, column.fieldType, column.flags&MYSQL_UNSIGNED_FLAG != 0)
+		values = append(values, r.field(column.name, value, begin))
+	}
+
+	return values
+}
+
+// queryOffset returns the offset of the query in the payload of a COM_QUERY
+// or a COM_STMT_PREPARE.
+func (d MySQLDecoder) queryOffset(payload []byte) (int, error) {
+	if d.kind != MYSQL_PACKET_COMMAND || len(payload) == 0 || payload[0] != MYSQL_COM_QUERY && payload[0] != MYSQL_COM_STMT_PREPARE {
+		return 0, fmt.Errorf("only the query of COM_QUERY and COM_STMT_PREPARE packets can be edited")
+	}
+
+	r := newReader(payload, binary.LittleEndian)
+	if r.byte() == MYSQL_COM_QUERY && d.capabilities&MYSQL_CLIENT_QUERY_ATTRIBUTES != 0 {
+		d.decodeQueryAttributes(r)
+	}
+
+	return r.offset, r.err
+}
+
+// Payload returns the query of COM_QUERY and COM_STMT_PREPARE packets.
+func (d MySQLDecoder) Payload(content []byte) ([]byte, error) {
+	packet := readMySQLPacket(content, 0)
+	if packet == nil || packet.length != len(content) {
+		return nil, fmt.Errorf("expected a single MySQL packet")
+	}
+
+	offset, err := d.queryOffset(packet.payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return packet.payload[offset:], nil
+}
+
+// SetPayload encodes the packet with the given query.
+func (d MySQLDecoder) SetPayload(content, payload []byte) ([]byte, error) {
+	packet := readMySQLPacket(content, 0)
+	if packet == nil || packet.length != len(content) {
+		return nil, fmt.Errorf("expected a single MySQL packet")
+	}
+
+	offset, err := d.queryOffset(packet.payload)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := append(bytes.Clone(packet.payload[:offset]), payload...)
+	return EncodeMySQLPacket(packet.sequence, edited), nil
+}
+
+// EncodeMySQLPacket encodes a payload into packets, beginning with the given
+// sequence ID.
+func EncodeMySQLPacket(sequence byte, payload []byte) []byte {
+	var encoded []byte
+	for {
+		length := min(len(payload), MYSQL_MAX_PAYLOAD_LENGTH)
+		encoded = append(encoded, byte(length), byte(length>>8), byte(length>>16), sequence)
+		encoded = append(encoded, payload[:length]...)
+
+		payload = payload[length:]
+		sequence++
+
+		// A payload of the maximal length is followed by a packet, which may
+		// be empty
+		if length < MYSQL_MAX_PAYLOAD_LENGTH {
+			return encoded
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package dissector
+
+import (
+	"testing"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mysqlPackets encodes a packet per payload, with sequence IDs beginning with
+// the given one.
+func mysqlPackets(sequence byte, payloads ...string) string {
+	var packets string
+	for i, payload := range payloads {
+		packets += string(EncodeMySQLPacket(sequence+byte(i), []byte(payload)))
+	}
+
+	return packets
+}
+
+func mysqlColumnDefinition(name string, fieldType byte) string {
+	lengthEncoded := func(s string) string { return string(rune(len(s))) + s }
+	return lengthEncoded("def") + lengthEncoded("shop") + lengthEncoded("users") + lengthEncoded("users") +
+		lengthEncoded(name) + lengthEncoded(name) + "\x0c\x21\x00\xff\x00\x00\x00" + string([]byte{fieldType}) + "\x00\x00\x00\x00\x00"
+}
+
+// decodeMySQLFrames frames data with the session, returning the summaries of
+// the frames.
+func decodeMySQLFrames(t *testing.T, session Session, direction tcpmessage.TransmittionDirection, data string) []string {
+	var summaries []string
+	for len(data) > 0 {
+		length, decoder, err := session.Frame(direction, []byte(data))
+		require.NoError(t, err)
+		require.NotZero(t, length)
+
+		dissection, err := decoder.Decode([]byte(data[:length]))
+		require.NoError(t, err)
+		summaries = append(summaries, dissection.Summary)
+
+		data = data[length:]
+	}
+
+	return summaries
+}
+
+func TestMySQLDetectPrefix(t *testing.T) {
+	assert.True(t, MySQLDissector{}.DetectPrefix(toClient, []byte("\x4a")))
+	assert.True(t, MySQLDissector{}.DetectPrefix(toClient, []byte("\x4a\x00\x00\x00")))
+	assert.False(t, MySQLDissector{}.DetectPrefix(toClient, []byte("OK")))
+	assert.False(t, MySQLDissector{}.DetectPrefix(toClient, []byte("\x02\x00")))
+	assert.False(t, MySQLDissector{}.DetectPrefix(toClient, []byte("\x4a\x00\x00\x01")))
+	assert.False(t, MySQLDissector{}.DetectPrefix(toServer, []byte("\x4a\x00")))
+}
+
+func TestMySQLSession(t *testing.T) {
+	session := MySQLDissector{}.NewSession()
+
+	// CLIENT_CONNECT_WITH_DB, CLIENT_PROTOCOL_41, CLIENT_SECURE_CONNECTION
+	// and CLIENT_PLUGIN_AUTH
+	handshake := mysqlPackets(0, "\x0a8.0.32\x00\x01\x00\x00\x00abcdefgh\x00\x08\x82\x21\x02\x00\x08\x00\x15"+
+		"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00ijklmnopqrst\x00caching_sha2_password\x00")
+	require.True(t, MySQLDissector{}.Detect(toClient, []byte(handshake)))
+	assert.Equal(t, []string{"Handshake 8.0.32"}, decodeMySQLFrames(t, session, toClient, handshake))
+
+	response := mysqlPackets(1, "\x08\x82\x08\x00\x00\x00\x00\x01\x21"+string(make([]byte, 23))+
+		"root\x00\x00shop\x00caching_sha2_password\x00")
+	assert.Equal(t, []string{"HandshakeResponse user=root database=shop"}, decodeMySQLFrames(t, session, toServer, response))
+	assert.Equal(t, []string{"OK"}, decodeMySQLFrames(t, session, toClient, mysqlPackets(2, "\x00\x00\x00\x02\x00\x00\x00")))
+
+	query := mysqlPackets(0, "\x03SELECT id, name FROM users")
+	assert.Equal(t, []string{"SELECT id, name FROM users"}, decodeMySQLFrames(t, session, toServer, query))
+
+	resultSet := mysqlPackets(1,
+		"\x02",
+		mysqlColumnDefinition("id", MYSQL_TYPE_LONG),
+		mysqlColumnDefinition("name", MYSQL_TYPE_VAR_STRING),
+		"\xfe\x00\x00\x02\x00",
+		"\x011\x05alice",
+		"\x012\xfb",
+		"\xfe\x00\x00\x02\x00",
+	)
+
+	// The result set is a single message, framed once it's complete
+	frames, rest := frameAll(t, session, toClient, resultSet[:len(resultSet)-3])
+	assert.Empty(t, frames)
+	assert.Equal(t, resultSet[:len(resultSet)-3], rest)
+
+	length, decoder, err := session.Frame(toClient, []byte(resultSet))
+	require.NoError(t, err)
+	require.Equal(t, len(resultSet), length)
+
+	dissection, err := decoder.Decode([]byte(resultSet))
+	require.NoError(t, err)
+	assert.Equal(t, "Result set: 2 columns, 2 rows", dissection.Summary)
+
+	rows := dissection.Fields[3]
+	require.Len(t, rows.Children, 2)
+	assert.Equal(t, "1, alice", rows.Children[0].Value)
+	assert.Equal(t, "name", rows.Children[1].Children[1].Name)
+	assert.Equal(t, "NULL", rows.Children[1].Children[1].Value)
+
+	errorResponse := mysqlPackets(1, "\xff\x7a\x04#42S02Table 'shop.orders' doesn't exist")
+	decodeMySQLFrames(t, session, toServer, mysqlPackets(0, "\x03SELECT * FROM orders"))
+	assert.Equal(t, []string{"ERR 1146 (42S02): Table 'shop.orders' doesn't exist"}, decodeMySQLFrames(t, session, toClient, errorResponse))
+}
+
+func TestMySQLPreparedStatement(t *testing.T) {
+	session := &mysqlSession{phase: MYSQL_PHASE_COMMAND, capabilities: MYSQL_CLIENT_PROTOCOL_41 | MYSQL_CLIENT_DEPRECATE_EOF, statements: make(map[uint32]*mysqlStatement)}
+
+	decodeMySQLFrames(t, session, toServer, mysqlPackets(0, "\x16SELECT name FROM users WHERE id = ?"))
+	prepareResponse := mysqlPackets(1,
+		"\x00\x07\x00\x00\x00\x01\x00\x01\x00\x00\x00\x00",
+		mysqlColumnDefinition("?", MYSQL_TYPE_LONGLONG),
+		mysqlColumnDefinition("name", MYSQL_TYPE_VAR_STRING),
+	)
+	assert.Equal(t, []string{"Prepared statement 7: 1 parameters, 1 columns"}, decodeMySQLFrames(t, session, toClient, prepareResponse))
+
+	// Binds a LONGLONG parameter of 42
+	execute := mysqlPackets(0, "\x17\x07\x00\x00\x00\x00\x01\x00\x00\x00\x00\x01\x08\x00\x2a\x00\x00\x00\x00\x00\x00\x00")
+	length, decoder, err := session.Frame(toServer, []byte(execute))
+	require.NoError(t, err)
+	require.Equal(t, len(execute), length)
+
+	dissection, err := decoder.Decode([]byte(execute))
+	require.NoError(t, err)
+	assert.Equal(t, "COM_STMT_EXECUTE statement 7", dissection.Summary)
+	values := dissection.Fields[len(dissection.Fields)-1]
+	require.Len(t, values.Children, 1)
+	assert.Equal(t, "42", values.Children[0].Value)
+
+	resultSet := mysqlPackets(1,
+		"\x01",
+		mysqlColumnDefinition("name", MYSQL_TYPE_VAR_STRING),
+		"\x00\x00\x05alice",
+		"\xfe\x00\x00\x02\x00\x00\x00",
+	)
+	assert.Equal(t, []string{"Result set: 1 columns, 1 rows"}, decodeMySQLFrames(t, session, toClient, resultSet))
+}
+
+func TestMySQLSetQuery(t *testing.T) {
+	decoder := MySQLDecoder{kind: MYSQL_PACKET_COMMAND}
+
+	edited, err := decoder.SetPayload([]byte(mysqlPackets(0, "\x03SELECT 1")), []byte("SELECT 42"))
+	require.NoError(t, err)
+	assert.Equal(t, mysqlPackets(0, "\x03SELECT 42"), string(edited))
+}
+
+// FuzzMySQL frames and decodes arbitrary packets sent to the server and
+// then to the client, both during the handshake and after it, checking that
+// malformed packets are reported as errors instead of panicking.
+func FuzzMySQL(f *testing.F) {
+	f.Add([]byte(mysqlPackets(1, "\x08\x82\x08\x00\x00\x00\x00\x01\x21"+string(make([]byte, 23))+"root\x00\x00shop\x00caching_sha2_password\x00")),
+		[]byte(mysqlPackets(0, "\x0a8.0.32\x00\x01\x00\x00\x00abcdefgh\x00\x08\x82\x21\x02\x00\x08\x00\x15"+
+			"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00ijklmnopqrst\x00caching_sha2_password\x00")))
+	f.Add([]byte(mysqlPackets(0, "\x03SELECT id FROM users")),
+		[]byte(mysqlPackets(1, "\x01", mysqlColumnDefinition("id", MYSQL_TYPE_LONG), "\xfe\x00\x00\x02\x00", "\x011", "\xfe\x00\x00\x02\x00")))
+	f.Add([]byte(mysqlPackets(0, "\x16SELECT name FROM users WHERE id = ?")),
+		[]byte(mysqlPackets(1, "\x00\x07\x00\x00\x00\x01\x00\x01\x00\x00\x00\x00", mysqlColumnDefinition("?", MYSQL_TYPE_LONGLONG), mysqlColumnDefinition("name", MYSQL_TYPE_VAR_STRING))))
+	f.Add([]byte(mysqlPackets(0, "\x17\x07\x00\x00\x00\x00\x01\x00\x00\x00\x00\x01\x08\x00\x2a\x00\x00\x00\x00\x00\x00\x00")),
+		[]byte(mysqlPackets(1, "\xff\x7a\x04#42S02Table 'shop.orders' doesn't exist")))
+
+	f.Fuzz(func(t *testing.T, toServerData, toClientData []byte) {
+		sessions := []Session{
+			MySQLDissector{}.NewSession(),
+			&mysqlSession{phase: MYSQL_PHASE_COMMAND, capabilities: MYSQL_CLIENT_PROTOCOL_41 | MYSQL_CLIENT_DEPRECATE_EOF, statements: make(map[uint32]*mysqlStatement)},
+		}
+
+		for _, session := range sessions {
+			for _, direction := range []tcpmessage.TransmittionDirection{toServer, toClient} {
+				data := toServerData
+				if direction == toClient {
+					data = toClientData
+				}
+
+				for len(data) > 0 {
+					length, decoder, err := session.Frame(direction, data)
+					if err != nil || length == 0 {
+						break
+					}
+					frame := data[:length]
+
+					decoder.Decode(frame)
+					if editor, ok := decoder.(PayloadEditor); ok {
+						if payload, err := editor.Payload(frame); err == nil {
+							editor.SetPayload(frame, payload)
+						}
+					}
+
+					data = data[length:]
+				}
+			}
+		}
+	})
+}
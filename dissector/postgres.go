@@ -0,0 +1,577 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+const (
+	POSTGRES_PROTOCOL_VERSION_3  = 196608
+	POSTGRES_SSL_REQUEST_CODE    = 80877103
+	POSTGRES_GSSENC_REQUEST_CODE = 80877104
+	POSTGRES_CANCEL_REQUEST_CODE = 80877102
+)
+
+// POSTGRES_MAX_MESSAGE_LENGTH is the maximal length of a message. Longer
+// messages fail the framing.
+const POSTGRES_MAX_MESSAGE_LENGTH = 1 << 30
+
+type PostgresDissector struct{}
+
+func (PostgresDissector) Name() string {
+	return "postgres"
+}
+
+func (PostgresDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(data) < 8 {
+		return false
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	switch binary.BigEndian.Uint32(data[4:]) {
+	case POSTGRES_SSL_REQUEST_CODE, POSTGRES_GSSENC_REQUEST_CODE:
+		return length == 8
+	case POSTGRES_CANCEL_REQUEST_CODE:
+		return length == 16
+	case POSTGRES_PROTOCOL_VERSION_3:
+		return length <= 10000
+	default:
+		return false
+	}
+}
+
+func (PostgresDissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	// The length is at most 10000, so its high bytes are 0
+	if direction != tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(data) >= 8 ||
+		(len(data) >= 1 && data[0] != 0) || (len(data) >= 2 && data[1] != 0) {
+		return false
+	}
+
+	if len(data) <= 4 {
+		return true
+	}
+
+	for _, code := range []uint32{POSTGRES_SSL_REQUEST_CODE, POSTGRES_GSSENC_REQUEST_CODE, POSTGRES_CANCEL_REQUEST_CODE, POSTGRES_PROTOCOL_VERSION_3} {
+		if isShorterPrefix(data[4:], string(binary.BigEndian.AppendUint32(nil, code))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (PostgresDissector) NewSession() Session {
+	return &postgresSession{}
+}
+
+type postgresMessageKind int
+
+const (
+	// POSTGRES_MESSAGE_UNTYPED is a message without a type byte, which is the
+	// startup message or a request sent instead of it.
+	POSTGRES_MESSAGE_UNTYPED postgresMessageKind = iota
+	// POSTGRES_MESSAGE_ENCRYPTION_RESPONSE is the single byte response to an
+	// SSLRequest or a GSSENCRequest.
+	POSTGRES_MESSAGE_ENCRYPTION_RESPONSE
+	POSTGRES_MESSAGE_TYPED
+)
+
+type postgresSession struct {
+	startupDone bool
+
+	// encryptionRequested is set when the response to an SSLRequest or a
+	// GSSENCRequest is expected.
+	encryptionRequested bool
+
+	// encrypted is set once the connection is encrypted, so it can no longer
+	// be dissected.
+	encrypted bool
+}
+
+func (s *postgresSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	if s.encrypted {
+		return len(data), nil, nil
+	}
+
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT && s.encryptionRequested {
+		s.encryptionRequested = false
+		if data[0] == 'S' || data[0] == 'G' {
+			s.encrypted = true
+		}
+
+		return 1, PostgresDecoder{direction, POSTGRES_MESSAGE_ENCRYPTION_RESPONSE}, nil
+	}
+
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER && !s.startupDone {
+		length, err := postgresMessageLength(data, 0)
+		if err != nil || length == 0 {
+			return 0, nil, err
+		}
+
+		code := binary.BigEndian.Uint32(data[4:])
+		if code == POSTGRES_SSL_REQUEST_CODE || code == POSTGRES_GSSENC_REQUEST_CODE {
+			s.encryptionRequested = true
+		} else {
+			s.startupDone = true
+		}
+
+		return length, PostgresDecoder{direction, POSTGRES_MESSAGE_UNTYPED}, nil
+	}
+
+	length, err := postgresMessageLength(data, 1)
+	if err != nil || length == 0 {
+		return 0, nil, err
+	}
+
+	return length, PostgresDecoder{direction, POSTGRES_MESSAGE_TYPED}, nil
+}
+
+// postgresMessageLength returns the length of the message at the beginning of
+// data, which has an int32 length after typeLength bytes. It returns 0 if the
+// message isn't complete.
+func postgresMessageLength(data []byte, typeLength int) (int, error) {
+	if len(data) < typeLength+4 {
+		return 0, nil
+	}
+
+	length := binary.BigEndian.Uint32(data[typeLength:])
+	if length < 4 || length > POSTGRES_MAX_MESSAGE_LENGTH {
+		return 0, fmt.Errorf("invalid PostgreSQL message length %d", length)
+	}
+	if typeLength == 0 && length < 8 {
+		return 0, fmt.Errorf("invalid PostgreSQL startup message length %d", length)
+	}
+
+	if typeLength+int(length) > len(data) {
+		return 0, nil
+	}
+
+	return typeLength + int(length), nil
+}
+
+// PostgresDecoder decodes PostgreSQL frontend and backend messages.
+type PostgresDecoder struct {
+	direction tcpmessage.TransmittionDirection
+	kind      postgresMessageKind
+}
+
+func (PostgresDecoder) Name() string {
+	return "postgres"
+}
+
+var postgresFrontendMessageNames = map[byte]string{
+	'B': "Bind",
+	'C': "Close",
+	'c': "CopyDone",
+	'd': "CopyData",
+	'D': "Describe",
+	'E': "Execute",
+	'f': "CopyFail",
+	'F': "FunctionCall",
+	'H': "Flush",
+	'p': "PasswordMessage",
+	'P': "Parse",
+	'Q': "Query",
+	'S': "Sync",
+	'X': "Terminate",
+}
+
+var postgresBackendMessageNames = map[byte]string{
+	'1': "ParseComplete",
+	'2': "BindComplete",
+	'3': "CloseComplete",
+	'A': "NotificationResponse",
+	'c': "CopyDone",
+	'C': "CommandComplete",
+	'd': "CopyData",
+	'D': "DataRow",
+	'E': "ErrorResponse",
+	'G': "CopyInResponse",
+	'H': "CopyOutResponse",
+	'I': "EmptyQueryResponse",
+	'K': "BackendKeyData",
+	'n': "NoData",
+	'N': "NoticeResponse",
+	'R': "Authentication",
+	's': "PortalSuspended",
+	'S': "ParameterStatus",
+	't': "ParameterDescription",
+	'T': "RowDescription",
+	'v': "NegotiateProtocolVersion",
+	'V': "FunctionCallResponse",
+	'W': "CopyBothResponse",
+	'Z': "ReadyForQuery",
+}
+
+// postgresErrorFieldNames names the fields of ErrorResponse and
+// NoticeResponse messages.
+var postgresErrorFieldNames = map[byte]string{
+	'S': "Severity",
+	'V': "Severity (non-localized)",
+	'C': "Code",
+	'M': "Message",
+	'D': "Detail",
+	'H': "Hint",
+	'P': "Position",
+	'p': "Internal position",
+	'q': "Internal query",
+	'W': "Where",
+	's': "Schema",
+	't': "Table",
+	'c': "Column",
+	'd': "Data type",
+	'n': "Constraint",
+	'F': "File",
+	'L': "Line",
+	'R': "Routine",
+}
+
+var postgresAuthenticationNames = map[int]string{
+	0:  "Ok",
+	2:  "KerberosV5",
+	3:  "CleartextPassword",
+	5:  "MD5Password",
+	7:  "GSS",
+	8:  "GSSContinue",
+	9:  "SSPI",
+	10: "SASL",
+	11: "SASLContinue",
+	12: "SASLFinal",
+}
+
+func (d PostgresDecoder) Decode(content []byte) (*Dissection, error) {
+	switch d.kind {
+	case POSTGRES_MESSAGE_ENCRYPTION_RESPONSE:
+		return decodePostgresEncryptionResponse(content)
+	case POSTGRES_MESSAGE_UNTYPED:
+		return decodePostgresUntyped(content)
+	case POSTGRES_MESSAGE_TYPED:
+		return d.decodeTyped(content)
+	default:
+		panic("invalid PostgreSQL message kind")
+	}
+}
+
+func decodePostgresEncryptionResponse(content []byte) (*Dissection, error) {
+	if len(content) != 1 {
+		return nil, fmt.Errorf("the encryption response must be a single byte")
+	}
+
+	summary := "Encryption accepted"
+	if content[0] == 'N' {
+		summary = "Encryption rejected"
+	}
+
+	return &Dissection{
+		Summary: summary,
+		Fields:  []*Field{{Name: "Response", Value: string(content), Length: 1}},
+	}, nil
+}
+
+func decodePostgresUntyped(content []byte) (*Dissection, error) {
+	r := newReader(content, binary.BigEndian)
+	fields := []*Field{r.int32Field("Length")}
+
+	begin := r.offset
+	code := r.int32()
+
+	dissection := &Dissection{}
+	switch code {
+	case POSTGRES_SSL_REQUEST_CODE:
+		dissection.Summary = "SSLRequest"
+		fields = append(fields, r.field("Code", code, begin))
+	case POSTGRES_GSSENC_REQUEST_CODE:
+		dissection.Summary = "GSSENCRequest"
+		fields = append(fields, r.field("Code", code, begin))
+	case POSTGRES_CANCEL_REQUEST_CODE:
+		dissection.Summary = "CancelRequest"
+		fields = append(fields, r.field("Code", code, begin), r.int32Field("Process ID"), r.int32Field("Secret key"))
+	default:
+		dissection.Summary = "StartupMessage"
+		fields = append(fields, r.field("Protocol version", fmt.Sprintf("%d.%d", code>>16, code&0xffff), begin))
+
+		parameters := &Field{Name: "Parameters", Offset: r.offset, Length: r.remaining()}
+		for r.err == nil && r.remaining() > 1 {
+			begin := r.offset
+			name := r.cstring()
+			value := r.cstring()
+			parameters.Children = append(parameters.Children, r.field(name, value, begin))
+
+			if name == "user" || name == "database" {
+				dissection.Summary += fmt.Sprintf(" %s=%s", name, value)
+			}
+		}
+		fields = append(fields, parameters)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	dissection.Fields = fields
+	return dissection, nil
+}
+
+func (d PostgresDecoder) decodeTyped(content []byte) (*Dissection, error) {
+	r := newReader(content, binary.BigEndian)
+
+	messageType := r.byte()
+	names := postgresFrontendMessageNames
+	if d.direction == tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT {
+		names = postgresBackendMessageNames
+	}
+
+	name, ok := names[messageType]
+	if !ok {
+		name = fmt.Sprintf("Unknown (%q)", messageType)
+	}
+
+	dissection := &Dissection{
+		Summary: name,
+		Fields:  []*Field{r.field("Type", name, 0), r.int32Field("Length")},
+	}
+
+	var fields []*Field
+	if d.direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+		fields = decodePostgresFrontendFields(r, messageType, dissection)
+	} else {
+		fields = decodePostgresBackendFields(r, messageType, dissection)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if r.remaining() > 0 {
+		fields = append(fields, &Field{Name: "Data", Value: DescribeBody(r.data[r.offset:]), Offset: r.offset, Length: r.remaining()})
+	}
+
+	dissection.Fields = append(dissection.Fields, fields...)
+	return dissection, nil
+}
+
+func decodePostgresFrontendFields(r *reader, messageType byte, dissection *Dissection) []*Field {
+	switch messageType {
+	case 'Q':
+		query := r.cstringField("Query")
+		dissection.Summary += ": " + summaryText(query.Value)
+		if r.err == nil {
+			query.Length-- // The payload is the query, without its terminator
+			query.Payload = true
+		}
+		return []*Field{query}
+	case 'P':
+		fields := []*Field{r.cstringField("Statement"), r.cstringField("Query")}
+		dissection.Summary += ": " + summaryText(fields[1].Value)
+		return append(fields, postgresArrayField(r, "Parameter types", func(i int) *Field {
+			return r.int32Field(fmt.Sprintf("[%d] OID", i))
+		}))
+	case 'B':
+		fields := []*Field{r.cstringField("Portal"), r.cstringField("Statement")}
+		if fields[1].Value != "" {
+			dissection.Summary += " " + fields[1].Value
+		}
+
+		fields = append(fields, postgresArrayField(r, "Parameter formats", func(i int) *Field {
+			return r.int16Field(fmt.Sprintf("[%d]", i))
+		}))
+		fields = append(fields, postgresArrayField(r, "Parameters", func(i int) *Field {
+			return postgresValueField(r, fmt.Sprintf("[%d]", i))
+		}))
+		return append(fields, postgresArrayField(r, "Result formats", func(i int) *Field {
+			return r.int16Field(fmt.Sprintf("[%d]", i))
+		}))
+	case 'E':
+		return []*Field{r.cstringField("Portal"), r.int32Field("Max rows")}
+	case 'D', 'C':
+		begin := r.offset
+		target := "Statement"
+		if r.byte() == 'P' {
+			target = "Portal"
+		}
+
+		fields := []*Field{r.field("Target", target, begin), r.cstringField("Name")}
+		dissection.Summary += fmt.Sprintf(" %s %q", strings.ToLower(target), fields[1].Value)
+		return fields
+	case 'f':
+		return []*Field{r.cstringField("Error")}
+	default:
+		return nil
+	}
+}
+
+func decodePostgresBackendFields(r *reader, messageType byte, dissection *Dissection) []*Field {
+	switch messageType {
+	case 'R':
+		begin := r.offset
+		code := r.int32()
+		name, ok := postgresAuthenticationNames[code]
+		if !ok {
+			name = fmt.Sprintf("Unknown (%d)", code)
+		}
+		dissection.Summary += name
+
+		fields := []*Field{r.field("Authentication", name, begin)}
+		switch code {
+		case 5:
+			begin := r.offset
+			fields = append(fields, r.field("Salt", fmt.Sprintf("%x", r.read(4)), begin))
+		case 10:
+			mechanisms := &Field{Name: "Mechanisms", Offset: r.offset, Length: r.remaining()}
+			for r.err == nil && r.remaining() > 1 {
+				mechanisms.Children = append(mechanisms.Children, r.cstringField("Mechanism"))
+			}
+			r.rest()
+			fields = append(fields, mechanisms)
+		}
+		return fields
+	case 'S':
+		fields := []*Field{r.cstringField("Name"), r.cstringField("Value")}
+		dissection.Summary += fmt.Sprintf(" %s=%s", fields[0].Value, fields[1].Value)
+		return fields
+	case 'K':
+		return []*Field{r.int32Field("Process ID"), r.int32Field("Secret key")}
+	case 'Z':
+		begin := r.offset
+		status := map[byte]string{'I': "Idle", 'T': "In transaction", 'E': "Failed transaction"}[r.byte()]
+		dissection.Summary += " (" + status + ")"
+		return []*Field{r.field("Status", status, begin)}
+	case 'T':
+		fields := postgresArrayField(r, "Columns", func(i int) *Field {
+			column := r.cstringField(fmt.Sprintf("[%d]", i))
+			column.Children = []*Field{
+				r.int32Field("Table OID"),
+				r.int16Field("Column number"),
+				r.int32Field("Type OID"),
+				r.int16Field("Type size"),
+				r.int32Field("Type modifier"),
+				r.int16Field("Format"),
+			}
+			column.Length = r.offset - column.Offset
+
+			return column
+		})
+
+		var columns []string
+		for _, column := range fields.Children {
+			columns = append(columns, column.Value)
+		}
+		dissection.Summary += ": " + summaryText(strings.Join(columns, ", "))
+		return []*Field{fields}
+	case 'D':
+		fields := postgresArrayField(r, "Columns", func(i int) *Field {
+			return postgresValueField(r, fmt.Sprintf("[%d]", i))
+		})
+
+		var values []string
+		for _, column := range fields.Children {
+			values = append(values, column.Value)
+		}
+		dissection.Summary += ": " + summaryText(strings.Join(values, ", "))
+		return []*Field{fields}
+	case 'C':
+		tag := r.cstringField("Tag")
+		dissection.Summary += ": " + tag.Value
+		return []*Field{tag}
+	case 'E', 'N':
+		fields, values := decodePostgresErrorFields(r)
+		dissection.Summary = fmt.Sprintf("%s %s: %s", values['S'], values['C'], values['M'])
+		return fields
+	case 't':
+		return []*Field{postgresArrayField(r, "Parameter types", func(i int) *Field {
+			return r.int32Field(fmt.Sprintf("[%d] OID", i))
+		})}
+	case 'A':
+		fields := []*Field{r.int32Field("Process ID"), r.cstringField("Channel"), r.cstringField("Payload")}
+		dissection.Summary += " " + fields[1].Value
+		return fields
+	default:
+		return nil
+	}
+}
+
+// decodePostgresErrorFields decodes the fields of an ErrorResponse or a
+// NoticeResponse, returning them along with their values by code.
+func decodePostgresErrorFields(r *reader) ([]*Field, map[byte]string) {
+	var fields []*Field
+	values := make(map[byte]string)
+
+	for r.err == nil {
+		begin := r.offset
+		code := r.byte()
+		if code == 0 {
+			break
+		}
+
+		name, ok := postgresErrorFieldNames[code]
+		if !ok {
+			name = fmt.Sprintf("Unknown (%q)", code)
+		}
+
+		value := r.cstring()
+		values[code] = value
+		fields = append(fields, r.field(name, value, begin))
+	}
+
+	return fields, values
+}
+
+// postgresArrayField reads an int16 count, followed by the elements read by
+// readElement, into a field.
+func postgresArrayField(r *reader, name string, readElement func(i int) *Field) *Field {
+	field := &Field{Offset: r.offset}
+
+	count := r.int16()
+	for i := 0; i < count && r.err == nil; i++ {
+		field.Children = append(field.Children, readElement(i))
+	}
+
+	field.Name = fmt.Sprintf("%s (%d)", name, count)
+	field.Length = r.offset - field.Offset
+	return field
+}
+
+// postgresValueField reads a value prefixed by its int32 length, where -1 is
+// NULL, into a field.
+func postgresValueField(r *reader, name string) *Field {
+	begin := r.offset
+
+	length := r.int32()
+	if length == -1 {
+		return r.field(name, "NULL", begin)
+	}
+
+	return r.field(name, DescribeBody(r.read(length)), begin)
+}
+
+// Payload returns the query of Query messages.
+func (d PostgresDecoder) Payload(content []byte) ([]byte, error) {
+	if d.kind != POSTGRES_MESSAGE_TYPED || d.direction != tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER || len(content) < 5 || content[0] != 'Q' {
+		return nil, fmt.Errorf("only the query of Query messages can be edited")
+	}
+
+	r := newReader(content, binary.BigEndian)
+	r.offset = 5
+	query := r.cstring()
+	return []byte(query), r.err
+}
+
+// SetPayload encodes a Query message with the given query.
+func (d PostgresDecoder) SetPayload(content, payload []byte) ([]byte, error) {
+	if _, err := d.Payload(content); err != nil {
+		return nil, err
+	}
+
+	if bytes.IndexByte(payload, 0) != -1 {
+		return nil, fmt.Errorf("the query can't contain NUL bytes")
+	}
+
+	encoded := []byte{'Q'}
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(4+len(payload)+1))
+	encoded = append(encoded, payload...)
+	return append(encoded, 0), nil
+}
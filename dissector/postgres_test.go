@@ -0,0 +1,66 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postgresMessage encodes a message of the given type, or an untyped message
+// if it's 0.
+func postgresMessage(messageType byte, body string) string {
+	var message []byte
+	if messageType != 0 {
+		message = append(message, messageType)
+	}
+
+	message = binary.BigEndian.AppendUint32(message, uint32(4+len(body)))
+	return string(message) + body
+}
+
+func TestPostgresSession(t *testing.T) {
+	session := PostgresDissector{}.NewSession()
+
+	sslRequest := postgresMessage(0, "\x04\xd2\x16\x2f")
+	require.True(t, PostgresDissector{}.Detect(toServer, []byte(sslRequest)))
+
+	frames, _ := frameAll(t, session, toServer, sslRequest)
+	assert.Equal(t, []string{sslRequest}, frames)
+	frames, _ = frameAll(t, session, toClient, "N")
+	assert.Equal(t, []string{"N"}, frames)
+
+	startup := postgresMessage(0, "\x00\x03\x00\x00user\x00alice\x00database\x00shop\x00\x00")
+	query := postgresMessage('Q', "SELECT * FROM orders\x00")
+	frames, _ = frameAll(t, session, toServer, startup+query)
+	assert.Equal(t, []string{startup, query}, frames)
+
+	summaries := make(map[string]string)
+	for _, message := range []struct {
+		decoder PostgresDecoder
+		content string
+	}{
+		{PostgresDecoder{toServer, POSTGRES_MESSAGE_UNTYPED}, startup},
+		{PostgresDecoder{toServer, POSTGRES_MESSAGE_TYPED}, query},
+		{PostgresDecoder{toClient, POSTGRES_MESSAGE_TYPED}, postgresMessage('E', "SERROR\x00C42P01\x00Mrelation \"orders\" does not exist\x00\x00")},
+		{PostgresDecoder{toClient, POSTGRES_MESSAGE_TYPED}, postgresMessage('D', "\x00\x02\x00\x00\x00\x011\xff\xff\xff\xff")},
+	} {
+		dissection, err := message.decoder.Decode([]byte(message.content))
+		require.NoError(t, err)
+		summaries[message.content[:1]] = dissection.Summary
+	}
+
+	assert.Equal(t, "StartupMessage user=alice database=shop", summaries["\x00"])
+	assert.Equal(t, "Query: SELECT * FROM orders", summaries["Q"])
+	assert.Equal(t, `ERROR 42P01: relation "orders" does not exist`, summaries["E"])
+	assert.Equal(t, "DataRow: 1, NULL", summaries["D"])
+}
+
+func TestPostgresSetQuery(t *testing.T) {
+	decoder := PostgresDecoder{toServer, POSTGRES_MESSAGE_TYPED}
+
+	edited, err := decoder.SetPayload([]byte(postgresMessage('Q', "SELECT 1\x00")), []byte("SELECT 42"))
+	require.NoError(t, err)
+	assert.Equal(t, postgresMessage('Q', "SELECT 42\x00"), string(edited))
+}
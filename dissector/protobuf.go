@@ -0,0 +1,761 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	PROTOBUF_WIRE_TYPE_VARINT = 0
+	PROTOBUF_WIRE_TYPE_I64    = 1
+	PROTOBUF_WIRE_TYPE_LEN    = 2
+	PROTOBUF_WIRE_TYPE_SGROUP = 3
+	PROTOBUF_WIRE_TYPE_EGROUP = 4
+	PROTOBUF_WIRE_TYPE_I32    = 5
+)
+
+var protobufWireTypeNames = []string{"varint", "i64", "len", "group", "end group", "i32"}
+
+// PROTOBUF_MAX_DEPTH is the maximal nesting of decoded messages and groups.
+const PROTOBUF_MAX_DEPTH = 64
+
+// GRPC_PREFIX_LENGTH is the length of the compressed flag and length which
+// prefix every gRPC message.
+const GRPC_PREFIX_LENGTH = 5
+
+// protobufRecord is a single field of a protobuf message, as encoded on the
+// wire.
+type protobufRecord struct {
+	number   int
+	wireType int
+
+	// value is the value of varint, i64 and i32 records
+	value uint64
+	// data is the value of len records and the content of groups
+	data []byte
+
+	offset      int
+	valueOffset int
+	length      int
+}
+
+func readProtobufVarint(data []byte, offset int) (uint64, int, error) {
+	value, n := binary.Uvarint(data[offset:])
+	if n <= 0 {
+		return 0, 0, fmt.Errorf("invalid protobuf varint at offset %d", offset)
+	}
+
+	return value, offset + n, nil
+}
+
+// readProtobufRecord reads the record at offset, returning it along with the
+// offset of the record after it.
+func readProtobufRecord(data []byte, offset int, depth int) (protobufRecord, int, error) {
+	record := protobufRecord{offset: offset}
+
+	tag, offset, err := readProtobufVarint(data, offset)
+	if err != nil {
+		return record, 0, err
+	}
+
+	record.number = int(tag >> 3)
+	record.wireType = int(tag & 7)
+	if record.number == 0 || tag>>3 > math.MaxInt32 {
+		return record, 0, fmt.Errorf("invalid protobuf field number %d at offset %d", tag>>3, record.offset)
+	}
+
+	record.valueOffset = offset
+	switch record.wireType {
+	case PROTOBUF_WIRE_TYPE_VARINT:
+		record.value, offset, err = readProtobufVarint(data, offset)
+	case PROTOBUF_WIRE_TYPE_I64, PROTOBUF_WIRE_TYPE_I32:
+		size := 8
+		if record.wireType == PROTOBUF_WIRE_TYPE_I32 {
+			size = 4
+		}
+		if size > len(data)-offset {
+			return record, 0, fmt.Errorf("truncated protobuf field %d at offset %d", record.number, record.offset)
+		}
+
+		var value [8]byte
+		copy(value[:], data[offset:offset+size])
+		record.value = binary.LittleEndian.Uint64(value[:])
+		offset += size
+	case PROTOBUF_WIRE_TYPE_LEN:
+		var length uint64
+		length, offset, err = readProtobufVarint(data, offset)
+		if err == nil && length > uint64(len(data)-offset) {
+			err = fmt.Errorf("truncated protobuf field %d at offset %d", record.number, record.offset)
+		}
+		if err != nil {
+			return record, 0, err
+		}
+
+		record.valueOffset = offset
+		record.data = data[offset : offset+int(length)]
+		offset += int(length)
+	case PROTOBUF_WIRE_TYPE_SGROUP:
+		if depth >= PROTOBUF_MAX_DEPTH {
+			return record, 0, fmt.Errorf("protobuf group at offset %d is nested too deeply", record.offset)
+		}
+
+		// The group ends with an end group record of the same number
+		for {
+			if offset >= len(data) {
+				return record, 0, fmt.Errorf("unterminated protobuf group %d at offset %d", record.number, record.offset)
+			}
+
+			child, next, err := readProtobufRecord(data, offset, depth+1)
+			if err != nil {
+				return record, 0, err
+			}
+
+			if child.wireType == PROTOBUF_WIRE_TYPE_EGROUP {
+				if child.number != record.number {
+					return record, 0, fmt.Errorf("protobuf group %d at offset %d ends with group %d", record.number, record.offset, child.number)
+				}
+
+				record.data = data[record.valueOffset:offset]
+				offset = next
+				break
+			}
+
+			offset = next
+		}
+	case PROTOBUF_WIRE_TYPE_EGROUP:
+		// Only valid as the end of a group, which is handled by the group
+	default:
+		return record, 0, fmt.Errorf("invalid protobuf wire type %d at offset %d", record.wireType, record.offset)
+	}
+
+	if err != nil {
+		return record, 0, err
+	}
+
+	record.length = offset - record.offset
+	return record, offset, nil
+}
+
+// readProtobufRecords reads all the records of a message.
+func readProtobufRecords(data []byte, depth int) ([]protobufRecord, error) {
+	var records []protobufRecord
+	for offset := 0; offset < len(data); {
+		record, next, err := readProtobufRecord(data, offset, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		if record.wireType == PROTOBUF_WIRE_TYPE_EGROUP {
+			return nil, fmt.Errorf("unexpected protobuf end group %d at offset %d", record.number, record.offset)
+		}
+
+		records = append(records, record)
+		offset = next
+	}
+
+	return records, nil
+}
+
+// Protobuf field types, as numbered by FieldDescriptorProto.Type
+const (
+	PROTOBUF_TYPE_DOUBLE   = 1
+	PROTOBUF_TYPE_FLOAT    = 2
+	PROTOBUF_TYPE_INT64    = 3
+	PROTOBUF_TYPE_UINT64   = 4
+	PROTOBUF_TYPE_INT32    = 5
+	PROTOBUF_TYPE_FIXED64  = 6
+	PROTOBUF_TYPE_FIXED32  = 7
+	PROTOBUF_TYPE_BOOL     = 8
+	PROTOBUF_TYPE_STRING   = 9
+	PROTOBUF_TYPE_GROUP    = 10
+	PROTOBUF_TYPE_MESSAGE  = 11
+	PROTOBUF_TYPE_BYTES    = 12
+	PROTOBUF_TYPE_UINT32   = 13
+	PROTOBUF_TYPE_ENUM     = 14
+	PROTOBUF_TYPE_SFIXED32 = 15
+	PROTOBUF_TYPE_SFIXED64 = 16
+	PROTOBUF_TYPE_SINT32   = 17
+	PROTOBUF_TYPE_SINT64   = 18
+)
+
+// protobufTypeWireType returns the wire type of non packed values of the
+// given type.
+func protobufTypeWireType(fieldType int) int {
+	switch fieldType {
+	case PROTOBUF_TYPE_DOUBLE, PROTOBUF_TYPE_FIXED64, PROTOBUF_TYPE_SFIXED64:
+		return PROTOBUF_WIRE_TYPE_I64
+	case PROTOBUF_TYPE_FLOAT, PROTOBUF_TYPE_FIXED32, PROTOBUF_TYPE_SFIXED32:
+		return PROTOBUF_WIRE_TYPE_I32
+	case PROTOBUF_TYPE_STRING, PROTOBUF_TYPE_MESSAGE, PROTOBUF_TYPE_BYTES:
+		return PROTOBUF_WIRE_TYPE_LEN
+	case PROTOBUF_TYPE_GROUP:
+		return PROTOBUF_WIRE_TYPE_SGROUP
+	default:
+		return PROTOBUF_WIRE_TYPE_VARINT
+	}
+}
+
+type protobufFieldDescriptor struct {
+	name      string
+	fieldType int
+	// typeName is the fully qualified name of the message or enum of the
+	// field, without the leading dot.
+	typeName string
+}
+
+// ProtobufMessage is a message type, by which messages are decoded.
+type ProtobufMessage struct {
+	// Name is the fully qualified name of the message.
+	Name string
+
+	fields   map[int]*protobufFieldDescriptor
+	registry *ProtobufRegistry
+}
+
+type protobufMethod struct {
+	input, output string
+}
+
+// ProtobufRegistry holds the message types of FileDescriptorSets.
+type ProtobufRegistry struct {
+	messages map[string]*ProtobufMessage
+	enums    map[string]map[uint64]string
+	// methods are the input and output types of the gRPC methods, by their
+	// path (/package.Service/Method).
+	methods map[string]protobufMethod
+}
+
+func NewProtobufRegistry() *ProtobufRegistry {
+	return &ProtobufRegistry{
+		messages: make(map[string]*ProtobufMessage),
+		enums:    make(map[string]map[uint64]string),
+		methods:  make(map[string]protobufMethod),
+	}
+}
+
+// protobufDescriptorFields returns the records of a descriptor by their field
+// number.
+func protobufDescriptorFields(data []byte) (map[int][]protobufRecord, error) {
+	records, err := readProtobufRecords(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[int][]protobufRecord)
+	for _, record := range records {
+		fields[record.number] = append(fields[record.number], record)
+	}
+
+	return fields, nil
+}
+
+func protobufDescriptorString(fields map[int][]protobufRecord, number int) string {
+	records := fields[number]
+	if len(records) == 0 {
+		return ""
+	}
+
+	return string(records[len(records)-1].data)
+}
+
+func protobufDescriptorVarint(fields map[int][]protobufRecord, number int) uint64 {
+	records := fields[number]
+	if len(records) == 0 {
+		return 0
+	}
+
+	return records[len(records)-1].value
+}
+
+func joinProtobufName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+
+	return scope + "." + name
+}
+
+// AddDescriptorSet adds the types of a FileDescriptorSet, as written by
+// protoc --descriptor_set_out.
+func (r *ProtobufRegistry) AddDescriptorSet(data []byte) error {
+	set, err := protobufDescriptorFields(data)
+	if err != nil {
+		return fmt.Errorf("invalid FileDescriptorSet: %w", err)
+	}
+
+	for _, file := range set[1] {
+		if err := r.addFile(file.data); err != nil {
+			return fmt.Errorf("invalid FileDescriptorProto: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *ProtobufRegistry) addFile(data []byte) error {
+	file, err := protobufDescriptorFields(data)
+	if err != nil {
+		return err
+	}
+
+	scope := protobufDescriptorString(file, 2)
+	for _, message := range file[4] {
+		if err := r.addMessage(scope, message.data); err != nil {
+			return err
+		}
+	}
+
+	for _, enum := range file[5] {
+		if err := r.addEnum(scope, enum.data); err != nil {
+			return err
+		}
+	}
+
+	for _, service := range file[6] {
+		if err := r.addService(scope, service.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *ProtobufRegistry) addMessage(scope string, data []byte) error {
+	descriptor, err := protobufDescriptorFields(data)
+	if err != nil {
+		return err
+	}
+
+	message := &ProtobufMessage{
+		Name:     joinProtobufName(scope, protobufDescriptorString(descriptor, 1)),
+		fields:   make(map[int]*protobufFieldDescriptor),
+		registry: r,
+	}
+	r.messages[message.Name] = message
+
+	for _, field := range descriptor[2] {
+		fieldDescriptor, err := protobufDescriptorFields(field.data)
+		if err != nil {
+			return err
+		}
+
+		message.fields[int(protobufDescriptorVarint(fieldDescriptor, 3))] = &protobufFieldDescriptor{
+			name:      protobufDescriptorString(fieldDescriptor, 1),
+			fieldType: int(protobufDescriptorVarint(fieldDescriptor, 5)),
+			typeName:  strings.TrimPrefix(protobufDescriptorString(fieldDescriptor, 6), "."),
+		}
+	}
+
+	for _, nested := range descriptor[3] {
+		if err := r.addMessage(message.Name, nested.data); err != nil {
+			return err
+		}
+	}
+
+	for _, enum := range descriptor[4] {
+		if err := r.addEnum(message.Name, enum.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *ProtobufRegistry) addEnum(scope string, data []byte) error {
+	descriptor, err := protobufDescriptorFields(data)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[uint64]string)
+	for _, value := range descriptor[2] {
+		valueDescriptor, err := protobufDescriptorFields(value.data)
+		if err != nil {
+			return err
+		}
+
+		// Negative values are encoded as 64 bit varints
+		values[protobufDescriptorVarint(valueDescriptor, 2)] = protobufDescriptorString(valueDescriptor, 1)
+	}
+
+	r.enums[joinProtobufName(scope, protobufDescriptorString(descriptor, 1))] = values
+	return nil
+}
+
+func (r *ProtobufRegistry) addService(scope string, data []byte) error {
+	descriptor, err := protobufDescriptorFields(data)
+	if err != nil {
+		return err
+	}
+
+	name := joinProtobufName(scope, protobufDescriptorString(descriptor, 1))
+	for _, method := range descriptor[2] {
+		methodDescriptor, err := protobufDescriptorFields(method.data)
+		if err != nil {
+			return err
+		}
+
+		r.methods["/"+name+"/"+protobufDescriptorString(methodDescriptor, 1)] = protobufMethod{
+			input:  strings.TrimPrefix(protobufDescriptorString(methodDescriptor, 2), "."),
+			output: strings.TrimPrefix(protobufDescriptorString(methodDescriptor, 3), "."),
+		}
+	}
+
+	return nil
+}
+
+// Message returns the message type of the given fully qualified name.
+func (r *ProtobufRegistry) Message(name string) (*ProtobufMessage, error) {
+	message, ok := r.messages[strings.TrimPrefix(name, ".")]
+	if !ok {
+		return nil, fmt.Errorf("unknown protobuf message %q", name)
+	}
+
+	return message, nil
+}
+
+// MessageNames returns the sorted names of the known message types.
+func (r *ProtobufRegistry) MessageNames() []string {
+	var names []string
+	for name := range r.messages {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// Method returns the request and response types of the gRPC method of the
+// given path, such as /package.Service/Method.
+func (r *ProtobufRegistry) Method(path string) (request, response *ProtobufMessage, err error) {
+	method, ok := r.methods[path]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown gRPC method %q", path)
+	}
+
+	if request, err = r.Message(method.input); err != nil {
+		return nil, nil, err
+	}
+	if response, err = r.Message(method.output); err != nil {
+		return nil, nil, err
+	}
+
+	return request, response, nil
+}
+
+// DecodeProtobuf decodes a protobuf message to fields. If message is nil, the
+// message is decoded by its wire format alone.
+func DecodeProtobuf(data []byte, message *ProtobufMessage) ([]*Field, error) {
+	return decodeProtobuf(data, 0, message, 0)
+}
+
+// decodeProtobuf decodes data, which begins at offset base of the decoded
+// content.
+func decodeProtobuf(data []byte, base int, message *ProtobufMessage, depth int) ([]*Field, error) {
+	records, err := readProtobufRecords(data, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []*Field
+	for _, record := range records {
+		var descriptor *protobufFieldDescriptor
+		if message != nil {
+			descriptor = message.fields[record.number]
+		}
+
+		field := &Field{Offset: base + record.offset, Length: record.length}
+		if descriptor != nil && decodeProtobufTypedValue(field, record, base, descriptor, message.registry, depth) {
+			field.Name = fmt.Sprintf("%s (%d)", descriptor.name, record.number)
+		} else {
+			field.Name = fmt.Sprintf("%d (%s)", record.number, protobufWireTypeNames[record.wireType])
+			decodeProtobufValue(field, record, base, depth)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// isPrintableText returns whether data is UTF-8 without control characters
+// other than whitespace.
+func isPrintableText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+
+	for _, char := range string(data) {
+		if char < ' ' && char != '\n' && char != '\r' && char != '\t' || char == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeProtobufValue sets the value of the field by the wire type of the
+// record alone.
+func decodeProtobufValue(field *Field, record protobufRecord, base int, depth int) {
+	switch record.wireType {
+	case PROTOBUF_WIRE_TYPE_VARINT:
+		field.Value = strconv.FormatUint(record.value, 10)
+		if int64(record.value) < 0 {
+			field.Value += fmt.Sprintf(" (%d)", int64(record.value))
+		}
+	case PROTOBUF_WIRE_TYPE_I64:
+		field.Value = fmt.Sprintf("0x%016x (double %g)", record.value, math.Float64frombits(record.value))
+	case PROTOBUF_WIRE_TYPE_I32:
+		field.Value = fmt.Sprintf("0x%08x (float %g)", record.value, math.Float32frombits(uint32(record.value)))
+	case PROTOBUF_WIRE_TYPE_LEN:
+		// Messages with small field numbers begin with control characters,
+		// so printable data is most likely a string
+		if isPrintableText(record.data) {
+			field.Value = strconv.Quote(string(record.data))
+			return
+		}
+
+		if depth < PROTOBUF_MAX_DEPTH {
+			if children, err := decodeProtobuf(record.data, base+record.valueOffset, nil, depth+1); err == nil {
+				field.Value = fmt.Sprintf("{%d fields}", len(children))
+				field.Children = children
+				return
+			}
+		}
+
+		field.Value = fmt.Sprintf("%x", record.data)
+	case PROTOBUF_WIRE_TYPE_SGROUP:
+		children, err := decodeProtobuf(record.data, base+record.valueOffset, nil, depth+1)
+		if err != nil {
+			field.Value = fmt.Sprintf("%x", record.data)
+			return
+		}
+
+		field.Value = fmt.Sprintf("{%d fields}", len(children))
+		field.Children = children
+	}
+}
+
+// decodeProtobufTypedValue sets the value of the field by its descriptor,
+// returning false if the record doesn't match the descriptor.
+func decodeProtobufTypedValue(field *Field, record protobufRecord, base int, descriptor *protobufFieldDescriptor, registry *ProtobufRegistry, depth int) bool {
+	wireType := protobufTypeWireType(descriptor.fieldType)
+
+	// Repeated scalars may be packed
+	if record.wireType == PROTOBUF_WIRE_TYPE_LEN && wireType != PROTOBUF_WIRE_TYPE_LEN && wireType != PROTOBUF_WIRE_TYPE_SGROUP {
+		values, err := decodePackedProtobuf(record.data, descriptor, registry)
+		if err != nil {
+			return false
+		}
+
+		field.Value = "[" + strings.Join(values, ", ") + "]"
+		return true
+	}
+
+	if record.wireType != wireType {
+		return false
+	}
+
+	switch descriptor.fieldType {
+	case PROTOBUF_TYPE_STRING:
+		field.Value = strconv.Quote(string(record.data))
+	case PROTOBUF_TYPE_BYTES:
+		field.Value = fmt.Sprintf("%x", record.data)
+	case PROTOBUF_TYPE_MESSAGE, PROTOBUF_TYPE_GROUP:
+		if depth >= PROTOBUF_MAX_DEPTH {
+			return false
+		}
+
+		message, _ := registry.Message(descriptor.typeName)
+		children, err := decodeProtobuf(record.data, base+record.valueOffset, message, depth+1)
+		if err != nil {
+			return false
+		}
+
+		field.Value = descriptor.typeName
+		field.Children = children
+	default:
+		field.Value = formatProtobufScalar(record.value, descriptor, registry)
+	}
+
+	return true
+}
+
+func decodePackedProtobuf(data []byte, descriptor *protobufFieldDescriptor, registry *ProtobufRegistry) ([]string, error) {
+	var values []string
+	for offset := 0; offset < len(data); {
+		var value uint64
+		switch protobufTypeWireType(descriptor.fieldType) {
+		case PROTOBUF_WIRE_TYPE_VARINT:
+			var err error
+			value, offset, err = readProtobufVarint(data, offset)
+			if err != nil {
+				return nil, err
+			}
+		case PROTOBUF_WIRE_TYPE_I64:
+			if len(data)-offset < 8 {
+				return nil, errors.New("truncated packed protobuf field")
+			}
+
+			value = binary.LittleEndian.Uint64(data[offset:])
+			offset += 8
+		case PROTOBUF_WIRE_TYPE_I32:
+			if len(data)-offset < 4 {
+				return nil, errors.New("truncated packed protobuf field")
+			}
+
+			value = uint64(binary.LittleEndian.Uint32(data[offset:]))
+			offset += 4
+		}
+
+		values = append(values, formatProtobufScalar(value, descriptor, registry))
+	}
+
+	return values, nil
+}
+
+func formatProtobufScalar(value uint64, descriptor *protobufFieldDescriptor, registry *ProtobufRegistry) string {
+	switch descriptor.fieldType {
+	case PROTOBUF_TYPE_DOUBLE:
+		return strconv.FormatFloat(math.Float64frombits(value), 'g', -1, 64)
+	case PROTOBUF_TYPE_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(value))), 'g', -1, 32)
+	case PROTOBUF_TYPE_INT64, PROTOBUF_TYPE_SFIXED64:
+		return strconv.FormatInt(int64(value), 10)
+	case PROTOBUF_TYPE_INT32:
+		return strconv.FormatInt(int64(int32(value)), 10)
+	case PROTOBUF_TYPE_SFIXED32:
+		return strconv.FormatInt(int64(int32(uint32(value))), 10)
+	case PROTOBUF_TYPE_UINT32, PROTOBUF_TYPE_FIXED32:
+		return strconv.FormatUint(uint64(uint32(value)), 10)
+	case PROTOBUF_TYPE_BOOL:
+		return strconv.FormatBool(value != 0)
+	case PROTOBUF_TYPE_SINT32, PROTOBUF_TYPE_SINT64:
+		return strconv.FormatInt(int64(value>>1)^-int64(value&1), 10)
+	case PROTOBUF_TYPE_ENUM:
+		// Negative enum values are sign extended to 64 bits
+		if name, ok := registry.enums[descriptor.typeName][uint64(int64(int32(value)))]; ok {
+			return fmt.Sprintf("%s (%d)", name, int32(value))
+		}
+
+		return strconv.FormatInt(int64(int32(value)), 10)
+	default:
+		return strconv.FormatUint(value, 10)
+	}
+}
+
+// splitGRPCMessages splits data to the gRPC length prefixed messages of which
+// it consists, returning false if it doesn't consist of them.
+func splitGRPCMessages(data []byte) ([][]byte, bool) {
+	var messages [][]byte
+	for len(data) > 0 {
+		if len(data) < GRPC_PREFIX_LENGTH || data[0] > 1 {
+			return nil, false
+		}
+
+		length := binary.BigEndian.Uint32(data[1:])
+		if uint64(length) > uint64(len(data)-GRPC_PREFIX_LENGTH) {
+			return nil, false
+		}
+
+		messages = append(messages, data[:GRPC_PREFIX_LENGTH+int(length)])
+		data = data[GRPC_PREFIX_LENGTH+int(length):]
+	}
+
+	return messages, len(messages) > 0
+}
+
+// splitDelimitedProtobuf splits data to the varint length prefixed messages
+// of which it consists, returning false if it doesn't consist of them.
+func splitDelimitedProtobuf(data []byte) ([][]byte, bool) {
+	var messages [][]byte
+	for len(data) > 0 {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n) {
+			return nil, false
+		}
+
+		if _, err := readProtobufRecords(data[n:n+int(length)], 0); err != nil {
+			return nil, false
+		}
+
+		messages = append(messages, data[:n+int(length)])
+		data = data[n+int(length):]
+	}
+
+	return messages, len(messages) > 0
+}
+
+// DecodeProtobufStream decodes data consisting of gRPC messages, a single
+// message, or varint length delimited messages, detecting which.
+func DecodeProtobufStream(data []byte, message *ProtobufMessage) ([]*Field, error) {
+	if grpcMessages, ok := splitGRPCMessages(data); ok {
+		var fields []*Field
+		offset := 0
+		for i, grpcMessage := range grpcMessages {
+			r := newReader(grpcMessage, binary.BigEndian)
+			compressed := r.byteField("Compressed")
+			children := []*Field{compressed, r.uint32Field("Length")}
+
+			payload := grpcMessage[GRPC_PREFIX_LENGTH:]
+			field := &Field{Name: fmt.Sprintf("gRPC message [%d]", i), Offset: 0, Length: len(grpcMessage)}
+			if compressed.Value != "0" {
+				field.Value = fmt.Sprintf("<%d compressed bytes>", len(payload))
+			} else {
+				decoded, err := decodeProtobuf(payload, GRPC_PREFIX_LENGTH, message, 0)
+				if err != nil {
+					return nil, fmt.Errorf("gRPC message %d: %w", i, err)
+				}
+
+				field.Value = fmt.Sprintf("{%d fields}", len(decoded))
+				children = append(children, decoded...)
+			}
+			field.Children = children
+
+			offsetFields([]*Field{field}, offset)
+			fields = append(fields, field)
+			offset += len(grpcMessage)
+		}
+
+		return fields, nil
+	}
+
+	fields, err := DecodeProtobuf(data, message)
+	if err == nil {
+		return fields, nil
+	}
+
+	// Length prefixes are mostly invalid as tags, so delimited messages are
+	// only tried once the data can't be decoded as a single message
+	if delimited, ok := splitDelimitedProtobuf(data); ok {
+		fields = nil
+		offset := 0
+		for i, part := range delimited {
+			length, n := binary.Uvarint(part)
+			decoded, err := decodeProtobuf(part[n:], offset+n, message, 0)
+			if err != nil {
+				return nil, fmt.Errorf("delimited message %d: %w", i, err)
+			}
+
+			children := append([]*Field{{Name: "Length", Value: strconv.FormatUint(length, 10), Offset: offset, Length: n}}, decoded...)
+			fields = append(fields, &Field{
+				Name:     fmt.Sprintf("Message [%d]", i),
+				Value:    fmt.Sprintf("{%d fields}", len(decoded)),
+				Offset:   offset,
+				Length:   len(part),
+				Children: children,
+			})
+			offset += len(part)
+		}
+
+		return fields, nil
+	}
+
+	return nil, err
+}
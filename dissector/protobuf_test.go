@@ -0,0 +1,90 @@
+package dissector
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// protobufLen encodes a len record of the given field number.
+func protobufLen(number int, data string) string {
+	record := binary.AppendUvarint(nil, uint64(number<<3|PROTOBUF_WIRE_TYPE_LEN))
+	record = binary.AppendUvarint(record, uint64(len(data)))
+	return string(record) + data
+}
+
+// protobufVarint encodes a varint record of the given field number.
+func protobufVarint(number int, value uint64) string {
+	record := binary.AppendUvarint(nil, uint64(number<<3|PROTOBUF_WIRE_TYPE_VARINT))
+	return string(binary.AppendUvarint(record, value))
+}
+
+func TestDecodeProtobufSchemaless(t *testing.T) {
+	message := protobufVarint(1, 150) + protobufLen(2, "testing") + protobufLen(3, protobufVarint(1, 1)+protobufVarint(2, 2))
+
+	fields, err := DecodeProtobuf([]byte(message), nil)
+	require.NoError(t, err)
+	require.Len(t, fields, 3)
+	assert.Equal(t, "1 (varint)", fields[0].Name)
+	assert.Equal(t, "150", fields[0].Value)
+	assert.Equal(t, `"testing"`, fields[1].Value)
+	require.Len(t, fields[2].Children, 2)
+	assert.Equal(t, "2", fields[2].Children[1].Value)
+	assert.Equal(t, len(message)-2, fields[2].Children[1].Offset)
+
+	_, err = DecodeProtobuf([]byte("\x0a\x05ab"), nil)
+	assert.Error(t, err)
+}
+
+func TestDecodeProtobufWithDescriptors(t *testing.T) {
+	// package shop;
+	// enum Status { UNKNOWN = 0; SHIPPED = 1; }
+	// message Order { string item = 1; sint32 delta = 2; Status status = 3; repeated int32 amounts = 4; }
+	// service Orders { rpc Place(Order) returns (Order); }
+	order := protobufLen(1, "Order") +
+		protobufLen(2, protobufLen(1, "item")+protobufVarint(3, 1)+protobufVarint(5, PROTOBUF_TYPE_STRING)) +
+		protobufLen(2, protobufLen(1, "delta")+protobufVarint(3, 2)+protobufVarint(5, PROTOBUF_TYPE_SINT32)) +
+		protobufLen(2, protobufLen(1, "status")+protobufVarint(3, 3)+protobufVarint(5, PROTOBUF_TYPE_ENUM)+protobufLen(6, ".shop.Status")) +
+		protobufLen(2, protobufLen(1, "amounts")+protobufVarint(3, 4)+protobufVarint(5, PROTOBUF_TYPE_INT32))
+	status := protobufLen(1, "Status") +
+		protobufLen(2, protobufLen(1, "UNKNOWN")+protobufVarint(2, 0)) +
+		protobufLen(2, protobufLen(1, "SHIPPED")+protobufVarint(2, 1))
+	service := protobufLen(1, "Orders") + protobufLen(2, protobufLen(1, "Place")+protobufLen(2, ".shop.Order")+protobufLen(3, ".shop.Order"))
+	set := protobufLen(1, protobufLen(2, "shop")+protobufLen(4, order)+protobufLen(5, status)+protobufLen(6, service))
+
+	registry := NewProtobufRegistry()
+	require.NoError(t, registry.AddDescriptorSet([]byte(set)))
+
+	request, _, err := registry.Method("/shop.Orders/Place")
+	require.NoError(t, err)
+	assert.Equal(t, "shop.Order", request.Name)
+
+	message := protobufLen(1, "book") + protobufVarint(2, 3) + protobufVarint(3, 1) + protobufLen(4, "\x01\x02\x03")
+	grpc := "\x00\x00\x00\x00" + string(rune(len(message))) + message
+
+	fields, err := DecodeProtobufStream([]byte(grpc), request)
+	require.NoError(t, err)
+	require.Len(t, fields, 1)
+
+	children := fields[0].Children
+	require.Len(t, children, 6)
+	assert.Equal(t, "item (1)", children[2].Name)
+	assert.Equal(t, `"book"`, children[2].Value)
+	assert.Equal(t, GRPC_PREFIX_LENGTH, children[2].Offset)
+	assert.Equal(t, "-2", children[3].Value)
+	assert.Equal(t, "SHIPPED (1)", children[4].Value)
+	assert.Equal(t, "[1, 2, 3]", children[5].Value)
+}
+
+func TestDecodeDelimitedProtobuf(t *testing.T) {
+	first := protobufVarint(1, 1)
+	second := protobufLen(2, "hi")
+	stream := string(rune(len(first))) + first + string(rune(len(second))) + second
+
+	fields, err := DecodeProtobufStream([]byte(stream), nil)
+	require.NoError(t, err)
+	require.Len(t, fields, 2)
+	assert.Equal(t, `"hi"`, fields[1].Children[1].Value)
+}
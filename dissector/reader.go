@@ -0,0 +1,157 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// reader reads the fields of a message, recording the first error. Once
+// there's an error, reads return zero values.
+type reader struct {
+	data   []byte
+	offset int
+	err    error
+	order  binary.ByteOrder
+}
+
+func newReader(data []byte, order binary.ByteOrder) *reader {
+	return &reader{data: data, order: order}
+}
+
+func (r *reader) read(length int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if length < 0 || length > len(r.data)-r.offset {
+		r.err = fmt.Errorf("unexpected end of message at offset %d", r.offset)
+		return nil
+	}
+
+	data := r.data[r.offset : r.offset+length]
+	r.offset += length
+	return data
+}
+
+func (r *reader) byte() byte {
+	data := r.read(1)
+	if data == nil {
+		return 0
+	}
+
+	return data[0]
+}
+
+func (r *reader) uint16() uint16 {
+	data := r.read(2)
+	if data == nil {
+		return 0
+	}
+
+	return r.order.Uint16(data)
+}
+
+func (r *reader) uint32() uint32 {
+	data := r.read(4)
+	if data == nil {
+		return 0
+	}
+
+	return r.order.Uint32(data)
+}
+
+func (r *reader) uint64() uint64 {
+	data := r.read(8)
+	if data == nil {
+		return 0
+	}
+
+	return r.order.Uint64(data)
+}
+
+func (r *reader) int16() int {
+	return int(int16(r.uint16()))
+}
+
+func (r *reader) int32() int {
+	return int(int32(r.uint32()))
+}
+
+// cstring reads a NUL terminated string.
+func (r *reader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+
+	end := bytes.IndexByte(r.data[r.offset:], 0)
+	if end == -1 {
+		r.err = fmt.Errorf("unterminated string at offset %d", r.offset)
+		return ""
+	}
+
+	s := string(r.data[r.offset : r.offset+end])
+	r.offset += end + 1
+	return s
+}
+
+func (r *reader) remaining() int {
+	return len(r.data) - r.offset
+}
+
+// rest reads the rest of the data.
+func (r *reader) rest() []byte {
+	return r.read(r.remaining())
+}
+
+// field returns a field of the given value, ranging from begin to the current
+// offset.
+func (r *reader) field(name string, value any, begin int) *Field {
+	return &Field{Name: name, Value: fmt.Sprint(value), Offset: begin, Length: r.offset - begin}
+}
+
+// integerType returns the type of integers of the given size read by r.
+func (r *reader) integerType(size int, signed bool, enum map[int64]string) *FieldType {
+	return &FieldType{Size: size, Signed: signed, Order: r.order, Enum: enum}
+}
+
+// cstringField reads a string into a field.
+func (r *reader) cstringField(name string) *Field {
+	begin := r.offset
+	return r.field(name, r.cstring(), begin)
+}
+
+func (r *reader) byteField(name string) *Field {
+	begin := r.offset
+	field := r.field(name, r.byte(), begin)
+	field.Type = r.integerType(1, false, nil)
+	return field
+}
+
+func (r *reader) uint16Field(name string) *Field {
+	begin := r.offset
+	field := r.field(name, r.uint16(), begin)
+	field.Type = r.integerType(2, false, nil)
+	return field
+}
+
+func (r *reader) uint32Field(name string) *Field {
+	begin := r.offset
+	field := r.field(name, r.uint32(), begin)
+	field.Type = r.integerType(4, false, nil)
+	return field
+}
+
+func (r *reader) int16Field(name string) *Field {
+	begin := r.offset
+	field := r.field(name, r.int16(), begin)
+	field.Type = r.integerType(2, true, nil)
+	return field
+}
+
+func (r *reader) int32Field(name string) *Field {
+	begin := r.offset
+	field := r.field(name, r.int32(), begin)
+	field.Type = r.integerType(4, true, nil)
+	return field
+}
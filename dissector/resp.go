@@ -0,0 +1,441 @@
+package dissector
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// RESP_MAX_DEPTH bounds the nesting of aggregate values, so malicious input
+// can't exhaust the stack.
+const RESP_MAX_DEPTH = 128
+
+// RESP_MAX_LENGTH bounds the lengths of blobs and aggregates, far above the
+// limits of Redis, so malicious lengths can't overflow offsets.
+const RESP_MAX_LENGTH = 1 << 32
+
+type respValue struct {
+	kind byte // The type byte, or 0 for inline commands
+
+	// data is the content of simple and bulk values.
+	data []byte
+	// isNull is set for the RESP2 null bulk strings and arrays ("$-1", "*-1").
+	isNull   bool
+	elements []*respValue
+
+	// attribute is the RESP3 attribute preceding the value, if any.
+	attribute *respValue
+
+	offset int
+	length int
+}
+
+func (value *respValue) typeName() string {
+	switch value.kind {
+	case 0:
+		return "Inline command"
+	case '+':
+		return "Simple string"
+	case '-':
+		return "Error"
+	case ':':
+		return "Integer"
+	case '$':
+		return "Bulk string"
+	case '*':
+		return "Array"
+	case '_':
+		return "Null"
+	case ',':
+		return "Double"
+	case '#':
+		return "Boolean"
+	case '!':
+		return "Bulk error"
+	case '=':
+		return "Verbatim string"
+	case '(':
+		return "Big number"
+	case '%':
+		return "Map"
+	case '~':
+		return "Set"
+	case '>':
+		return "Push"
+	case '|':
+		return "Attribute"
+	default:
+		panic("invalid RESP type")
+	}
+}
+
+func isRESPType(kind byte) bool {
+	return strings.IndexByte("+-:$*_,#!=(%~>|", kind) != -1
+}
+
+// isRESPBlob checks if values of the type hold length prefixed data.
+func isRESPBlob(kind byte) bool {
+	return kind == '$' || kind == '!' || kind == '='
+}
+
+// isRESPAggregate checks if values of the type hold a count of elements.
+func isRESPAggregate(kind byte) bool {
+	return kind == '*' || kind == '%' || kind == '~' || kind == '>' || kind == '|'
+}
+
+// respLine returns the line beginning data, without its "\r\n", and whether
+// it's complete.
+func respLine(data []byte) ([]byte, bool) {
+	lineEnd := bytes.Index(data, []byte("\r\n"))
+	if lineEnd == -1 {
+		return nil, false
+	}
+
+	return data[:lineEnd], true
+}
+
+// parseRESP parses the value beginning at the given offset of data. It returns
+// nil if the value isn't complete.
+func parseRESP(data []byte, offset int, depth int) (*respValue, error) {
+	if depth > RESP_MAX_DEPTH {
+		return nil, fmt.Errorf("RESP values are nested deeper than %d", RESP_MAX_DEPTH)
+	}
+
+	if offset >= len(data) {
+		return nil, nil
+	}
+
+	kind := data[offset]
+	if !isRESPType(kind) {
+		return nil, fmt.Errorf("invalid RESP type byte %q", kind)
+	}
+
+	line, ok := respLine(data[offset+1:])
+	if !ok {
+		return nil, nil
+	}
+
+	value := &respValue{kind: kind, offset: offset}
+	end := offset + 1 + len(line) + len("\r\n")
+
+	switch {
+	case isRESPBlob(kind), isRESPAggregate(kind):
+		count, err := strconv.Atoi(string(line))
+		if err != nil || count < -1 || count > RESP_MAX_LENGTH {
+			return nil, fmt.Errorf("invalid RESP length %q", line)
+		}
+
+		if count == -1 {
+			value.isNull = true
+			break
+		}
+
+		if isRESPBlob(kind) {
+			if count > len(data)-end-len("\r\n") {
+				return nil, nil
+			}
+
+			value.data = data[end : end+count]
+			end += count + len("\r\n")
+			break
+		}
+
+		if kind == '%' || kind == '|' { // Maps hold pairs of keys and values
+			count *= 2
+		}
+
+		for range count {
+			element, err := parseRESP(data, end, depth+1)
+			if err != nil || element == nil {
+				return nil, err
+			}
+
+			value.elements = append(value.elements, element)
+			end = element.offset + element.length
+		}
+	default:
+		value.data = line
+	}
+
+	value.length = end - offset
+
+	if kind == '|' { // An attribute precedes the value it describes
+		attributed, err := parseRESP(data, end, depth+1)
+		if err != nil || attributed == nil {
+			return nil, err
+		}
+
+		attributed.attribute = value
+		attributed.length += attributed.offset - offset
+		attributed.offset = offset
+		return attributed, nil
+	}
+
+	return value, nil
+}
+
+// parseRESPMessage parses the command or reply at the beginning of data,
+// which may be an inline command when sent to the server. It returns nil if
+// the message isn't complete.
+func parseRESPMessage(direction tcpmessage.TransmittionDirection, data []byte) (*respValue, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER && !isRESPType(data[0]) {
+		lineEnd := bytes.IndexByte(data, '\n')
+		if lineEnd == -1 {
+			return nil, nil
+		}
+
+		return &respValue{data: bytes.TrimRight(data[:lineEnd], "\r"), length: lineEnd + 1}, nil
+	}
+
+	return parseRESP(data, 0, 0)
+}
+
+type RESPDissector struct{}
+
+func (RESPDissector) Name() string {
+	return "redis"
+}
+
+func (RESPDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	// Commands are sent as arrays of bulk strings
+	return direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER &&
+		len(data) >= 2 && data[0] == '*' && '0' <= data[1] && data[1] <= '9'
+}
+
+func (RESPDissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	return direction == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER && string(data) == "*"
+}
+
+func (RESPDissector) NewSession() Session {
+	return respSession{}
+}
+
+type respSession struct{}
+
+func (respSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	value, err := parseRESPMessage(direction, data)
+	if err != nil || value == nil {
+		return 0, nil, err
+	}
+
+	return value.length, RESPDecoder{direction}, nil
+}
+
+// RESPDecoder decodes RESP2 and RESP3 commands and replies.
+type RESPDecoder struct {
+	direction tcpmessage.TransmittionDirection
+}
+
+func (RESPDecoder) Name() string {
+	return "redis"
+}
+
+func (d RESPDecoder) parse(content []byte) (*respValue, error) {
+	value, err := parseRESPMessage(d.direction, content)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("incomplete RESP message")
+	}
+	if value.length != len(content) {
+		return nil, fmt.Errorf("%d bytes after the end of the RESP message", len(content)-value.length)
+	}
+
+	return value, nil
+}
+
+func (d RESPDecoder) Decode(content []byte) (*Dissection, error) {
+	value, err := d.parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dissection{
+		Summary: respSummary(value),
+		Fields:  []*Field{respField(value, "")},
+	}, nil
+}
+
+func respField(value *respValue, namePrefix string) *Field {
+	field := &Field{
+		Name:   namePrefix + value.typeName(),
+		Offset: value.offset,
+		Length: value.length,
+	}
+
+	switch {
+	case value.isNull:
+		field.Value = "(nil)"
+	case isRESPAggregate(value.kind):
+		field.Name += fmt.Sprintf(" (%d elements)", len(value.elements))
+	case value.kind == 0:
+		field.Value = string(value.data)
+	default:
+		field.Value = DescribeBody(value.data)
+	}
+
+	if value.attribute != nil {
+		field.Children = append(field.Children, respField(value.attribute, ""))
+	}
+
+	for i, element := range value.elements {
+		prefix := fmt.Sprintf("[%d] ", i)
+		if value.kind == '%' || value.kind == '|' {
+			prefix = "Value: "
+			if i%2 == 0 {
+				prefix = fmt.Sprintf("[%d] Key: ", i/2)
+			}
+		}
+
+		field.Children = append(field.Children, respField(element, prefix))
+	}
+
+	return field
+}
+
+// RESP_SUMMARY_ARGUMENTS is the number of words of a command shown in its
+// summary, including the command name.
+const RESP_SUMMARY_ARGUMENTS = 2
+
+// respSummaryText formats text for a summary, quoting it if it's not a single
+// printable word.
+func respSummaryText(text []byte) string {
+	if len(text) == 0 || bytes.ContainsFunc(text, func(r rune) bool { return !unicode.IsGraphic(r) || unicode.IsSpace(r) }) {
+		return strconv.Quote(string(text))
+	}
+
+	return string(text)
+}
+
+func respSummary(value *respValue) string {
+	words, isCommand := respCommandWords(value)
+	if isCommand {
+		var summary []string
+		for _, word := range words[:min(len(words), RESP_SUMMARY_ARGUMENTS)] {
+			summary = append(summary, respSummaryText(word))
+		}
+		if len(words) > RESP_SUMMARY_ARGUMENTS {
+			summary = append(summary, "…")
+		}
+
+		return strings.Join(summary, " ")
+	}
+
+	switch {
+	case value.isNull || value.kind == '_':
+		return "(nil)"
+	case isRESPAggregate(value.kind):
+		return fmt.Sprintf("%s (%d elements)", strings.ToLower(value.typeName()), len(value.elements))
+	case isRESPBlob(value.kind):
+		return respSummaryText(value.data)
+	default:
+		return string(value.data)
+	}
+}
+
+// respCommandWords returns the words of the value if it's a command, which is
+// either an inline command or a non-empty array of bulk strings.
+func respCommandWords(value *respValue) ([][]byte, bool) {
+	if value.kind == 0 {
+		return bytes.Fields(value.data), true
+	}
+
+	if value.kind != '*' || value.isNull || len(value.elements) == 0 {
+		return nil, false
+	}
+
+	var words [][]byte
+	for _, element := range value.elements {
+		if element.kind != '$' || element.isNull {
+			return nil, false
+		}
+
+		words = append(words, element.data)
+	}
+
+	return words, true
+}
+
+// Payload returns the editable text of a command or a simple reply. A command
+// is a line per argument, with arguments which contain newlines or begin with
+// a quote written as quoted Go strings. A reply is its data.
+func (d RESPDecoder) Payload(content []byte) ([]byte, error) {
+	value, err := d.parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if words, isCommand := respCommandWords(value); isCommand {
+		var lines []string
+		for _, word := range words {
+			if bytes.ContainsAny(word, "\r\n") || bytes.HasPrefix(word, []byte(`"`)) {
+				lines = append(lines, strconv.Quote(string(word)))
+			} else {
+				lines = append(lines, string(word))
+			}
+		}
+
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	}
+
+	if isRESPAggregate(value.kind) || value.isNull || value.attribute != nil || value.kind == '_' {
+		return nil, fmt.Errorf("editing RESP %s values is not supported", strings.ToLower(value.typeName()))
+	}
+
+	return value.data, nil
+}
+
+// SetPayload encodes the command or the reply from the text returned by
+// Payload.
+func (d RESPDecoder) SetPayload(content, payload []byte) ([]byte, error) {
+	value, err := d.parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, isCommand := respCommandWords(value); isCommand {
+		var words []string
+		for _, line := range strings.Split(strings.TrimSuffix(string(payload), "\n"), "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if strings.HasPrefix(line, `"`) {
+				line, err = strconv.Unquote(line)
+				if err != nil {
+					return nil, fmt.Errorf("invalid quoted argument: %w", err)
+				}
+			}
+
+			words = append(words, line)
+		}
+
+		return EncodeRESPCommand(words...), nil
+	}
+
+	if isRESPBlob(value.kind) {
+		return fmt.Appendf(nil, "%c%d\r\n%s\r\n", value.kind, len(payload), payload), nil
+	}
+
+	if bytes.ContainsAny(payload, "\r\n") {
+		return nil, fmt.Errorf("RESP %s values can't contain newlines", strings.ToLower(value.typeName()))
+	}
+
+	return fmt.Appendf(nil, "%c%s\r\n", value.kind, payload), nil
+}
+
+// EncodeRESPCommand encodes a command as an array of bulk strings.
+func EncodeRESPCommand(words ...string) []byte {
+	encoded := fmt.Appendf(nil, "*%d\r\n", len(words))
+	for _, word := range words {
+		encoded = fmt.Appendf(encoded, "$%d\r\n%s\r\n", len(word), word)
+	}
+
+	return encoded
+}
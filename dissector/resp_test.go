@@ -0,0 +1,67 @@
+package dissector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRESPFraming(t *testing.T) {
+	session := RESPDissector{}.NewSession()
+
+	commands := string(EncodeRESPCommand("SET", "user:1", "alice")) + "PING\r\n" + "*1\r\n$4\r\nPI"
+	frames, rest := frameAll(t, session, toServer, commands)
+	assert.Equal(t, []string{string(EncodeRESPCommand("SET", "user:1", "alice")), "PING\r\n"}, frames)
+	assert.Equal(t, "*1\r\n$4\r\nPI", rest)
+
+	replies := "+OK\r\n$-1\r\n|1\r\n+ttl\r\n:3\r\n%1\r\n+key\r\n*2\r\n:1\r\n_\r\n"
+	frames, rest = frameAll(t, session, toClient, replies)
+	assert.Equal(t, []string{"+OK\r\n", "$-1\r\n", "|1\r\n+ttl\r\n:3\r\n%1\r\n+key\r\n*2\r\n:1\r\n_\r\n"}, frames)
+	assert.Empty(t, rest)
+}
+
+func TestRESPHugeLengths(t *testing.T) {
+	session := RESPDissector{}.NewSession()
+
+	length, _, err := session.Frame(toServer, []byte("*1\r\n$9223372036854775807\r\nabc"))
+	assert.Error(t, err)
+	assert.Zero(t, length)
+
+	// Waits for the rest of the bulk string
+	length, _, err = session.Frame(toServer, []byte("*1\r\n$4294967296\r\nabc"))
+	assert.NoError(t, err)
+	assert.Zero(t, length)
+
+	_, _, err = session.Frame(toClient, []byte("%4611686018427387904\r\n"))
+	assert.Error(t, err)
+}
+
+func TestRESPDecode(t *testing.T) {
+	dissection, err := RESPDecoder{toServer}.Decode(EncodeRESPCommand("SET", "user:1", "hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, "SET user:1 …", dissection.Summary)
+	assert.Equal(t, "[2] Bulk string", dissection.Fields[0].Children[2].Name)
+	assert.Equal(t, "hello world", dissection.Fields[0].Children[2].Value)
+
+	dissection, err = RESPDecoder{toClient}.Decode([]byte("-ERR unknown command\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "ERR unknown command", dissection.Summary)
+}
+
+func TestRESPSetPayload(t *testing.T) {
+	decoder := RESPDecoder{toServer}
+	content := EncodeRESPCommand("SET", "key", "multi\nline")
+
+	payload, err := decoder.Payload(content)
+	require.NoError(t, err)
+	assert.Equal(t, "SET\nkey\n\"multi\\nline\"\n", string(payload))
+
+	edited, err := decoder.SetPayload(content, []byte("SET\nkey\n\"new\\nvalue\"\nEX\n10\n"))
+	require.NoError(t, err)
+	assert.Equal(t, EncodeRESPCommand("SET", "key", "new\nvalue", "EX", "10"), edited)
+
+	edited, err = RESPDecoder{toClient}.SetPayload([]byte("$5\r\nhello\r\n"), []byte("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, "$2\r\nhi\r\n", string(edited))
+}
@@ -0,0 +1,223 @@
+package dissector
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// StructuredKind is the kind of a StructuredValue.
+type StructuredKind int
+
+const (
+	STRUCTURED_KIND_SCALAR StructuredKind = iota
+	STRUCTURED_KIND_ARRAY
+	STRUCTURED_KIND_MAP
+)
+
+// STRUCTURED_MAX_DEPTH is the maximal nesting of decoded arrays and maps.
+const STRUCTURED_MAX_DEPTH = 64
+
+// StructuredValue is a value decoded from a self-describing binary format,
+// such as MessagePack, CBOR or BSON.
+type StructuredValue struct {
+	Kind StructuredKind
+	// Text is the value of scalars, in a JSON like notation
+	Text string
+	// Tag is the tag (CBOR) or extension type (MessagePack) of the value, if
+	// it has one
+	Tag string
+
+	// Keys are the keys of the entries of maps, in a JSON like notation
+	Keys     []string
+	Elements []*StructuredValue
+
+	Offset int
+	Length int
+}
+
+// StructuredFormat is a self-describing binary format.
+type StructuredFormat struct {
+	Name string
+	// decode decodes the value at offset, returning it along with the offset
+	// after it. On errors, the value decoded so far is returned.
+	decode func(data []byte, offset int, depth int) (*StructuredValue, int, error)
+	// single is whether the data is a single value, and not a sequence
+	single bool
+}
+
+// StructuredFormats are the supported formats, by their detection priority.
+var StructuredFormats = []StructuredFormat{
+	{Name: "BSON", decode: decodeBSONDocument, single: true},
+	{Name: "MessagePack", decode: decodeMessagePack},
+	{Name: "CBOR", decode: decodeCBOR},
+}
+
+// StructuredError is an error decoding a structured value, at an offset of
+// the data.
+type StructuredError struct {
+	Offset int
+	Err    error
+}
+
+func (e *StructuredError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *StructuredError) Unwrap() error {
+	return e.Err
+}
+
+var errStructuredTruncated = errors.New("unexpected end of data")
+
+func structuredError(offset int, format string, args ...any) error {
+	return &StructuredError{Offset: offset, Err: fmt.Errorf(format, args...)}
+}
+
+func structuredTruncated(offset int) error {
+	return &StructuredError{Offset: offset, Err: errStructuredTruncated}
+}
+
+// structuredScalar returns a scalar at offset, ending at end.
+func structuredScalar(text string, offset, end int) *StructuredValue {
+	return &StructuredValue{Kind: STRUCTURED_KIND_SCALAR, Text: text, Offset: offset, Length: end - offset}
+}
+
+// structuredKey returns the text of value as a map key.
+func structuredKey(value *StructuredValue) string {
+	if value == nil {
+		return "?"
+	}
+
+	switch value.Kind {
+	case STRUCTURED_KIND_ARRAY:
+		return fmt.Sprintf("[array of %d]", len(value.Elements))
+	case STRUCTURED_KIND_MAP:
+		return fmt.Sprintf("{map of %d}", len(value.Elements))
+	}
+
+	if value.Tag != "" {
+		return value.Tag + "(" + value.Text + ")"
+	}
+
+	return value.Text
+}
+
+func formatStructuredFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "Infinity"
+	case math.IsInf(value, -1):
+		return "-Infinity"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func formatStructuredBytes(data []byte) string {
+	return fmt.Sprintf("h'%x'", data)
+}
+
+// readStructured returns the length bytes at offset, or an error if the data
+// is too short.
+func readStructured(data []byte, offset int, length uint64) ([]byte, error) {
+	if length > uint64(len(data)-offset) {
+		return nil, structuredTruncated(offset)
+	}
+
+	return data[offset : offset+int(length)], nil
+}
+
+// readStructuredUint reads a big endian unsigned integer of size bytes at
+// offset.
+func readStructuredUint(data []byte, offset int, size int) (uint64, error) {
+	bytes, err := readStructured(data, offset, uint64(size))
+	if err != nil {
+		return 0, err
+	}
+
+	var value uint64
+	for _, b := range bytes {
+		value = value<<8 | uint64(b)
+	}
+
+	return value, nil
+}
+
+// decodeStructured decodes data as a sequence of values (or a single value,
+// for formats which aren't sequences) of the format. On errors, the values
+// decoded so far are returned.
+func decodeStructured(data []byte, format StructuredFormat) ([]*StructuredValue, error) {
+	var values []*StructuredValue
+	offset := 0
+	for offset < len(data) {
+		value, next, err := format.decode(data, offset, 0)
+		if value != nil {
+			values = append(values, value)
+		}
+		if err != nil {
+			return values, err
+		}
+
+		offset = next
+		if format.single && offset < len(data) {
+			return values, structuredError(offset, "%d trailing bytes", len(data)-offset)
+		}
+	}
+
+	return values, nil
+}
+
+// DecodeStructured decodes data by the format with the given name.
+func DecodeStructured(data []byte, name string) ([]*StructuredValue, error) {
+	for _, format := range StructuredFormats {
+		if format.Name == name {
+			return decodeStructured(data, format)
+		}
+	}
+
+	return nil, fmt.Errorf("unknown format %q", name)
+}
+
+// DetectStructured decodes data by the format it's most likely encoded in,
+// returning the name of the format. A format which decodes the whole data
+// into the fewest values is preferred, and if no format decodes the whole
+// data, the format which decodes the most of it is chosen, along with its
+// error.
+func DetectStructured(data []byte) (string, []*StructuredValue, error) {
+	if len(data) == 0 {
+		return "", nil, errors.New("the data is empty")
+	}
+
+	bestName := ""
+	var bestValues []*StructuredValue
+	var bestErr error
+	bestOffset := -1
+
+	for _, format := range StructuredFormats {
+		values, err := decodeStructured(data, format)
+		if err == nil {
+			if bestErr == nil && bestName != "" && len(bestValues) <= len(values) {
+				continue
+			}
+
+			bestName, bestValues, bestErr = format.Name, values, nil
+			continue
+		}
+
+		var structuredErr *StructuredError
+		offset := 0
+		if errors.As(err, &structuredErr) {
+			offset = structuredErr.Offset
+		}
+
+		if bestName == "" || (bestErr != nil && offset > bestOffset) {
+			bestName, bestValues, bestErr, bestOffset = format.Name, values, err, offset
+		}
+	}
+
+	return bestName, bestValues, bestErr
+}
@@ -0,0 +1,61 @@
+package dissector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectStructured(t *testing.T) {
+	tests := []struct {
+		data   string
+		format string
+		keys   []string
+		texts  []string
+	}{
+		// {"compact": true, "schema": 0}
+		{"82a7636f6d70616374c3a6736368656d6100", "MessagePack", []string{`"compact"`, `"schema"`}, []string{"true", "0"}},
+		// {"a": 1, "b": -2.5}
+		{"a26161016162f9c100", "CBOR", []string{`"a"`, `"b"`}, []string{"1", "-2.5"}},
+		// {"hello": "world"}
+		{"160000000268656c6c6f0006000000776f726c640000", "BSON", []string{`"hello"`}, []string{`"world"`}},
+	}
+
+	for _, test := range tests {
+		format, values, err := DetectStructured([]byte(mustDecodeHex(t, test.data)))
+		require.NoError(t, err)
+		assert.Equal(t, test.format, format)
+		require.Len(t, values, 1)
+
+		value := values[0]
+		assert.Equal(t, STRUCTURED_KIND_MAP, value.Kind)
+		assert.Equal(t, test.keys, value.Keys)
+		var texts []string
+		for _, element := range value.Elements {
+			texts = append(texts, element.Text)
+		}
+		assert.Equal(t, test.texts, texts)
+	}
+}
+
+func TestDecodeStructuredPartial(t *testing.T) {
+	// {"a": 1, "b": ...} truncated before the value of "b"
+	values, err := DecodeStructured([]byte(mustDecodeHex(t, "82a16101a162")), "MessagePack")
+	var structuredErr *StructuredError
+	require.True(t, errors.As(err, &structuredErr))
+	assert.Equal(t, 6, structuredErr.Offset)
+
+	require.Len(t, values, 1)
+	assert.Equal(t, []string{`"a"`}, values[0].Keys)
+
+	// 1(1363896240) [h'0102', _ "ab" "c"]
+	values, err = DecodeStructured([]byte(mustDecodeHex(t, "c11a514b67b0"+"824201027f6261626163ff")), "CBOR")
+	require.NoError(t, err)
+	require.Len(t, values, 2)
+	assert.Equal(t, "1", values[0].Tag)
+	assert.Equal(t, `"2013-03-21T20:04:00Z"`, values[0].Text)
+	assert.Equal(t, "h'0102'", values[1].Elements[0].Text)
+	assert.Equal(t, `"abc"`, values[1].Elements[1].Text)
+}
@@ -0,0 +1,587 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TEMPLATE_MAX_DEPTH is the maximal nesting of decoded structs.
+const TEMPLATE_MAX_DEPTH = 64
+
+// TEMPLATE_COUNT_EOS repeats a field until the end of the data.
+const TEMPLATE_COUNT_EOS = "eos"
+
+const (
+	TEMPLATE_TYPE_VARINT = "varint"
+	TEMPLATE_TYPE_STRING = "string"
+	TEMPLATE_TYPE_BYTES  = "bytes"
+	TEMPLATE_TYPE_STRUCT = "struct"
+)
+
+type templateInteger struct {
+	size   int
+	signed bool
+	// order is nil for integers of the template's byte order
+	order binary.ByteOrder
+}
+
+// templateIntegers are the integer types, by their names. Multi-byte types
+// without a le or be suffix are of the byte order of the template.
+var templateIntegers = map[string]templateInteger{}
+
+// templateChecksumAlgorithms are the checksums of the checksum fields, by
+// their names. Checksums are truncated to the size of their fields.
+var templateChecksumAlgorithms = map[string]func(data []byte) uint64{
+	"sum8": func(data []byte) uint64 {
+		var sum uint64
+		for _, b := range data {
+			sum += uint64(b)
+		}
+		return sum & 0xff
+	},
+	"sum16": func(data []byte) uint64 {
+		var sum uint64
+		for _, b := range data {
+			sum += uint64(b)
+		}
+		return sum & 0xffff
+	},
+	"xor8": func(data []byte) uint64 {
+		var xor byte
+		for _, b := range data {
+			xor ^= b
+		}
+		return uint64(xor)
+	},
+	// internet is the ones' complement checksum of IP, TCP and UDP (RFC 1071)
+	"internet": func(data []byte) uint64 {
+		var sum uint32
+		for i := 0; i+1 < len(data); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(data[i:]))
+		}
+		if len(data)%2 == 1 {
+			sum += uint32(data[len(data)-1]) << 8
+		}
+		for sum > 0xffff {
+			sum = sum&0xffff + sum>>16
+		}
+		return uint64(^uint16(sum))
+	},
+	"crc32": func(data []byte) uint64 {
+		return uint64(crc32.ChecksumIEEE(data))
+	},
+}
+
+// templateIntegerMask returns the mask of the bits of the integer field.
+func templateIntegerMask(field *TemplateField) uint64 {
+	size := templateIntegers[field.Type].size
+	if size == 8 {
+		return math.MaxUint64
+	}
+	return 1<<(8*size) - 1
+}
+
+func init() {
+	for _, size := range []int{1, 2, 4, 8} {
+		for _, signed := range []bool{false, true} {
+			name := "u"
+			if signed {
+				name = "i"
+			}
+			name += strconv.Itoa(size * 8)
+
+			templateIntegers[name] = templateInteger{size, signed, nil}
+			if size > 1 {
+				templateIntegers[name+"le"] = templateInteger{size, signed, binary.LittleEndian}
+				templateIntegers[name+"be"] = templateInteger{size, signed, binary.BigEndian}
+			}
+		}
+	}
+}
+
+// TemplateField is a field of a template.
+type TemplateField struct {
+	Name string `yaml:"name"`
+	// Type is an integer type (u8, u16le, i32be, ...), varint, string, bytes
+	// or struct. Fields with nested fields are structs.
+	Type string `yaml:"type"`
+	// Length is the length of strings, bytes and structs. Strings without a
+	// length are null terminated, and bytes and structs without a length span
+	// the rest of the data.
+	Length string `yaml:"length"`
+	// Count repeats the field, either by an expression or until the end of
+	// the data (eos).
+	Count string `yaml:"count"`
+	// If is the condition under which the field is present.
+	If string `yaml:"if"`
+	// Enum is the name of the enum by which integers are named.
+	Enum string `yaml:"enum"`
+	// Checksum is the algorithm of integers which are checksums, see
+	// templateChecksumAlgorithms.
+	Checksum string `yaml:"checksum"`
+	// ChecksumOf are the comma separated names of the fields of the struct
+	// the checksum covers. Defaults to the fields before the checksum.
+	ChecksumOf string           `yaml:"checksum-of"`
+	Fields     []*TemplateField `yaml:"fields"`
+
+	length    templateExpression
+	count     templateExpression
+	condition templateExpression
+
+	// lengthPath and lengthConstant are set if the length is a field plus a
+	// constant, so that the field can be updated when the length changes
+	lengthPath     string
+	lengthConstant int64
+}
+
+// Template describes the binary structure of a message, by which messages of
+// custom protocols are decoded to fields. Lengths, counts and conditions are
+// expressions over the integer fields decoded before them, see
+// compileTemplateExpression.
+type Template struct {
+	Name string `yaml:"name"`
+	// Endian is the byte order (big or little) of integers whose type
+	// doesn't specify one. Defaults to big.
+	Endian string                      `yaml:"endian"`
+	Enums  map[string]map[int64]string `yaml:"enums"`
+	Fields []*TemplateField            `yaml:"fields"`
+
+	order binary.ByteOrder
+	// enumValues are the values of the enums, by their names
+	enumValues map[string]int64
+}
+
+// ParseTemplate parses a YAML template, such as:
+//
+//	name: chat
+//	enums:
+//	  opcode: {1: LOGIN, 2: MESSAGE}
+//	fields:
+//	  - {name: opcode, type: u8, enum: opcode}
+//	  - {name: length, type: u16le}
+//	  - name: message
+//	    if: opcode == MESSAGE
+//	    fields:
+//	      - {name: text, type: string, length: length}
+func ParseTemplate(data []byte) (*Template, error) {
+	var template Template
+	if err := yaml.Unmarshal(data, &template); err != nil {
+		return nil, err
+	}
+
+	switch template.Endian {
+	case "", "big":
+		template.order = binary.BigEndian
+	case "little":
+		template.order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("invalid endian %q, expected big or little", template.Endian)
+	}
+
+	template.enumValues = make(map[string]int64)
+	for _, enum := range template.Enums {
+		for value, name := range enum {
+			template.enumValues[name] = value
+		}
+	}
+
+	if len(template.Fields) == 0 {
+		return nil, errors.New("the template has no fields")
+	}
+
+	if err := template.compileFields(template.Fields); err != nil {
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+func (t *Template) compileFields(fields []*TemplateField) error {
+	for _, field := range fields {
+		if err := t.compileField(field); err != nil {
+			return fmt.Errorf("field %q: %w", field.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (t *Template) compileField(field *TemplateField) error {
+	if field.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if field.Type == "" && len(field.Fields) > 0 {
+		field.Type = TEMPLATE_TYPE_STRUCT
+	}
+
+	_, isInteger := templateIntegers[field.Type]
+	switch {
+	case isInteger, field.Type == TEMPLATE_TYPE_VARINT:
+		if field.Length != "" {
+			return fmt.Errorf("%s fields have no length", field.Type)
+		}
+	case field.Type == TEMPLATE_TYPE_STRING, field.Type == TEMPLATE_TYPE_BYTES, field.Type == TEMPLATE_TYPE_STRUCT:
+	default:
+		return fmt.Errorf("invalid type %q", field.Type)
+	}
+
+	if (field.Type == TEMPLATE_TYPE_STRUCT) != (len(field.Fields) > 0) {
+		return errors.New("only structs have fields, and they must have at least one")
+	}
+
+	if field.Enum != "" {
+		if _, ok := t.Enums[field.Enum]; !ok {
+			return fmt.Errorf("unknown enum %q", field.Enum)
+		}
+		if !isInteger && field.Type != TEMPLATE_TYPE_VARINT {
+			return errors.New("only integers have enums")
+		}
+	}
+
+	if field.Checksum != "" {
+		if _, ok := templateChecksumAlgorithms[field.Checksum]; !ok {
+			return fmt.Errorf("unknown checksum %q", field.Checksum)
+		}
+		if !isInteger || field.Count != "" {
+			return errors.New("checksums must be integers which aren't repeated")
+		}
+	}
+
+	var err error
+	if field.Length != "" {
+		if field.length, err = compileTemplateExpression(field.Length); err != nil {
+			return err
+		}
+		field.lengthPath, field.lengthConstant, _ = templateLinearReference(field.Length)
+	}
+	if field.Count != "" && field.Count != TEMPLATE_COUNT_EOS {
+		if field.count, err = compileTemplateExpression(field.Count); err != nil {
+			return err
+		}
+	}
+	if field.If != "" {
+		if field.condition, err = compileTemplateExpression(field.If); err != nil {
+			return err
+		}
+	}
+
+	return t.compileFields(field.Fields)
+}
+
+// templateValue is what's known of a decoded field, to re-encode it.
+type templateValue struct {
+	template *TemplateField
+	// array is set for the field holding the elements of a repeated field
+	array bool
+	// parent is the struct or array the field is in, nil at the top level
+	parent *Field
+	// lengthField is the integer field the length of the field is taken
+	// from, if its length is the value of a field plus a constant
+	lengthField *Field
+	// value is the value of integer fields
+	value int64
+}
+
+// templateChecksum is a decoded checksum field, and the range of the data it
+// covers.
+type templateChecksum struct {
+	field      *Field
+	template   *TemplateField
+	begin, end int
+}
+
+// templateDecoder decodes data by a template, recording what's needed to
+// re-encode the decoded fields.
+type templateDecoder struct {
+	template  *Template
+	data      []byte
+	values    map[*Field]*templateValue
+	checksums []templateChecksum
+}
+
+// decode decodes data by the template, returning the decoder along with the
+// decoded fields.
+func (t *Template) decode(data []byte) (*templateDecoder, []*Field, error) {
+	decoder := &templateDecoder{template: t, data: data, values: make(map[*Field]*templateValue)}
+
+	fields, offset, err := decoder.decodeFields(len(data), 0, t.Fields, newTemplateScope(nil, t), nil, 0)
+	if err == nil && offset < len(data) {
+		err = fmt.Errorf("%d trailing bytes at offset %d", len(data)-offset, offset)
+	}
+
+	return decoder, fields, err
+}
+
+// Decode decodes data by the template. On errors, the fields decoded so far
+// are returned along with the error.
+func (t *Template) Decode(data []byte) ([]*Field, error) {
+	_, fields, err := t.decode(data)
+	return fields, err
+}
+
+// decodeFields decodes the fields of a struct at offset, up to end.
+func (d *templateDecoder) decodeFields(end int, offset int, templateFields []*TemplateField, scope *templateScope, parent *Field, depth int) ([]*Field, int, error) {
+	begin := offset
+
+	var fields []*Field
+	for _, templateField := range templateFields {
+		field, next, err := d.decodeField(end, offset, templateField, scope, parent, depth)
+		if field != nil {
+			fields = append(fields, field)
+		}
+		if err != nil {
+			return fields, offset, fmt.Errorf("%s: %w", templateField.Name, err)
+		}
+
+		offset = next
+	}
+
+	for _, field := range fields {
+		value := d.values[field]
+		if value.template.Checksum != "" {
+			if err := d.checkChecksum(field, value.template, fields, begin); err != nil {
+				return fields, offset, fmt.Errorf("%s: %w", field.Name, err)
+			}
+		}
+	}
+
+	return fields, offset, nil
+}
+
+// checkChecksum records the checksum field and marks it if it's invalid. The
+// checksum covers the fields of checksum-of, or the fields before it in its
+// struct, which begins at begin.
+func (d *templateDecoder) checkChecksum(field *Field, templateField *TemplateField, fields []*Field, begin int) error {
+	end := field.Offset
+	if templateField.ChecksumOf != "" {
+		begin, end = -1, -1
+		for _, name := range strings.Split(templateField.ChecksumOf, ",") {
+			name = strings.TrimSpace(name)
+			index := slices.IndexFunc(fields, func(field *Field) bool { return field.Name == name })
+			if index == -1 {
+				return fmt.Errorf("the checksummed field %q is missing", name)
+			}
+
+			covered := fields[index]
+			if begin == -1 || covered.Offset < begin {
+				begin = covered.Offset
+			}
+			end = max(end, covered.Offset+covered.Length)
+		}
+	}
+
+	d.checksums = append(d.checksums, templateChecksum{field, templateField, begin, end})
+
+	expected := templateChecksumAlgorithms[templateField.Checksum](d.data[begin:end]) & templateIntegerMask(templateField)
+	if uint64(d.values[field].value)&templateIntegerMask(templateField) != expected {
+		field.Value += fmt.Sprintf(" (invalid checksum, expected %d)", expected)
+	}
+
+	return nil
+}
+
+// decodeField decodes the field at offset, returning nil if its condition
+// doesn't hold.
+func (d *templateDecoder) decodeField(end int, offset int, templateField *TemplateField, scope *templateScope, parent *Field, depth int) (*Field, int, error) {
+	if templateField.condition != nil {
+		condition, err := templateField.condition(scope)
+		if err != nil {
+			return nil, offset, err
+		}
+		if condition == 0 {
+			return nil, offset, nil
+		}
+	}
+
+	if templateField.Count == "" {
+		return d.decodeValue(end, offset, templateField, templateField.Name, scope, parent, depth)
+	}
+
+	count := int64(-1)
+	if templateField.count != nil {
+		var err error
+		if count, err = templateField.count(scope); err != nil {
+			return nil, offset, err
+		}
+		if count < 0 {
+			return nil, offset, fmt.Errorf("invalid count %d", count)
+		}
+	}
+
+	array := &Field{Name: templateField.Name, Offset: offset}
+	d.values[array] = &templateValue{template: templateField, array: true, parent: parent}
+	for i := int64(0); i != count && (count != -1 || offset < end); i++ {
+		element, next, err := d.decodeValue(end, offset, templateField, fmt.Sprintf("[%d]", i), scope, array, depth)
+		if element != nil {
+			array.Children = append(array.Children, element)
+		}
+		if err != nil {
+			array.Length = offset - array.Offset
+			if element != nil {
+				array.Length += element.Length
+			}
+			return array, offset, fmt.Errorf("[%d]: %w", i, err)
+		}
+
+		if next == offset {
+			return array, offset, fmt.Errorf("[%d] is empty, so it can't be repeated", i)
+		}
+		offset = next
+	}
+
+	array.Value = fmt.Sprintf("%d elements", len(array.Children))
+	array.Length = offset - array.Offset
+	return array, offset, nil
+}
+
+// decodeValue decodes a single value of the field at offset.
+func (d *templateDecoder) decodeValue(end int, offset int, templateField *TemplateField, name string, scope *templateScope, parent *Field, depth int) (*Field, int, error) {
+	field := &Field{Name: name, Offset: offset}
+	value := &templateValue{template: templateField, parent: parent}
+	data := d.data[:end]
+
+	length := -1
+	if templateField.length != nil {
+		lengthValue, err := templateField.length(scope)
+		if err != nil {
+			return nil, offset, err
+		}
+		if lengthValue < 0 || lengthValue > int64(len(data)-offset) {
+			return nil, offset, fmt.Errorf("length %d exceeds the %d remaining bytes at offset %d", lengthValue, len(data)-offset, offset)
+		}
+		length = int(lengthValue)
+
+		if templateField.lengthPath != "" {
+			value.lengthField, _ = scope.lookupField(templateField.lengthPath)
+		}
+	}
+
+	if integer, ok := templateIntegers[templateField.Type]; ok {
+		if integer.size > len(data)-offset {
+			return nil, offset, fmt.Errorf("unexpected end of data at offset %d", offset)
+		}
+
+		integerValue := readTemplateInteger(data[offset:], integer, d.template.order)
+		text := strconv.FormatUint(integerValue, 10)
+		if integer.signed {
+			text = strconv.FormatInt(int64(integerValue), 10)
+		}
+
+		return d.integerField(field, value, int64(integerValue), text, scope, integer.size)
+	}
+
+	d.values[field] = value
+
+	switch templateField.Type {
+	case TEMPLATE_TYPE_VARINT:
+		integerValue, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, offset, fmt.Errorf("invalid varint at offset %d", offset)
+		}
+		return d.integerField(field, value, int64(integerValue), strconv.FormatUint(integerValue, 10), scope, n)
+	case TEMPLATE_TYPE_STRING:
+		end := offset + length
+		if length == -1 {
+			terminator := bytes.IndexByte(data[offset:], 0)
+			if terminator == -1 {
+				return nil, offset, fmt.Errorf("unterminated string at offset %d", offset)
+			}
+			end = offset + terminator + 1
+			field.Value = strconv.Quote(string(data[offset : end-1]))
+		} else {
+			field.Value = strconv.Quote(string(data[offset:end]))
+		}
+
+		field.Length = end - offset
+		return field, end, nil
+	case TEMPLATE_TYPE_BYTES:
+		end := len(data)
+		if length != -1 {
+			end = offset + length
+		}
+
+		field.Value = fmt.Sprintf("%x", data[offset:end])
+		field.Length = end - offset
+		return field, end, nil
+	}
+
+	// TEMPLATE_TYPE_STRUCT
+	if depth >= TEMPLATE_MAX_DEPTH {
+		return nil, offset, errors.New("too deeply nested")
+	}
+
+	structEnd := len(data)
+	if length != -1 {
+		structEnd = offset + length
+	}
+
+	structScope := newTemplateScope(scope, d.template)
+	scope.scopes[templateField.Name] = structScope
+
+	children, childrenEnd, err := d.decodeFields(structEnd, offset, templateField.Fields, structScope, field, depth+1)
+	field.Children = children
+	field.Length = childrenEnd - offset
+	if err != nil {
+		return field, childrenEnd, err
+	}
+
+	if childrenEnd != structEnd && length != -1 {
+		return field, childrenEnd, fmt.Errorf("%d unused bytes of the struct at offset %d", structEnd-childrenEnd, childrenEnd)
+	}
+	return field, childrenEnd, nil
+}
+
+// integerField sets field to the integer value, naming it by the field's
+// enum, and records it in scope.
+func (d *templateDecoder) integerField(field *Field, value *templateValue, integerValue int64, text string, scope *templateScope, size int) (*Field, int, error) {
+	if name, ok := d.template.Enums[value.template.Enum][integerValue]; ok {
+		text = name + " (" + text + ")"
+	}
+
+	value.value = integerValue
+	d.values[field] = value
+
+	scope.values[value.template.Name] = integerValue
+	scope.fields[value.template.Name] = field
+	field.Value = text
+	field.Length = size
+	return field, field.Offset + size, nil
+}
+
+// readTemplateInteger reads the integer at the beginning of data, sign
+// extending signed integers.
+func readTemplateInteger(data []byte, integer templateInteger, defaultOrder binary.ByteOrder) uint64 {
+	order := integer.order
+	if order == nil {
+		order = defaultOrder
+	}
+
+	var value uint64
+	switch integer.size {
+	case 1:
+		value = uint64(data[0])
+	case 2:
+		value = uint64(order.Uint16(data))
+	case 4:
+		value = uint64(order.Uint32(data))
+	case 8:
+		value = order.Uint64(data)
+	}
+
+	if integer.signed {
+		shift := 64 - 8*integer.size
+		value = uint64(int64(value<<shift) >> shift)
+	}
+
+	return value
+}
@@ -0,0 +1,120 @@
+package dissector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTemplate = `
+name: chat
+endian: little
+enums:
+  opcode: {1: LOGIN, 2: MESSAGE}
+fields:
+  - {name: magic, type: u16be}
+  - {name: opcode, type: u8, enum: opcode}
+  - name: login
+    if: opcode == LOGIN
+    fields:
+      - {name: user, type: string}
+  - name: message
+    if: opcode == MESSAGE
+    fields:
+      - {name: count, type: varint}
+      - name: lines
+        count: count
+        fields:
+          - {name: length, type: u16}
+          - {name: text, type: string, length: length - 1}
+          - {name: flags, type: u8}
+  - {name: trailer, type: bytes}
+`
+
+func TestTemplateDecode(t *testing.T) {
+	template, err := ParseTemplate([]byte(testTemplate))
+	require.NoError(t, err)
+
+	fields, err := template.Decode([]byte("\xca\xfe\x02\x02\x03\x00hi\x01\x01\x00\x00\xff"))
+	require.NoError(t, err)
+	require.Len(t, fields, 4)
+	assert.Equal(t, "51966", fields[0].Value)
+	assert.Equal(t, "MESSAGE (2)", fields[1].Value)
+
+	lines := fields[2].Children[1]
+	assert.Equal(t, "2 elements", lines.Value)
+	require.Len(t, lines.Children, 2)
+	assert.Equal(t, `"hi"`, lines.Children[0].Children[1].Value)
+	assert.Equal(t, 6, lines.Children[0].Children[1].Offset)
+	assert.Equal(t, 2, lines.Children[0].Children[1].Length)
+	assert.Equal(t, `""`, lines.Children[1].Children[1].Value)
+	assert.Equal(t, "ff", fields[3].Value)
+
+	fields, err = template.Decode([]byte("\xca\xfe\x01bob\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"magic", "opcode", "login", "trailer"}, []string{fields[0].Name, fields[1].Name, fields[2].Name, fields[3].Name})
+
+	// Truncated in the text of the first line
+	fields, err = template.Decode([]byte("\xca\xfe\x02\x01\x09\x00hi"))
+	assert.ErrorContains(t, err, "message: lines: [0]: text: length 8 exceeds the 2 remaining bytes")
+	assert.Len(t, fields, 3)
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, template := range []string{
+		"fields: [{name: a, type: u24}]",
+		"fields: [{name: a, type: u8, length: 2}]",
+		"fields: [{name: a, type: u8, enum: missing}]",
+		"fields: [{name: a, type: bytes, length: (a + }]",
+		"endian: middle\nfields: [{name: a, type: u8}]",
+	} {
+		_, err := ParseTemplate([]byte(template))
+		assert.Error(t, err, template)
+	}
+}
+
+func TestTemplateSetField(t *testing.T) {
+	template, err := ParseTemplate([]byte(`
+enums:
+  kind: {1: TEXT, 2: BINARY}
+fields:
+  - {name: length, type: u16}
+  - name: body
+    length: length - 1
+    fields:
+      - {name: kind, type: u8, enum: kind}
+      - {name: size, type: u8}
+      - {name: text, type: string, length: size}
+  - {name: checksum, type: u8, checksum: sum8, checksum-of: body}
+`))
+	require.NoError(t, err)
+
+	data := []byte("\x00\x05\x01\x02hi\xd4")
+	fields, err := template.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, "212", fields[2].Value)
+
+	text, err := template.FieldText(data, 4)
+	require.NoError(t, err)
+	assert.Equal(t, `"hi"`, text)
+
+	// The lengths of the text and of the body, and the checksum, are updated
+	edited, err := template.SetField(data, 4, `"hello"`)
+	require.NoError(t, err)
+	assert.Equal(t, "\x00\x08\x01\x05hello\x1a", string(edited))
+
+	edited, err = template.SetField(edited, 2, "BINARY")
+	require.NoError(t, err)
+	assert.Equal(t, byte(2), edited[2])
+
+	_, err = template.SetField(data, 2, "300")
+	assert.Error(t, err)
+	_, err = template.SetField(data, 1, "00")
+	assert.ErrorContains(t, err, "edit its fields instead")
+
+	// An invalid checksum is shown
+	fields, err = template.Decode([]byte("\x00\x05\x01\x02hi\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, "0 (invalid checksum, expected 212)", fields[2].Value)
+}
@@ -0,0 +1,232 @@
+package dissector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// flattenFields returns the fields and their children, each field before its
+// children.
+func flattenFields(flattened []*Field, fields []*Field) []*Field {
+	for _, field := range fields {
+		flattened = append(flattened, field)
+		flattened = flattenFields(flattened, field.Children)
+	}
+
+	return flattened
+}
+
+// editedField decodes data and returns the decoder along with the field at
+// index, in the order of flattenFields.
+func (t *Template) editedField(data []byte, index int) (*templateDecoder, *Field, error) {
+	decoder, fields, err := t.decode(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("only messages decoded without errors can be edited: %w", err)
+	}
+
+	flattened := flattenFields(nil, fields)
+	if index < 0 || index >= len(flattened) {
+		return nil, nil, fmt.Errorf("there's no field %d", index)
+	}
+
+	field := flattened[index]
+	if value := decoder.values[field]; value.array || value.template.Type == TEMPLATE_TYPE_STRUCT {
+		return nil, nil, fmt.Errorf("%s can't be edited, edit its fields instead", field.Name)
+	}
+
+	return decoder, field, nil
+}
+
+// FieldText returns the value of the field at index (in the order of the
+// decoded fields, each field before its children) of data, as it's edited:
+// integers in decimal or by their enum names, strings quoted and bytes in hex.
+func (t *Template) FieldText(data []byte, index int) (string, error) {
+	decoder, field, err := t.editedField(data, index)
+	if err != nil {
+		return "", err
+	}
+
+	value := decoder.values[field]
+	content := data[field.Offset : field.Offset+field.Length]
+	switch value.template.Type {
+	case TEMPLATE_TYPE_STRING:
+		if value.template.Length == "" {
+			content = content[:len(content)-1] // The null terminator
+		}
+		return strconv.Quote(string(content)), nil
+	case TEMPLATE_TYPE_BYTES:
+		return hex.EncodeToString(content), nil
+	}
+
+	if name, ok := t.Enums[value.template.Enum][value.value]; ok {
+		return name, nil
+	}
+
+	if integer, ok := templateIntegers[value.template.Type]; ok && !integer.signed {
+		return strconv.FormatUint(uint64(value.value)&templateIntegerMask(value.template), 10), nil
+	}
+	return strconv.FormatInt(value.value, 10), nil
+}
+
+// encodeTemplateValue encodes text, in the format of FieldText, as a value of
+// the field.
+func (t *Template) encodeTemplateValue(templateField *TemplateField, text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+
+	switch templateField.Type {
+	case TEMPLATE_TYPE_STRING:
+		if strings.HasPrefix(text, `"`) {
+			unquoted, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted string: %w", err)
+			}
+			text = unquoted
+		}
+
+		if templateField.Length != "" {
+			return []byte(text), nil
+		}
+		if strings.IndexByte(text, 0) != -1 {
+			return nil, errors.New("null terminated strings can't contain null bytes")
+		}
+		return append([]byte(text), 0), nil
+	case TEMPLATE_TYPE_BYTES:
+		return hex.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+
+	var value uint64
+	if enumValue, ok := enumValueByName(t.Enums[templateField.Enum], text); ok {
+		value = uint64(enumValue)
+	} else if signed, err := strconv.ParseInt(text, 0, 64); err == nil {
+		value = uint64(signed)
+	} else if unsigned, err := strconv.ParseUint(text, 0, 64); err == nil {
+		value = unsigned
+	} else {
+		return nil, fmt.Errorf("invalid integer %q", text)
+	}
+
+	if templateField.Type == TEMPLATE_TYPE_VARINT {
+		return binary.AppendUvarint(nil, value), nil
+	}
+
+	return encodeTemplateInteger(templateField, t.order, value)
+}
+
+func enumValueByName(enum map[int64]string, name string) (int64, bool) {
+	for value, valueName := range enum {
+		if valueName == name {
+			return value, true
+		}
+	}
+
+	return 0, false
+}
+
+// encodeTemplateInteger encodes the value as the integer field, failing if it
+// doesn't fit in it.
+func encodeTemplateInteger(templateField *TemplateField, defaultOrder binary.ByteOrder, value uint64) ([]byte, error) {
+	integer := templateIntegers[templateField.Type]
+
+	if bits := 8 * integer.size; bits < 64 {
+		signed := int64(value)
+		fits := value <= templateIntegerMask(templateField)
+		if integer.signed {
+			fits = signed >= -1<<(bits-1) && signed < 1<<(bits-1)
+		}
+		if !fits {
+			return nil, fmt.Errorf("%d doesn't fit in %s", signed, templateField.Type)
+		}
+	}
+
+	order := integer.order
+	if order == nil {
+		order = defaultOrder
+	}
+
+	encoded := make([]byte, integer.size)
+	switch integer.size {
+	case 1:
+		encoded[0] = byte(value)
+	case 2:
+		order.PutUint16(encoded, uint16(value))
+	case 4:
+		order.PutUint32(encoded, uint32(value))
+	case 8:
+		order.PutUint64(encoded, value)
+	}
+
+	return encoded, nil
+}
+
+// SetField sets the field at index (in the order of FieldText) of data to
+// text, in the format of FieldText, returning the re-encoded data. When the
+// length of the field changes, the fields holding its length and the lengths
+// of the structs it's in are updated, and checksums are recomputed (except
+// for an edited checksum).
+func (t *Template) SetField(data []byte, index int, text string) ([]byte, error) {
+	decoder, field, err := t.editedField(data, index)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := decoder.values[field]
+	encoded, err := t.encodeTemplateValue(edited.template, text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field.Name, err)
+	}
+
+	delta := len(encoded) - field.Length
+	result := bytes.Join([][]byte{data[:field.Offset], encoded, data[field.Offset+field.Length:]}, nil)
+
+	// The lengths of the field and of the structs it's in grow by delta
+	for current := field; current != nil && delta != 0; current = decoder.values[current].parent {
+		value := decoder.values[current]
+		if value.template.length == nil || value.array {
+			continue
+		}
+		if value.lengthField == nil {
+			return nil, fmt.Errorf("the length of %s (%s) can't be updated, as it's not a field plus a constant", current.Name, value.template.Length)
+		}
+
+		lengthField := value.lengthField
+		lengthValue := int64(current.Length+delta) - value.template.lengthConstant
+		lengthTemplate := decoder.values[lengthField].template
+		if lengthTemplate.Type == TEMPLATE_TYPE_VARINT {
+			return nil, fmt.Errorf("the length of %s is a varint, which can't be updated in place", current.Name)
+		}
+
+		encodedLength, err := encodeTemplateInteger(lengthTemplate, t.order, uint64(lengthValue))
+		if err != nil {
+			return nil, fmt.Errorf("the length of %s: %w", current.Name, err)
+		}
+		if lengthField.Offset > field.Offset {
+			return nil, fmt.Errorf("the length of %s follows it", current.Name)
+		}
+		copy(result[lengthField.Offset:], encodedLength)
+	}
+
+	reencoded, _, err := t.decode(result)
+	if err != nil {
+		return nil, fmt.Errorf("the edited message can't be decoded: %w", err)
+	}
+
+	for _, checksum := range reencoded.checksums {
+		if checksum.field.Offset == field.Offset && checksum.template == edited.template {
+			continue
+		}
+
+		value := templateChecksumAlgorithms[checksum.template.Checksum](result[checksum.begin:checksum.end])
+		encodedChecksum, err := encodeTemplateInteger(checksum.template, t.order, value&templateIntegerMask(checksum.template))
+		if err != nil {
+			return nil, err
+		}
+		copy(result[checksum.field.Offset:], encodedChecksum)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,363 @@
+package dissector
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// templateExpression is a compiled expression of a template, such as a length
+// or a condition, evaluated in the scope of the fields decoded before it.
+type templateExpression func(scope *templateScope) (int64, error)
+
+// templateScope holds the integer fields decoded so far in a struct, and the
+// scopes of the structs nested in it.
+type templateScope struct {
+	parent   *templateScope
+	template *Template
+	values   map[string]int64
+	fields   map[string]*Field
+	scopes   map[string]*templateScope
+}
+
+func newTemplateScope(parent *templateScope, template *Template) *templateScope {
+	return &templateScope{
+		parent:   parent,
+		template: template,
+		values:   make(map[string]int64),
+		fields:   make(map[string]*Field),
+		scopes:   make(map[string]*templateScope),
+	}
+}
+
+// find returns the scope holding the field at the dotted path, searching the
+// enclosing scopes for its first component, along with the field's name.
+func (s *templateScope) find(path string) (*templateScope, string, bool) {
+	names := strings.Split(path, ".")
+
+	for scope := s; scope != nil; scope = scope.parent {
+		current := scope
+		found := true
+		for _, name := range names[:len(names)-1] {
+			current, found = current.scopes[name]
+			if !found {
+				break
+			}
+		}
+
+		if _, ok := current.values[names[len(names)-1]]; found && ok {
+			return current, names[len(names)-1], true
+		}
+	}
+
+	return nil, "", false
+}
+
+// lookup returns the value of the field at the dotted path. If there's no
+// such field, the path is looked up as an enum value name.
+func (s *templateScope) lookup(path string) (int64, error) {
+	if scope, name, ok := s.find(path); ok {
+		return scope.values[name], nil
+	}
+
+	if value, ok := s.template.enumValues[path]; ok {
+		return value, nil
+	}
+
+	return 0, fmt.Errorf("unknown field %q", path)
+}
+
+// lookupField returns the decoded field at the dotted path.
+func (s *templateScope) lookupField(path string) (*Field, bool) {
+	scope, name, ok := s.find(path)
+	if !ok {
+		return nil, false
+	}
+
+	return scope.fields[name], true
+}
+
+// templateToken is a token of an expression, which is either a number, a
+// field path or an operator.
+type templateToken struct {
+	text   string
+	number bool
+	path   bool
+}
+
+var templateOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<<", ">>", "<", ">", "+", "-", "*", "/", "%", "&", "|", "^", "!", "(", ")"}
+
+func tokenizeTemplateExpression(text string) ([]templateToken, error) {
+	var tokens []templateToken
+
+	for i := 0; i < len(text); {
+		char := rune(text[i])
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case unicode.IsDigit(char):
+			end := i
+			for end < len(text) && (unicode.IsLetter(rune(text[end])) || unicode.IsDigit(rune(text[end]))) {
+				end++
+			}
+			tokens = append(tokens, templateToken{text: text[i:end], number: true})
+			i = end
+		case unicode.IsLetter(char) || char == '_':
+			end := i
+			for end < len(text) && (unicode.IsLetter(rune(text[end])) || unicode.IsDigit(rune(text[end])) || text[end] == '_' || text[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, templateToken{text: text[i:end], path: true})
+			i = end
+		default:
+			matched := false
+			for _, operator := range templateOperators {
+				if strings.HasPrefix(text[i:], operator) {
+					tokens = append(tokens, templateToken{text: operator})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+
+			if !matched {
+				return nil, fmt.Errorf("unexpected %q in %q", char, text)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// templateLinearReference returns the field path and constant of the
+// expression text if it's a field plus or minus a constant, such as
+// "length - 4", so the field can be set by the value of the expression.
+func templateLinearReference(text string) (string, int64, bool) {
+	tokens, err := tokenizeTemplateExpression(text)
+	if err != nil {
+		return "", 0, false
+	}
+
+	parseNumber := func(token templateToken) (int64, bool) {
+		value, err := strconv.ParseInt(token.text, 0, 64)
+		return value, token.number && err == nil
+	}
+
+	switch {
+	case len(tokens) == 1 && tokens[0].path:
+		return tokens[0].text, 0, true
+	case len(tokens) == 3 && tokens[0].path && (tokens[1].text == "+" || tokens[1].text == "-"):
+		constant, ok := parseNumber(tokens[2])
+		if tokens[1].text == "-" {
+			constant = -constant
+		}
+		return tokens[0].text, constant, ok
+	case len(tokens) == 3 && tokens[2].path && tokens[1].text == "+":
+		constant, ok := parseNumber(tokens[0])
+		return tokens[2].text, constant, ok
+	}
+
+	return "", 0, false
+}
+
+// templateBinaryOperators are the binary operators by their precedence, from
+// the lowest, as in Go.
+var templateBinaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-", "|", "^"},
+	{"*", "/", "%", "<<", ">>", "&"},
+}
+
+type templateParser struct {
+	tokens []templateToken
+	index  int
+}
+
+func (p *templateParser) peek() string {
+	if p.index >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.index].text
+}
+
+// compileTemplateExpression compiles the expression text, which is made of
+// integers, field paths (resolved by templateScope.lookup) and Go's
+// arithmetic, comparison and logical operators. Comparisons and logical
+// operators evaluate to 1 or 0.
+func compileTemplateExpression(text string) (templateExpression, error) {
+	tokens, err := tokenizeTemplateExpression(text)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &templateParser{tokens: tokens}
+	expression, err := parser.binary(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %q", err, text)
+	}
+	if parser.index != len(tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", parser.peek(), text)
+	}
+
+	return expression, nil
+}
+
+func (p *templateParser) binary(precedence int) (templateExpression, error) {
+	if precedence == len(templateBinaryOperators) {
+		return p.unary()
+	}
+
+	left, err := p.binary(precedence + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := p.peek()
+		if !slices.Contains(templateBinaryOperators[precedence], operator) {
+			return left, nil
+		}
+		p.index++
+
+		right, err := p.binary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = templateBinaryExpression(operator, left, right)
+	}
+}
+
+func (p *templateParser) unary() (templateExpression, error) {
+	operator := p.peek()
+	if operator == "-" || operator == "!" {
+		p.index++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(scope *templateScope) (int64, error) {
+			value, err := operand(scope)
+			if operator == "-" {
+				return -value, err
+			}
+			return boolToInt64(value == 0), err
+		}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *templateParser) primary() (templateExpression, error) {
+	if p.index >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	token := p.tokens[p.index]
+	p.index++
+
+	switch {
+	case token.number:
+		value, err := strconv.ParseInt(token.text, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token.text)
+		}
+		return func(*templateScope) (int64, error) { return value, nil }, nil
+	case token.path:
+		return func(scope *templateScope) (int64, error) { return scope.lookup(token.text) }, nil
+	case token.text == "(":
+		expression, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.index++
+		return expression, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+func templateBinaryExpression(operator string, left, right templateExpression) templateExpression {
+	return func(scope *templateScope) (int64, error) {
+		a, err := left(scope)
+		if err != nil {
+			return 0, err
+		}
+
+		// Logical operators short circuit
+		switch {
+		case operator == "&&" && a == 0:
+			return 0, nil
+		case operator == "||" && a != 0:
+			return 1, nil
+		}
+
+		b, err := right(scope)
+		if err != nil {
+			return 0, err
+		}
+
+		switch operator {
+		case "||", "&&":
+			return boolToInt64(b != 0), nil
+		case "==":
+			return boolToInt64(a == b), nil
+		case "!=":
+			return boolToInt64(a != b), nil
+		case "<":
+			return boolToInt64(a < b), nil
+		case "<=":
+			return boolToInt64(a <= b), nil
+		case ">":
+			return boolToInt64(a > b), nil
+		case ">=":
+			return boolToInt64(a >= b), nil
+		case "+":
+			return a + b, nil
+		case "-":
+			return a - b, nil
+		case "|":
+			return a | b, nil
+		case "^":
+			return a ^ b, nil
+		case "*":
+			return a * b, nil
+		case "&":
+			return a & b, nil
+		case "<<", ">>":
+			if b < 0 || b > 63 {
+				return 0, fmt.Errorf("invalid shift count %d", b)
+			}
+			if operator == "<<" {
+				return a << b, nil
+			}
+			return a >> b, nil
+		}
+
+		// Division and remainder
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if operator == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	}
+}
+
+func boolToInt64(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
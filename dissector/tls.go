@@ -0,0 +1,969 @@
+package dissector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// TLS_RECORD_HEADER_LENGTH is the length of the content type, version and
+// length which begin every TLS record.
+const TLS_RECORD_HEADER_LENGTH = 5
+
+// TLS_MAX_RECORD_LENGTH is the maximal length of an encrypted record's
+// fragment.
+const TLS_MAX_RECORD_LENGTH = 1<<14 + 2048
+
+// TLS_MAX_HANDSHAKE_MESSAGE_LENGTH is the maximal length of a handshake
+// message which is reassembled from the records it's fragmented into. Longer
+// messages are skipped.
+const TLS_MAX_HANDSHAKE_MESSAGE_LENGTH = 1 << 16
+
+const (
+	TLS_CONTENT_CHANGE_CIPHER_SPEC = 20
+	TLS_CONTENT_ALERT              = 21
+	TLS_CONTENT_HANDSHAKE          = 22
+	TLS_CONTENT_APPLICATION_DATA   = 23
+	TLS_CONTENT_HEARTBEAT          = 24
+)
+
+var tlsContentTypeNames = map[byte]string{
+	TLS_CONTENT_CHANGE_CIPHER_SPEC: "ChangeCipherSpec",
+	TLS_CONTENT_ALERT:              "Alert",
+	TLS_CONTENT_HANDSHAKE:          "Handshake",
+	TLS_CONTENT_APPLICATION_DATA:   "Application data",
+	TLS_CONTENT_HEARTBEAT:          "Heartbeat",
+}
+
+var tlsContentTypeEnum = fieldEnum(tlsContentTypeNames)
+
+const (
+	TLS_HANDSHAKE_CLIENT_HELLO        = 1
+	TLS_HANDSHAKE_SERVER_HELLO        = 2
+	TLS_HANDSHAKE_CERTIFICATE         = 11
+	TLS_HANDSHAKE_SERVER_KEY_EXCHANGE = 12
+)
+
+var tlsHandshakeTypeNames = map[byte]string{
+	0:                                 "HelloRequest",
+	TLS_HANDSHAKE_CLIENT_HELLO:        "ClientHello",
+	TLS_HANDSHAKE_SERVER_HELLO:        "ServerHello",
+	4:                                 "NewSessionTicket",
+	5:                                 "EndOfEarlyData",
+	8:                                 "EncryptedExtensions",
+	TLS_HANDSHAKE_CERTIFICATE:         "Certificate",
+	TLS_HANDSHAKE_SERVER_KEY_EXCHANGE: "ServerKeyExchange",
+	13:                                "CertificateRequest",
+	14:                                "ServerHelloDone",
+	15:                                "CertificateVerify",
+	16:                                "ClientKeyExchange",
+	20:                                "Finished",
+	24:                                "KeyUpdate",
+}
+
+func tlsHandshakeTypeName(handshakeType byte) string {
+	name, ok := tlsHandshakeTypeNames[handshakeType]
+	if !ok {
+		return fmt.Sprintf("Handshake(%d)", handshakeType)
+	}
+
+	return name
+}
+
+const (
+	TLS_EXTENSION_SERVER_NAME        = 0
+	TLS_EXTENSION_SUPPORTED_GROUPS   = 10
+	TLS_EXTENSION_SIGNATURE_ALGS     = 13
+	TLS_EXTENSION_ALPN               = 16
+	TLS_EXTENSION_SUPPORTED_VERSIONS = 43
+	TLS_EXTENSION_KEY_SHARE          = 51
+)
+
+var tlsExtensionNames = map[uint16]string{
+	TLS_EXTENSION_SERVER_NAME:        "server_name",
+	1:                                "max_fragment_length",
+	5:                                "status_request",
+	TLS_EXTENSION_SUPPORTED_GROUPS:   "supported_groups",
+	11:                               "ec_point_formats",
+	TLS_EXTENSION_SIGNATURE_ALGS:     "signature_algorithms",
+	14:                               "use_srtp",
+	15:                               "heartbeat",
+	TLS_EXTENSION_ALPN:               "application_layer_protocol_negotiation",
+	18:                               "signed_certificate_timestamp",
+	21:                               "padding",
+	22:                               "encrypt_then_mac",
+	23:                               "extended_master_secret",
+	27:                               "compress_certificate",
+	28:                               "record_size_limit",
+	35:                               "session_ticket",
+	41:                               "pre_shared_key",
+	42:                               "early_data",
+	TLS_EXTENSION_SUPPORTED_VERSIONS: "supported_versions",
+	44:                               "cookie",
+	45:                               "psk_key_exchange_modes",
+	47:                               "certificate_authorities",
+	49:                               "post_handshake_auth",
+	50:                               "signature_algorithms_cert",
+	TLS_EXTENSION_KEY_SHARE:          "key_share",
+	17513:                            "application_settings",
+	0xfe0d:                           "encrypted_client_hello",
+	0xff01:                           "renegotiation_info",
+}
+
+// isGREASE reports whether value is a GREASE value (RFC 8701), which clients
+// send to keep servers tolerant of unknown values.
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func tlsExtensionName(extensionType uint16) string {
+	if isGREASE(extensionType) {
+		return "GREASE"
+	}
+
+	name, ok := tlsExtensionNames[extensionType]
+	if !ok {
+		return fmt.Sprintf("extension(%d)", extensionType)
+	}
+
+	return name
+}
+
+func tlsVersionName(version uint16) string {
+	if isGREASE(version) {
+		return "GREASE"
+	}
+
+	return tls.VersionName(version)
+}
+
+func tlsCipherSuiteName(suite uint16) string {
+	if isGREASE(suite) {
+		return "GREASE"
+	}
+
+	return tls.CipherSuiteName(suite)
+}
+
+func tlsGroupName(group uint16) string {
+	if isGREASE(group) {
+		return "GREASE"
+	}
+
+	return tls.CurveID(group).String()
+}
+
+var tlsAlertNames = map[byte]string{
+	0:   "close_notify",
+	10:  "unexpected_message",
+	20:  "bad_record_mac",
+	22:  "record_overflow",
+	40:  "handshake_failure",
+	42:  "bad_certificate",
+	43:  "unsupported_certificate",
+	44:  "certificate_revoked",
+	45:  "certificate_expired",
+	46:  "certificate_unknown",
+	47:  "illegal_parameter",
+	48:  "unknown_ca",
+	49:  "access_denied",
+	50:  "decode_error",
+	51:  "decrypt_error",
+	70:  "protocol_version",
+	71:  "insufficient_security",
+	80:  "internal_error",
+	86:  "inappropriate_fallback",
+	90:  "user_canceled",
+	100: "no_renegotiation",
+	109: "missing_extension",
+	110: "unsupported_extension",
+	112: "unrecognized_name",
+	116: "certificate_required",
+	120: "no_application_protocol",
+}
+
+func tlsUint24(data []byte) int {
+	return int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+}
+
+// TLSDissector dissects the records of TLS connections, decoding their
+// plaintext handshake.
+type TLSDissector struct{}
+
+func (TLSDissector) Name() string {
+	return "tls"
+}
+
+func (TLSDissector) Detect(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	return len(data) >= TLS_RECORD_HEADER_LENGTH+4 && data[0] == TLS_CONTENT_HANDSHAKE && data[1] == 3 && data[2] <= 4 &&
+		(data[5] == TLS_HANDSHAKE_CLIENT_HELLO || data[5] == TLS_HANDSHAKE_SERVER_HELLO)
+}
+
+func (TLSDissector) DetectPrefix(direction tcpmessage.TransmittionDirection, data []byte) bool {
+	return len(data) < TLS_RECORD_HEADER_LENGTH+4 &&
+		(len(data) < 1 || data[0] == TLS_CONTENT_HANDSHAKE) &&
+		(len(data) < 2 || data[1] == 3) &&
+		(len(data) < 3 || data[2] <= 4) &&
+		(len(data) < 6 || data[5] == TLS_HANDSHAKE_CLIENT_HELLO || data[5] == TLS_HANDSHAKE_SERVER_HELLO)
+}
+
+func (TLSDissector) NewSession() Session {
+	return &tlsSession{
+		streams: map[tcpmessage.TransmittionDirection]*tlsStream{
+			tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER: {},
+			tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT: {},
+		},
+	}
+}
+
+// tlsStream is the state of the records sent in one direction.
+type tlsStream struct {
+	// encrypted is set once a ChangeCipherSpec was sent, after which
+	// handshake records are encrypted.
+	encrypted bool
+
+	// handshake holds the beginning of a handshake message which continues
+	// in the next handshake record. Its buffer is appended to in place and
+	// shared with the decoders, which only see its earlier bytes.
+	handshake []byte
+
+	// skipped is the length of the rest of a handshake message longer than
+	// TLS_MAX_HANDSHAKE_MESSAGE_LENGTH, which isn't reassembled.
+	skipped int
+}
+
+type tlsSession struct {
+	streams map[tcpmessage.TransmittionDirection]*tlsStream
+}
+
+func (s *tlsSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	if len(data) < TLS_RECORD_HEADER_LENGTH {
+		return 0, nil, nil
+	}
+
+	contentType := data[0]
+	length := int(binary.BigEndian.Uint16(data[3:]))
+	if data[1] != 3 || length > TLS_MAX_RECORD_LENGTH {
+		return 0, nil, fmt.Errorf("invalid TLS record header %x", data[:TLS_RECORD_HEADER_LENGTH])
+	}
+	if TLS_RECORD_HEADER_LENGTH+length > len(data) {
+		return 0, nil, nil
+	}
+
+	stream := s.streams[direction]
+	decoder := TLSDecoder{encrypted: stream.encrypted}
+	switch contentType {
+	case TLS_CONTENT_CHANGE_CIPHER_SPEC:
+		// TLS 1.3 sends it only for compatibility, its records are encrypted
+		// anyway
+		stream.encrypted = true
+	case TLS_CONTENT_HANDSHAKE:
+		if stream.encrypted {
+			break
+		}
+
+		fragment := data[TLS_RECORD_HEADER_LENGTH : TLS_RECORD_HEADER_LENGTH+length]
+		decoder.skipped = min(stream.skipped, len(fragment))
+		stream.skipped -= decoder.skipped
+
+		decoder.handshake = stream.handshake[:len(stream.handshake):len(stream.handshake)]
+		handshake := append(stream.handshake, fragment[decoder.skipped:]...)
+		for len(handshake) >= 4 {
+			messageLength := 4 + tlsUint24(handshake[1:])
+			if messageLength > 4+TLS_MAX_HANDSHAKE_MESSAGE_LENGTH {
+				stream.skipped = messageLength - min(messageLength, len(handshake))
+				handshake = handshake[min(messageLength, len(handshake)):]
+				continue
+			}
+			if messageLength > len(handshake) {
+				break
+			}
+
+			handshake = handshake[messageLength:]
+		}
+
+		stream.handshake = handshake
+		if len(handshake) == 0 {
+			// Releases the buffer of the reassembled messages
+			stream.handshake = nil
+		}
+	}
+
+	return TLS_RECORD_HEADER_LENGTH + length, decoder, nil
+}
+
+// TLSDecoder decodes TLS records.
+type TLSDecoder struct {
+	// encrypted is set if the record was sent after a ChangeCipherSpec.
+	encrypted bool
+
+	// handshake is the beginning of the first handshake message of the
+	// record, which began in the previous records.
+	handshake []byte
+
+	// skipped is the length of the end of a handshake message longer than
+	// TLS_MAX_HANDSHAKE_MESSAGE_LENGTH at the beginning of the record.
+	skipped int
+}
+
+func (TLSDecoder) Name() string {
+	return "tls"
+}
+
+// tlsHandshakeMessage is a handshake message in a record.
+type tlsHandshakeMessage struct {
+	handshakeType byte
+	body          []byte
+	// offset is the offset of the message in the handshake data of the
+	// record, negative if it began in a previous record.
+	offset int
+}
+
+// handshakeMessages returns the handshake messages of the record which end
+// in it, and the length of the beginning of a message which continues in the
+// next record. A message which began in the previous records is copied only
+// once it's complete.
+func (d TLSDecoder) handshakeMessages(fragment []byte) ([]tlsHandshakeMessage, int) {
+	var messages []tlsHandshakeMessage
+	offset := min(d.skipped, len(fragment))
+	if len(d.handshake) > 0 {
+		headerLength := min(len(d.handshake), 4)
+		header := append(d.handshake[:headerLength:headerLength], fragment[offset:offset+min(4-headerLength, len(fragment)-offset)]...)
+		if len(header) < 4 || 4+tlsUint24(header[1:]) > len(d.handshake)+len(fragment)-offset {
+			return nil, len(d.handshake) + len(fragment) - offset
+		}
+
+		end := offset + 4 + tlsUint24(header[1:]) - len(d.handshake)
+		message := append(d.handshake[:len(d.handshake):len(d.handshake)], fragment[offset:end]...)
+		messages = append(messages, tlsHandshakeMessage{
+			handshakeType: message[0],
+			body:          message[4:],
+			offset:        offset - len(d.handshake),
+		})
+		offset = end
+	}
+
+	for len(fragment)-offset >= 4 {
+		length := tlsUint24(fragment[offset+1:])
+		if 4+length > len(fragment)-offset {
+			break
+		}
+
+		messages = append(messages, tlsHandshakeMessage{
+			handshakeType: fragment[offset],
+			body:          fragment[offset+4 : offset+4+length],
+			offset:        offset,
+		})
+		offset += 4 + length
+	}
+
+	return messages, len(fragment) - offset
+}
+
+func (d TLSDecoder) Decode(content []byte) (*Dissection, error) {
+	r := newReader(content, binary.BigEndian)
+
+	begin := r.offset
+	contentType := r.byte()
+	typeName, ok := tlsContentTypeNames[contentType]
+	if !ok {
+		typeName = fmt.Sprintf("Content(%d)", contentType)
+	}
+	typeField := r.field("Content type", typeName, begin)
+	typeField.Type = r.integerType(1, false, tlsContentTypeEnum)
+	fields := []*Field{typeField}
+
+	begin = r.offset
+	fields = append(fields, r.field("Version", tlsVersionName(r.uint16()), begin))
+	fields = append(fields, r.uint16Field("Length"))
+	fragment := r.rest()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(fragment) != int(binary.BigEndian.Uint16(content[3:])) {
+		return nil, fmt.Errorf("the TLS record length doesn't match its %d bytes", len(fragment))
+	}
+
+	fragmentField := func(name string, value string) *Field {
+		return &Field{Name: name, Value: value, Offset: TLS_RECORD_HEADER_LENGTH, Length: len(fragment)}
+	}
+
+	summary := typeName
+	switch {
+	case contentType == TLS_CONTENT_APPLICATION_DATA || d.encrypted:
+		if contentType == TLS_CONTENT_HANDSHAKE {
+			summary = "Encrypted handshake message"
+		} else if contentType != TLS_CONTENT_APPLICATION_DATA {
+			summary = "Encrypted " + strings.ToLower(typeName)
+		}
+
+		summary += fmt.Sprintf(" (%d bytes)", len(fragment))
+		fields = append(fields, fragmentField("Encrypted data", fmt.Sprintf("<%d bytes>", len(fragment))))
+	case contentType == TLS_CONTENT_CHANGE_CIPHER_SPEC:
+		fields = append(fields, fragmentField("Message", fmt.Sprintf("%x", fragment)))
+	case contentType == TLS_CONTENT_ALERT:
+		if len(fragment) != 2 {
+			return nil, fmt.Errorf("invalid alert length %d", len(fragment))
+		}
+
+		level := map[byte]string{1: "warning", 2: "fatal"}[fragment[0]]
+		description, ok := tlsAlertNames[fragment[1]]
+		if !ok {
+			description = fmt.Sprintf("alert(%d)", fragment[1])
+		}
+
+		summary = fmt.Sprintf("Alert %s %s", level, description)
+		fields = append(fields,
+			&Field{Name: "Level", Value: level, Offset: TLS_RECORD_HEADER_LENGTH, Length: 1},
+			&Field{Name: "Description", Value: description, Offset: TLS_RECORD_HEADER_LENGTH + 1, Length: 1})
+	case contentType == TLS_CONTENT_HANDSHAKE:
+		messages, incomplete := d.handshakeMessages(fragment)
+
+		var names []string
+		if skipped := min(d.skipped, len(fragment)); skipped > 0 {
+			fields = append(fields, &Field{
+				Name:   "Handshake fragment",
+				Value:  fmt.Sprintf("<%d bytes of a message longer than %d bytes>", skipped, TLS_MAX_HANDSHAKE_MESSAGE_LENGTH),
+				Offset: TLS_RECORD_HEADER_LENGTH,
+				Length: skipped,
+			})
+			names = append(names, "fragment")
+		}
+		for _, message := range messages {
+			field, err := decodeTLSHandshakeMessage(message)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", tlsHandshakeTypeName(message.handshakeType), err)
+			}
+
+			fields = append(fields, field)
+			names = append(names, tlsHandshakeSummary(message))
+		}
+
+		if incomplete > 0 {
+			fields = append(fields, &Field{
+				Name:   "Handshake fragment",
+				Value:  fmt.Sprintf("<%d bytes, continued in the next record>", incomplete),
+				Offset: TLS_RECORD_HEADER_LENGTH + max(len(fragment)-incomplete, 0),
+				Length: min(incomplete, len(fragment)),
+			})
+			names = append(names, "fragment")
+		}
+
+		summary = "Handshake: " + strings.Join(names, ", ")
+	default:
+		fields = append(fields, fragmentField("Data", fmt.Sprintf("%x", fragment)))
+	}
+
+	return &Dissection{Summary: summary, Fields: fields}, nil
+}
+
+// tlsHandshakeSummary summarizes a handshake message, for example by the
+// server name of a ClientHello.
+func tlsHandshakeSummary(message tlsHandshakeMessage) string {
+	name := tlsHandshakeTypeName(message.handshakeType)
+	if message.handshakeType != TLS_HANDSHAKE_CLIENT_HELLO && message.handshakeType != TLS_HANDSHAKE_SERVER_HELLO {
+		return name
+	}
+
+	hello, err := parseTLSHello(message.body, message.handshakeType == TLS_HANDSHAKE_CLIENT_HELLO)
+	if err != nil {
+		return name
+	}
+
+	if hello.client {
+		if serverName := hello.serverName(); serverName != "" {
+			name += " " + serverName
+		}
+	} else {
+		name += " " + tlsVersionName(hello.negotiatedVersion()) + " " + tlsCipherSuiteName(hello.cipherSuites[0])
+	}
+
+	if protocols := hello.alpn(); len(protocols) > 0 {
+		name += " (" + strings.Join(protocols, ", ") + ")"
+	}
+
+	return name
+}
+
+// decodeTLSHandshakeMessage decodes a handshake message to a field, whose
+// offsets are in the record.
+func decodeTLSHandshakeMessage(message tlsHandshakeMessage) (*Field, error) {
+	var children []*Field
+	var err error
+	switch message.handshakeType {
+	case TLS_HANDSHAKE_CLIENT_HELLO, TLS_HANDSHAKE_SERVER_HELLO:
+		var hello *tlsHello
+		hello, err = parseTLSHello(message.body, message.handshakeType == TLS_HANDSHAKE_CLIENT_HELLO)
+		if err == nil {
+			children = hello.fields()
+		}
+	case TLS_HANDSHAKE_CERTIFICATE:
+		// The certificates of TLS 1.3 are encrypted
+		children, err = decodeTLSCertificates(message.body)
+	case TLS_HANDSHAKE_SERVER_KEY_EXCHANGE:
+		children = decodeTLSServerKeyExchange(message.body)
+	default:
+		if len(message.body) > 0 {
+			children = []*Field{{Name: "Body", Value: fmt.Sprintf("%x", message.body), Offset: 4, Length: len(message.body)}}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	field := &Field{
+		Name:     tlsHandshakeTypeName(message.handshakeType),
+		Value:    fmt.Sprintf("%d bytes", len(message.body)),
+		Length:   4 + len(message.body),
+		Children: children,
+	}
+
+	// Fields of the parts of the message from previous records are clamped
+	// to the beginning of the record
+	offsetFields([]*Field{field}, TLS_RECORD_HEADER_LENGTH+message.offset)
+	clampFields([]*Field{field}, TLS_RECORD_HEADER_LENGTH)
+	return field, nil
+}
+
+// clampFields clamps the fields to begin at or after begin.
+func clampFields(fields []*Field, begin int) {
+	for _, field := range fields {
+		if field.Offset < begin {
+			field.Length = max(field.Offset+field.Length-begin, 0)
+			field.Offset = begin
+		}
+
+		clampFields(field.Children, begin)
+	}
+}
+
+type tlsExtension struct {
+	extensionType uint16
+	data          []byte
+	// offset is the offset of the data in the message body.
+	offset int
+}
+
+type tlsHello struct {
+	client             bool
+	version            uint16
+	random             []byte
+	sessionID          []byte
+	cipherSuites       []uint16
+	compressionMethods []byte
+	extensions         []tlsExtension
+
+	// fieldRanges are the offsets and lengths of the parts of the message
+	// body, by their names.
+	fieldRanges map[string][2]int
+}
+
+// parseTLSHello parses the body of a ClientHello or a ServerHello.
+func parseTLSHello(body []byte, client bool) (*tlsHello, error) {
+	r := newReader(body, binary.BigEndian)
+	hello := &tlsHello{client: client, fieldRanges: make(map[string][2]int)}
+
+	mark := func(name string, begin int) {
+		hello.fieldRanges[name] = [2]int{begin, r.offset - begin}
+	}
+
+	begin := r.offset
+	hello.version = r.uint16()
+	mark("version", begin)
+
+	begin = r.offset
+	hello.random = r.read(32)
+	mark("random", begin)
+
+	begin = r.offset
+	hello.sessionID = r.read(int(r.byte()))
+	mark("session_id", begin)
+
+	begin = r.offset
+	if client {
+		suites := newReader(r.read(int(r.uint16())), binary.BigEndian)
+		for suites.remaining() >= 2 {
+			hello.cipherSuites = append(hello.cipherSuites, suites.uint16())
+		}
+		mark("cipher_suites", begin)
+
+		begin = r.offset
+		hello.compressionMethods = r.read(int(r.byte()))
+	} else {
+		hello.cipherSuites = []uint16{r.uint16()}
+		mark("cipher_suites", begin)
+
+		begin = r.offset
+		hello.compressionMethods = r.read(1)
+	}
+	mark("compression_methods", begin)
+
+	if r.err == nil && r.remaining() > 0 {
+		extensions := newReader(r.read(int(r.uint16())), binary.BigEndian)
+		base := r.offset - len(extensions.data)
+		for extensions.err == nil && extensions.remaining() > 0 {
+			extensionType := extensions.uint16()
+			data := extensions.read(int(extensions.uint16()))
+			hello.extensions = append(hello.extensions, tlsExtension{extensionType, data, base + extensions.offset - len(data)})
+		}
+		if extensions.err != nil {
+			return nil, extensions.err
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.remaining() > 0 {
+		return nil, fmt.Errorf("%d unexpected bytes after the extensions", r.remaining())
+	}
+
+	return hello, nil
+}
+
+func (hello *tlsHello) extension(extensionType uint16) ([]byte, bool) {
+	for _, extension := range hello.extensions {
+		if extension.extensionType == extensionType {
+			return extension.data, true
+		}
+	}
+
+	return nil, false
+}
+
+func (hello *tlsHello) serverName() string {
+	data, ok := hello.extension(TLS_EXTENSION_SERVER_NAME)
+	if !ok {
+		return ""
+	}
+
+	r := newReader(data, binary.BigEndian)
+	names := newReader(r.read(int(r.uint16())), binary.BigEndian)
+	for names.err == nil && names.remaining() > 0 {
+		nameType := names.byte()
+		name := names.read(int(names.uint16()))
+		if nameType == 0 && names.err == nil {
+			return string(name)
+		}
+	}
+
+	return ""
+}
+
+func (hello *tlsHello) alpn() []string {
+	data, ok := hello.extension(TLS_EXTENSION_ALPN)
+	if !ok {
+		return nil
+	}
+
+	r := newReader(data, binary.BigEndian)
+	list := newReader(r.read(int(r.uint16())), binary.BigEndian)
+
+	var protocols []string
+	for list.err == nil && list.remaining() > 0 {
+		protocol := list.read(int(list.byte()))
+		if list.err == nil {
+			protocols = append(protocols, string(protocol))
+		}
+	}
+
+	return protocols
+}
+
+// uint16List returns the list of 16 bit values of an extension, whose length
+// is prefixed by a lengthSize bytes length.
+func (hello *tlsHello) uint16List(extensionType uint16, lengthSize int) []uint16 {
+	data, ok := hello.extension(extensionType)
+	if !ok || len(data) < lengthSize {
+		return nil
+	}
+
+	var values []uint16
+	for i := lengthSize; i+2 <= len(data); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(data[i:]))
+	}
+
+	return values
+}
+
+func (hello *tlsHello) supportedVersions() []uint16 {
+	if !hello.client {
+		return hello.uint16List(TLS_EXTENSION_SUPPORTED_VERSIONS, 0)
+	}
+
+	return hello.uint16List(TLS_EXTENSION_SUPPORTED_VERSIONS, 1)
+}
+
+// negotiatedVersion returns the version chosen by a ServerHello, which is in
+// supported_versions since TLS 1.3.
+func (hello *tlsHello) negotiatedVersion() uint16 {
+	if versions := hello.supportedVersions(); len(versions) == 1 {
+		return versions[0]
+	}
+
+	return hello.version
+}
+
+// keyShareGroups returns the groups of the key shares.
+func (hello *tlsHello) keyShareGroups() []uint16 {
+	data, ok := hello.extension(TLS_EXTENSION_KEY_SHARE)
+	if !ok {
+		return nil
+	}
+
+	r := newReader(data, binary.BigEndian)
+	if hello.client {
+		r = newReader(r.read(int(r.uint16())), binary.BigEndian)
+	}
+
+	var groups []uint16
+	for r.err == nil && r.remaining() > 0 {
+		group := r.uint16()
+		if !hello.client && r.remaining() == 0 { // A HelloRetryRequest, which has only the group
+			return []uint16{group}
+		}
+
+		r.read(int(r.uint16()))
+		if r.err == nil {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+func joinNames[T any](values []T, name func(T) string) string {
+	var names []string
+	for _, value := range values {
+		names = append(names, name(value))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// extensionValue returns the decoded value of an extension, or an empty
+// string if it's not decoded.
+func (hello *tlsHello) extensionValue(extension tlsExtension) string {
+	signatureSchemeName := func(scheme uint16) string {
+		if isGREASE(scheme) {
+			return "GREASE"
+		}
+		return tls.SignatureScheme(scheme).String()
+	}
+
+	switch extension.extensionType {
+	case TLS_EXTENSION_SERVER_NAME:
+		return hello.serverName()
+	case TLS_EXTENSION_ALPN:
+		return strings.Join(hello.alpn(), ", ")
+	case TLS_EXTENSION_SUPPORTED_VERSIONS:
+		return joinNames(hello.supportedVersions(), tlsVersionName)
+	case TLS_EXTENSION_SUPPORTED_GROUPS:
+		return joinNames(hello.uint16List(TLS_EXTENSION_SUPPORTED_GROUPS, 2), tlsGroupName)
+	case TLS_EXTENSION_SIGNATURE_ALGS, 50:
+		return joinNames(hello.uint16List(extension.extensionType, 2), signatureSchemeName)
+	case TLS_EXTENSION_KEY_SHARE:
+		return joinNames(hello.keyShareGroups(), tlsGroupName)
+	}
+
+	if len(extension.data) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", extension.data)
+}
+
+// fields returns the fields of the hello, whose offsets are in the message.
+func (hello *tlsHello) fields() []*Field {
+	// The body follows the type and length of the message
+	rangeField := func(name, key, value string) *Field {
+		fieldRange := hello.fieldRanges[key]
+		return &Field{Name: name, Value: value, Offset: 4 + fieldRange[0], Length: fieldRange[1]}
+	}
+
+	suites := rangeField("Cipher suites", "cipher_suites", fmt.Sprint(len(hello.cipherSuites)))
+	if !hello.client {
+		suites = rangeField("Cipher suite", "cipher_suites", tlsCipherSuiteName(hello.cipherSuites[0]))
+	} else {
+		for _, suite := range hello.cipherSuites {
+			suites.Children = append(suites.Children, &Field{Name: tlsCipherSuiteName(suite), Value: fmt.Sprintf("0x%04x", suite)})
+		}
+	}
+
+	fields := []*Field{
+		rangeField("Version", "version", tlsVersionName(hello.version)),
+		rangeField("Random", "random", fmt.Sprintf("%x", hello.random)),
+		rangeField("Session ID", "session_id", fmt.Sprintf("%x", hello.sessionID)),
+		suites,
+		rangeField("Compression methods", "compression_methods", fmt.Sprintf("%x", hello.compressionMethods)),
+	}
+
+	var extensions []*Field
+	for _, extension := range hello.extensions {
+		extensions = append(extensions, &Field{
+			Name:   tlsExtensionName(extension.extensionType),
+			Value:  hello.extensionValue(extension),
+			Offset: extension.offset, // The message header and the extension header are both 4 bytes
+			Length: 4 + len(extension.data),
+		})
+	}
+
+	return append(fields, groupField(fmt.Sprintf("Extensions (%d)", len(extensions)), extensions))
+}
+
+// decodeTLSCertificates decodes the certificate list of a TLS 1.2
+// Certificate message.
+func decodeTLSCertificates(body []byte) ([]*Field, error) {
+	r := newReader(body, binary.BigEndian)
+	listLength := r.read(3)
+	if r.err != nil || tlsUint24(listLength) != r.remaining() {
+		return nil, fmt.Errorf("invalid certificate list length")
+	}
+
+	var certificates []*Field
+	for i := 0; r.err == nil && r.remaining() > 0; i++ {
+		begin := r.offset
+		length := r.read(3)
+		if r.err != nil {
+			break
+		}
+
+		der := r.read(tlsUint24(length))
+		if r.err != nil {
+			break
+		}
+
+		field := r.field(fmt.Sprintf("[%d]", i), fmt.Sprintf("<%d bytes>", len(der)), begin)
+		if certificate, err := x509.ParseCertificate(der); err == nil {
+			field.Value = certificate.Subject.String()
+			field.Children = tlsCertificateFields(certificate)
+		}
+		certificates = append(certificates, field)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	offsetFields(certificates, 4)
+	return []*Field{groupField(fmt.Sprintf("Certificates (%d)", len(certificates)), certificates)}, nil
+}
+
+func tlsCertificateFields(certificate *x509.Certificate) []*Field {
+	fields := []*Field{
+		{Name: "Subject", Value: certificate.Subject.String()},
+		{Name: "Issuer", Value: certificate.Issuer.String()},
+		{Name: "Serial number", Value: fmt.Sprintf("%x", certificate.SerialNumber)},
+		{Name: "Not before", Value: certificate.NotBefore.UTC().Format(time.RFC3339)},
+		{Name: "Not after", Value: certificate.NotAfter.UTC().Format(time.RFC3339)},
+		{Name: "Signature algorithm", Value: certificate.SignatureAlgorithm.String()},
+		{Name: "Public key algorithm", Value: certificate.PublicKeyAlgorithm.String()},
+	}
+
+	var names []string
+	names = append(names, certificate.DNSNames...)
+	for _, address := range certificate.IPAddresses {
+		names = append(names, address.String())
+	}
+	if len(names) > 0 {
+		fields = append(fields, &Field{Name: "Subject alternative names", Value: strings.Join(names, ", ")})
+	}
+
+	return fields
+}
+
+// decodeTLSServerKeyExchange decodes the curve of an ECDHE ServerKeyExchange,
+// and shows other key exchanges as is.
+func decodeTLSServerKeyExchange(body []byte) []*Field {
+	// A named curve
+	if len(body) >= 4 && body[0] == 3 && 4+int(body[3]) <= len(body) {
+		return []*Field{
+			{Name: "Named curve", Value: tlsGroupName(binary.BigEndian.Uint16(body[1:])), Offset: 4, Length: 3},
+			{Name: "Public key", Value: fmt.Sprintf("%x", body[4:4+body[3]]), Offset: 7, Length: 1 + int(body[3])},
+			{Name: "Signature", Value: fmt.Sprintf("<%d bytes>", len(body)-4-int(body[3])), Offset: 8 + int(body[3]), Length: len(body) - 4 - int(body[3])},
+		}
+	}
+
+	return []*Field{{Name: "Body", Value: fmt.Sprintf("%x", body), Offset: 4, Length: len(body)}}
+}
+
+// Handshake returns the negotiated parameters of the handshake messages of a
+// record, such as the server name, versions and certificates, without the
+// encoding details shown by Decode.
+func (d TLSDecoder) Handshake(content []byte) ([]*Field, error) {
+	if len(content) < TLS_RECORD_HEADER_LENGTH || content[0] != TLS_CONTENT_HANDSHAKE || d.encrypted {
+		return nil, fmt.Errorf("the record isn't a plaintext handshake record")
+	}
+
+	messages, _ := d.handshakeMessages(content[TLS_RECORD_HEADER_LENGTH:])
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("the record has no complete handshake message")
+	}
+
+	var fields []*Field
+	for _, message := range messages {
+		field := &Field{Name: tlsHandshakeTypeName(message.handshakeType)}
+		switch message.handshakeType {
+		case TLS_HANDSHAKE_CLIENT_HELLO, TLS_HANDSHAKE_SERVER_HELLO:
+			hello, err := parseTLSHello(message.body, message.handshakeType == TLS_HANDSHAKE_CLIENT_HELLO)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", field.Name, err)
+			}
+
+			field.Children = hello.negotiationFields()
+		case TLS_HANDSHAKE_CERTIFICATE:
+			certificates, err := decodeTLSCertificates(message.body)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", field.Name, err)
+			}
+
+			field.Children = certificates[0].Children
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// negotiationFields returns the parameters which the hello offers or
+// chooses.
+func (hello *tlsHello) negotiationFields() []*Field {
+	var fields []*Field
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, &Field{Name: name, Value: value})
+		}
+	}
+
+	if hello.client {
+		add("Version", tlsVersionName(hello.version))
+		add("Supported versions", joinNames(hello.supportedVersions(), tlsVersionName))
+		add("Server name", hello.serverName())
+		add("ALPN", strings.Join(hello.alpn(), ", "))
+
+		suites := &Field{Name: "Cipher suites", Value: fmt.Sprint(len(hello.cipherSuites))}
+		for _, suite := range hello.cipherSuites {
+			suites.Children = append(suites.Children, &Field{Name: tlsCipherSuiteName(suite)})
+		}
+		fields = append(fields, suites)
+
+		add("Supported groups", joinNames(hello.uint16List(TLS_EXTENSION_SUPPORTED_GROUPS, 2), tlsGroupName))
+		add("Key shares", joinNames(hello.keyShareGroups(), tlsGroupName))
+	} else {
+		add("Version", tlsVersionName(hello.negotiatedVersion()))
+		add("Cipher suite", tlsCipherSuiteName(hello.cipherSuites[0]))
+		add("ALPN", strings.Join(hello.alpn(), ", "))
+		add("Key share", joinNames(hello.keyShareGroups(), tlsGroupName))
+	}
+
+	add("Extensions", joinNames(hello.extensions, func(extension tlsExtension) string {
+		return tlsExtensionName(extension.extensionType)
+	}))
+
+	return fields
+}
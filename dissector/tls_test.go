@@ -0,0 +1,174 @@
+package dissector
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConn records the data written to it.
+type recordingConn struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	written []byte
+}
+
+func (c *recordingConn) Write(data []byte) (int, error) {
+	c.mutex.Lock()
+	c.written = append(c.written, data...)
+	c.mutex.Unlock()
+
+	return c.Conn.Write(data)
+}
+
+// tlsHandshake returns the data sent by a TLS 1.2 client and server during a
+// handshake.
+func tlsHandshake(t *testing.T) (client, server string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	clientConn, serverConn := net.Pipe()
+	clientRecorder := &recordingConn{Conn: clientConn}
+	serverRecorder := &recordingConn{Conn: serverConn}
+
+	tlsServer := tls.Server(serverRecorder, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certificate}, PrivateKey: key}},
+		NextProtos:   []string{"h2"},
+		MaxVersion:   tls.VersionTLS12,
+	})
+	tlsClient := tls.Client(clientRecorder, &tls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+	})
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		assert.NoError(t, tlsServer.Handshake())
+	}()
+	require.NoError(t, tlsClient.Handshake())
+	wait.Wait()
+
+	clientConn.Close()
+	serverConn.Close()
+	return string(clientRecorder.written), string(serverRecorder.written)
+}
+
+// decodeTLSRecords frames data and decodes its records, returning their
+// summaries, decoders and contents.
+func decodeTLSRecords(t *testing.T, direction tcpmessage.TransmittionDirection, data string) ([]string, []Decoder, []string) {
+	frames, rest := frameAll(t, TLSDissector{}.NewSession(), direction, data)
+	require.Empty(t, rest)
+
+	// A new session, as the decoders depend on the records before them
+	session := TLSDissector{}.NewSession()
+
+	var summaries []string
+	var decoders []Decoder
+
+	for _, frame := range frames {
+		_, decoder, err := session.Frame(direction, []byte(frame))
+		require.NoError(t, err)
+		dissection, err := decoder.Decode([]byte(frame))
+		require.NoError(t, err)
+
+		summaries = append(summaries, dissection.Summary)
+		decoders = append(decoders, decoder)
+	}
+
+	return summaries, decoders, frames
+}
+
+func TestTLSHandshake(t *testing.T) {
+	client, server := tlsHandshake(t)
+	require.True(t, TLSDissector{}.Detect(toServer, []byte(client)))
+
+	summaries, _, _ := decodeTLSRecords(t, toServer, client)
+	assert.Equal(t, "Handshake: ClientHello example.com (h2, http/1.1)", summaries[0])
+	assert.Equal(t, "ChangeCipherSpec", summaries[len(summaries)-2])
+	assert.True(t, strings.HasPrefix(summaries[len(summaries)-1], "Encrypted handshake message"))
+
+	summaries, decoders, frames := decodeTLSRecords(t, toClient, server)
+	require.GreaterOrEqual(t, len(summaries), 4)
+	assert.Regexp(t, `^Handshake: ServerHello TLS 1\.2 TLS_ECDHE_ECDSA_WITH_\S+ \(h2\)$`, summaries[0])
+
+	certificateIndex := -1
+	for i, summary := range summaries {
+		if strings.Contains(summary, "Certificate") {
+			certificateIndex = i
+		}
+	}
+	require.NotEqual(t, -1, certificateIndex)
+
+	handshake, err := decoders[certificateIndex].(TLSDecoder).Handshake([]byte(frames[certificateIndex]))
+	require.NoError(t, err)
+	require.Equal(t, "Certificate", handshake[0].Name)
+	assert.Equal(t, "CN=example.com", handshake[0].Children[0].Value)
+}
+
+func TestTLSFragmentedHandshake(t *testing.T) {
+	client, _ := tlsHandshake(t)
+
+	// Splits the ClientHello into two records
+	record := client[:TLS_RECORD_HEADER_LENGTH+tlsUint24([]byte{0, client[3], client[4]})]
+	fragment := record[TLS_RECORD_HEADER_LENGTH:]
+	split := func(data string) string {
+		return record[:3] + string([]byte{byte(len(data) >> 8), byte(len(data))}) + data
+	}
+
+	summaries, decoders, frames := decodeTLSRecords(t, toServer, split(fragment[:10])+split(fragment[10:]))
+	assert.Equal(t, []string{"Handshake: fragment", "Handshake: ClientHello example.com (h2, http/1.1)"}, summaries)
+
+	handshake, err := decoders[1].(TLSDecoder).Handshake([]byte(frames[1]))
+	require.NoError(t, err)
+	assert.Equal(t, "ClientHello", handshake[0].Name)
+}
+
+func TestTLSOversizedHandshakeMessage(t *testing.T) {
+	handshakeRecord := func(data string) string {
+		return "\x16\x03\x03" + string([]byte{byte(len(data) >> 8), byte(len(data))}) + data
+	}
+
+	// A Certificate longer than TLS_MAX_HANDSHAKE_MESSAGE_LENGTH, followed by
+	// a ServerHelloDone
+	messageLength := TLS_MAX_HANDSHAKE_MESSAGE_LENGTH + 1000
+	handshake := "\x0b" + string([]byte{byte(messageLength >> 16), byte(messageLength >> 8), byte(messageLength)}) +
+		strings.Repeat("a", messageLength) + "\x0e\x00\x00\x00"
+
+	var records string
+	for len(handshake) > 0 {
+		length := min(len(handshake), 1<<14)
+		records += handshakeRecord(handshake[:length])
+		handshake = handshake[length:]
+	}
+
+	summaries, _, _ := decodeTLSRecords(t, toClient, records)
+	assert.Equal(t, "Handshake: fragment", summaries[0])
+	assert.Equal(t, "Handshake: fragment, ServerHelloDone", summaries[len(summaries)-1])
+}
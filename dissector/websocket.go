@@ -0,0 +1,488 @@
+package dissector
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+type WebSocketOpcode byte
+
+const (
+	WEBSOCKET_OPCODE_CONTINUATION WebSocketOpcode = 0x0
+	WEBSOCKET_OPCODE_TEXT         WebSocketOpcode = 0x1
+	WEBSOCKET_OPCODE_BINARY       WebSocketOpcode = 0x2
+	WEBSOCKET_OPCODE_CLOSE        WebSocketOpcode = 0x8
+	WEBSOCKET_OPCODE_PING         WebSocketOpcode = 0x9
+	WEBSOCKET_OPCODE_PONG         WebSocketOpcode = 0xa
+)
+
+func (opcode WebSocketOpcode) String() string {
+	switch opcode {
+	case WEBSOCKET_OPCODE_CONTINUATION:
+		return "continuation"
+	case WEBSOCKET_OPCODE_TEXT:
+		return "text"
+	case WEBSOCKET_OPCODE_BINARY:
+		return "binary"
+	case WEBSOCKET_OPCODE_CLOSE:
+		return "close"
+	case WEBSOCKET_OPCODE_PING:
+		return "ping"
+	case WEBSOCKET_OPCODE_PONG:
+		return "pong"
+	default:
+		return fmt.Sprintf("unknown (%#x)", byte(opcode))
+	}
+}
+
+// isControl checks if frames of the opcode are control frames, which may be
+// sent between the fragments of a message.
+func (opcode WebSocketOpcode) isControl() bool {
+	return opcode&0x8 != 0
+}
+
+// WEBSOCKET_WINDOW_SIZE is the maximal size of the LZ77 window of
+// permessage-deflate, which is kept between messages unless context takeover
+// is disabled.
+const WEBSOCKET_WINDOW_SIZE = 1 << 15
+
+// deflateTail terminates a permessage-deflate message, which has its sync
+// flush marker stripped, so it can be read to the end by flate.
+const deflateTail = "\x00\x00\xff\xff\x01\x00\x00\xff\xff"
+
+type webSocketFrame struct {
+	fin        bool
+	compressed bool // The RSV1 bit, set on the first frame of compressed messages
+	opcode     WebSocketOpcode
+	masked     bool
+	maskKey    [4]byte
+
+	headerLength  int
+	payloadLength int
+}
+
+// parseWebSocketFrame parses the header of the frame at the beginning of data.
+// It returns nil if the frame isn't complete.
+func parseWebSocketFrame(data []byte) (*webSocketFrame, error) {
+	if len(data) < 2 {
+		return nil, nil
+	}
+
+	frame := &webSocketFrame{
+		fin:          data[0]&0x80 != 0,
+		compressed:   data[0]&0x40 != 0,
+		opcode:       WebSocketOpcode(data[0] & 0x0f),
+		masked:       data[1]&0x80 != 0,
+		headerLength: 2,
+	}
+
+	var payloadLength uint64
+	switch length := data[1] & 0x7f; length {
+	case 126:
+		if len(data) < 4 {
+			return nil, nil
+		}
+		payloadLength = uint64(binary.BigEndian.Uint16(data[2:]))
+		frame.headerLength += 2
+	case 127:
+		if len(data) < 10 {
+			return nil, nil
+		}
+		payloadLength = binary.BigEndian.Uint64(data[2:])
+		frame.headerLength += 8
+	default:
+		payloadLength = uint64(length)
+	}
+
+	if payloadLength > 1<<62 {
+		return nil, fmt.Errorf("invalid WebSocket payload length %d", payloadLength)
+	}
+	frame.payloadLength = int(payloadLength)
+
+	if frame.masked {
+		if len(data) < frame.headerLength+len(frame.maskKey) {
+			return nil, nil
+		}
+		copy(frame.maskKey[:], data[frame.headerLength:])
+		frame.headerLength += len(frame.maskKey)
+	}
+
+	if frame.length() > len(data) {
+		return nil, nil
+	}
+
+	return frame, nil
+}
+
+func (frame *webSocketFrame) length() int {
+	return frame.headerLength + frame.payloadLength
+}
+
+// payload returns the unmasked payload of the frame, which begins data.
+func (frame *webSocketFrame) payload(data []byte) []byte {
+	payload := bytes.Clone(data[frame.headerLength:frame.length()])
+	if frame.masked {
+		maskWebSocketPayload(payload, frame.maskKey)
+	}
+
+	return payload
+}
+
+// encode encodes the frame with the given payload, updating its length and
+// masking the payload with the key of the frame.
+func (frame webSocketFrame) encode(payload []byte) []byte {
+	var header []byte
+
+	firstByte := byte(frame.opcode)
+	if frame.fin {
+		firstByte |= 0x80
+	}
+	if frame.compressed {
+		firstByte |= 0x40
+	}
+	header = append(header, firstByte)
+
+	var maskBit byte
+	if frame.masked {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) < 126:
+		header = append(header, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(payload)))
+	}
+
+	payload = bytes.Clone(payload)
+	if frame.masked {
+		header = append(header, frame.maskKey[:]...)
+		maskWebSocketPayload(payload, frame.maskKey)
+	}
+
+	return append(header, payload...)
+}
+
+// maskWebSocketPayload masks, or unmasks, the payload in place.
+func maskWebSocketPayload(payload []byte, maskKey [4]byte) {
+	for i := range payload {
+		payload[i] ^= maskKey[i%len(maskKey)]
+	}
+}
+
+func inflate(compressed, dictionary []byte) ([]byte, error) {
+	reader := flate.NewReaderDict(io.MultiReader(bytes.NewReader(compressed), strings.NewReader(deflateTail)), dictionary)
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func deflate(payload, dictionary []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	writer, err := flate.NewWriterDict(&compressed, flate.DefaultCompression, dictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(compressed.Bytes(), []byte(deflateTail[:4])), nil
+}
+
+// webSocketExtensions is the permessage-deflate configuration negotiated by a
+// handshake.
+type webSocketExtensions struct {
+	deflate bool
+
+	// noContextTakeover holds, for every direction, whether its sender
+	// resets the LZ77 window after every message.
+	noContextTakeover map[tcpmessage.TransmittionDirection]bool
+}
+
+// parseWebSocketExtensions parses the Sec-WebSocket-Extensions header of a
+// handshake response.
+func parseWebSocketExtensions(header string) webSocketExtensions {
+	extensions := webSocketExtensions{noContextTakeover: make(map[tcpmessage.TransmittionDirection]bool)}
+
+	for _, extension := range strings.Split(header, ",") {
+		params := strings.Split(extension, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+
+		extensions.deflate = true
+		for _, param := range params[1:] {
+			name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch name {
+			case "client_no_context_takeover":
+				extensions.noContextTakeover[tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER] = true
+			case "server_no_context_takeover":
+				extensions.noContextTakeover[tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT] = true
+			}
+		}
+	}
+
+	return extensions
+}
+
+// webSocketStream is the state of the messages sent in one direction.
+type webSocketStream struct {
+	// fragments holds the payloads of the frames of the current message.
+	fragments  [][]byte
+	opcode     WebSocketOpcode
+	compressed bool
+
+	// window holds the end of the previous uncompressed messages, which is
+	// the LZ77 window of the next compressed one.
+	window []byte
+}
+
+type webSocketSession struct {
+	extensions webSocketExtensions
+	streams    map[tcpmessage.TransmittionDirection]*webSocketStream
+}
+
+func newWebSocketSession(extensions webSocketExtensions) *webSocketSession {
+	return &webSocketSession{
+		extensions: extensions,
+		streams: map[tcpmessage.TransmittionDirection]*webSocketStream{
+			tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER: {},
+			tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT: {},
+		},
+	}
+}
+
+func (s *webSocketSession) Frame(direction tcpmessage.TransmittionDirection, data []byte) (int, Decoder, error) {
+	frame, err := parseWebSocketFrame(data)
+	if err != nil || frame == nil {
+		return 0, nil, err
+	}
+
+	if frame.opcode.isControl() {
+		return frame.length(), WebSocketDecoder{opcode: frame.opcode}, nil
+	}
+
+	stream := s.streams[direction]
+	if frame.opcode != WEBSOCKET_OPCODE_CONTINUATION {
+		stream.fragments = nil
+		stream.opcode = frame.opcode
+		stream.compressed = s.extensions.deflate && frame.compressed
+	}
+
+	decoder := WebSocketDecoder{
+		opcode:          stream.opcode,
+		compressed:      stream.compressed,
+		contextTakeover: stream.compressed && !s.extensions.noContextTakeover[direction],
+		fragments:       slices.Clip(stream.fragments),
+		window:          stream.window,
+	}
+
+	stream.fragments = append(stream.fragments, frame.payload(data))
+	if frame.fin {
+		if stream.compressed && !s.extensions.noContextTakeover[direction] {
+			message, _ := inflate(bytes.Join(stream.fragments, nil), stream.window)
+
+			// A new slice, as the previous window is shared with the decoders
+			window := append(slices.Clip(stream.window), message...)
+			stream.window = window[max(len(window)-WEBSOCKET_WINDOW_SIZE, 0):]
+		}
+
+		stream.fragments = nil
+	}
+
+	return frame.length(), decoder, nil
+}
+
+// WebSocketDecoder decodes WebSocket frames. The decoder of the last frame of
+// a fragmented message reassembles the message.
+type WebSocketDecoder struct {
+	// opcode and compressed are of the message of the frame, as set by its
+	// first frame.
+	opcode     WebSocketOpcode
+	compressed bool
+
+	// contextTakeover is set when the message is compressed, and its sender
+	// keeps the LZ77 window for the next messages.
+	contextTakeover bool
+
+	// fragments holds the unmasked payloads of the previous frames of the
+	// message.
+	fragments [][]byte
+
+	// window is the LZ77 window of the message, if it's compressed.
+	window []byte
+}
+
+func (WebSocketDecoder) Name() string {
+	return "websocket"
+}
+
+// parse parses the frame which is the whole content.
+func (WebSocketDecoder) parse(content []byte) (*webSocketFrame, error) {
+	frame, err := parseWebSocketFrame(content)
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		return nil, fmt.Errorf("incomplete WebSocket frame")
+	}
+	if frame.length() != len(content) {
+		return nil, fmt.Errorf("%d bytes after the end of the WebSocket frame", len(content)-frame.length())
+	}
+
+	return frame, nil
+}
+
+// isWholeCompressedMessage checks if the frame is a compressed message on its
+// own, so its payload can be decompressed and compressed back.
+func (d WebSocketDecoder) isWholeCompressedMessage(frame *webSocketFrame) bool {
+	return d.compressed && frame.fin && frame.opcode != WEBSOCKET_OPCODE_CONTINUATION
+}
+
+func (d WebSocketDecoder) Decode(content []byte) (*Dissection, error) {
+	frame, err := d.parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := frame.payload(content)
+
+	maskKeyLength := 0
+	if frame.masked {
+		maskKeyLength = len(frame.maskKey)
+	}
+
+	dissection := &Dissection{
+		Fields: []*Field{
+			{Name: "FIN", Value: fmt.Sprint(frame.fin), Length: 1},
+			{Name: "Compressed", Value: fmt.Sprint(frame.compressed), Length: 1},
+			{Name: "Opcode", Value: frame.opcode.String(), Length: 1},
+			{Name: "Payload length", Value: fmt.Sprint(frame.payloadLength), Offset: 1, Length: frame.headerLength - 1 - maskKeyLength},
+		},
+	}
+	if frame.masked {
+		dissection.Fields = append(dissection.Fields, &Field{
+			Name:   "Mask key",
+			Value:  hex.EncodeToString(frame.maskKey[:]),
+			Offset: frame.headerLength - maskKeyLength,
+			Length: maskKeyLength,
+		})
+	}
+
+	payloadField := &Field{
+		Name:   fmt.Sprintf("Payload (%d bytes)", len(payload)),
+		Value:  DescribeBody(payload),
+		Offset: frame.headerLength,
+		Length: frame.payloadLength,
+
+		Payload: !frame.masked && !d.isWholeCompressedMessage(frame),
+	}
+	dissection.Fields = append(dissection.Fields, payloadField)
+
+	opcode := frame.opcode
+	switch {
+	case opcode == WEBSOCKET_OPCODE_CLOSE && len(payload) >= 2:
+		payloadField.Children = []*Field{
+			{Name: "Status code", Value: fmt.Sprint(binary.BigEndian.Uint16(payload)), Offset: frame.headerLength, Length: 2},
+			{Name: "Reason", Value: string(payload[2:]), Offset: frame.headerLength + 2, Length: len(payload) - 2},
+		}
+		dissection.Summary = fmt.Sprintf("close %d %s", binary.BigEndian.Uint16(payload), payload[2:])
+	case opcode.isControl():
+		dissection.Summary = opcode.String()
+	case frame.fin && (d.compressed || len(d.fragments) > 0):
+		message := append(bytes.Join(d.fragments, nil), payload...)
+		if d.compressed {
+			message, err = inflate(message, d.window)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress the message: %w", err)
+			}
+		}
+
+		dissection.Fields = append(dissection.Fields, &Field{
+			Name:  fmt.Sprintf("Message (%d frames, %d bytes)", len(d.fragments)+1, len(message)),
+			Value: DescribeBody(message),
+		})
+		dissection.Summary = webSocketMessageSummary(d.opcode, message)
+	case !frame.fin:
+		dissection.Summary = fmt.Sprintf("%v fragment (%d bytes)", opcode, len(payload))
+	default:
+		dissection.Summary = webSocketMessageSummary(opcode, payload)
+	}
+
+	return dissection, nil
+}
+
+// WEBSOCKET_SUMMARY_TEXT_LENGTH is the maximal length of the text of a text
+// message shown in its summary.
+const WEBSOCKET_SUMMARY_TEXT_LENGTH = 40
+
+func webSocketMessageSummary(opcode WebSocketOpcode, message []byte) string {
+	if opcode != WEBSOCKET_OPCODE_TEXT {
+		return fmt.Sprintf("%v (%d bytes)", opcode, len(message))
+	}
+
+	text := []rune(strings.Join(strings.Fields(string(message)), " "))
+	if len(text) > WEBSOCKET_SUMMARY_TEXT_LENGTH {
+		return fmt.Sprintf("text %q...", string(text[:WEBSOCKET_SUMMARY_TEXT_LENGTH]))
+	}
+
+	return fmt.Sprintf("text %q", string(text))
+}
+
+// Payload returns the unmasked, and if possible decompressed, payload of the
+// frame.
+func (d WebSocketDecoder) Payload(content []byte) ([]byte, error) {
+	frame, err := d.parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := frame.payload(content)
+	if d.isWholeCompressedMessage(frame) {
+		return inflate(payload, d.window)
+	}
+
+	return payload, nil
+}
+
+// SetPayload re-frames the frame with the given payload, compressing it if it
+// was decompressed by Payload, and masking it with the key of the frame.
+// Compressed messages can only be edited without context takeover.
+func (d WebSocketDecoder) SetPayload(content, payload []byte) ([]byte, error) {
+	frame, err := d.parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.isWholeCompressedMessage(frame) {
+		if d.contextTakeover {
+			return nil, fmt.Errorf("compressed messages can only be edited when their sender negotiated no_context_takeover, " +
+				"as the receiver decompresses the next messages with the window of the edited one")
+		}
+
+		payload, err = deflate(payload, d.window)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return frame.encode(payload), nil
+}
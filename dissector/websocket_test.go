@@ -0,0 +1,108 @@
+package dissector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const webSocketHandshake = "GET /chat HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+
+func webSocketSessionAfterHandshake(t *testing.T, extensions string) Session {
+	session := HTTPDissector{}.NewSession()
+
+	frames, _ := frameAll(t, session, toServer, webSocketHandshake)
+	require.Len(t, frames, 1)
+
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"
+	if extensions != "" {
+		response += "Sec-WebSocket-Extensions: " + extensions + "\r\n"
+	}
+	frames, _ = frameAll(t, session, toClient, response+"\r\n")
+	require.Len(t, frames, 1)
+
+	return session
+}
+
+func TestWebSocketFragmentedMessage(t *testing.T) {
+	session := webSocketSessionAfterHandshake(t, "")
+
+	maskKey := [4]byte{1, 2, 3, 4}
+	first := webSocketFrame{opcode: WEBSOCKET_OPCODE_TEXT, masked: true, maskKey: maskKey}.encode([]byte("hello "))
+	ping := webSocketFrame{fin: true, opcode: WEBSOCKET_OPCODE_PING, masked: true, maskKey: maskKey}.encode(nil)
+	last := webSocketFrame{fin: true, opcode: WEBSOCKET_OPCODE_CONTINUATION, masked: true, maskKey: maskKey}.encode([]byte("world"))
+
+	var summaries []string
+	data := string(first) + string(ping) + string(last)
+	for len(data) > 0 {
+		length, decoder, err := session.Frame(toServer, []byte(data))
+		require.NoError(t, err)
+		require.NotZero(t, length)
+
+		dissection, err := decoder.Decode([]byte(data[:length]))
+		require.NoError(t, err)
+		summaries = append(summaries, dissection.Summary)
+
+		data = data[length:]
+	}
+
+	assert.Equal(t, []string{"text fragment (6 bytes)", "ping", `text "hello world"`}, summaries)
+}
+
+func TestWebSocketDeflateContextTakeover(t *testing.T) {
+	session := webSocketSessionAfterHandshake(t, "permessage-deflate; client_max_window_bits")
+
+	var window []byte
+	for _, text := range []string{"compressed message", "compressed message again"} {
+		compressed, err := deflate([]byte(text), window)
+		require.NoError(t, err)
+		window = append(window, text...)
+
+		frame := webSocketFrame{fin: true, compressed: true, opcode: WEBSOCKET_OPCODE_TEXT}.encode(compressed)
+		length, decoder, err := session.Frame(toClient, frame)
+		require.NoError(t, err)
+		require.Equal(t, len(frame), length)
+
+		payload, err := decoder.(PayloadEditor).Payload(frame)
+		require.NoError(t, err)
+		assert.Equal(t, text, string(payload))
+
+		// The next messages depend on the window of the original message
+		_, err = decoder.(PayloadEditor).SetPayload(frame, []byte("edited"))
+		assert.ErrorContains(t, err, "no_context_takeover")
+	}
+}
+
+func TestWebSocketDeflateNoContextTakeover(t *testing.T) {
+	session := webSocketSessionAfterHandshake(t, "permessage-deflate; server_no_context_takeover")
+
+	compressed, err := deflate([]byte("compressed message"), nil)
+	require.NoError(t, err)
+
+	frame := webSocketFrame{fin: true, compressed: true, opcode: WEBSOCKET_OPCODE_TEXT}.encode(compressed)
+	_, decoder, err := session.Frame(toClient, frame)
+	require.NoError(t, err)
+
+	edited, err := decoder.(PayloadEditor).SetPayload(frame, []byte("edited"))
+	require.NoError(t, err)
+	payload, err := decoder.(PayloadEditor).Payload(edited)
+	require.NoError(t, err)
+	assert.Equal(t, "edited", string(payload))
+}
+
+func TestWebSocketSetPayloadRemasks(t *testing.T) {
+	frame := webSocketFrame{fin: true, opcode: WEBSOCKET_OPCODE_BINARY, masked: true, maskKey: [4]byte{0xaa, 0xbb, 0xcc, 0xdd}}
+	content := frame.encode([]byte("short"))
+
+	longPayload := make([]byte, 300)
+	edited, err := WebSocketDecoder{}.SetPayload(content, longPayload)
+	require.NoError(t, err)
+
+	parsed, err := parseWebSocketFrame(edited)
+	require.NoError(t, err)
+	require.NotNil(t, parsed)
+	assert.Equal(t, 300, parsed.payloadLength)
+	assert.Equal(t, frame.maskKey, parsed.maskKey)
+	assert.Equal(t, longPayload, parsed.payload(edited))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EditFormat is the format in which a message is written to a file for
+// editing in $EDITOR.
+type EditFormat int
+
+const (
+	EDIT_FORMAT_RAW EditFormat = iota
+	EDIT_FORMAT_HEXDUMP
+	EDIT_FORMAT_ESCAPED
+)
+
+// EDIT_COMMENT_PREFIX starts a line which is ignored when parsing an edited
+// hexdump or escaped message.
+const EDIT_COMMENT_PREFIX = "#"
+
+// EDIT_ERROR_PREFIX starts the comment reporting a parse error of the
+// previous edit.
+const EDIT_ERROR_PREFIX = EDIT_COMMENT_PREFIX + " Error: "
+
+func ParseEditFormat(s string) (EditFormat, error) {
+	switch s {
+	case "raw":
+		return EDIT_FORMAT_RAW, nil
+	case "hexdump":
+		return EDIT_FORMAT_HEXDUMP, nil
+	case "escaped":
+		return EDIT_FORMAT_ESCAPED, nil
+	default:
+		return 0, fmt.Errorf("unknown edit format %q", s)
+	}
+}
+
+// FileSuffix returns the suffix of files in the format, to help the editor
+// choose how to open them.
+func (format EditFormat) FileSuffix() string {
+	if format == EDIT_FORMAT_RAW {
+		return ".bin"
+	}
+
+	return ".txt"
+}
+
+// EncodeForEdit encodes content in the given format.
+func EncodeForEdit(content []byte, format EditFormat) []byte {
+	switch format {
+	case EDIT_FORMAT_RAW:
+		return content
+	case EDIT_FORMAT_HEXDUMP:
+		header := EDIT_COMMENT_PREFIX + " Edit the hex bytes. The offsets and the ascii column are ignored.\n"
+		return []byte(header + hex.Dump(content))
+	case EDIT_FORMAT_ESCAPED:
+		header := EDIT_COMMENT_PREFIX + " Edit the quoted strings. They are concatenated, Go escapes are supported.\n"
+		return []byte(header + EscapeLines(content))
+	default:
+		panic("invalid edit format")
+	}
+}
+
+// DecodeEdited decodes text encoded by EncodeForEdit in the given format.
+func DecodeEdited(text []byte, format EditFormat) ([]byte, error) {
+	switch format {
+	case EDIT_FORMAT_RAW:
+		return text, nil
+	case EDIT_FORMAT_HEXDUMP:
+		return ParseHexdump(string(text))
+	case EDIT_FORMAT_ESCAPED:
+		return UnescapeLines(string(text))
+	default:
+		panic("invalid edit format")
+	}
+}
+
+// AddEditError returns text with err reported in a comment at its beginning,
+// replacing the error of a previous AddEditError.
+func AddEditError(text []byte, err error) []byte {
+	var res bytes.Buffer
+	res.WriteString(EDIT_ERROR_PREFIX + err.Error() + "\n")
+
+	for _, line := range strings.SplitAfter(string(text), "\n") {
+		if !strings.HasPrefix(line, EDIT_ERROR_PREFIX) {
+			res.WriteString(line)
+		}
+	}
+
+	return res.Bytes()
+}
+
+// IsEditCanceled reports whether the edit of text in $EDITOR was canceled, by
+// leaving the file empty or unchanged.
+func IsEditCanceled(text, editedText []byte) bool {
+	return len(bytes.TrimSpace(editedText)) == 0 || bytes.Equal(text, editedText)
+}
+
+// isHexDumpLine checks if line has the layout of a line written by hex.Dump:
+// an offset of 8 hex digits, the hex and the ascii column between pipes.
+func isHexDumpLine(line string) bool {
+	offset, rest, found := strings.Cut(line, "  ")
+	if !found || len(offset) != 8 {
+		return false
+	}
+
+	if _, err := strconv.ParseUint(offset, 16, 64); err != nil {
+		return false
+	}
+
+	rest = strings.TrimRight(rest, " \t")
+	return strings.Count(rest, "|") >= 2 && strings.HasSuffix(rest, "|")
+}
+
+// isHexdumpOffset checks if field is the offset at the beginning of a
+// hexdump line, as written by xxd ("00000010:").
+func isHexdumpOffset(field string) bool {
+	field, hasColon := strings.CutSuffix(field, ":")
+	if !hasColon {
+		return false
+	}
+
+	_, err := strconv.ParseUint(field, 16, 64)
+	return err == nil
+}
+
+// ParseHexdump parses a hexdump in the format of hex.Dump back into bytes,
+// like `xxd -r`. Offsets are optional and ignored, so bytes may be added and
+// removed freely. An offset is either followed by a colon, as written by xxd,
+// or begins a line laid out like those of hex.Dump. Otherwise, the first field
+// is hex, as in "deadbeef 00". Everything after a '|' is the ascii column, and
+// is ignored. Empty lines and lines beginning with EDIT_COMMENT_PREFIX are
+// ignored.
+func ParseHexdump(dump string) ([]byte, error) {
+	var res []byte
+
+	scanner := bufio.NewScanner(strings.NewReader(dump))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, EDIT_COMMENT_PREFIX) {
+			continue
+		}
+
+		isDumpLine := isHexDumpLine(line)
+		line, _, _ = strings.Cut(line, "|")
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (isDumpLine || isHexdumpOffset(fields[0])) {
+			fields = fields[1:]
+		}
+
+		for _, field := range fields {
+			decoded, err := hex.DecodeString(field)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid hex %q: %w", lineNumber, field, err)
+			}
+
+			res = append(res, decoded...)
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+// EscapeBytes quotes buffer as a Go string literal, escaping every byte
+// which isn't a displayable ascii character.
+func EscapeBytes(buffer []byte) string {
+	var res strings.Builder
+	res.WriteByte('"')
+
+	for _, char := range buffer {
+		switch char {
+		case '"', '\\':
+			res.WriteByte('\\')
+			res.WriteByte(char)
+		case '\n':
+			res.WriteString(`\n`)
+		case '\r':
+			res.WriteString(`\r`)
+		case '\t':
+			res.WriteString(`\t`)
+		default:
+			if IsCharacter(char) {
+				res.WriteByte(char)
+			} else {
+				fmt.Fprintf(&res, `\x%02x`, char)
+			}
+		}
+	}
+
+	res.WriteByte('"')
+	return res.String()
+}
+
+// EscapeLines escapes buffer with EscapeBytes, splitting it to a quoted string
+// per line of the buffer.
+func EscapeLines(buffer []byte) string {
+	var res strings.Builder
+
+	for _, line := range bytes.SplitAfter(buffer, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		res.WriteString(EscapeBytes(line) + "\n")
+	}
+
+	return res.String()
+}
+
+// UnescapeLines parses the quoted strings written by EscapeLines,
+// concatenating them. Empty lines and lines beginning with
+// EDIT_COMMENT_PREFIX are ignored.
+func UnescapeLines(text string) ([]byte, error) {
+	var res []byte
+
+	for lineNumber, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, EDIT_COMMENT_PREFIX) {
+			continue
+		}
+
+		unquoted, err := strconv.Unquote(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid quoted string %s: %w", lineNumber+1, line, err)
+		}
+
+		res = append(res, unquoted...)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func allBytes() []byte {
+	var buffer []byte
+	for i := 0; i < 256; i++ {
+		buffer = append(buffer, byte(i))
+	}
+
+	return buffer
+}
+
+func TestEditFormatRoundTrip(t *testing.T) {
+	for _, format := range []EditFormat{EDIT_FORMAT_RAW, EDIT_FORMAT_HEXDUMP, EDIT_FORMAT_ESCAPED} {
+		for _, content := range [][]byte{nil, []byte("GET / HTTP/1.1\r\n\r\n"), allBytes()} {
+			decoded, err := DecodeEdited(EncodeForEdit(content, format), format)
+			assert.NoError(t, err)
+			assert.Equal(t, string(content), string(decoded))
+		}
+	}
+}
+
+func TestParseHexdump(t *testing.T) {
+	parsed, err := ParseHexdump("00000000  68 65 6c 6c 6f  |hello|\n# comment\n\n2c20 776f726c64 |, world|\n00000010: 21\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello, world!"), parsed)
+
+	_, err = ParseHexdump("68 65\n6g\n")
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = ParseHexdump("686")
+	assert.Error(t, err)
+
+	// Without a colon, or the layout of hex.Dump, the first field is hex
+	parsed, err = ParseHexdump("deadbeef 00\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe, 0xef, 0x00}, parsed)
+
+	parsed, err = ParseHexdump("00000010  41 42\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x10, 0x41, 0x42}, parsed)
+}
+
+func TestIsEditCanceled(t *testing.T) {
+	assert.True(t, IsEditCanceled([]byte("# header\n68\n"), []byte("# header\n68\n")))
+	assert.True(t, IsEditCanceled([]byte("# header\n68\n"), []byte(" \n")))
+	assert.False(t, IsEditCanceled([]byte("# header\n68\n"), []byte("# header\n69\n")))
+}
+
+func TestUnescapeLines(t *testing.T) {
+	parsed, err := UnescapeLines("\"GET / \\r\\n\"\n  \"\\x00\\\"\"  \n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("GET / \r\n\x00\""), parsed)
+
+	_, err = UnescapeLines("\"unterminated\n")
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestAddEditError(t *testing.T) {
+	text := AddEditError([]byte("# header\n68\n"), errors.New("first"))
+	text = AddEditError(text, errors.New("second"))
+	assert.Equal(t, "# Error: second\n# header\n68\n", string(text))
+}
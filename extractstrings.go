@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// StringEncoding is the encoding of a string found by ExtractStrings.
+type StringEncoding int
+
+const (
+	STRING_ENCODING_ASCII StringEncoding = iota
+	STRING_ENCODING_UTF8
+	STRING_ENCODING_UTF16LE
+	STRING_ENCODING_UTF16BE
+)
+
+func (encoding StringEncoding) String() string {
+	switch encoding {
+	case STRING_ENCODING_ASCII:
+		return "ascii"
+	case STRING_ENCODING_UTF8:
+		return "utf-8"
+	case STRING_ENCODING_UTF16LE:
+		return "utf-16le"
+	case STRING_ENCODING_UTF16BE:
+		return "utf-16be"
+	default:
+		panic("invalid string encoding")
+	}
+}
+
+// ExtractedString is a string found in a buffer, at Offset, whose encoding
+// takes Length bytes.
+type ExtractedString struct {
+	Offset   int
+	Length   int
+	Encoding StringEncoding
+	Text     string
+}
+
+// extractUTF8Strings returns the runs of at least minStringLength printable
+// characters of buffer in UTF-8 (or ascii, if they're all ascii).
+func extractUTF8Strings(buffer []byte, minStringLength int) []ExtractedString {
+	var found []ExtractedString
+
+	stringBegin, characters := 0, 0
+	ascii := true
+	endString := func(end int) {
+		if characters >= minStringLength {
+			encoding := STRING_ENCODING_UTF8
+			if ascii {
+				encoding = STRING_ENCODING_ASCII
+			}
+			found = append(found, ExtractedString{stringBegin, end - stringBegin, encoding, string(buffer[stringBegin:end])})
+		}
+	}
+
+	for i := 0; i < len(buffer); {
+		char, size := utf8.DecodeRune(buffer[i:])
+		if (char == utf8.RuneError && size == 1) || !unicode.IsPrint(char) {
+			endString(i)
+			characters = 0
+			i += size
+			continue
+		}
+
+		if characters == 0 {
+			stringBegin, ascii = i, true
+		}
+		characters++
+		ascii = ascii && size == 1
+		i += size
+	}
+	endString(len(buffer))
+
+	return found
+}
+
+// UTF16_MAX_CHARACTER is the greatest character of the UTF-16 strings found
+// by ExtractStrings. Beyond it, too many pairs of bytes (ascii text read at
+// the wrong alignment, or any binary data) are printable characters.
+const UTF16_MAX_CHARACTER = 0x7ff
+
+// extractUTF16Strings returns the runs of at least minStringLength printable
+// characters, up to UTF16_MAX_CHARACTER (the Latin, Greek, Cyrillic, Hebrew
+// and Arabic scripts), of buffer in UTF-16 of the given byte order, beginning
+// at any offset. Characters can't contain the covered bytes.
+func extractUTF16Strings(buffer []byte, minStringLength int, encoding StringEncoding, covered []bool) []ExtractedString {
+	var found []ExtractedString
+
+	characterAt := func(i int) rune {
+		if encoding == STRING_ENCODING_UTF16LE {
+			return rune(buffer[i]) | rune(buffer[i+1])<<8
+		}
+		return rune(buffer[i])<<8 | rune(buffer[i+1])
+	}
+
+	for alignment := 0; alignment < 2; alignment++ {
+		var text []rune
+		stringBegin := alignment
+		endString := func(end int) {
+			if len(text) >= minStringLength {
+				found = append(found, ExtractedString{stringBegin, end - stringBegin, encoding, string(text)})
+			}
+			text = nil
+		}
+
+		i := alignment
+		for ; i+1 < len(buffer); i += 2 {
+			char := characterAt(i)
+			if char > UTF16_MAX_CHARACTER || !unicode.IsPrint(char) || covered[i] || covered[i+1] {
+				endString(i)
+				continue
+			}
+
+			if len(text) == 0 {
+				stringBegin = i
+			}
+			text = append(text, char)
+		}
+		endString(i)
+	}
+
+	return found
+}
+
+// ExtractStrings returns the strings of at least minStringLength printable
+// characters in buffer, in UTF-8 (including ascii), UTF-16LE and UTF-16BE,
+// ordered by their offsets. Where strings overlap (such as UTF-16 strings read
+// at both alignments), the longest is returned.
+func ExtractStrings(buffer []byte, minStringLength int) []ExtractedString {
+	candidates := extractUTF8Strings(buffer, minStringLength)
+
+	// UTF-16 characters can't contain bytes of UTF-8 strings, as otherwise
+	// the bytes around them are read as UTF-16 characters (e.g. "d\x02" as
+	// "ɤ"). Single characters are as likely to be bytes of UTF-16 strings.
+	covered := make([]bool, len(buffer))
+	for _, found := range candidates {
+		if utf8.RuneCountInString(found.Text) >= 2 {
+			for i := found.Offset; i < found.Offset+found.Length; i++ {
+				covered[i] = true
+			}
+		}
+	}
+
+	candidates = append(candidates, extractUTF16Strings(buffer, minStringLength, STRING_ENCODING_UTF16LE, covered)...)
+	candidates = append(candidates, extractUTF16Strings(buffer, minStringLength, STRING_ENCODING_UTF16BE, covered)...)
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Length > candidates[j].Length })
+
+	// The candidates of every encoding and alignment don't overlap each
+	// other, so every byte is checked by a few candidates at most
+	taken := make([]bool, len(buffer))
+	var found []ExtractedString
+	for _, candidate := range candidates {
+		if slices.Contains(taken[candidate.Offset:candidate.Offset+candidate.Length], true) {
+			continue
+		}
+
+		for i := candidate.Offset; i < candidate.Offset+candidate.Length; i++ {
+			taken[i] = true
+		}
+		found = append(found, candidate)
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Offset < found[j].Offset })
+	return found
+}
+
+// RenderStrings renders the strings of at least minStringLength characters
+// in content, a string per line, with their offsets and encodings.
+func RenderStrings(content []byte, minStringLength int) string {
+	var lines []string
+	for _, found := range ExtractStrings(content, minStringLength) {
+		lines = append(lines, fmt.Sprintf("%08x %-8s %s", found.Offset, found.Encoding, found.Text))
+	}
+
+	return strings.Join(lines, "\n")
+}
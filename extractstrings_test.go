@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractStrings(t *testing.T) {
+	buffer := []byte("\x00hello\x01héllo wörld\x02" +
+		"w\x00i\x00n\x00d\x00o\x00w\x00s\x00\x00\x00" +
+		"\x00o\x00k\x00!\x00?" +
+		"\xff\xff\x3f\x04\x40\x04\x38\x04")
+
+	assert.Equal(t, []ExtractedString{
+		{1, 5, STRING_ENCODING_ASCII, "hello"},
+		{7, 13, STRING_ENCODING_UTF8, "héllo wörld"},
+		{21, 14, STRING_ENCODING_UTF16LE, "windows"},
+		{37, 8, STRING_ENCODING_UTF16BE, "ok!?"},
+	}, ExtractStrings(buffer, 4))
+
+	assert.Len(t, ExtractStrings(buffer, 6), 2)
+	assert.Contains(t, ExtractStrings(buffer, 1), ExtractedString{21, 14, STRING_ENCODING_UTF16LE, "windows"})
+
+	found := ExtractStrings(buffer, 3)
+	assert.Equal(t, ExtractedString{47, 6, STRING_ENCODING_UTF16LE, "при"}, found[len(found)-1])
+
+	assert.Equal(t, "00000001 ascii    hello", RenderStrings([]byte("\x00hello"), 4))
+}
+
+func TestExtractStringsManyCandidates(t *testing.T) {
+	// Many strings, which were compared to every string found before them
+	buffer := []byte(strings.Repeat("abcd\x00", 1<<16))
+	assert.Len(t, ExtractStrings(buffer, 4), 1<<16)
+}
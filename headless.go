@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/proxycore"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+type HeadlessFormat int
+
+const (
+	HEADLESS_FORMAT_HEXDUMP HeadlessFormat = iota
+	HEADLESS_FORMAT_HEX
+	HEADLESS_FORMAT_STRINGS
+	HEADLESS_FORMAT_JSON
+)
+
+func ParseHeadlessFormat(s string) (HeadlessFormat, error) {
+	switch s {
+	case "hexdump":
+		return HEADLESS_FORMAT_HEXDUMP, nil
+	case "hex":
+		return HEADLESS_FORMAT_HEX, nil
+	case "strings":
+		return HEADLESS_FORMAT_STRINGS, nil
+	case "json":
+		return HEADLESS_FORMAT_JSON, nil
+	default:
+		return 0, fmt.Errorf("unknown headless format %q", s)
+	}
+}
+
+// MessageLogger writes messages to an output in a HeadlessFormat. It's safe
+// for concurrent use.
+type MessageLogger struct {
+	output      io.Writer
+	format      HeadlessFormat
+	outputMutex sync.Mutex
+}
+
+func NewMessageLogger(output io.Writer, format HeadlessFormat) *MessageLogger {
+	return &MessageLogger{
+		output: output,
+		format: format,
+	}
+}
+
+type jsonLoggedMessage struct {
+	Time      time.Time                        `json:"time"`
+	Direction tcpmessage.TransmittionDirection `json:"direction"`
+	Length    int                              `json:"length"`
+	Protocol  string                           `json:"protocol,omitempty"`
+	Content   []byte                           `json:"content"`
+}
+
+func (l *MessageLogger) Log(message *tcpmessage.TCPMessage) error {
+	content := message.Content()
+
+	var entry string
+	switch l.format {
+	case HEADLESS_FORMAT_JSON:
+		line, err := json.Marshal(jsonLoggedMessage{
+			Time:      message.Time(),
+			Direction: message.Direction(),
+			Length:    len(content),
+			Protocol:  ProtocolName(message),
+			Content:   content,
+		})
+		if err != nil {
+			return err
+		}
+
+		entry = string(line) + "\n"
+	default:
+		header := fmt.Sprintf("[%v] %v (%v bytes)", message.Time().Format(time.TimeOnly), message.Direction(), len(content))
+		rendered := strings.TrimRight(RenderMessageContent(content, l.format.displayMethod(), 0), "\n")
+		entry = header + "\n" + rendered + "\n\n"
+	}
+
+	l.outputMutex.Lock()
+	defer l.outputMutex.Unlock()
+
+	_, err := io.WriteString(l.output, entry)
+	return err
+}
+
+func (format HeadlessFormat) displayMethod() MessageDisplayMethod {
+	switch format {
+	case HEADLESS_FORMAT_HEXDUMP:
+		return MESSAGE_DISPLAY_METHOD_HEXDUMP
+	case HEADLESS_FORMAT_HEX:
+		return MESSAGE_DISPLAY_METHOD_HEX
+	case HEADLESS_FORMAT_STRINGS:
+		return MESSAGE_DISPLAY_METHOD_STRINGS
+	default:
+		panic("headless format has no display method")
+	}
+}
+
+// RunHeadless runs the proxy without the TUI, auto transmitting every message
+// its interceptor (see Rules) doesn't drop and logging it, until SIGINT or
+// SIGTERM is received.
+func RunHeadless(proxy *proxycore.Proxy, args Args) error {
+	output := os.Stdout
+	if args.headlessOutput != "" {
+		file, err := os.Create(args.headlessOutput)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		output = file
+	}
+
+	logger := NewMessageLogger(output, args.headlessFormat)
+
+	proxy.SetAutoTransmit(true)
+	unsubscribe := proxy.Subscribe(func(_ int, message *tcpmessage.TCPMessage) {
+		if err := logger.Log(message); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to log message: %v\n", err)
+		}
+	})
+	defer unsubscribe()
+
+	if err := proxy.Start(); err != nil {
+		return err
+	}
+	defer proxy.Stop()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	<-ctx.Done()
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/symbols"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageLoggerJSON(t *testing.T) {
+	var output bytes.Buffer
+	logger := NewMessageLogger(&output, HEADLESS_FORMAT_JSON)
+
+	require.NoError(t, logger.Log(tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, []byte("ping\x00"))))
+	require.NoError(t, logger.Log(tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT, []byte("pong"))))
+
+	lines := bytes.Split(bytes.TrimSuffix(output.Bytes(), []byte("\n")), []byte("\n"))
+	require.Len(t, lines, 2)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(lines[0], &entry))
+	assert.Equal(t, "to_server", entry["direction"])
+	assert.Equal(t, float64(5), entry["length"])
+	assert.Equal(t, "cGluZwA=", entry["content"])
+	assert.NotContains(t, entry, "protocol")
+
+	require.NoError(t, json.Unmarshal(lines[1], &entry))
+	assert.Equal(t, "to_client", entry["direction"])
+	assert.Equal(t, "cG9uZw==", entry["content"])
+
+	loggedTime, err := time.Parse(time.RFC3339Nano, entry["time"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), loggedTime, time.Minute)
+}
+
+func TestMessageLoggerHexdump(t *testing.T) {
+	symbols.CurrentMap = symbols.DefaultMap
+
+	var output bytes.Buffer
+	logger := NewMessageLogger(&output, HEADLESS_FORMAT_HEXDUMP)
+
+	content := []byte("hello, world\n\x01\x02")
+	message := tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT, content)
+	require.NoError(t, logger.Log(message))
+
+	expected := fmt.Sprintf("[%v] → (15 bytes)\n%v\n", message.Time().Format(time.TimeOnly), hex.Dump(content))
+	assert.Equal(t, expected, output.String())
+}
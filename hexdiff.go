@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/bytediff"
+	"github.com/Denloob/protocol-proxy/styles"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const HEXDUMP_DIFF_BYTES_PER_LINE = 16
+
+type diffCellKind int
+
+const (
+	DIFF_CELL_EQUAL diffCellKind = iota
+	DIFF_CELL_INSERTED
+	DIFF_CELL_REMOVED
+	DIFF_CELL_CHANGED
+)
+
+func (kind diffCellKind) style() lipgloss.Style {
+	switch kind {
+	case DIFF_CELL_EQUAL:
+		return styles.Unstyled
+	case DIFF_CELL_INSERTED:
+		return styles.DiffInserted
+	case DIFF_CELL_REMOVED:
+		return styles.DiffRemoved
+	case DIFF_CELL_CHANGED:
+		return styles.DiffChanged
+	default:
+		panic("invalid diff cell kind")
+	}
+}
+
+// diffCell is a byte of the unified diff. Removed bytes hold their original
+// value, and the rest hold their current one.
+type diffCell struct {
+	value byte
+	kind  diffCellKind
+}
+
+// diffCells flattens the ops to cells, pairing deleted bytes with inserted
+// bytes right next to them as changed bytes.
+func diffCells(ops []bytediff.Op) []diffCell {
+	var cells []diffCell
+
+	for i := 0; i < len(ops); i++ {
+		op := ops[i]
+
+		if op.Kind == bytediff.OP_EQUAL {
+			for _, b := range op.Data {
+				cells = append(cells, diffCell{b, DIFF_CELL_EQUAL})
+			}
+			continue
+		}
+
+		var deleted, inserted []byte
+		for ; i < len(ops) && ops[i].Kind != bytediff.OP_EQUAL; i++ {
+			if ops[i].Kind == bytediff.OP_DELETE {
+				deleted = append(deleted, ops[i].Data...)
+			} else {
+				inserted = append(inserted, ops[i].Data...)
+			}
+		}
+		i-- // Let the loop increment reach the next equal op
+
+		changedCount := min(len(deleted), len(inserted))
+		for _, b := range inserted[:changedCount] {
+			cells = append(cells, diffCell{b, DIFF_CELL_CHANGED})
+		}
+		for _, b := range deleted[changedCount:] {
+			cells = append(cells, diffCell{b, DIFF_CELL_REMOVED})
+		}
+		for _, b := range inserted[changedCount:] {
+			cells = append(cells, diffCell{b, DIFF_CELL_INSERTED})
+		}
+	}
+
+	return cells
+}
+
+// RenderHexdumpDiff renders the difference between the original and the
+// current content as a hexdump, highlighting inserted, removed and changed
+// bytes. The offsets are of the current content.
+func RenderHexdumpDiff(original, current []byte) string {
+	cells := diffCells(bytediff.Diff(original, current))
+
+	counts := make(map[diffCellKind]int)
+	for _, cell := range cells {
+		counts[cell.kind]++
+	}
+
+	lines := []string{
+		fmt.Sprintf("Original %d bytes, current %d bytes: %s %s %s",
+			len(original), len(current),
+			styles.DiffInserted.Render(fmt.Sprintf("+%d inserted", counts[DIFF_CELL_INSERTED])),
+			styles.DiffRemoved.Render(fmt.Sprintf("-%d removed", counts[DIFF_CELL_REMOVED])),
+			styles.DiffChanged.Render(fmt.Sprintf("~%d changed", counts[DIFF_CELL_CHANGED])),
+		),
+	}
+
+	offset := 0
+	for lineBegin := 0; lineBegin < len(cells); lineBegin += HEXDUMP_DIFF_BYTES_PER_LINE {
+		lineCells := cells[lineBegin:min(lineBegin+HEXDUMP_DIFF_BYTES_PER_LINE, len(cells))]
+
+		var hexPart, asciiPart strings.Builder
+		lineOffset := offset
+
+		for i := 0; i < HEXDUMP_DIFF_BYTES_PER_LINE; i++ {
+			if i == HEXDUMP_DIFF_BYTES_PER_LINE/2 {
+				hexPart.WriteString(" ")
+			}
+
+			if i >= len(lineCells) {
+				hexPart.WriteString("   ")
+				continue
+			}
+
+			cell := lineCells[i]
+			if cell.kind != DIFF_CELL_REMOVED {
+				offset++
+			}
+
+			char := "."
+			if IsCharacter(cell.value) {
+				char = string(cell.value)
+			}
+
+			style := cell.kind.style()
+			hexPart.WriteString(style.Render(fmt.Sprintf("%02x", cell.value)) + " ")
+			asciiPart.WriteString(style.Render(char))
+		}
+
+		lines = append(lines, fmt.Sprintf("%08x  %s |%s|", lineOffset, hexPart.String(), asciiPart.String()))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
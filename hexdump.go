@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/styles"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// HEXDUMP_MIN_OFFSET_DIGITS is the minimal number of digits of the offsets of
+// a hexdump.
+const HEXDUMP_MIN_OFFSET_DIGITS = 8
+
+// HEXDUMP_GROUP_SIZES are the numbers of bytes by which a hexdump can be
+// grouped, in the order they're cycled through.
+var HEXDUMP_GROUP_SIZES = []int{1, 2, 4, 8}
+
+// HexdumpConfig is the layout of a hexdump rendered by RenderHexdump.
+type HexdumpConfig struct {
+	// BytesPerLine is the number of bytes of every line, 0 to fit as many
+	// as possible in the width.
+	BytesPerLine int
+	// GroupSize is the number of bytes whose hex is written together, one of
+	// HEXDUMP_GROUP_SIZES.
+	GroupSize int
+	// DecimalOffsets writes the offsets in decimal instead of hex.
+	DecimalOffsets bool
+}
+
+// NextGroupSize returns the config with the next group size of
+// HEXDUMP_GROUP_SIZES.
+func (config HexdumpConfig) NextGroupSize() HexdumpConfig {
+	for i, size := range HEXDUMP_GROUP_SIZES {
+		if size == config.GroupSize {
+			config.GroupSize = HEXDUMP_GROUP_SIZES[(i+1)%len(HEXDUMP_GROUP_SIZES)]
+			return config
+		}
+	}
+
+	config.GroupSize = HEXDUMP_GROUP_SIZES[0]
+	return config
+}
+
+// HexdumpHighlight highlights the bytes from Begin to End of a hexdump with
+// Style.
+type HexdumpHighlight struct {
+	Begin, End int
+	Style      lipgloss.Style
+}
+
+// SearchHighlights returns the highlights of the occurrences of pattern in
+// data.
+func SearchHighlights(data []byte, pattern []byte) []HexdumpHighlight {
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	var highlights []HexdumpHighlight
+	for offset := 0; ; {
+		index := bytes.Index(data[offset:], pattern)
+		if index == -1 {
+			return highlights
+		}
+
+		begin := offset + index
+		highlights = append(highlights, HexdumpHighlight{begin, begin + len(pattern), styles.SearchHit})
+		offset = begin + 1
+	}
+}
+
+// byteClassStyle returns the style of the class of b: null, printable,
+// control or high (non-ascii).
+func byteClassStyle(b byte) lipgloss.Style {
+	switch {
+	case b == 0:
+		return styles.ByteNull
+	case IsCharacter(b):
+		return styles.BytePrintable
+	case b < 0x80:
+		return styles.ByteControl
+	default:
+		return styles.ByteHigh
+	}
+}
+
+// hexdumpLineWidth returns the width of a line of bytesPerLine bytes.
+func hexdumpLineWidth(bytesPerLine, groupSize, offsetDigits int) int {
+	groups := (bytesPerLine + groupSize - 1) / groupSize
+	// The offset, 2 spaces, the hex of the groups separated by spaces, 2
+	// spaces and the ascii between pipes
+	return offsetDigits + 2 + 2*bytesPerLine + groups - 1 + 2 + bytesPerLine + 2
+}
+
+// layout returns the number of bytes per line of a hexdump of dataLength
+// bytes, fitting as many groups as possible in width (at least one, and a
+// multiple of 8 bytes when there are more), and the base and the number of
+// digits of its offsets.
+func (config HexdumpConfig) layout(dataLength int, width int) (bytesPerLine, offsetBase, offsetDigits int) {
+	offsetBase = 16
+	if config.DecimalOffsets {
+		offsetBase = 10
+	}
+	offsetDigits = max(len(strconv.FormatInt(int64(dataLength), offsetBase)), HEXDUMP_MIN_OFFSET_DIGITS)
+
+	if config.BytesPerLine > 0 {
+		return config.BytesPerLine, offsetBase, offsetDigits
+	}
+
+	bytesPerLine = config.GroupSize
+	for hexdumpLineWidth(bytesPerLine+config.GroupSize, config.GroupSize, offsetDigits) <= width {
+		bytesPerLine += config.GroupSize
+	}
+
+	if bytesPerLine > 8 {
+		bytesPerLine -= bytesPerLine % 8
+	}
+
+	return bytesPerLine, offsetBase, offsetDigits
+}
+
+// highlightIndexes returns, for every one of length bytes, the index of its
+// last highlight plus 1, or 0 if it isn't highlighted. The highlights are
+// painted from the last one, skipping the bytes painted by later ones, so
+// overlapping highlights (such as search hits) take linear time.
+func highlightIndexes(length int, highlights []HexdumpHighlight) []int {
+	indexes := make([]int, length)
+
+	// next[i] leads to the first unpainted byte from i
+	next := make([]int, length+1)
+	for i := range next {
+		next[i] = i
+	}
+	unpainted := func(i int) int {
+		for next[i] != i {
+			next[i] = next[next[i]]
+			i = next[i]
+		}
+		return i
+	}
+
+	for h := len(highlights) - 1; h >= 0; h-- {
+		end := min(highlights[h].End, length)
+		for i := unpainted(Clamp(highlights[h].Begin, 0, length)); i < end; i = unpainted(i) {
+			indexes[i] = h + 1
+			next[i] = i + 1
+		}
+	}
+
+	return indexes
+}
+
+// RenderHexdump renders data as a hexdump laid out by config, fitted to width
+// (see HexdumpConfig.BytesPerLine). The bytes are colored by their class, and
+// the highlighted bytes by their highlight (the last highlight of a byte).
+func RenderHexdump(data []byte, config HexdumpConfig, width int, highlights []HexdumpHighlight) string {
+	config.GroupSize = max(config.GroupSize, 1)
+	bytesPerLine, offsetBase, offsetDigits := config.layout(len(data), width)
+
+	highlightOf := highlightIndexes(len(data), highlights)
+	styleOf := func(index int) lipgloss.Style {
+		if highlightOf[index] != 0 {
+			return highlights[highlightOf[index]-1].Style
+		}
+		return byteClassStyle(data[index])
+	}
+
+	var lines []string
+	for lineBegin := 0; lineBegin < len(data); lineBegin += bytesPerLine {
+		var hexPart, asciiPart strings.Builder
+
+		for i := lineBegin; i < lineBegin+bytesPerLine; i++ {
+			if i != lineBegin && (i-lineBegin)%config.GroupSize == 0 {
+				hexPart.WriteString(" ")
+			}
+
+			if i >= len(data) {
+				hexPart.WriteString("  ")
+				asciiPart.WriteString(" ")
+				continue
+			}
+
+			char := "."
+			if IsCharacter(data[i]) {
+				char = string(data[i])
+			}
+
+			style := styleOf(i)
+			hexPart.WriteString(style.Render(fmt.Sprintf("%02x", data[i])))
+			asciiPart.WriteString(style.Render(char))
+		}
+
+		offset := strconv.FormatInt(int64(lineBegin), offsetBase)
+		offset = strings.Repeat("0", max(offsetDigits-len(offset), 0)) + offset
+		lines = append(lines, fmt.Sprintf("%s  %s  |%s|", offset, hexPart.String(), asciiPart.String()))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// HexdumpLineOf returns the line of the byte at index in a hexdump of data
+// rendered by RenderHexdump.
+func HexdumpLineOf(data []byte, config HexdumpConfig, width int, index int) int {
+	config.GroupSize = max(config.GroupSize, 1)
+	bytesPerLine, _, _ := config.layout(len(data), width)
+
+	return index / bytesPerLine
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderHexdump(t *testing.T) {
+	data := []byte("0123456789abcdef\x00\xff")
+
+	// 16 bytes fit in hex.Dump's width
+	assert.Equal(t,
+		"00000000  30313233 34353637 38396162 63646566  |0123456789abcdef|\n"+
+			"00000010  00ff                                 |..              |",
+		RenderHexdump(data, HexdumpConfig{GroupSize: 4}, 79, nil))
+
+	assert.Equal(t,
+		"00000000  30 31 32 33 34 35 36 37  |01234567|\n"+
+			"00000008  38 39 61 62 63 64 65 66  |89abcdef|\n"+
+			"00000016  00 ff                    |..      |",
+		RenderHexdump(data, HexdumpConfig{GroupSize: 1, DecimalOffsets: true}, 60, nil))
+
+	assert.Equal(t, "00000000  30  |0|", RenderHexdump(data[:1], HexdumpConfig{}, 0, nil))
+	assert.Equal(t, 2, HexdumpLineOf(data, HexdumpConfig{GroupSize: 1}, 60, 17))
+}
+
+func TestHighlightIndexes(t *testing.T) {
+	highlights := []HexdumpHighlight{{Begin: 0, End: 4}, {Begin: 2, End: 6}, {Begin: 5, End: 100}, {Begin: -3, End: 1}}
+	assert.Equal(t, []int{4, 1, 2, 2, 2, 3, 3}, highlightIndexes(7, highlights))
+	assert.Equal(t, []int{0, 0}, highlightIndexes(2, []HexdumpHighlight{{Begin: 5, End: 9}}))
+}
+
+func TestSearch(t *testing.T) {
+	highlights := SearchHighlights([]byte("aaab"), []byte("aa"))
+	require.Len(t, highlights, 2)
+	assert.Equal(t, []int{0, 2, 1, 3}, []int{highlights[0].Begin, highlights[0].End, highlights[1].Begin, highlights[1].End})
+
+	pattern, err := ParseSearchPattern("# comment\n\"GET\\r\\n\"\n")
+	require.NoError(t, err)
+	assert.Equal(t, "GET\r\n", string(pattern))
+
+	pattern, err = ParseSearchPattern("de ad\nbe ef")
+	require.NoError(t, err)
+	assert.Equal(t, "\xde\xad\xbe\xef", string(pattern))
+
+	_, err = ParseSearchPattern("xyz")
+	assert.Error(t, err)
+}
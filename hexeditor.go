@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Denloob/protocol-proxy/styles"
+	"github.com/Denloob/protocol-proxy/symbols"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const HEX_EDITOR_BYTES_PER_LINE = 16
+
+type HexEditorSide int
+
+const (
+	HEX_EDITOR_SIDE_HEX HexEditorSide = iota
+	HEX_EDITOR_SIDE_ASCII
+)
+
+type hexEditorSnapshot struct {
+	buffer []byte
+	cursor int
+}
+
+// HexEditor edits a buffer, either through its hex or through its ascii
+// representation. The cursor may be placed one byte past the end of the
+// buffer, to append to it.
+type HexEditor struct {
+	buffer     []byte
+	cursor     int
+	side       HexEditorSide
+	insertMode bool
+
+	// lowNibble is set when the high nibble of the byte under the cursor was
+	// just typed on the hex side, so the next hex digit sets the low one.
+	lowNibble bool
+
+	undoStack []hexEditorSnapshot
+}
+
+func NewHexEditor(buffer []byte) *HexEditor {
+	return &HexEditor{
+		buffer: append([]byte(nil), buffer...),
+	}
+}
+
+func (e *HexEditor) Buffer() []byte {
+	return e.buffer
+}
+
+// CursorLine returns the index of the line on which the cursor is rendered.
+func (e *HexEditor) CursorLine() int {
+	return e.cursor / HEX_EDITOR_BYTES_PER_LINE
+}
+
+func (e *HexEditor) MoveCursor(delta int) {
+	e.cursor = Clamp(e.cursor+delta, 0, len(e.buffer))
+	e.lowNibble = false
+}
+
+func (e *HexEditor) MoveCursorToLineBegin() {
+	e.MoveCursor(-(e.cursor % HEX_EDITOR_BYTES_PER_LINE))
+}
+
+func (e *HexEditor) MoveCursorToLineEnd() {
+	e.MoveCursor(HEX_EDITOR_BYTES_PER_LINE - 1 - e.cursor%HEX_EDITOR_BYTES_PER_LINE)
+}
+
+func (e *HexEditor) SwitchSide() {
+	if e.side == HEX_EDITOR_SIDE_HEX {
+		e.side = HEX_EDITOR_SIDE_ASCII
+	} else {
+		e.side = HEX_EDITOR_SIDE_HEX
+	}
+	e.lowNibble = false
+}
+
+func (e *HexEditor) ToggleInsertMode() {
+	e.insertMode = !e.insertMode
+	e.lowNibble = false
+}
+
+func (e *HexEditor) saveSnapshot() {
+	e.undoStack = append(e.undoStack, hexEditorSnapshot{
+		buffer: append([]byte(nil), e.buffer...),
+		cursor: e.cursor,
+	})
+}
+
+// Undo reverts the last modification, reporting whether there was one.
+func (e *HexEditor) Undo() bool {
+	if len(e.undoStack) == 0 {
+		return false
+	}
+
+	snapshot := e.undoStack[len(e.undoStack)-1]
+	e.undoStack = e.undoStack[:len(e.undoStack)-1]
+
+	e.buffer = snapshot.buffer
+	e.cursor = snapshot.cursor
+	e.lowNibble = false
+
+	return true
+}
+
+// putByte writes b under the cursor, inserting it in insert mode or when the
+// cursor is past the end of the buffer, and overwriting otherwise.
+func (e *HexEditor) putByte(b byte) {
+	if e.insertMode || e.cursor == len(e.buffer) {
+		e.buffer = append(e.buffer[:e.cursor], append([]byte{b}, e.buffer[e.cursor:]...)...)
+	} else {
+		e.buffer[e.cursor] = b
+	}
+}
+
+// Input types char at the cursor, as a hex digit on the hex side and as an
+// ascii character on the ascii side.
+func (e *HexEditor) Input(char rune) error {
+	switch e.side {
+	case HEX_EDITOR_SIDE_HEX:
+		nibble, ok := hexDigitValue(char)
+		if !ok {
+			return fmt.Errorf("%q is not a hex digit", char)
+		}
+
+		if e.lowNibble {
+			e.buffer[e.cursor] = e.buffer[e.cursor]&0xf0 | nibble
+			e.lowNibble = false
+			e.cursor++
+			return nil
+		}
+
+		e.saveSnapshot()
+		if e.insertMode || e.cursor == len(e.buffer) {
+			e.putByte(nibble << 4)
+		} else {
+			e.buffer[e.cursor] = nibble<<4 | e.buffer[e.cursor]&0x0f
+		}
+		e.lowNibble = true
+	case HEX_EDITOR_SIDE_ASCII:
+		if char >= utf8.RuneSelf || !IsCharacter(byte(char)) {
+			return fmt.Errorf("%q is not a displayable ascii character", char)
+		}
+
+		e.saveSnapshot()
+		e.putByte(byte(char))
+		e.cursor++
+	}
+
+	return nil
+}
+
+// Delete deletes the byte under the cursor.
+func (e *HexEditor) Delete() {
+	if e.cursor == len(e.buffer) {
+		return
+	}
+
+	e.saveSnapshot()
+	e.buffer = append(e.buffer[:e.cursor], e.buffer[e.cursor+1:]...)
+	e.lowNibble = false
+}
+
+// Backspace deletes the byte before the cursor.
+func (e *HexEditor) Backspace() {
+	if e.cursor == 0 {
+		return
+	}
+
+	e.MoveCursor(-1)
+	e.Delete()
+}
+
+func hexDigitValue(char rune) (byte, bool) {
+	switch {
+	case '0' <= char && char <= '9':
+		return byte(char - '0'), true
+	case 'a' <= char && char <= 'f':
+		return byte(char - 'a' + 10), true
+	case 'A' <= char && char <= 'F':
+		return byte(char - 'A' + 10), true
+	default:
+		return 0, false
+	}
+}
+
+// renderCell renders a cell of the byte at index, highlighting it if the
+// cursor is on it.
+func (e *HexEditor) renderCell(index int, side HexEditorSide, text string) string {
+	if index != e.cursor {
+		return text
+	}
+
+	if side == e.side {
+		return styles.Cursor.Render(text)
+	}
+
+	return styles.SecondaryCursor.Render(text)
+}
+
+// Render renders the buffer in hex.Dump format, with the cursor highlighted.
+func (e *HexEditor) Render() string {
+	var lines []string
+
+	// One more line is rendered when the buffer is full, for the append cell
+	for lineBegin := 0; lineBegin <= len(e.buffer); lineBegin += HEX_EDITOR_BYTES_PER_LINE {
+		var hexPart, asciiPart strings.Builder
+
+		for i := lineBegin; i < lineBegin+HEX_EDITOR_BYTES_PER_LINE; i++ {
+			if i == lineBegin+HEX_EDITOR_BYTES_PER_LINE/2 {
+				hexPart.WriteString(" ")
+			}
+
+			switch {
+			case i < len(e.buffer):
+				char := "."
+				if IsCharacter(e.buffer[i]) {
+					char = string(e.buffer[i])
+				}
+
+				hexPart.WriteString(e.renderCell(i, HEX_EDITOR_SIDE_HEX, fmt.Sprintf("%02x", e.buffer[i])) + " ")
+				asciiPart.WriteString(e.renderCell(i, HEX_EDITOR_SIDE_ASCII, char))
+			case i == len(e.buffer):
+				hexPart.WriteString(e.renderCell(i, HEX_EDITOR_SIDE_HEX, "__") + " ")
+				asciiPart.WriteString(e.renderCell(i, HEX_EDITOR_SIDE_ASCII, "_"))
+			default:
+				hexPart.WriteString("   ")
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("%08x  %s |%s|", lineBegin, hexPart.String(), asciiPart.String()))
+	}
+
+	mode := "overwrite"
+	if e.insertMode {
+		mode = "insert"
+	}
+	lines = append(lines, fmt.Sprintf("-- %s -- offset %#x/%#x", mode, e.cursor, len(e.buffer)))
+
+	return strings.Join(lines, "\n")
+}
+
+type HexEditorAction int
+
+const (
+	HEX_EDITOR_ACTION_INPUT HexEditorAction = iota
+	HEX_EDITOR_ACTION_MOVE
+	HEX_EDITOR_ACTION_LINE_BEGIN
+	HEX_EDITOR_ACTION_LINE_END
+	HEX_EDITOR_ACTION_SWITCH_SIDE
+	HEX_EDITOR_ACTION_TOGGLE_INSERT
+	HEX_EDITOR_ACTION_DELETE
+	HEX_EDITOR_ACTION_BACKSPACE
+	HEX_EDITOR_ACTION_UNDO
+	HEX_EDITOR_ACTION_COMMIT
+	HEX_EDITOR_ACTION_CANCEL
+)
+
+type HexEditorMsg struct {
+	action HexEditorAction
+	input  rune
+	move   int
+}
+
+func CreateHexEditorCmd(msg HexEditorMsg) tea.Cmd {
+	return func() tea.Msg {
+		return msg
+	}
+}
+
+type StartHexEditorMsg struct{}
+
+func StartHexEditorCmd() tea.Msg {
+	return StartHexEditorMsg{}
+}
+
+// HexEditorOpenMsg tells the proxy view whether the hex editor of the message
+// view is open, in which case the keys are handled by the HexEditorKeyMap.
+type HexEditorOpenMsg bool
+
+func CreateHexEditorOpenCmd(open bool) tea.Cmd {
+	return func() tea.Msg {
+		return HexEditorOpenMsg(open)
+	}
+}
+
+// HexEditorKeyMap is the key map used while the message view hex editor is
+// open.
+type HexEditorKeyMap struct {
+	Up,
+	Down,
+	Left,
+	Right,
+	LineBegin,
+	LineEnd,
+	SwitchSide,
+	ToggleInsert,
+	Delete,
+	Backspace,
+	Undo,
+	Commit,
+	Cancel,
+	Quit key.Binding
+}
+
+func NewHexEditorKeyMap() *HexEditorKeyMap {
+	return &HexEditorKeyMap{
+		Up: key.NewBinding(
+			key.WithKeys("up"),
+			key.WithHelp(symbols.CurrentMap[symbols.ScArrowUp], "move up"),
+		),
+		Down: key.NewBinding(
+			key.WithKeys("down"),
+			key.WithHelp(symbols.CurrentMap[symbols.ScArrowDown], "move down"),
+		),
+		Left: key.NewBinding(
+			key.WithKeys("left"),
+			key.WithHelp(symbols.CurrentMap[symbols.ScArrowLeft], "move left"),
+		),
+		Right: key.NewBinding(
+			key.WithKeys("right"),
+			key.WithHelp(symbols.CurrentMap[symbols.ScArrowRight], "move right"),
+		),
+		LineBegin: key.NewBinding(
+			key.WithKeys("home"),
+			key.WithHelp("home", "line begin"),
+		),
+		LineEnd: key.NewBinding(
+			key.WithKeys("end"),
+			key.WithHelp("end", "line end"),
+		),
+		SwitchSide: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "switch hex/ascii"),
+		),
+		ToggleInsert: key.NewBinding(
+			key.WithKeys("insert", "ctrl+o"),
+			key.WithHelp("ctrl+o", "toggle insert"),
+		),
+		Delete: key.NewBinding(
+			key.WithKeys("delete"),
+			key.WithHelp("del", "delete byte"),
+		),
+		Backspace: key.NewBinding(
+			key.WithKeys("backspace"),
+			key.WithHelp("backspace", "delete previous byte"),
+		),
+		Undo: key.NewBinding(
+			key.WithKeys("ctrl+z"),
+			key.WithHelp("ctrl+z", "undo"),
+		),
+		Commit: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "save"),
+		),
+		Cancel: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "cancel"),
+		),
+		Quit: key.NewBinding(
+			key.WithKeys("ctrl+c"),
+			key.WithHelp("ctrl+c", "quit"),
+		),
+	}
+}
+
+func (k *HexEditorKeyMap) Handle(model tea.Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	editorMsg := HexEditorMsg{action: HEX_EDITOR_ACTION_MOVE}
+
+	switch {
+	case key.Matches(msg, k.Up):
+		editorMsg.move = -HEX_EDITOR_BYTES_PER_LINE
+	case key.Matches(msg, k.Down):
+		editorMsg.move = HEX_EDITOR_BYTES_PER_LINE
+	case key.Matches(msg, k.Left):
+		editorMsg.move = -1
+	case key.Matches(msg, k.Right):
+		editorMsg.move = 1
+	case key.Matches(msg, k.LineBegin):
+		editorMsg.action = HEX_EDITOR_ACTION_LINE_BEGIN
+	case key.Matches(msg, k.LineEnd):
+		editorMsg.action = HEX_EDITOR_ACTION_LINE_END
+	case key.Matches(msg, k.SwitchSide):
+		editorMsg.action = HEX_EDITOR_ACTION_SWITCH_SIDE
+	case key.Matches(msg, k.ToggleInsert):
+		editorMsg.action = HEX_EDITOR_ACTION_TOGGLE_INSERT
+	case key.Matches(msg, k.Delete):
+		editorMsg.action = HEX_EDITOR_ACTION_DELETE
+	case key.Matches(msg, k.Backspace):
+		editorMsg.action = HEX_EDITOR_ACTION_BACKSPACE
+	case key.Matches(msg, k.Undo):
+		editorMsg.action = HEX_EDITOR_ACTION_UNDO
+	case key.Matches(msg, k.Commit):
+		editorMsg.action = HEX_EDITOR_ACTION_COMMIT
+	case key.Matches(msg, k.Cancel):
+		editorMsg.action = HEX_EDITOR_ACTION_CANCEL
+	case key.Matches(msg, k.Quit):
+		return model, tea.Quit
+	case msg.Type == tea.KeyRunes && len(msg.Runes) == 1:
+		editorMsg.action = HEX_EDITOR_ACTION_INPUT
+		editorMsg.input = msg.Runes[0]
+	case msg.Type == tea.KeySpace:
+		editorMsg.action = HEX_EDITOR_ACTION_INPUT
+		editorMsg.input = ' '
+	default:
+		return model, nil
+	}
+
+	return model, CreateHexEditorCmd(editorMsg)
+}
+
+func (k HexEditorKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Commit, k.Cancel, k.SwitchSide, k.ToggleInsert, k.Undo}
+}
+
+func (k HexEditorKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.Up, k.Down, k.Left, k.Right},
+		{k.LineBegin, k.LineEnd},
+		{k.SwitchSide, k.ToggleInsert},
+		{k.Delete, k.Backspace, k.Undo},
+		{k.Commit, k.Cancel, k.Quit},
+	}
+}
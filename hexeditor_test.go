@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHexEditorOverwrite(t *testing.T) {
+	e := NewHexEditor([]byte("abc"))
+
+	assert.NoError(t, e.Input('4'))
+	assert.NoError(t, e.Input('1'))
+	assert.Equal(t, []byte("Abc"), e.Buffer())
+
+	e.SwitchSide()
+	assert.NoError(t, e.Input('B'))
+	assert.Error(t, e.Input('é'))
+	assert.Equal(t, []byte("ABc"), e.Buffer())
+
+	e.MoveCursor(10)
+	assert.NoError(t, e.Input('d'))
+	assert.Equal(t, []byte("ABcd"), e.Buffer())
+}
+
+func TestHexEditorInsertAndDelete(t *testing.T) {
+	e := NewHexEditor([]byte("ac"))
+	e.ToggleInsertMode()
+	e.MoveCursor(1)
+
+	assert.NoError(t, e.Input('6'))
+	assert.NoError(t, e.Input('2'))
+	assert.Equal(t, []byte("abc"), e.Buffer())
+
+	e.Backspace()
+	assert.Equal(t, []byte("ac"), e.Buffer())
+
+	e.Delete()
+	assert.Equal(t, []byte("a"), e.Buffer())
+
+	assert.True(t, e.Undo())
+	assert.True(t, e.Undo())
+	assert.Equal(t, []byte("abc"), e.Buffer())
+	assert.True(t, e.Undo())
+	assert.Equal(t, []byte("ac"), e.Buffer())
+	assert.False(t, e.Undo())
+}
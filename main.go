@@ -2,14 +2,22 @@ package main
 
 import (
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/proxycore"
+	"github.com/Denloob/protocol-proxy/styles"
+	"github.com/Denloob/protocol-proxy/transform"
+
 	"github.com/Denloob/protocol-proxy/symbols"
 	"github.com/Denloob/protocol-proxy/tcpmessage"
 
@@ -24,12 +32,60 @@ type Args struct {
 	inPort  int
 	outPort int
 	outIP   string
+
+	headless       bool
+	headlessFormat HeadlessFormat
+	headlessOutput string
+
+	apiAddress string
+
+	editFormat EditFormat
+
+	// dissector is the dissector of every connection, nil for raw framing or
+	// when detectProtocol is set.
+	dissector      dissector.Dissector
+	detectProtocol bool
+
+	// protobufRegistry holds the types of -proto-descriptors, by which gRPC
+	// messages are decoded by their method.
+	protobufRegistry *dissector.ProtobufRegistry
+	// protobufMessage is the type by which messages are shown as protobuf,
+	// nil to show them by their wire format or gRPC method.
+	protobufMessage *dissector.ProtobufMessage
+
+	// template is the template of -template, by which messages are decoded,
+	// nil if there's none.
+	template *dissector.Template
+
+	// decodeChain is the initial decode chain by which payloads are viewed
+	// and edited.
+	decodeChain transform.Chain
+
+	// hexdump is the initial layout of the hexdump display method.
+	hexdump HexdumpConfig
+
+	// rules are the rules of -rules, by which new messages are dropped or
+	// rewritten, nil if there are none.
+	rules Rules
 }
 
 func getArgs() Args {
 	inPortPtr := flag.Int("in-port", 0, "The in port on which to listen")
 	outPortPtr := flag.Int("out-port", 0, "The out port to which to output")
 	outIPPtr := flag.String("out-ip", "127.0.0.1", "The out ip to which to output")
+	headlessPtr := flag.Bool("headless", false, "Run without the TUI, auto transmitting and logging every message")
+	headlessFormatPtr := flag.String("headless-format", "hexdump", "The format in which to log messages in headless mode (hexdump, hex, strings or json)")
+	headlessOutputPtr := flag.String("headless-output", "", "The file to which to log messages in headless mode (default stdout)")
+	editFormatPtr := flag.String("edit-format", "hexdump", "The format in which messages are opened in $EDITOR (hexdump, escaped or raw)")
+	protocolPtr := flag.String("protocol", "auto", "The protocol by which messages are framed and dissected (auto, raw, or one of "+strings.Join(dissector.Names(), ", ")+")")
+	protoDescriptorsPtr := flag.String("proto-descriptors", "", "Comma separated FileDescriptorSet files (protoc --descriptor_set_out) by which protobuf messages are decoded")
+	protoMessagePtr := flag.String("proto-message", "", "The fully qualified type of protobuf messages, from -proto-descriptors")
+	templatePtr := flag.String("template", "", "A YAML template by which messages of custom protocols are decoded to fields")
+	decodeChainPtr := flag.String("decode-chain", "", "Comma separated steps by which payloads are decoded for viewing and editing ("+strings.Join(transform.Names(), ", ")+")")
+	hexdumpWidthPtr := flag.Int("hexdump-width", 0, "The number of bytes per line of the hexdump display, 0 to fit the window")
+	hexdumpGroupPtr := flag.Int("hexdump-group", 1, "The number of bytes grouped together in the hexdump display (1, 2, 4 or 8)")
+	rulesPtr := flag.String("rules", "", "A YAML file of rules by which new messages are dropped or rewritten before they're transmitted")
+	apiAddressPtr := flag.String("api", "", "The address (host:port or unix:/path/to/socket) on which to serve the HTTP control API")
 	flag.Parse()
 
 	if *inPortPtr == 0 || *outPortPtr == 0 {
@@ -39,39 +95,126 @@ func getArgs() Args {
 		os.Exit(1)
 	}
 
-	return Args{*inPortPtr, *outPortPtr, *outIPPtr}
-}
+	headlessFormat, err := ParseHeadlessFormat(*headlessFormatPtr)
+	if err != nil {
+		fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+		fmt.Println("Run with -help for usage.")
+
+		os.Exit(1)
+	}
 
-func forward(source io.Reader, dest io.Writer, handleTransmittion func([]byte) []byte) {
-	for {
-		buffer := make([]byte, 1<<16)
-		size, err := source.Read(buffer)
+	editFormat, err := ParseEditFormat(*editFormatPtr)
+	if err != nil {
+		fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+		fmt.Println("Run with -help for usage.")
+
+		os.Exit(1)
+	}
+
+	var protocolDissector dissector.Dissector
+	if *protocolPtr != "auto" && *protocolPtr != "raw" {
+		protocolDissector, err = dissector.Lookup(*protocolPtr)
 		if err != nil {
-			log.Fatalf("Read failed: %v", err)
+			fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+			fmt.Println("Run with -help for usage.")
+
+			os.Exit(1)
 		}
+	}
 
-		go func() {
-			newBuffer := handleTransmittion(buffer[:size])
-			if len(newBuffer) == 0 { // Message dropped, skip
-				return
-			}
+	var protoDescriptors []string
+	if *protoDescriptorsPtr != "" {
+		protoDescriptors = strings.Split(*protoDescriptorsPtr, ",")
+	}
 
-			_, err := dest.Write(newBuffer)
+	protobufRegistry, err := LoadProtobufDescriptors(protoDescriptors)
+	var protobufMessage *dissector.ProtobufMessage
+	if err == nil && *protoMessagePtr != "" {
+		protobufMessage, err = protobufRegistry.Message(*protoMessagePtr)
+	}
+	if err != nil {
+		fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+		fmt.Println("Run with -help for usage.")
 
-			if err != nil {
-				log.Printf("Write failed: %v", err)
-			}
-		}()
+		os.Exit(1)
 	}
-}
 
-type editBufferInEditorMsg struct {
-	newBuffer []byte
-	err       error
+	var template *dissector.Template
+	if *templatePtr != "" {
+		template, err = LoadTemplate(*templatePtr)
+		if err != nil {
+			fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+			fmt.Println("Run with -help for usage.")
+
+			os.Exit(1)
+		}
+	}
+
+	decodeChain, err := transform.ParseChain(*decodeChainPtr)
+	if err != nil {
+		fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+		fmt.Println("Run with -help for usage.")
+
+		os.Exit(1)
+	}
+
+	var rules Rules
+	if *rulesPtr != "" {
+		rules, err = LoadRules(*rulesPtr)
+		if err != nil {
+			fmt.Printf("%v: %v\n", strings.Join(os.Args, " "), err)
+			fmt.Println("Run with -help for usage.")
+
+			os.Exit(1)
+		}
+	}
+
+	if *hexdumpWidthPtr < 0 || !slices.Contains(HEXDUMP_GROUP_SIZES, *hexdumpGroupPtr) {
+		fmt.Printf("%v: invalid hexdump width %d or group %d\n", strings.Join(os.Args, " "), *hexdumpWidthPtr, *hexdumpGroupPtr)
+		fmt.Println("Run with -help for usage.")
+
+		os.Exit(1)
+	}
+
+	return Args{
+		inPort:  *inPortPtr,
+		outPort: *outPortPtr,
+		outIP:   *outIPPtr,
+
+		headless:       *headlessPtr,
+		headlessFormat: headlessFormat,
+		headlessOutput: *headlessOutputPtr,
+
+		apiAddress: *apiAddressPtr,
+
+		editFormat: editFormat,
+
+		dissector:      protocolDissector,
+		detectProtocol: *protocolPtr == "auto",
+
+		protobufRegistry: protobufRegistry,
+		protobufMessage:  protobufMessage,
+
+		template: template,
+
+		decodeChain: decodeChain,
+
+		hexdump: HexdumpConfig{BytesPerLine: *hexdumpWidthPtr, GroupSize: *hexdumpGroupPtr},
+
+		rules: rules,
+	}
 }
 
-func editBufferInEditor(buffer []byte) (tea.Cmd, error) {
-	tempfile, err := os.CreateTemp("", "hexdump*.bin")
+// editBufferInEditor opens buffer in $EDITOR. Once the editor exits, the
+// returned command returns the message created by onDone from the edited
+// buffer.
+func editBufferInEditor(buffer []byte, fileSuffix string, onDone func(newBuffer []byte, err error) tea.Msg) (tea.Cmd, error) {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		return nil, fmt.Errorf("$EDITOR is not set, use the hex editor instead")
+	}
+
+	tempfile, err := os.CreateTemp("", "hexdump*"+fileSuffix)
 	if err != nil {
 		return nil, err
 	}
@@ -81,18 +224,36 @@ func editBufferInEditor(buffer []byte) (tea.Cmd, error) {
 	tempfile.Write(buffer)
 	tempfile.Close()
 
-	editor := os.Getenv("EDITOR")
-
 	cmd := exec.Command(editor, filename)
 
 	return tea.ExecProcess(cmd, func(err error) tea.Msg {
 		defer os.Remove(tempfile.Name())
 
+		if err != nil {
+			return onDone(nil, err)
+		}
+
 		newBuffer, err := os.ReadFile(filename)
-		return editBufferInEditorMsg{newBuffer, err}
+		return onDone(newBuffer, err)
 	}), nil
 }
 
+type editMessageInEditorMsg struct {
+	message    *tcpmessage.TCPMessage
+	format     EditFormat
+	text       []byte
+	editedText []byte
+	err        error
+}
+
+// editMessageInEditor opens text, the message encoded in the given format, in
+// $EDITOR.
+func editMessageInEditor(message *tcpmessage.TCPMessage, format EditFormat, text []byte) (tea.Cmd, error) {
+	return editBufferInEditor(text, format.FileSuffix(), func(editedText []byte, err error) tea.Msg {
+		return editMessageInEditorMsg{message, format, text, editedText, err}
+	})
+}
+
 type KeyMap interface {
 	Handle(model tea.Model, keyMsg tea.KeyMsg) (tea.Model, tea.Cmd)
 
@@ -110,10 +271,37 @@ type MainKeyMap struct {
 	DisplayHex,
 	DisplayHexdump,
 	DisplayStrings,
+	DisplayText,
+	DisplayBase64,
+	DisplayCArray,
+	DisplayGoLiteral,
+	DisplayPythonBytes,
+	DisplayBinary,
+	DisplayDecimal,
+	DisplayDiff,
+	DisplayDissected,
+	DisplayProtobuf,
+	DisplayTLS,
+	DisplayStructured,
+	DisplayTemplate,
+	PreviousField,
+	NextField,
+	EditField,
+	EditDecodeChain,
+	CycleHexdumpGroup,
+	ToggleHexdumpOffsets,
+	Search,
+	IncreaseStringsMinLength,
+	DecreaseStringsMinLength,
+	GroupByStream,
 	Drop,
 	Transmit,
 	ToggleAutoTransmit,
-	Edit key.Binding
+	Edit,
+	HexEdit,
+	Undo,
+	Redo,
+	Revert key.Binding
 }
 
 func NewMainKeymap() *MainKeyMap {
@@ -138,6 +326,46 @@ func NewMainKeymap() *MainKeyMap {
 			key.WithKeys("s"),
 			key.WithHelp("s", "show message strings"),
 		),
+		DisplayText: key.NewBinding(
+			key.WithKeys("v"),
+			key.WithHelp("v", "show message as formatted text"),
+		),
+		DisplayBase64: key.NewBinding(
+			key.WithKeys("b"),
+			key.WithHelp("b", "show message base64"),
+		),
+		DisplayCArray: key.NewBinding(
+			key.WithKeys("C"),
+			key.WithHelp("C", "show message as a C array"),
+		),
+		DisplayGoLiteral: key.NewBinding(
+			key.WithKeys("G"),
+			key.WithHelp("G", "show message as a Go literal"),
+		),
+		DisplayPythonBytes: key.NewBinding(
+			key.WithKeys("Y"),
+			key.WithHelp("Y", "show message as Python bytes"),
+		),
+		DisplayBinary: key.NewBinding(
+			key.WithKeys("B"),
+			key.WithHelp("B", "show message binary"),
+		),
+		DisplayDecimal: key.NewBinding(
+			key.WithKeys("N"),
+			key.WithHelp("N", "show message decimal bytes"),
+		),
+		DisplayDiff: key.NewBinding(
+			key.WithKeys("D"),
+			key.WithHelp("D", "show message edits diff"),
+		),
+		DisplayDissected: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "show dissected message"),
+		),
+		DisplayProtobuf: key.NewBinding(
+			key.WithKeys("P"),
+			key.WithHelp("P", "show message as protobuf"),
+		),
 		Up: key.NewBinding(
 			key.WithKeys("k", "up"),
 			key.WithHelp(symbols.CurrentMap[symbols.ScArrowUp]+"/k", "move up"),
@@ -154,6 +382,58 @@ func NewMainKeymap() *MainKeyMap {
 			key.WithKeys("J", "shift+down"),
 			key.WithHelp(symbols.CurrentMap[symbols.ScShift]+"+"+symbols.CurrentMap[symbols.ScArrowDown]+"/J", "move down"),
 		),
+		DisplayTLS: key.NewBinding(
+			key.WithKeys("L"),
+			key.WithHelp("L", "show TLS handshake"),
+		),
+		DisplayStructured: key.NewBinding(
+			key.WithKeys("M"),
+			key.WithHelp("M", "show message as MessagePack/CBOR/BSON"),
+		),
+		DisplayTemplate: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "show message by template"),
+		),
+		PreviousField: key.NewBinding(
+			key.WithKeys("["),
+			key.WithHelp("[", "select previous field"),
+		),
+		NextField: key.NewBinding(
+			key.WithKeys("]"),
+			key.WithHelp("]", "select next field"),
+		),
+		EditField: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "edit selected field in $EDITOR"),
+		),
+		EditDecodeChain: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "edit payload decode chain"),
+		),
+		CycleHexdumpGroup: key.NewBinding(
+			key.WithKeys("w"),
+			key.WithHelp("w", "cycle hexdump byte grouping"),
+		),
+		ToggleHexdumpOffsets: key.NewBinding(
+			key.WithKeys("O"),
+			key.WithHelp("O", "toggle hexdump decimal offsets"),
+		),
+		Search: key.NewBinding(
+			key.WithKeys("/"),
+			key.WithHelp("/", "search bytes in hexdump"),
+		),
+		IncreaseStringsMinLength: key.NewBinding(
+			key.WithKeys("+"),
+			key.WithHelp("+", "increase strings min length"),
+		),
+		DecreaseStringsMinLength: key.NewBinding(
+			key.WithKeys("-"),
+			key.WithHelp("-", "decrease strings min length"),
+		),
+		GroupByStream: key.NewBinding(
+			key.WithKeys("g"),
+			key.WithHelp("g", "toggle showing only the selected stream"),
+		),
 		Drop: key.NewBinding(
 			key.WithKeys("d"),
 			key.WithHelp("d", "drop"),
@@ -170,11 +450,27 @@ func NewMainKeymap() *MainKeyMap {
 			key.WithKeys("e"),
 			key.WithHelp("e", "edit"),
 		),
+		HexEdit: key.NewBinding(
+			key.WithKeys("E"),
+			key.WithHelp("E", "edit in hex editor"),
+		),
+		Undo: key.NewBinding(
+			key.WithKeys("u"),
+			key.WithHelp("u", "undo edit"),
+		),
+		Redo: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "redo edit"),
+		),
+		Revert: key.NewBinding(
+			key.WithKeys("U"),
+			key.WithHelp("U", "revert to original"),
+		),
 	}
 }
 
 func (k *MainKeyMap) Handle(model tea.Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	proxy := model.(*Proxy)
+	proxy := model.(*ProxyModel)
 	selectedMessageChanged := false
 
 	switch {
@@ -182,9 +478,8 @@ func (k *MainKeyMap) Handle(model tea.Model, msg tea.KeyMsg) (tea.Model, tea.Cmd
 		return proxy, tea.Quit
 	case key.Matches(msg, k.Help):
 		return proxy, ShowFullHelpCmd
-	case key.Matches(msg, k.Up) && proxy.selectedMessageIndex > 0:
-		proxy.selectedMessageIndex--
-		selectedMessageChanged = true
+	case key.Matches(msg, k.Up):
+		selectedMessageChanged = proxy.moveSelection(-1)
 	case key.Matches(msg, k.MessageUp):
 		return proxy, CreateScrollMessageViewCmd(ScrollMessageViewUp)
 	case key.Matches(msg, k.MessageDown):
@@ -195,12 +490,60 @@ func (k *MainKeyMap) Handle(model tea.Model, msg tea.KeyMsg) (tea.Model, tea.Cmd
 		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_HEXDUMP)
 	case key.Matches(msg, k.DisplayStrings):
 		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_STRINGS)
-	case key.Matches(msg, k.Down) && proxy.selectedMessageIndex < len(proxy.messages)-1:
-		proxy.selectedMessageIndex++
-		selectedMessageChanged = true
+	case key.Matches(msg, k.DisplayText):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_TEXT)
+	case key.Matches(msg, k.DisplayBase64):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_BASE64)
+	case key.Matches(msg, k.DisplayCArray):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_C_ARRAY)
+	case key.Matches(msg, k.DisplayGoLiteral):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_GO_LITERAL)
+	case key.Matches(msg, k.DisplayPythonBytes):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_PYTHON_BYTES)
+	case key.Matches(msg, k.DisplayBinary):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_BINARY)
+	case key.Matches(msg, k.DisplayDecimal):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_DECIMAL)
+	case key.Matches(msg, k.DisplayDiff):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_DIFF)
+	case key.Matches(msg, k.DisplayDissected):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_DISSECTED)
+	case key.Matches(msg, k.DisplayProtobuf):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_PROTOBUF)
+	case key.Matches(msg, k.DisplayTLS):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_TLS)
+	case key.Matches(msg, k.DisplayStructured):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_STRUCTURED)
+	case key.Matches(msg, k.DisplayTemplate):
+		return proxy, CreateChangeMessageDisplayMethodCmd(MESSAGE_DISPLAY_METHOD_TEMPLATE)
+	case key.Matches(msg, k.PreviousField):
+		return proxy, CreateSelectFieldCmd(-1)
+	case key.Matches(msg, k.NextField):
+		return proxy, CreateSelectFieldCmd(1)
+	case key.Matches(msg, k.EditField):
+		return proxy, EditFieldCmd
+	case key.Matches(msg, k.CycleHexdumpGroup):
+		return proxy, CreateHexdumpOptionCmd(HEXDUMP_OPTION_GROUP_SIZE)
+	case key.Matches(msg, k.ToggleHexdumpOffsets):
+		return proxy, CreateHexdumpOptionCmd(HEXDUMP_OPTION_DECIMAL_OFFSETS)
+	case key.Matches(msg, k.Search):
+		return proxy, SearchCmd
+	case key.Matches(msg, k.IncreaseStringsMinLength):
+		return proxy, CreateStringsMinLengthCmd(1)
+	case key.Matches(msg, k.DecreaseStringsMinLength):
+		return proxy, CreateStringsMinLengthCmd(-1)
+	case key.Matches(msg, k.EditDecodeChain):
+		return proxy, StartEditDecodeChain(proxy.decodeChain)
+	case key.Matches(msg, k.Down):
+		selectedMessageChanged = proxy.moveSelection(1)
+	case key.Matches(msg, k.GroupByStream):
+		proxy.toggleStreamFilter()
 	case key.Matches(msg, k.ToggleAutoTransmit):
-		return proxy, CreateAutoTransmitCmd(!proxy.AutoTransmit())
-	case key.Matches(msg, k.Drop), key.Matches(msg, k.Transmit), key.Matches(msg, k.Edit):
+		return proxy, CreateAutoTransmitCmd(!proxy.core.AutoTransmit())
+	case key.Matches(msg, k.HexEdit):
+		return proxy, StartHexEditorCmd
+	case key.Matches(msg, k.Drop), key.Matches(msg, k.Transmit), key.Matches(msg, k.Edit),
+		key.Matches(msg, k.Undo), key.Matches(msg, k.Redo), key.Matches(msg, k.Revert):
 		message, err := proxy.SelectedMessage()
 		if err != nil {
 			log.Println(err)
@@ -221,14 +564,32 @@ func (k *MainKeyMap) Handle(model tea.Model, msg tea.KeyMsg) (tea.Model, tea.Cmd
 				return proxy, nil
 			}
 		case key.Matches(msg, k.Edit):
-			messageContent := message.Content()
-			cmd, err := editBufferInEditor(messageContent)
+			content, err := EditableContent(message, proxy.decodeChain)
+			if err != nil {
+				log.Println(err)
+				return proxy, nil
+			}
+
+			messageText := EncodeForEdit(content, proxy.editFormat)
+			cmd, err := editMessageInEditor(message, proxy.editFormat, messageText)
 			if err != nil {
 				log.Printf("Edit in editor error: %s\n", err)
 				return proxy, nil
 			}
 
 			return proxy, cmd
+		case key.Matches(msg, k.Undo):
+			if err := message.Undo(); err != nil {
+				log.Printf("Undo error: %s\n", err)
+			}
+		case key.Matches(msg, k.Redo):
+			if err := message.Redo(); err != nil {
+				log.Printf("Redo error: %s\n", err)
+			}
+		case key.Matches(msg, k.Revert):
+			if err := message.Revert(); err != nil {
+				log.Printf("Revert error: %s\n", err)
+			}
 		}
 	}
 
@@ -247,10 +608,16 @@ func (k MainKeyMap) ShortHelp() []key.Binding {
 func (k MainKeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down},
-		{k.Transmit, k.Edit, k.Drop},
+		{k.Transmit, k.Edit, k.HexEdit, k.Drop},
+		{k.Undo, k.Redo, k.Revert},
 		{k.ToggleAutoTransmit},
-		{k.MessageUp, k.MessageDown},
-		{k.DisplayHex, k.DisplayHexdump, k.DisplayStrings},
+		{k.MessageUp, k.MessageDown, k.GroupByStream},
+		{k.DisplayHex, k.DisplayHexdump, k.DisplayStrings, k.DisplayText, k.DisplayDiff, k.DisplayDissected, k.DisplayProtobuf, k.DisplayTLS, k.DisplayStructured, k.DisplayTemplate},
+		{k.DisplayBase64, k.DisplayCArray, k.DisplayGoLiteral, k.DisplayPythonBytes, k.DisplayBinary, k.DisplayDecimal},
+		{k.PreviousField, k.NextField, k.EditField},
+		{k.CycleHexdumpGroup, k.ToggleHexdumpOffsets, k.Search},
+		{k.IncreaseStringsMinLength, k.DecreaseStringsMinLength},
+		{k.EditDecodeChain},
 		{k.Quit, k.Help},
 	}
 }
@@ -263,6 +630,19 @@ const (
 	MESSAGE_DISPLAY_METHOD_HEXDUMP MessageDisplayMethod = iota
 	MESSAGE_DISPLAY_METHOD_STRINGS
 	MESSAGE_DISPLAY_METHOD_HEX
+	MESSAGE_DISPLAY_METHOD_DIFF
+	MESSAGE_DISPLAY_METHOD_DISSECTED
+	MESSAGE_DISPLAY_METHOD_PROTOBUF
+	MESSAGE_DISPLAY_METHOD_TLS
+	MESSAGE_DISPLAY_METHOD_STRUCTURED
+	MESSAGE_DISPLAY_METHOD_TEMPLATE
+	MESSAGE_DISPLAY_METHOD_TEXT
+	MESSAGE_DISPLAY_METHOD_BASE64
+	MESSAGE_DISPLAY_METHOD_C_ARRAY
+	MESSAGE_DISPLAY_METHOD_GO_LITERAL
+	MESSAGE_DISPLAY_METHOD_PYTHON_BYTES
+	MESSAGE_DISPLAY_METHOD_BINARY
+	MESSAGE_DISPLAY_METHOD_DECIMAL
 )
 
 func CreateChangeMessageDisplayMethodCmd(method MessageDisplayMethod) tea.Cmd {
@@ -277,12 +657,52 @@ type MessageViewModel struct {
 	displayMethod MessageDisplayMethod
 	windowSize    tea.WindowSizeMsg
 	scroll        int
+
+	// hexEditor is the editor of the viewed message, nil when not editing.
+	hexEditor *HexEditor
+
+	// protobufRegistry and protobufMessage are the types of messages shown
+	// as protobuf, see Args.
+	protobufRegistry *dissector.ProtobufRegistry
+	protobufMessage  *dissector.ProtobufMessage
+
+	// dissectionField is the selected field of the field tree of the
+	// dissected display method.
+	dissectionField int
+
+	// template is the template of Args, and templateField is the selected
+	// row of its field table.
+	template      *dissector.Template
+	templateField int
+
+	// decodeChain decodes the payload of the viewed message before it's
+	// displayed, and encodes it back after it's edited.
+	decodeChain transform.Chain
+
+	// hexdump is the layout of the hexdump display method, and search is the
+	// pattern highlighted in it.
+	hexdump HexdumpConfig
+	search  []byte
+
+	// stringsMinLength is the minimal number of characters of the strings
+	// of the strings display method.
+	stringsMinLength int
 }
 
 type ViewMessageMsg struct {
 	message *tcpmessage.TCPMessage
 }
 
+// StringsMinLengthMsg changes the minimal length of the strings of the
+// strings display method by the given delta.
+type StringsMinLengthMsg int
+
+func CreateStringsMinLengthCmd(delta int) tea.Cmd {
+	return func() tea.Msg {
+		return StringsMinLengthMsg(delta)
+	}
+}
+
 type ScrollMessageViewMsg int
 
 const (
@@ -316,16 +736,218 @@ func (m *MessageViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case ViewMessageMsg:
 		m.viewedMessage = msg.message
 		m.scroll = 0
+		m.dissectionField = 0
+		m.templateField = 0
 	case MessageDisplayMethod:
 		m.displayMethod = msg
 		m.scroll = 0
+	case DecodeChainMsg:
+		m.decodeChain = msg.chain
+		m.scroll = 0
+		m.templateField = 0
+	case HexdumpOptionMsg:
+		switch msg {
+		case HEXDUMP_OPTION_GROUP_SIZE:
+			m.hexdump = m.hexdump.NextGroupSize()
+		case HEXDUMP_OPTION_DECIMAL_OFFSETS:
+			m.hexdump.DecimalOffsets = !m.hexdump.DecimalOffsets
+		}
+		m.scroll = Clamp(m.scroll, 0, m.maxScroll())
+	case StringsMinLengthMsg:
+		m.stringsMinLength = max(m.stringsMinLength+int(msg), 1)
+		m.scroll = Clamp(m.scroll, 0, m.maxScroll())
+	case SearchMsg:
+		return m, StartEditSearch(m.search)
+	case editSearchInEditorMsg:
+		pattern, ok, cmd := FinishEditSearch(msg)
+		if ok {
+			m.setSearch(pattern)
+		}
+		return m, cmd
 	case ScrollMessageViewMsg:
 		m.scroll += int(msg)
 		m.scroll = Clamp(m.scroll, 0, m.maxScroll())
+	case SelectFieldMsg:
+		if m.displayMethod == MESSAGE_DISPLAY_METHOD_DISSECTED || m.displayMethod == MESSAGE_DISPLAY_METHOD_HEXDUMP {
+			m.selectDissectionField(int(msg))
+		} else {
+			m.selectTemplateField(int(msg))
+		}
+	case EditFieldMsg:
+		switch {
+		case m.viewedMessage != nil && m.displayMethod == MESSAGE_DISPLAY_METHOD_DISSECTED:
+			return m, StartEditDissectionField(m.viewedMessage, m.dissectionField)
+		case m.viewedMessage != nil && m.template != nil && m.displayMethod == MESSAGE_DISPLAY_METHOD_TEMPLATE:
+			return m, StartEditTemplateField(m.viewedMessage, m.template, m.decodeChain, m.templateField)
+		default:
+			log.Println("Select a field in the dissected view (p) or the template view (y) to edit it")
+			return m, nil
+		}
+	case editDissectionFieldInEditorMsg:
+		return m, FinishEditDissectionField(msg)
+	case editTemplateFieldInEditorMsg:
+		return m, FinishEditTemplateField(msg, m.template)
+	case StartHexEditorMsg:
+		return m, m.startHexEditor()
+	case HexEditorMsg:
+		return m, m.updateHexEditor(msg)
 	}
 
 	return m, nil
 }
+
+func (m *MessageViewModel) selectTemplateField(delta int) {
+	if m.viewedMessage == nil || m.template == nil || m.displayMethod != MESSAGE_DISPLAY_METHOD_TEMPLATE {
+		return
+	}
+
+	payload, err := DecodedPayload(m.viewedMessage, m.decodeChain)
+	if err != nil {
+		return
+	}
+
+	rows, _ := decodeTemplate(payload, m.template)
+	m.templateField = Clamp(m.templateField+delta, 0, max(len(rows)-1, 0))
+
+	// Keep the selected row in view
+	line := TEMPLATE_TABLE_HEADER_LINES + m.templateField
+	if line < m.scroll {
+		m.scroll = line
+	} else if line >= m.scroll+m.windowSize.Height-1 {
+		m.scroll = line - m.windowSize.Height + 2
+	}
+	m.scroll = Clamp(m.scroll, 0, m.maxScroll())
+}
+
+func (m *MessageViewModel) selectDissectionField(delta int) {
+	if m.viewedMessage == nil {
+		return
+	}
+
+	m.dissectionField = Clamp(m.dissectionField+delta, 0, max(DissectionFieldCount(m.viewedMessage)-1, 0))
+
+	// Keep the selected field in view
+	var line int
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_HEXDUMP {
+		begin, _, ok := DissectionFieldRange(m.viewedMessage, m.dissectionField)
+		if !ok || len(m.decodeChain) != 0 {
+			return
+		}
+		line = HexdumpLineOf(m.viewedMessage.Content(), m.hexdump, m.windowSize.Width, begin)
+	} else {
+		_, line = renderDissection(m.viewedMessage, m.windowSize.Width, m.dissectionField)
+	}
+
+	if line < m.scroll {
+		m.scroll = line
+	} else if line >= m.scroll+m.windowSize.Height-1 {
+		m.scroll = line - m.windowSize.Height + 2
+	}
+	m.scroll = Clamp(m.scroll, 0, m.maxScroll())
+}
+
+// setSearch highlights the pattern in the hexdump, scrolling to its first
+// occurrence.
+func (m *MessageViewModel) setSearch(pattern []byte) {
+	m.search = pattern
+	if m.viewedMessage == nil || len(pattern) == 0 {
+		return
+	}
+
+	content, err := m.rawContent()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	hits := SearchHighlights(content, pattern)
+	log.Printf("Found %d occurrences of %q\n", len(hits), pattern)
+	if len(hits) == 0 {
+		return
+	}
+
+	m.displayMethod = MESSAGE_DISPLAY_METHOD_HEXDUMP
+	m.scroll = Clamp(HexdumpLineOf(content, m.hexdump, m.windowSize.Width, hits[0].Begin), 0, m.maxScroll())
+}
+
+// startHexEditor opens the hex editor on the viewed message, returning the
+// command which lets the proxy view know.
+func (m *MessageViewModel) startHexEditor() tea.Cmd {
+	if m.viewedMessage == nil {
+		log.Println("No message to edit")
+		return nil
+	}
+
+	if m.viewedMessage.Status() != tcpmessage.STATUS_PENDING {
+		log.Println("The message can no longer be edited.")
+		return nil
+	}
+
+	content, err := EditableContent(m.viewedMessage, m.decodeChain)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	m.hexEditor = NewHexEditor(content)
+	m.scroll = 0
+	return CreateHexEditorOpenCmd(true)
+}
+
+func (m *MessageViewModel) stopHexEditor() tea.Cmd {
+	m.hexEditor = nil
+	m.scroll = 0
+	return CreateHexEditorOpenCmd(false)
+}
+
+func (m *MessageViewModel) updateHexEditor(msg HexEditorMsg) tea.Cmd {
+	if m.hexEditor == nil {
+		return nil
+	}
+
+	switch msg.action {
+	case HEX_EDITOR_ACTION_INPUT:
+		if err := m.hexEditor.Input(msg.input); err != nil {
+			log.Println(err)
+		}
+	case HEX_EDITOR_ACTION_MOVE:
+		m.hexEditor.MoveCursor(msg.move)
+	case HEX_EDITOR_ACTION_LINE_BEGIN:
+		m.hexEditor.MoveCursorToLineBegin()
+	case HEX_EDITOR_ACTION_LINE_END:
+		m.hexEditor.MoveCursorToLineEnd()
+	case HEX_EDITOR_ACTION_SWITCH_SIDE:
+		m.hexEditor.SwitchSide()
+	case HEX_EDITOR_ACTION_TOGGLE_INSERT:
+		m.hexEditor.ToggleInsertMode()
+	case HEX_EDITOR_ACTION_DELETE:
+		m.hexEditor.Delete()
+	case HEX_EDITOR_ACTION_BACKSPACE:
+		m.hexEditor.Backspace()
+	case HEX_EDITOR_ACTION_UNDO:
+		if !m.hexEditor.Undo() {
+			log.Println("Nothing to undo")
+		}
+	case HEX_EDITOR_ACTION_COMMIT:
+		if err := SetEditedContent(m.viewedMessage, m.decodeChain, m.hexEditor.Buffer()); err != nil {
+			log.Println(err)
+		}
+		return m.stopHexEditor()
+	case HEX_EDITOR_ACTION_CANCEL:
+		return m.stopHexEditor()
+	}
+
+	// Keep the cursor in view
+	cursorLine := m.hexEditor.CursorLine()
+	if cursorLine < m.scroll {
+		m.scroll = cursorLine
+	} else if cursorLine >= m.scroll+m.windowSize.Height-1 {
+		m.scroll = cursorLine - m.windowSize.Height + 2
+	}
+	m.scroll = Clamp(m.scroll, 0, m.maxScroll())
+
+	return nil
+}
 func (m *MessageViewModel) View() string {
 	lines := strings.Split(m.renderWrapped(), "\n")
 
@@ -337,11 +959,27 @@ func (m *MessageViewModel) View() string {
 func (m *MessageViewModel) renderWrapped() string {
 	lines := strings.Split(m.render(), "\n")
 
-	// Wrap is not supported for hexdump display
-	if m.displayMethod != MESSAGE_DISPLAY_METHOD_HEXDUMP {
+	// Wrap is not supported for the diff hexdump, and the field tree
+	// displays wrap themselves
+	isHexdump := m.displayMethod == MESSAGE_DISPLAY_METHOD_DIFF
+	isFieldTree := m.displayMethod == MESSAGE_DISPLAY_METHOD_DISSECTED || m.displayMethod == MESSAGE_DISPLAY_METHOD_PROTOBUF ||
+		m.displayMethod == MESSAGE_DISPLAY_METHOD_TLS || m.displayMethod == MESSAGE_DISPLAY_METHOD_STRUCTURED ||
+		m.displayMethod == MESSAGE_DISPLAY_METHOD_TEMPLATE
+	if !isHexdump && !isFieldTree && m.hexEditor == nil {
+		// The raw formats are wrapped between their bytes, and the Python
+		// bytes literal fits itself to the width
+		wrap := WrapLine
+		switch m.displayMethod {
+		case MESSAGE_DISPLAY_METHOD_BASE64, MESSAGE_DISPLAY_METHOD_C_ARRAY, MESSAGE_DISPLAY_METHOD_GO_LITERAL,
+			MESSAGE_DISPLAY_METHOD_BINARY, MESSAGE_DISPLAY_METHOD_DECIMAL:
+			wrap = WrapWords
+		case MESSAGE_DISPLAY_METHOD_PYTHON_BYTES:
+			wrap = func(line string, _ int) []string { return []string{line} }
+		}
+
 		var wrappedLines [][]string
 		for _, line := range lines {
-			wrappedLines = append(wrappedLines, WrapLine(line, m.windowSize.Width))
+			wrappedLines = append(wrappedLines, wrap(line, m.windowSize.Width))
 		}
 		lines = Flatten(wrappedLines)
 	}
@@ -354,14 +992,119 @@ func (m *MessageViewModel) render() string {
 		return "No message to view"
 	}
 
-	messageContent := m.viewedMessage.Content()
+	if m.hexEditor != nil {
+		return m.hexEditor.Render()
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_DISSECTED {
+		return RenderDissection(m.viewedMessage, m.windowSize.Width, m.dissectionField)
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_TLS {
+		return RenderTLSHandshake(m.viewedMessage, m.windowSize.Width)
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_DIFF {
+		return m.renderDiff()
+	}
+
+	// The payload views show the payload decoded by the decode chain
+	payload, err := DecodedPayload(m.viewedMessage, m.decodeChain)
+	if err != nil {
+		return styles.Error.Render(err.Error())
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_TEMPLATE {
+		return RenderTemplate(payload, m.template, m.templateField, m.hexdump, m.windowSize.Width)
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_STRUCTURED {
+		return RenderStructured(payload, m.windowSize.Width)
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_PROTOBUF {
+		return RenderProtobuf(m.viewedMessage, payload, m.protobufRegistry, m.protobufMessage, m.windowSize.Width)
+	}
+
+	content, err := m.rawContent()
+	if err != nil {
+		return styles.Error.Render(err.Error())
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_HEXDUMP {
+		highlights := SearchHighlights(content, m.search)
+
+		// The field selected in the dissected view is highlighted when the
+		// whole content is shown
+		if begin, end, ok := DissectionFieldRange(m.viewedMessage, m.dissectionField); ok && len(m.decodeChain) == 0 {
+			highlights = append(highlights, HexdumpHighlight{begin, end, styles.Cursor})
+		}
+
+		return RenderHexdump(content, m.hexdump, m.windowSize.Width, highlights)
+	}
+
+	if m.displayMethod == MESSAGE_DISPLAY_METHOD_STRINGS {
+		header := styles.Summary.Render(fmt.Sprintf("Strings of at least %d characters", m.stringsMinLength))
+		return header + "\n" + RenderStrings(content, m.stringsMinLength)
+	}
+
+	return RenderMessageContent(content, m.displayMethod, m.windowSize.Width)
+}
+
+// rawContent returns the content shown by the raw display methods (such as
+// the hexdump), the payload of the viewed message decoded by the decode
+// chain, or its whole content without a chain.
+func (m *MessageViewModel) rawContent() ([]byte, error) {
+	if len(m.decodeChain) == 0 {
+		return m.viewedMessage.Content(), nil
+	}
+
+	return DecodedPayload(m.viewedMessage, m.decodeChain)
+}
 
-	switch m.displayMethod {
+// renderDiff renders the diff of the original and the current content of the
+// viewed message, or of their payloads decoded by the decode chain.
+func (m *MessageViewModel) renderDiff() string {
+	original, current := m.viewedMessage.OriginalContent(), m.viewedMessage.Content()
+	if len(m.decodeChain) == 0 {
+		return RenderHexdumpDiff(original, current)
+	}
+
+	original, err := m.decodeChain.Decode(contentPayload(m.viewedMessage, original))
+	if err != nil {
+		return styles.Error.Render(fmt.Sprintf("failed to decode the original payload by the decode chain: %v", err))
+	}
+	current, err = m.decodeChain.Decode(contentPayload(m.viewedMessage, current))
+	if err != nil {
+		return styles.Error.Render(fmt.Sprintf("failed to decode the payload by the decode chain: %v", err))
+	}
+
+	return RenderHexdumpDiff(original, current)
+}
+
+// RenderMessageContent renders the given message content using the given
+// display method. The formats which can't be wrapped are fitted to width, if
+// it's positive.
+func RenderMessageContent(messageContent []byte, displayMethod MessageDisplayMethod, width int) string {
+	switch displayMethod {
 	case MESSAGE_DISPLAY_METHOD_HEXDUMP:
 		return hex.Dump(messageContent)
 	case MESSAGE_DISPLAY_METHOD_STRINGS:
-		extractedStrings := ExtractStrings([]byte(messageContent), DEFAULT_EXTRACT_STRINGS_MIN_LENGTH)
-		return strings.Join(extractedStrings, "\n")
+		return RenderStrings(messageContent, DEFAULT_EXTRACT_STRINGS_MIN_LENGTH)
+	case MESSAGE_DISPLAY_METHOD_TEXT:
+		return RenderText(messageContent)
+	case MESSAGE_DISPLAY_METHOD_BASE64:
+		return RenderBase64(messageContent)
+	case MESSAGE_DISPLAY_METHOD_C_ARRAY:
+		return RenderCArray(messageContent)
+	case MESSAGE_DISPLAY_METHOD_GO_LITERAL:
+		return RenderGoLiteral(messageContent)
+	case MESSAGE_DISPLAY_METHOD_PYTHON_BYTES:
+		return RenderPythonBytes(messageContent, width)
+	case MESSAGE_DISPLAY_METHOD_BINARY:
+		return RenderBinary(messageContent)
+	case MESSAGE_DISPLAY_METHOD_DECIMAL:
+		return RenderDecimal(messageContent)
 	case MESSAGE_DISPLAY_METHOD_HEX:
 		return fmt.Sprintf("%x", messageContent)
 	default:
@@ -373,7 +1116,7 @@ type Model struct {
 	tui *boxer.Boxer
 }
 
-func MakeModel(proxy *Proxy, debugConsole *Console, messageView *MessageViewModel) Model {
+func MakeModel(proxy *ProxyModel, debugConsole *Console, messageView *MessageViewModel) Model {
 	m := Model{
 		tui: &boxer.Boxer{},
 	}
@@ -420,14 +1163,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		return m, m.UpdateNode(msg, "main")
-	case ViewMessageMsg, MessageDisplayMethod, ScrollMessageViewMsg:
+	case DecodeChainMsg:
+		return m, m.UpdateMultipleNodes(msg, "main", "messageView")
+	case ViewMessageMsg, MessageDisplayMethod, ScrollMessageViewMsg, HexdumpOptionMsg, StringsMinLengthMsg, SearchMsg, editSearchInEditorMsg, SelectFieldMsg, EditFieldMsg, editDissectionFieldInEditorMsg, editTemplateFieldInEditorMsg,
+		StartHexEditorMsg, HexEditorMsg:
 		return m, m.UpdateNode(msg, "messageView")
 	case tea.WindowSizeMsg:
 		m.tui.UpdateSize(msg)
 		m.UpdateNode(tea.WindowSizeMsg{Height: msg.Height/2 - 1, Width: msg.Width}, "main")
 		m.UpdateNode(tea.WindowSizeMsg{Height: msg.Height/4 - 1, Width: msg.Width}, "messageView")
 		m.UpdateNode(tea.WindowSizeMsg{Height: msg.Height/4 - 1, Width: msg.Width}, "debug")
-	case TickMsg, editBufferInEditorMsg, ShowFullHelpMsg, AutoTransmitMsg:
+	case TickMsg, editMessageInEditorMsg, editDecodeChainInEditorMsg, ShowFullHelpMsg, AutoTransmitMsg, HexEditorOpenMsg:
 		return m, m.UpdateNode(msg, "main")
 	}
 	return m, nil
@@ -438,17 +1184,60 @@ func (m Model) View() string {
 }
 
 func main() {
+	if err := run(); err != nil {
+		log.Printf("There's been an error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the proxy by the command line arguments until it's quit, returning
+// once everything is stopped, so that main can exit by the error.
+func run() error {
 	symbols.CurrentMap = symbols.DefaultMap
 
 	keyMap = NewMainKeymap()
 
-	proxy := NewProxy(getArgs())
+	args := getArgs()
+	proxy := proxycore.New(proxycore.Config{
+		ListenAddress: fmt.Sprintf(":%d", args.inPort),
+		ServerAddress: net.JoinHostPort(args.outIP, strconv.Itoa(args.outPort)),
+
+		Dissector:      args.dissector,
+		DetectProtocol: args.detectProtocol,
+	})
+	if args.rules != nil {
+		proxy.SetInterceptor(args.rules)
+	}
+
+	if args.apiAddress != "" {
+		listener, err := ListenAPI(args.apiAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen for the API: %w", err)
+		}
+		defer listener.Close()
+
+		go func() {
+			if err := NewAPIServer(proxy).Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("API server error: %v", err)
+			}
+		}()
+	}
+
+	if args.headless {
+		return RunHeadless(proxy, args)
+	}
+
+	if err := proxy.Start(); err != nil {
+		return fmt.Errorf("failed to start the proxy: %w", err)
+	}
+	defer proxy.Stop()
+
 	debugConsole := NewConsole("Debug Console")
+	// Errors are logged by main once the TUI is closed
+	defer log.SetOutput(log.Writer())
 	log.SetOutput(debugConsole)
 
-	program := tea.NewProgram(MakeModel(proxy, debugConsole, &MessageViewModel{}), tea.WithAltScreen())
-	if _, err := program.Run(); err != nil {
-		log.Printf("There's been an error: %v", err)
-		os.Exit(1)
-	}
+	program := tea.NewProgram(MakeModel(NewProxyModel(proxy, args.editFormat, args.decodeChain), debugConsole, &MessageViewModel{protobufRegistry: args.protobufRegistry, protobufMessage: args.protobufMessage, template: args.template, decodeChain: args.decodeChain, hexdump: args.hexdump, stringsMinLength: DEFAULT_EXTRACT_STRINGS_MIN_LENGTH}), tea.WithAltScreen())
+	_, err := program.Run()
+	return err
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/styles"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// LoadProtobufDescriptors loads the FileDescriptorSet files of paths into a
+// registry.
+func LoadProtobufDescriptors(paths []string) (*dissector.ProtobufRegistry, error) {
+	registry := dissector.NewProtobufRegistry()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := registry.AddDescriptorSet(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return registry, nil
+}
+
+// grpcMessageType returns the type of the gRPC message, by the method of its
+// HTTP/2 stream, or nil if it's unknown.
+func grpcMessageType(message *tcpmessage.TCPMessage, registry *dissector.ProtobufRegistry) *dissector.ProtobufMessage {
+	decoder, ok := message.Protocol().(dissector.HTTP2Decoder)
+	if !ok || decoder.Path() == "" {
+		return nil
+	}
+
+	request, response, err := registry.Method(decoder.Path())
+	if err != nil {
+		return nil
+	}
+
+	if message.Direction() == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+		return request
+	}
+	return response
+}
+
+// RenderProtobuf renders payload, the payload of the message, as a protobuf
+// message of the given type. If messageType is nil, gRPC messages are decoded by the type
+// of their method in registry, and other messages by their wire format alone.
+func RenderProtobuf(message *tcpmessage.TCPMessage, payload []byte, registry *dissector.ProtobufRegistry, messageType *dissector.ProtobufMessage, width int) string {
+	if messageType == nil {
+		messageType = grpcMessageType(message, registry)
+	}
+
+	fields, err := dissector.DecodeProtobufStream(payload, messageType)
+	if err != nil {
+		return fmt.Sprintf("Failed to decode the message as protobuf: %v", err)
+	}
+
+	typeName := "raw"
+	if messageType != nil {
+		typeName = messageType.Name
+	}
+
+	lines := []string{styles.FieldName.Render("protobuf: ") + styles.FieldValue.Render(typeName)}
+	for _, field := range fields {
+		lines = appendFieldLines(lines, field, DISSECTION_INDENT, width)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -1,15 +1,14 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log"
-	"net"
-	"sync"
 	"time"
 
+	"github.com/Denloob/protocol-proxy/proxycore"
 	"github.com/Denloob/protocol-proxy/styles"
 	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/Denloob/protocol-proxy/transform"
 
 	"github.com/charmbracelet/bubbles/help"
 	tea "github.com/charmbracelet/bubbletea"
@@ -21,72 +20,107 @@ func Tick() tea.Msg {
 	return TickMsg(time.Now())
 }
 
-type Proxy struct {
-	args                 Args
-	messages             []*tcpmessage.TCPMessage
-	messagesMutex         sync.RWMutex
+// ProxyModel is the TUI of a proxycore.Proxy, listing its messages.
+type ProxyModel struct {
+	core                 *proxycore.Proxy
 	selectedMessageIndex int
 	help                 help.Model
 	windowSize           tea.WindowSizeMsg
-	autoTransmit         bool
+	editFormat           EditFormat
+	summaries            SummaryCache
+
+	// decodeChain decodes the payloads of edited messages, see
+	// MessageViewModel.
+	decodeChain transform.Chain
+
+	// streamFilter is the stream whose messages are listed, nil to list all
+	// messages.
+	streamFilter *MessageStream
+
+	// hexEditorKeyMap handles the keys instead of keyMap while the hex
+	// editor of the message view is open.
+	hexEditorKeyMap KeyMap
+	hexEditorOpen   bool
 }
 
-func NewProxy(args Args) *Proxy {
-	return &Proxy{
-		args:                 args,
-		messages:             nil,
+func NewProxyModel(core *proxycore.Proxy, editFormat EditFormat, decodeChain transform.Chain) *ProxyModel {
+	return &ProxyModel{
+		core:                 core,
 		selectedMessageIndex: -1,
 		help:                 help.New(),
-		autoTransmit:         false,
+		editFormat:           editFormat,
+		summaries:            make(SummaryCache),
+		decodeChain:          decodeChain,
+		hexEditorKeyMap:      NewHexEditorKeyMap(),
 	}
 }
 
-func (p *Proxy) AutoTransmit() bool {
-	return p.autoTransmit
-}
+// activeKeyMap returns the key map which handles the keys.
+func (p *ProxyModel) activeKeyMap() KeyMap {
+	if p.hexEditorOpen {
+		return p.hexEditorKeyMap
+	}
 
-func (p *Proxy) SelectedMessage() (*tcpmessage.TCPMessage, error) {
-	p.messagesMutex.RLock()
-	defer p.messagesMutex.RUnlock()
+	return keyMap
+}
 
+func (p *ProxyModel) SelectedMessage() (*tcpmessage.TCPMessage, error) {
 	if p.selectedMessageIndex == -1 {
 		return nil, fmt.Errorf("no message selected")
 	}
 
-	return p.messages[p.selectedMessageIndex], nil
+	return p.core.Message(p.selectedMessageIndex)
 }
 
-func (p *Proxy) AddMessage(message *tcpmessage.TCPMessage) {
-	p.messagesMutex.Lock()
-	defer p.messagesMutex.Unlock()
+// isListed reports whether the message is listed under the stream filter.
+func (p *ProxyModel) isListed(message *tcpmessage.TCPMessage) bool {
+	if p.streamFilter == nil {
+		return true
+	}
 
-	p.messages = append(p.messages, message)
+	stream, ok := StreamOf(message)
+	return ok && stream == *p.streamFilter
 }
 
-func (proxy *Proxy) CreateTransmittionHandler(transmittionDirection tcpmessage.TransmittionDirection) func(buffer []byte) []byte {
-	return func(buffer []byte) []byte {
-		message := tcpmessage.New(transmittionDirection, buffer)
+// moveSelection selects the closest listed message in the given direction
+// (-1 for up, 1 for down), returning whether the selection changed.
+func (p *ProxyModel) moveSelection(direction int) bool {
+	messages := p.core.Messages()
+	for i := p.selectedMessageIndex + direction; 0 <= i && i < len(messages); i += direction {
+		if p.isListed(messages[i]) {
+			p.selectedMessageIndex = i
+			return true
+		}
+	}
 
-		proxy.AddMessage(message)
+	return false
+}
 
-		if proxy.AutoTransmit() {
-			message.MarkAsTransmited()
-		} else {
-			transmit := message.WaitForTransmittion()
-			if !transmit {
-				return nil
-			}
-		}
+// toggleStreamFilter lists only the messages of the stream of the selected
+// message, or all messages if they're already filtered.
+func (p *ProxyModel) toggleStreamFilter() {
+	if p.streamFilter != nil {
+		p.streamFilter = nil
+		return
+	}
 
-		return message.Content()
+	message, err := p.SelectedMessage()
+	if err != nil {
+		log.Println(err)
+		return
 	}
-}
 
-func (p *Proxy) Init() tea.Cmd {
-	return func() tea.Msg {
-		go p.Run()
-		return Tick()
+	stream, ok := StreamOf(message)
+	if !ok {
+		log.Println("The protocol of the message has no streams")
+		return
 	}
+
+	p.streamFilter = &stream
+}
+
+func (p *ProxyModel) Init() tea.Cmd {
+	return Tick
 }
 
 type ShowFullHelpMsg struct{}
@@ -103,51 +137,100 @@ func CreateAutoTransmitCmd(autoTransmit bool) tea.Cmd {
 	}
 }
 
-func (p *Proxy) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+func (p *ProxyModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds tea.BatchMsg
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		newProxy, cmd := keyMap.Handle(p, msg)
-		p = newProxy.(*Proxy)
+		newProxy, cmd := p.activeKeyMap().Handle(p, msg)
+		p = newProxy.(*ProxyModel)
 		cmds = append(cmds, cmd)
 	case tea.WindowSizeMsg:
 		p.windowSize = msg
 	case AutoTransmitMsg:
-		p.autoTransmit = bool(msg)
+		p.core.SetAutoTransmit(bool(msg))
+	case HexEditorOpenMsg:
+		p.hexEditorOpen = bool(msg)
 	case TickMsg:
 		cmds = append(cmds, Tick, p.tick())
 	case ShowFullHelpMsg:
 		p.help.ShowAll = !p.help.ShowAll
-	case editBufferInEditorMsg:
-		if msg.err != nil {
-			log.Printf("error during message editing: %v\n", msg.err)
-		} else {
-			err := Must(p.SelectedMessage()).SetContent(msg.newBuffer)
-			if err != nil {
-				log.Println(err)
-			}
-		}
+	case editMessageInEditorMsg:
+		cmds = append(cmds, p.finishEditInEditor(msg))
+	case editDecodeChainInEditorMsg:
+		cmds = append(cmds, FinishEditDecodeChain(msg))
+	case DecodeChainMsg:
+		p.decodeChain = msg.chain
 	}
 
 	return p, tea.Batch(cmds...)
 }
 
-func (p *Proxy) View() string {
-	p.messagesMutex.RLock()
-	defer p.messagesMutex.RUnlock()
+// finishEditInEditor sets the edited content of the message. If the edited
+// text can't be parsed, the editor is reopened with the error, until the edit
+// is canceled (see IsEditCanceled).
+func (p *ProxyModel) finishEditInEditor(msg editMessageInEditorMsg) tea.Cmd {
+	if msg.err != nil {
+		log.Printf("error during message editing: %v\n", msg.err)
+		return nil
+	}
+
+	if IsEditCanceled(msg.text, msg.editedText) {
+		log.Println("Message edit canceled")
+		return nil
+	}
+
+	newContent, err := DecodeEdited(msg.editedText, msg.format)
+	if err != nil {
+		log.Printf("Failed to parse the edited message: %v\n", err)
+
+		cmd, err := editMessageInEditor(msg.message, msg.format, AddEditError(msg.editedText, err))
+		if err != nil {
+			log.Printf("Edit in editor error: %s\n", err)
+		}
+
+		return cmd
+	}
+
+	if err := SetEditedContent(msg.message, p.decodeChain, newContent); err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
+
+func (p *ProxyModel) View() string {
+	messages := p.core.Messages()
+
+	var listed []int
+	selected := 0
+	for i, message := range messages {
+		if i == p.selectedMessageIndex {
+			selected = len(listed)
+		}
+		if p.isListed(message) || i == p.selectedMessageIndex {
+			listed = append(listed, i)
+		}
+	}
 
 	var res string
-	availableLines := p.windowSize.Height - CountLines(p.help.View(keyMap)) - 1
+	availableLines := p.windowSize.Height - CountLines(p.help.View(p.activeKeyMap())) - 1
+	if p.streamFilter != nil {
+		res += styles.Summary.Render(fmt.Sprintf("Stream %d of connection %d", p.streamFilter.stream, p.streamFilter.connectionID)) + "\n"
+		availableLines--
+	}
+	if len(p.decodeChain) > 0 {
+		res += styles.Summary.Render("Decode chain: "+p.decodeChain.String()) + "\n"
+		availableLines--
+	}
 
-	begin := p.selectedMessageIndex - availableLines/2
+	begin := selected - availableLines/2
 	begin = max(begin, 0)
 	end := begin + availableLines
-	end = min(end, len(p.messages))
-
-	for i, message := range p.messages[begin:end] {
-		i += begin // Offset i to be the index in p.messages instead the index in the [begin:end] slice
+	end = min(end, len(listed))
 
+	for _, i := range listed[begin:end] {
+		message := messages[i]
 		line := fmt.Sprintf("%d. %v", i+1, message)
 
 		style := styles.Unstyled
@@ -157,52 +240,24 @@ func (p *Proxy) View() string {
 		}
 
 		line = style.Render(line)
+		if summary := p.summaries.Summary(message); summary != "" {
+			line += " " + styles.Summary.Render(summary)
+		}
 		res += line + "\n"
 	}
 
-	return PutOnTheBottomOfView(res, p.help.View(keyMap), p.windowSize.Height)
-}
-
-func (proxy *Proxy) Run() {
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", proxy.args.inPort))
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer l.Close()
-
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		go func(inConn net.Conn) {
-			var dialer net.Dialer
-
-			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-			defer cancel()
-
-			outConn, err := dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", proxy.args.outIP, proxy.args.outPort))
-			if err != nil {
-				log.Fatalf("Failed to dial: %v", err)
-			}
-
-			go forward(inConn, outConn, proxy.CreateTransmittionHandler(tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER))
-			go forward(outConn, inConn, proxy.CreateTransmittionHandler(tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT))
-		}(conn)
-	}
+	return PutOnTheBottomOfView(res, p.help.View(p.activeKeyMap()), p.windowSize.Height)
 }
 
 // tick "ticks" the state of the Proxy, updating everything that should be
 // updated every tick
-func (p *Proxy) tick() tea.Cmd {
-	p.messagesMutex.RLock()
-	defer p.messagesMutex.RUnlock()
+func (p *ProxyModel) tick() tea.Cmd {
+	messages := p.core.Messages()
 
-	if len(p.messages) > 0 && p.selectedMessageIndex == -1 {
+	if len(messages) > 0 && p.selectedMessageIndex == -1 {
 		p.selectedMessageIndex = 0
 
-		return CreateViewMsgCmd(p.messages[p.selectedMessageIndex])
+		return CreateViewMsgCmd(messages[p.selectedMessageIndex])
 	}
 
 	return nil
@@ -0,0 +1,133 @@
+package proxycore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// MAX_FRAME_LENGTH bounds the amount of data held while waiting for the rest
+// of a frame. Once exceeded, the connection falls back to raw framing.
+const MAX_FRAME_LENGTH = 1 << 24
+
+// DETECTION_TIMEOUT bounds the time data is held for detection without more
+// data arriving. Once exceeded, detection is given up on, see
+// framer.stopDetection.
+const DETECTION_TIMEOUT = 500 * time.Millisecond
+
+type frame struct {
+	content []byte
+	decoder dissector.Decoder // nil when the frame can't be decoded
+}
+
+// framer frames both streams of a connection, using a dissector.Session when
+// the protocol is known, and by reads otherwise.
+type framer struct {
+	connectionID int
+
+	// detectProtocol is set while the protocol is yet to be detected. The data
+	// is held until it's long enough to be detected, see
+	// dissector.DetectPrefix.
+	detectProtocol bool
+	session        dissector.Session
+	mutex          sync.Mutex
+}
+
+func newFramer(connectionID int, config Config) *framer {
+	f := &framer{
+		connectionID:   connectionID,
+		detectProtocol: config.Dissector == nil && config.DetectProtocol,
+	}
+
+	if config.Dissector != nil {
+		f.session = config.Dissector.NewSession()
+	}
+
+	return f
+}
+
+// frame splits the complete frames off the beginning of data, which was sent
+// in the given direction, returning them along with the rest of data.
+func (f *framer) frame(direction tcpmessage.TransmittionDirection, data []byte) (frames []frame, rest []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.detectProtocol {
+		detected := dissector.Detect(direction, data)
+		if detected == nil && dissector.DetectPrefix(direction, data) {
+			return nil, data // Wait for the data needed for detection
+		}
+
+		f.detectProtocol = false // Only the beginning of the first stream is used for detection
+
+		if detected != nil {
+			f.session = detected.NewSession()
+		}
+	}
+
+	for len(data) > 0 && f.session != nil {
+		length, decoder, err := f.frameBySession(direction, data)
+		if err == nil && length == 0 && len(data) > MAX_FRAME_LENGTH {
+			err = errFrameTooLong
+		}
+
+		if err != nil {
+			log.Printf("Framing of connection %d failed, falling back to raw framing: %v", f.connectionID, err)
+			f.session = nil
+			break
+		}
+
+		if length == 0 { // Wait for the rest of the frame
+			return frames, data
+		}
+
+		frames = append(frames, frame{bytes.Clone(data[:length]), decoder})
+		data = data[length:]
+	}
+
+	if len(data) > 0 {
+		frames = append(frames, frame{content: data})
+	}
+
+	return frames, nil
+}
+
+// detecting reports whether the protocol is yet to be detected.
+func (f *framer) detecting() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.detectProtocol
+}
+
+// stopDetection gives up on detecting the protocol, framing data, which was
+// held for detection, by the session detected by the other direction if any,
+// and by reads otherwise.
+func (f *framer) stopDetection(direction tcpmessage.TransmittionDirection, data []byte) (frames []frame, rest []byte) {
+	f.mutex.Lock()
+	f.detectProtocol = false
+	f.mutex.Unlock()
+
+	return f.frame(direction, data)
+}
+
+// frameBySession calls the Frame of the session, turning its panics into
+// errors, so a connection which crashes its dissector falls back to raw
+// framing instead of crashing the proxy.
+func (f *framer) frameBySession(direction tcpmessage.TransmittionDirection, data []byte) (length int, decoder dissector.Decoder, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			length, decoder, err = 0, nil, fmt.Errorf("the dissector panicked: %v", r)
+		}
+	}()
+
+	return f.session.Frame(direction, data)
+}
+
+var errFrameTooLong = errors.New("frame is too long")
@@ -0,0 +1,508 @@
+// Package proxycore implements an intercepting TCP proxy. The streams of the
+// peers are framed, either by reads or by a dissector, and every frame becomes
+// a tcpmessage.TCPMessage, which is held until it's transmitted or dropped,
+// either manually, automatically or by an Interceptor.
+package proxycore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+const DIAL_TIMEOUT = time.Minute
+
+type Config struct {
+	// ListenAddress is the address on which to listen for clients, for
+	// example ":1337". Use port 0 to listen on a random port, see Proxy.Addr.
+	ListenAddress string
+
+	// ServerAddress is the address of the server to which the clients are
+	// proxied, for example "127.0.0.1:8080".
+	ServerAddress string
+
+	// Dissector frames the streams of the connections into protocol
+	// messages. If it's nil and DetectProtocol is set, the dissector is
+	// detected separately for every connection. Otherwise, every read becomes
+	// a message.
+	Dissector      dissector.Dissector
+	DetectProtocol bool
+}
+
+// MessageHandler is called with every new message the proxy receives, along
+// with the message's ID.
+type MessageHandler func(id int, message *tcpmessage.TCPMessage)
+
+type Verdict int
+
+const (
+	// VERDICT_PASS leaves the message pending, unless auto transmit is on.
+	VERDICT_PASS Verdict = iota
+	VERDICT_TRANSMIT
+	VERDICT_DROP
+)
+
+// Interceptor decides the fate of every new message before it's transmitted.
+// Intercept may edit the message with SetContent, but must not call Transmit
+// or Drop on it, returning the corresponding Verdict instead. It's called from
+// the goroutine which received the message, so it must be safe for concurrent
+// use.
+type Interceptor interface {
+	Intercept(id int, message *tcpmessage.TCPMessage) Verdict
+}
+
+// InterceptorFunc allows to use an ordinary function as an Interceptor.
+type InterceptorFunc func(id int, message *tcpmessage.TCPMessage) Verdict
+
+func (f InterceptorFunc) Intercept(id int, message *tcpmessage.TCPMessage) Verdict {
+	return f(id, message)
+}
+
+// Connection is a client connection proxied to the server.
+type Connection struct {
+	id         int
+	time       time.Time
+	clientConn net.Conn
+	serverConn net.Conn
+
+	framer *framer
+}
+
+func (c *Connection) ID() int {
+	return c.id
+}
+
+func (c *Connection) Time() time.Time {
+	return c.time
+}
+
+func (c *Connection) ClientAddr() net.Addr {
+	return c.clientConn.RemoteAddr()
+}
+
+func (c *Connection) ServerAddr() net.Addr {
+	return c.serverConn.RemoteAddr()
+}
+
+// Close closes both sides of the connection.
+func (c *Connection) Close() error {
+	return errors.Join(c.clientConn.Close(), c.serverConn.Close())
+}
+
+// source returns the connection from which messages sent in the given
+// direction are read.
+func (c *Connection) source(direction tcpmessage.TransmittionDirection) net.Conn {
+	switch direction {
+	case tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER:
+		return c.clientConn
+	case tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT:
+		return c.serverConn
+	default:
+		panic("Invalid direction")
+	}
+}
+
+// destination returns the connection to which messages sent in the given
+// direction are written.
+func (c *Connection) destination(direction tcpmessage.TransmittionDirection) net.Conn {
+	switch direction {
+	case tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER:
+		return c.serverConn
+	case tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT:
+		return c.clientConn
+	default:
+		panic("Invalid direction")
+	}
+}
+
+type Proxy struct {
+	config Config
+
+	listener  net.Listener
+	acceptWG  sync.WaitGroup
+	isRunning atomic.Bool
+
+	messages         []*tcpmessage.TCPMessage
+	messagesMutex    sync.RWMutex
+	connections      []*Connection
+	connectionsMutex sync.RWMutex
+
+	autoTransmit atomic.Bool
+	interceptor  atomic.Pointer[Interceptor]
+
+	subscribers      map[int]MessageHandler
+	nextSubscriberID int
+	subscribersMutex sync.Mutex
+}
+
+func New(config Config) *Proxy {
+	return &Proxy{
+		config:      config,
+		subscribers: make(map[int]MessageHandler),
+	}
+}
+
+// Start starts listening for clients and proxying them in the background.
+func (p *Proxy) Start() error {
+	if !p.isRunning.CompareAndSwap(false, true) {
+		return fmt.Errorf("the proxy is already running")
+	}
+
+	listener, err := net.Listen("tcp", p.config.ListenAddress)
+	if err != nil {
+		p.isRunning.Store(false)
+		return err
+	}
+	p.listener = listener
+
+	p.acceptWG.Add(1)
+	go func() {
+		defer p.acceptWG.Done()
+		p.acceptLoop()
+	}()
+
+	return nil
+}
+
+// Stop stops listening, closes all the connections and drops all the pending
+// messages.
+func (p *Proxy) Stop() error {
+	if !p.isRunning.CompareAndSwap(true, false) {
+		return fmt.Errorf("the proxy is not running")
+	}
+
+	err := p.listener.Close()
+	p.acceptWG.Wait()
+
+	for _, connection := range p.Connections() {
+		connection.Close()
+	}
+
+	for _, message := range p.Messages() {
+		message.Drop() // Only pending messages can be dropped, ignore the rest
+	}
+
+	return err
+}
+
+// Addr returns the address on which the proxy listens. Valid only after Start.
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+func (p *Proxy) AutoTransmit() bool {
+	return p.autoTransmit.Load()
+}
+
+func (p *Proxy) SetAutoTransmit(autoTransmit bool) {
+	p.autoTransmit.Store(autoTransmit)
+}
+
+// SetInterceptor sets the interceptor of new messages. nil removes it.
+func (p *Proxy) SetInterceptor(interceptor Interceptor) {
+	if interceptor == nil {
+		p.interceptor.Store(nil)
+		return
+	}
+
+	p.interceptor.Store(&interceptor)
+}
+
+// Subscribe registers handler to be called with every new message. The
+// handler is called from the goroutine which received the message, so it must
+// be safe for concurrent use. Call the returned function to unsubscribe.
+func (p *Proxy) Subscribe(handler MessageHandler) (unsubscribe func()) {
+	p.subscribersMutex.Lock()
+	defer p.subscribersMutex.Unlock()
+
+	id := p.nextSubscriberID
+	p.nextSubscriberID++
+	p.subscribers[id] = handler
+
+	return func() {
+		p.subscribersMutex.Lock()
+		defer p.subscribersMutex.Unlock()
+
+		delete(p.subscribers, id)
+	}
+}
+
+func (p *Proxy) notifySubscribers(id int, message *tcpmessage.TCPMessage) {
+	p.subscribersMutex.Lock()
+	defer p.subscribersMutex.Unlock()
+
+	for _, handler := range p.subscribers {
+		handler(id, message)
+	}
+}
+
+// addMessage adds the message to the proxy, returning its ID.
+func (p *Proxy) addMessage(message *tcpmessage.TCPMessage) (id int) {
+	p.messagesMutex.Lock()
+	defer p.messagesMutex.Unlock()
+
+	p.messages = append(p.messages, message)
+
+	return len(p.messages) - 1
+}
+
+// Messages returns a snapshot of all the messages. The index of a message is
+// its ID.
+func (p *Proxy) Messages() []*tcpmessage.TCPMessage {
+	p.messagesMutex.RLock()
+	defer p.messagesMutex.RUnlock()
+
+	return p.messages[:len(p.messages):len(p.messages)]
+}
+
+func (p *Proxy) Message(id int) (*tcpmessage.TCPMessage, error) {
+	p.messagesMutex.RLock()
+	defer p.messagesMutex.RUnlock()
+
+	if id < 0 || id >= len(p.messages) {
+		return nil, fmt.Errorf("no message with id %d", id)
+	}
+
+	return p.messages[id], nil
+}
+
+func (p *Proxy) addConnection(clientConn, serverConn net.Conn) *Connection {
+	p.connectionsMutex.Lock()
+	defer p.connectionsMutex.Unlock()
+
+	connection := &Connection{
+		id:         len(p.connections),
+		time:       time.Now(),
+		clientConn: clientConn,
+		serverConn: serverConn,
+	}
+	connection.framer = newFramer(connection.id, p.config)
+	p.connections = append(p.connections, connection)
+
+	return connection
+}
+
+// Connections returns a snapshot of all the connections. The index of a
+// connection is its ID.
+func (p *Proxy) Connections() []*Connection {
+	p.connectionsMutex.RLock()
+	defer p.connectionsMutex.RUnlock()
+
+	return p.connections[:len(p.connections):len(p.connections)]
+}
+
+func (p *Proxy) Connection(id int) (*Connection, error) {
+	p.connectionsMutex.RLock()
+	defer p.connectionsMutex.RUnlock()
+
+	if id < 0 || id >= len(p.connections) {
+		return nil, fmt.Errorf("no connection with id %d", id)
+	}
+
+	return p.connections[id], nil
+}
+
+// Inject sends content on the connection in the given direction, as if one of
+// the peers sent it. The injected message is added to the proxy as already
+// transmitted.
+func (p *Proxy) Inject(connection *Connection, direction tcpmessage.TransmittionDirection, content []byte) (id int, err error) {
+	message := tcpmessage.New(connection.id, direction, content)
+	message.MarkAsTransmited()
+
+	id = p.addMessage(message)
+	p.notifySubscribers(id, message)
+
+	_, err = connection.destination(direction).Write(content)
+
+	return id, err
+}
+
+// receiveFrame adds a message of the given frame and notifies the subscribers
+// about it.
+func (p *Proxy) receiveFrame(connection *Connection, direction tcpmessage.TransmittionDirection, frame frame) (int, *tcpmessage.TCPMessage) {
+	message := tcpmessage.New(connection.id, direction, frame.content)
+	if frame.decoder != nil {
+		message.SetProtocol(frame.decoder)
+	}
+
+	id := p.addMessage(message)
+	p.notifySubscribers(id, message)
+
+	return id, message
+}
+
+// handleTransmittion blocks until the message is either transmitted,
+// returning its final content, or dropped, returning nil.
+func (p *Proxy) handleTransmittion(id int, message *tcpmessage.TCPMessage) []byte {
+	verdict := VERDICT_PASS
+	if interceptor := p.interceptor.Load(); interceptor != nil {
+		verdict = (*interceptor).Intercept(id, message)
+	}
+
+	if verdict == VERDICT_PASS && p.AutoTransmit() {
+		verdict = VERDICT_TRANSMIT
+	}
+
+	switch verdict {
+	case VERDICT_TRANSMIT:
+		if message.MarkAsTransmited() == nil {
+			return message.Content()
+		}
+	case VERDICT_DROP:
+		if message.MarkAsDropped() == nil {
+			return nil
+		}
+	}
+
+	// Either nobody decided yet, or somebody else decided first, in which case
+	// they are notifying about their decision.
+	if !message.WaitForTransmittion() {
+		return nil
+	}
+
+	return message.Content()
+}
+
+// forward forwards everything sent in the given direction on the connection,
+// a frame at a time, until the source is closed. Frames are written in order,
+// once they're transmitted or dropped.
+func (p *Proxy) forward(connection *Connection, direction tcpmessage.TransmittionDirection) {
+	source, dest := connection.source(direction), connection.destination(direction)
+
+	previousWritten := make(chan struct{})
+	close(previousWritten)
+
+	// The messages are added right away, so they're listed in the order in
+	// which they were sent
+	transmitFrame := func(frame frame) {
+		id, message := p.receiveFrame(connection, direction, frame)
+		written := make(chan struct{})
+
+		go func(previousWritten <-chan struct{}) {
+			defer close(written)
+
+			newBuffer := p.handleTransmittion(id, message)
+			<-previousWritten
+
+			if len(newBuffer) == 0 { // Message dropped, skip
+				return
+			}
+
+			_, err := dest.Write(newBuffer)
+
+			if err != nil {
+				log.Printf("Write failed: %v", err)
+			}
+		}(previousWritten)
+
+		previousWritten = written
+	}
+
+	var pending []byte
+	for {
+		// Data held for detection is framed as is if nothing follows it
+		// in time, so that peers which send a short message and wait for a
+		// response don't stall
+		heldForDetection := len(pending) > 0 && connection.framer.detecting()
+		if heldForDetection {
+			source.SetReadDeadline(time.Now().Add(DETECTION_TIMEOUT))
+		}
+
+		buffer := make([]byte, 1<<16)
+		size, err := source.Read(buffer)
+		if heldForDetection {
+			source.SetReadDeadline(time.Time{})
+		}
+
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			var frames []frame
+			frames, pending = connection.framer.stopDetection(direction, pending)
+			for _, frame := range frames {
+				transmitFrame(frame)
+			}
+			continue
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Read failed: %v", err)
+			}
+			break
+		}
+
+		var frames []frame
+		frames, pending = connection.framer.frame(direction, append(pending, buffer[:size]...))
+		for _, frame := range frames {
+			transmitFrame(frame)
+		}
+	}
+
+	if len(pending) > 0 { // The stream ended in the middle of a frame
+		transmitFrame(frame{content: pending})
+	}
+
+	<-previousWritten
+
+	// Let the destination know nothing more will be sent, while still
+	// allowing it to respond.
+	if tcpDest, ok := dest.(*net.TCPConn); ok {
+		tcpDest.CloseWrite()
+	} else {
+		dest.Close()
+	}
+}
+
+func (p *Proxy) acceptLoop() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Accept failed: %v", err)
+			}
+			return
+		}
+
+		go p.proxyConnection(conn)
+	}
+}
+
+func (p *Proxy) proxyConnection(clientConn net.Conn) {
+	var dialer net.Dialer
+
+	ctx, cancel := context.WithTimeout(context.Background(), DIAL_TIMEOUT)
+	defer cancel()
+
+	serverConn, err := dialer.DialContext(ctx, "tcp", p.config.ServerAddress)
+	if err != nil {
+		log.Printf("Failed to dial: %v", err)
+		clientConn.Close()
+		return
+	}
+
+	connection := p.addConnection(clientConn, serverConn)
+
+	var forwardWG sync.WaitGroup
+	for _, direction := range []tcpmessage.TransmittionDirection{
+		tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER,
+		tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT,
+	} {
+		forwardWG.Add(1)
+		go func() {
+			defer forwardWG.Done()
+			p.forward(connection, direction)
+		}()
+	}
+
+	forwardWG.Wait()
+	connection.Close()
+}
@@ -0,0 +1,223 @@
+package proxycore
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer starts a server which echoes back everything it reads.
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func startProxy(t *testing.T, interceptor Interceptor) (*Proxy, net.Conn) {
+	server := startEchoServer(t)
+
+	proxy := New(Config{
+		ListenAddress: "127.0.0.1:0",
+		ServerAddress: server.Addr().String(),
+	})
+	proxy.SetInterceptor(interceptor)
+	require.NoError(t, proxy.Start())
+	t.Cleanup(func() { proxy.Stop() })
+
+	client, err := net.Dial("tcp", proxy.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	return proxy, client
+}
+
+func readWithTimeout(t *testing.T, conn net.Conn, size int) []byte {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buffer := make([]byte, size)
+	_, err := io.ReadFull(conn, buffer)
+	require.NoError(t, err)
+
+	return buffer
+}
+
+func TestInterceptorEditsMessages(t *testing.T) {
+	proxy, client := startProxy(t, InterceptorFunc(func(id int, message *tcpmessage.TCPMessage) Verdict {
+		if message.Direction() == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+			message.SetContent(bytes.ToUpper(message.Content()))
+		}
+
+		return VERDICT_TRANSMIT
+	}))
+
+	_, err := client.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("HELLO"), readWithTimeout(t, client, 5))
+
+	messages := proxy.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, messages[0].Direction())
+	assert.True(t, messages[0].Edited())
+	assert.Equal(t, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT, messages[1].Direction())
+	assert.Equal(t, []byte("HELLO"), messages[1].Content())
+	assert.Len(t, proxy.Connections(), 1)
+}
+
+func TestManualTransmit(t *testing.T) {
+	proxy, client := startProxy(t, nil)
+
+	newMessages := make(chan *tcpmessage.TCPMessage, 2)
+	proxy.Subscribe(func(id int, message *tcpmessage.TCPMessage) {
+		newMessages <- message
+	})
+
+	_, err := client.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	message := <-newMessages
+	assert.Equal(t, tcpmessage.STATUS_PENDING, message.Status())
+	require.NoError(t, message.Transmit())
+
+	require.NoError(t, (<-newMessages).Drop())
+	proxy.SetAutoTransmit(true)
+
+	_, err = client.Write([]byte("world"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("world"), readWithTimeout(t, client, 5))
+}
+
+func TestDissectorFraming(t *testing.T) {
+	server := startEchoServer(t)
+
+	proxy := New(Config{
+		ListenAddress: "127.0.0.1:0",
+		ServerAddress: server.Addr().String(),
+		Dissector:     dissector.HTTPDissector{},
+	})
+	proxy.SetAutoTransmit(true)
+	require.NoError(t, proxy.Start())
+	t.Cleanup(func() { proxy.Stop() })
+
+	client, err := net.Dial("tcp", proxy.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	first, second := "GET /a HTTP/1.1\r\n\r\n", "GET /b HTTP/1.1\r\n\r\n"
+	_, err = client.Write([]byte(first + second[:5]))
+	require.NoError(t, err)
+	_, err = client.Write([]byte(second[5:]))
+	require.NoError(t, err)
+
+	readWithTimeout(t, client, len(first+second))
+
+	var requests []string
+	for _, message := range proxy.Messages() {
+		if message.Direction() == tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER {
+			requests = append(requests, string(message.Content()))
+			assert.Equal(t, "http", message.Protocol().Name())
+		}
+	}
+	assert.Equal(t, []string{first, second}, requests)
+}
+
+func TestProtocolDetectionAcrossReads(t *testing.T) {
+	f := newFramer(0, Config{DetectProtocol: true})
+
+	frames, rest := f.frame(tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, []byte("GE"))
+	assert.Empty(t, frames)
+	assert.Equal(t, []byte("GE"), rest)
+
+	frames, rest = f.frame(tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, append(rest, "T / HTTP/1.1\r\n\r\n"...))
+	require.Len(t, frames, 1)
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(frames[0].content))
+	assert.Equal(t, "http", frames[0].decoder.Name())
+	assert.Empty(t, rest)
+
+	f = newFramer(0, Config{DetectProtocol: true})
+	frames, rest = f.frame(tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, []byte("hello"))
+	require.Len(t, frames, 1)
+	assert.Nil(t, frames[0].decoder)
+	assert.Empty(t, rest)
+}
+
+func TestShortGreetingIsNotHeldForDetection(t *testing.T) {
+	for _, greeting := range []string{"OK", "\x4a\x00"} { // The latter is a prefix of a MySQL handshake
+		server, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { server.Close() })
+
+		go func() {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			conn.Write([]byte(greeting))
+			io.Copy(io.Discard, conn) // Wait for a response which never comes
+		}()
+
+		proxy := New(Config{
+			ListenAddress:  "127.0.0.1:0",
+			ServerAddress:  server.Addr().String(),
+			DetectProtocol: true,
+		})
+		proxy.SetAutoTransmit(true)
+		require.NoError(t, proxy.Start())
+		t.Cleanup(func() { proxy.Stop() })
+
+		client, err := net.Dial("tcp", proxy.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+
+		assert.Equal(t, []byte(greeting), readWithTimeout(t, client, len(greeting)))
+
+		messages := proxy.Messages()
+		require.Len(t, messages, 1)
+		assert.Equal(t, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT, messages[0].Direction())
+		assert.Nil(t, messages[0].Protocol())
+	}
+}
+
+type panickingSession struct{}
+
+func (panickingSession) Frame(tcpmessage.TransmittionDirection, []byte) (int, dissector.Decoder, error) {
+	panic("index out of range")
+}
+
+func TestFramingPanicFallsBackToRaw(t *testing.T) {
+	f := newFramer(0, Config{})
+	f.session = panickingSession{}
+
+	frames, rest := f.frame(tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, []byte("hello"))
+	require.Len(t, frames, 1)
+	assert.Equal(t, []byte("hello"), frames[0].content)
+	assert.Nil(t, frames[0].decoder)
+	assert.Empty(t, rest)
+	assert.Nil(t, f.session)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// RAW_FORMAT_INDENT is the indentation of the bytes of the array and literal
+// raw formats.
+const RAW_FORMAT_INDENT = "    "
+
+// The raw formats render the bytes of a message as space separated words,
+// which renderWrapped wraps between words, to fit the width of the view. The
+// Python bytes literal can't be wrapped, so it's fitted to the width itself.
+
+// RenderBase64 renders content in base64.
+func RenderBase64(content []byte) string {
+	return base64.StdEncoding.EncodeToString(content)
+}
+
+// joinBytes formats every byte of content and joins them by separator.
+func joinBytes(content []byte, format string, separator string) string {
+	formatted := make([]string, len(content))
+	for i, b := range content {
+		formatted[i] = fmt.Sprintf(format, b)
+	}
+
+	return strings.Join(formatted, separator)
+}
+
+// RenderCArray renders content as the initializer of a C unsigned char array.
+func RenderCArray(content []byte) string {
+	return fmt.Sprintf("unsigned char message[%d] = {\n%s%s\n};", len(content), RAW_FORMAT_INDENT, joinBytes(content, "0x%02x", ", "))
+}
+
+// RenderGoLiteral renders content as a Go []byte literal.
+func RenderGoLiteral(content []byte) string {
+	if len(content) == 0 {
+		return "[]byte{}"
+	}
+
+	return fmt.Sprintf("[]byte{\n%s%s,\n}", RAW_FORMAT_INDENT, joinBytes(content, "0x%02x", ", "))
+}
+
+// pythonByteEscape returns b as it's written in a Python bytes literal.
+func pythonByteEscape(b byte) string {
+	switch {
+	case b == '\\' || b == '"':
+		return `\` + string(b)
+	case b == '\n':
+		return `\n`
+	case b == '\r':
+		return `\r`
+	case b == '\t':
+		return `\t`
+	case IsCharacter(b):
+		return string(b)
+	default:
+		return fmt.Sprintf(`\x%02x`, b)
+	}
+}
+
+// pythonLiterals splits content to Python bytes literals, after every newline
+// and before a literal gets wider than width (unless it has a single byte).
+// If width <= 0, literals are only split after newlines.
+func pythonLiterals(content []byte, width int) []string {
+	var literals []string
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() != 0 {
+			literals = append(literals, `b"`+literal.String()+`"`)
+			literal.Reset()
+		}
+	}
+
+	for _, b := range content {
+		escape := pythonByteEscape(b)
+		if width > 0 && literal.Len() != 0 && len(`b"`)+literal.Len()+len(escape)+len(`"`) > width {
+			flush()
+		}
+
+		literal.WriteString(escape)
+		if b == '\n' {
+			flush()
+		}
+	}
+	flush()
+
+	return literals
+}
+
+// RenderPythonBytes renders content as a Python bytes literal, with escapes
+// for non-printable bytes. Content of many lines, or wider than width (if
+// it's positive), is rendered as many literals concatenated in parentheses,
+// split after newlines and to fit the width.
+func RenderPythonBytes(content []byte, width int) string {
+	literals := pythonLiterals(content, width)
+	switch len(literals) {
+	case 0:
+		return `b""`
+	case 1:
+		return literals[0]
+	}
+
+	// The literals are split again to fit the width with their indentation
+	if width > 0 {
+		literals = pythonLiterals(content, max(width-len(RAW_FORMAT_INDENT), 1))
+	}
+	return "(\n" + RAW_FORMAT_INDENT + strings.Join(literals, "\n"+RAW_FORMAT_INDENT) + "\n)"
+}
+
+// RenderBinary renders the bits of every byte of content.
+func RenderBinary(content []byte) string {
+	return joinBytes(content, "%08b", " ")
+}
+
+// RenderDecimal renders every byte of content in decimal.
+func RenderDecimal(content []byte) string {
+	return joinBytes(content, "%d", " ")
+}
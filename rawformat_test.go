@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawFormats(t *testing.T) {
+	content := []byte("hi\r\n\"\x00")
+
+	assert.Equal(t, "aGkNCiIA", RenderBase64(content))
+	assert.Equal(t, "unsigned char message[6] = {\n    0x68, 0x69, 0x0d, 0x0a, 0x22, 0x00\n};", RenderCArray(content))
+	assert.Equal(t, "[]byte{\n    0x68, 0x69, 0x0d, 0x0a, 0x22, 0x00,\n}", RenderGoLiteral(content))
+	assert.Equal(t, "(\n    b\"hi\\r\\n\"\n    b\"\\\"\\x00\"\n)", RenderPythonBytes(content, 0))
+	assert.Equal(t, `b"a\\"`, RenderPythonBytes([]byte(`a\`), 0))
+	assert.Equal(t, `b""`, RenderPythonBytes(nil, 0))
+
+	// Split to fit 11 columns, without splitting escapes
+	assert.Equal(t, `b"hi\r\n"`, RenderPythonBytes([]byte("hi\r\n"), 11))
+	assert.Equal(t, "(\n    b\"abcd\"\n    b\"\\x00\"\n    b\"e\"\n)", RenderPythonBytes([]byte("abcd\x00e"), 11))
+
+	assert.Equal(t, "01101000 00000000", RenderBinary([]byte("h\x00")))
+	assert.Equal(t, "104 0 255", RenderDecimal([]byte("h\x00\xff")))
+}
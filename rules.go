@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"github.com/Denloob/protocol-proxy/proxycore"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"gopkg.in/yaml.v3"
+)
+
+type RuleAction int
+
+const (
+	RULE_ACTION_REPLACE RuleAction = iota
+	RULE_ACTION_DROP
+)
+
+// Rule drops or rewrites the messages whose content contains Match.
+type Rule struct {
+	// Direction is to_server or to_client, empty for both.
+	Direction string `yaml:"direction"`
+	Match     string `yaml:"match"`
+	// Action is replace (the default) or drop.
+	Action string `yaml:"action"`
+	// Replace replaces every occurrence of Match.
+	Replace string `yaml:"replace"`
+
+	action     RuleAction
+	directions []tcpmessage.TransmittionDirection
+}
+
+// Rules is an Interceptor which applies its rules to every message, in order.
+// Messages which aren't dropped pass (see proxycore.VERDICT_PASS).
+type Rules []*Rule
+
+// ParseRules parses YAML rules, such as:
+//
+//   - {match: "GET /admin", action: drop}
+//   - {direction: to_client, match: "Server: nginx", replace: "Server: proxy"}
+func ParseRules(data []byte) (Rules, error) {
+	var rules Rules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	if len(rules) == 0 {
+		return nil, errors.New("there are no rules")
+	}
+
+	for i, rule := range rules {
+		if rule.Match == "" {
+			return nil, fmt.Errorf("rule %d has nothing to match", i)
+		}
+
+		switch rule.Direction {
+		case "":
+			rule.directions = []tcpmessage.TransmittionDirection{tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT}
+		case "to_server":
+			rule.directions = []tcpmessage.TransmittionDirection{tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER}
+		case "to_client":
+			rule.directions = []tcpmessage.TransmittionDirection{tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT}
+		default:
+			return nil, fmt.Errorf("rule %d has invalid direction %q, expected to_server or to_client", i, rule.Direction)
+		}
+
+		switch rule.Action {
+		case "", "replace":
+			rule.action = RULE_ACTION_REPLACE
+		case "drop":
+			rule.action = RULE_ACTION_DROP
+			if rule.Replace != "" {
+				return nil, fmt.Errorf("rule %d drops, so it can't replace", i)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d has invalid action %q, expected replace or drop", i, rule.Action)
+		}
+	}
+
+	return rules, nil
+}
+
+// LoadRules loads the YAML rules at path.
+func LoadRules(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := ParseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return rules, nil
+}
+
+func (rules Rules) Intercept(id int, message *tcpmessage.TCPMessage) proxycore.Verdict {
+	content := message.Content()
+	edited := false
+	for _, rule := range rules {
+		if !slices.Contains(rule.directions, message.Direction()) || !bytes.Contains(content, []byte(rule.Match)) {
+			continue
+		}
+
+		if rule.action == RULE_ACTION_DROP {
+			return proxycore.VERDICT_DROP
+		}
+
+		content = bytes.ReplaceAll(content, []byte(rule.Match), []byte(rule.Replace))
+		edited = true
+	}
+
+	if edited && !bytes.Equal(content, message.Content()) {
+		if err := message.SetContent(content); err != nil {
+			log.Printf("Failed to apply the rules to message %d: %v", id, err)
+		}
+	}
+
+	return proxycore.VERDICT_PASS
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Denloob/protocol-proxy/proxycore"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"- {action: drop}",
+		"- {match: a, direction: sideways}",
+		"- {match: a, action: reject}",
+		"- {match: a, action: drop, replace: b}",
+	} {
+		_, err := ParseRules([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestRulesIntercept(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+- {match: "GET /admin", action: drop}
+- {direction: to_client, match: "nginx", replace: "proxy"}
+- {match: "\r\n", replace: "\n"}
+`))
+	require.NoError(t, err)
+
+	message := tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, []byte("GET /admin HTTP/1.1\r\n"))
+	assert.Equal(t, proxycore.VERDICT_DROP, rules.Intercept(0, message))
+	assert.Equal(t, []byte("GET /admin HTTP/1.1\r\n"), message.Content())
+
+	message = tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_SERVER, []byte("Via: nginx\r\n"))
+	assert.Equal(t, proxycore.VERDICT_PASS, rules.Intercept(1, message))
+	assert.Equal(t, []byte("Via: nginx\n"), message.Content())
+
+	message = tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT, []byte("Server: nginx\r\n"))
+	assert.Equal(t, proxycore.VERDICT_PASS, rules.Intercept(2, message))
+	assert.Equal(t, []byte("Server: proxy\n"), message.Content())
+	assert.Equal(t, 1, message.CurrentVersion())
+
+	message = tcpmessage.New(0, tcpmessage.TRANSMITTION_DIRECTION_TO_CLIENT, []byte("unmatched"))
+	assert.Equal(t, proxycore.VERDICT_PASS, rules.Intercept(3, message))
+	assert.Equal(t, 0, message.CurrentVersion())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// HexdumpOptionMsg changes an option of the hexdump of the message view.
+type HexdumpOptionMsg int
+
+const (
+	HEXDUMP_OPTION_GROUP_SIZE HexdumpOptionMsg = iota
+	HEXDUMP_OPTION_DECIMAL_OFFSETS
+)
+
+func CreateHexdumpOptionCmd(option HexdumpOptionMsg) tea.Cmd {
+	return func() tea.Msg {
+		return option
+	}
+}
+
+// SearchMsg opens the pattern searched in the hexdump of the message view in
+// $EDITOR.
+type SearchMsg struct{}
+
+func SearchCmd() tea.Msg {
+	return SearchMsg{}
+}
+
+type editSearchInEditorMsg struct {
+	text       []byte
+	editedText []byte
+	err        error
+}
+
+// editSearchInEditor opens text, a search pattern, in $EDITOR.
+func editSearchInEditor(text []byte) (tea.Cmd, error) {
+	return editBufferInEditor(text, ".txt", func(editedText []byte, err error) tea.Msg {
+		return editSearchInEditorMsg{text, editedText, err}
+	})
+}
+
+// StartEditSearch opens pattern in $EDITOR, as a quoted string.
+func StartEditSearch(pattern []byte) tea.Cmd {
+	header := EDIT_COMMENT_PREFIX + " The bytes to search for, as quoted strings (Go escapes are supported) or hex. Empty to stop searching.\n"
+
+	text := header
+	if len(pattern) != 0 {
+		text += strconv.Quote(string(pattern)) + "\n"
+	}
+
+	cmd, err := editSearchInEditor([]byte(text))
+	if err != nil {
+		log.Printf("Edit in editor error: %s\n", err)
+	}
+
+	return cmd
+}
+
+// ParseSearchPattern parses a search pattern, either quoted strings (see
+// UnescapeLines) or hex bytes. Lines beginning with EDIT_COMMENT_PREFIX are
+// ignored.
+func ParseSearchPattern(text string) ([]byte, error) {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(line, EDIT_COMMENT_PREFIX) {
+			lines = append(lines, line)
+		}
+	}
+
+	text = strings.TrimSpace(strings.Join(lines, "\n"))
+	if strings.HasPrefix(text, `"`) {
+		return UnescapeLines(text)
+	}
+
+	return hex.DecodeString(strings.Join(strings.Fields(text), ""))
+}
+
+// FinishEditSearch parses the edited search pattern, returning whether it's
+// valid. If it isn't, the returned command reopens the editor with the error.
+func FinishEditSearch(msg editSearchInEditorMsg) ([]byte, bool, tea.Cmd) {
+	if msg.err != nil {
+		log.Printf("error during search editing: %v\n", msg.err)
+		return nil, false, nil
+	}
+
+	if IsEditCanceled(msg.text, msg.editedText) {
+		log.Println("Search edit canceled")
+		return nil, false, nil
+	}
+
+	pattern, err := ParseSearchPattern(string(msg.editedText))
+	if err != nil {
+		log.Printf("Failed to parse the search pattern: %v\n", err)
+
+		cmd, err := editSearchInEditor(AddEditError(msg.editedText, err))
+		if err != nil {
+			log.Printf("Edit in editor error: %s\n", err)
+		}
+
+		return nil, false, cmd
+	}
+
+	return pattern, true, nil
+}
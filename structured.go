@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/styles"
+)
+
+// RenderStructured renders the payload of a message as a JSON like tree,
+// decoded by the self-describing binary format (MessagePack, CBOR or BSON)
+// it's detected to be. If only a part of the payload is valid, the valid part
+// is rendered, followed by the offset of the error.
+func RenderStructured(payload []byte, width int) string {
+	format, values, err := dissector.DetectStructured(payload)
+	if format == "" {
+		return fmt.Sprintf("Failed to decode the message: %v", err)
+	}
+
+	header := format
+	if len(values) != 1 {
+		header += fmt.Sprintf(" (%d values)", len(values))
+	}
+
+	lines := []string{styles.FieldName.Render("format: ") + styles.FieldValue.Render(header)}
+	for i, value := range values {
+		lines = appendStructuredLines(lines, "", value, "", i == len(values)-1, width)
+	}
+
+	if err != nil {
+		var structuredErr *dissector.StructuredError
+		if errors.As(err, &structuredErr) {
+			err = structuredErr.Err
+			lines = append(lines, styles.Error.Render(fmt.Sprintf("Error at offset %d (0x%x): %v", structuredErr.Offset, structuredErr.Offset, err)))
+		} else {
+			lines = append(lines, styles.Error.Render(fmt.Sprintf("Error: %v", err)))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// appendStructuredLines appends the lines of value, prefixed by key and
+// indented by indent, to lines. Values other than the last are followed by a
+// comma.
+func appendStructuredLines(lines []string, key string, value *dissector.StructuredValue, indent string, last bool, width int) []string {
+	if key != "" {
+		key += ": "
+	}
+
+	open, close := "", ""
+	if value.Tag != "" {
+		open, close = value.Tag+"(", ")"
+	}
+	if !last {
+		close += ","
+	}
+
+	if value.Kind == dissector.STRUCTURED_KIND_SCALAR {
+		// Values are wrapped before they're styled, as the styles break the
+		// wrap
+		prefix := indent + key + open
+		valueLines := WrapLine(value.Text+close, max(width-len(prefix), 1))
+
+		lines = append(lines, indent+styles.FieldName.Render(key)+open+styles.FieldValue.Render(valueLines[0]))
+		for _, valueLine := range valueLines[1:] {
+			lines = append(lines, strings.Repeat(" ", len(prefix))+styles.FieldValue.Render(valueLine))
+		}
+		return lines
+	}
+
+	brackets := "[]"
+	if value.Kind == dissector.STRUCTURED_KIND_MAP {
+		brackets = "{}"
+	}
+
+	if len(value.Elements) == 0 {
+		return append(lines, indent+styles.FieldName.Render(key)+open+brackets+close)
+	}
+
+	lines = append(lines, indent+styles.FieldName.Render(key)+open+brackets[:1])
+	for i, element := range value.Elements {
+		elementKey := ""
+		if value.Kind == dissector.STRUCTURED_KIND_MAP {
+			elementKey = value.Keys[i]
+		}
+
+		lines = appendStructuredLines(lines, elementKey, element, indent+DISSECTION_INDENT, i == len(value.Elements)-1, width)
+	}
+
+	return append(lines, indent+brackets[1:]+close)
+}
@@ -16,3 +16,53 @@ var Unfocused = lipgloss.NewStyle().
 var UnfocusedSelected = lipgloss.NewStyle().
 	Bold(true).
 	Foreground(lipgloss.Color("#AEAEAE"))
+
+var Cursor = lipgloss.NewStyle().
+	Reverse(true)
+
+var SecondaryCursor = lipgloss.NewStyle().
+	Underline(true)
+
+var DiffInserted = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#5FD75F"))
+
+var DiffRemoved = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FF5F5F")).
+	Strikethrough(true)
+
+var DiffChanged = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FFD75F"))
+
+var FieldName = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#87AFFF"))
+
+var FieldValue = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#D7D7AF"))
+
+var Summary = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#AAAAAA"))
+
+var Error = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FF5F5F"))
+
+var Literal = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#AF87FF"))
+
+var Escape = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FF87D7"))
+
+var SearchHit = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FAFAFA")).
+	Background(lipgloss.Color("#875F00"))
+
+var ByteNull = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#6C6C6C"))
+
+var BytePrintable = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#AFD7FF"))
+
+var ByteControl = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FFD75F"))
+
+var ByteHigh = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#FF87AF"))
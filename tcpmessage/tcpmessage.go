@@ -2,6 +2,7 @@ package tcpmessage
 
 import (
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -24,6 +25,21 @@ const (
 	STATUS_DROPPED
 )
 
+// MarshalText implements encoding.TextMarshaler, naming the status in plain
+// text instead of using symbols.
+func (status statusRaw) MarshalText() ([]byte, error) {
+	switch status {
+	case STATUS_PENDING:
+		return []byte("pending"), nil
+	case STATUS_TRANSMITED:
+		return []byte("transmitted"), nil
+	case STATUS_DROPPED:
+		return []byte("dropped"), nil
+	default:
+		return nil, fmt.Errorf("Invalid status %d", status)
+	}
+}
+
 func (status *Status) String() string {
 	switch status.Status() {
 	case STATUS_PENDING:
@@ -55,22 +71,52 @@ func (direction TransmittionDirection) String() string {
 	}
 }
 
+// MarshalText implements encoding.TextMarshaler, naming the direction in plain
+// text instead of using symbols.
+func (direction TransmittionDirection) MarshalText() ([]byte, error) {
+	switch direction {
+	case TRANSMITTION_DIRECTION_TO_SERVER:
+		return []byte("to_server"), nil
+	case TRANSMITTION_DIRECTION_TO_CLIENT:
+		return []byte("to_client"), nil
+	default:
+		return nil, fmt.Errorf("Invalid direction %d", direction)
+	}
+}
+
+// Protocol is the protocol of a message, as determined when the message was
+// framed out of the stream of its connection.
+type Protocol interface {
+	Name() string
+}
+
 type TCPMessage struct {
-	content   []byte
-	edited    bool
-	status    Status
-	time      time.Time
-	direction TransmittionDirection
+	// versions holds every content the message had, in the order in which
+	// they were set, and is only appended to. The first is the original
+	// content. parents holds the index of the version from which every
+	// version was edited (-1 for the original), which undo returns to.
+	versions       [][]byte
+	parents        []int
+	currentVersion int
+	// contentMutex guards the versions, and the status changes, so the
+	// content can't change once the message is no longer pending.
+	contentMutex sync.RWMutex
+	status       Status
+	time         time.Time
+	direction    TransmittionDirection
+	connectionID int
+	protocol     Protocol
 
 	transmitChan chan bool
 }
 
-func New(transmittionDirection TransmittionDirection, content []byte) *TCPMessage {
+func New(connectionID int, transmittionDirection TransmittionDirection, content []byte) *TCPMessage {
 	m := &TCPMessage{
-		content:   content,
-		edited:    false,
-		time:      time.Now(),
-		direction: transmittionDirection,
+		versions:     [][]byte{content},
+		parents:      []int{-1},
+		time:         time.Now(),
+		direction:    transmittionDirection,
+		connectionID: connectionID,
 
 		transmitChan: make(chan bool),
 	}
@@ -90,6 +136,9 @@ func (message *TCPMessage) WaitForTransmittion() (transmit bool) {
 // waiting for transmittion. Use only when nobody is waiting for transmittion.
 // no calls to Transmit/MarkAsTransmited will be possible after this call.
 func (message *TCPMessage) MarkAsTransmited() error {
+	message.contentMutex.Lock()
+	defer message.contentMutex.Unlock()
+
 	switch message.status.Status() {
 	case STATUS_PENDING:
 		message.status.SetStatus(STATUS_TRANSMITED)
@@ -118,7 +167,13 @@ func (message *TCPMessage) Transmit() error {
 	return nil
 }
 
-func (message *TCPMessage) Drop() error {
+// MarkAsDropped marks the message as dropped without notifying anobody
+// waiting for transmittion. Use only when nobody is waiting for transmittion.
+// no calls to Drop/MarkAsDropped will be possible after this call.
+func (message *TCPMessage) MarkAsDropped() error {
+	message.contentMutex.Lock()
+	defer message.contentMutex.Unlock()
+
 	switch message.status.Status() {
 	case STATUS_PENDING:
 		message.status.SetStatus(STATUS_DROPPED)
@@ -131,31 +186,164 @@ func (message *TCPMessage) Drop() error {
 		panic("Invalid status")
 	}
 
+	return nil
+}
+
+// Drop marks the packet as dropped and notifies everybody waiting with
+// WaitForTransmittion about the drop.
+func (message *TCPMessage) Drop() error {
+	err := message.MarkAsDropped()
+	if err != nil {
+		return err
+	}
+
 	message.transmitChan <- false
 
 	return nil
 }
 
+// SetContent sets the content of a pending message as a new version, edited
+// from the current one. The undone versions are kept in the history.
 func (message *TCPMessage) SetContent(newContent []byte) error {
+	message.contentMutex.Lock()
+	defer message.contentMutex.Unlock()
+
+	if message.status.Status() != STATUS_PENDING {
+		return fmt.Errorf("The message can no longer be edited.")
+	}
+
+	message.versions = append(message.versions, newContent)
+	message.parents = append(message.parents, message.currentVersion)
+	message.currentVersion = len(message.versions) - 1
+
+	return nil
+}
+
+// lastEdit returns the index of the newest version edited from the given
+// version, len(message.versions) if there's none.
+func (message *TCPMessage) lastEdit(version int) int {
+	for i := len(message.parents) - 1; i > version; i-- {
+		if message.parents[i] == version {
+			return i
+		}
+	}
+
+	return len(message.versions)
+}
+
+// setCurrentVersion makes the version whose index is returned by
+// getVersion, given the current version index, the content of a pending
+// message.
+func (message *TCPMessage) setCurrentVersion(getVersion func(current int) int) error {
+	message.contentMutex.Lock()
+	defer message.contentMutex.Unlock()
+
 	if message.status.Status() != STATUS_PENDING {
 		return fmt.Errorf("The message can no longer be edited.")
 	}
 
-	message.content = newContent
-	message.edited = true
+	version := getVersion(message.currentVersion)
+
+	if version < 0 {
+		return fmt.Errorf("Nothing to undo.")
+	}
+	if version >= len(message.versions) {
+		return fmt.Errorf("Nothing to redo.")
+	}
+
+	message.currentVersion = version
 
 	return nil
 }
 
+// Undo reverts the content of a pending message to the version from which
+// it was edited.
+func (message *TCPMessage) Undo() error {
+	return message.setCurrentVersion(func(current int) int { return message.parents[current] })
+}
+
+// Redo restores the newest version of a pending message which was edited
+// from the current one.
+func (message *TCPMessage) Redo() error {
+	return message.setCurrentVersion(message.lastEdit)
+}
+
+// Revert reverts the content of a pending message to the original content.
+// It can be redone like Undo.
+func (message *TCPMessage) Revert() error {
+	return message.setCurrentVersion(func(int) int { return 0 })
+}
+
 func (message *TCPMessage) Content() []byte {
-	return message.content
+	message.contentMutex.RLock()
+	defer message.contentMutex.RUnlock()
+
+	return message.versions[message.currentVersion]
+}
+
+// OriginalContent returns the content of the message as it was received.
+func (message *TCPMessage) OriginalContent() []byte {
+	message.contentMutex.RLock()
+	defer message.contentMutex.RUnlock()
+
+	return message.versions[0]
+}
+
+// History returns every content the message had, in the order in which they
+// were set, from the original one, including undone versions.
+func (message *TCPMessage) History() [][]byte {
+	message.contentMutex.RLock()
+	defer message.contentMutex.RUnlock()
+
+	return message.versions[:len(message.versions):len(message.versions)]
+}
+
+// CurrentVersion returns the index in History of the current content.
+func (message *TCPMessage) CurrentVersion() int {
+	message.contentMutex.RLock()
+	defer message.contentMutex.RUnlock()
+
+	return message.currentVersion
+}
+
+// Edited reports whether the current content isn't the original one.
+func (message *TCPMessage) Edited() bool {
+	return message.CurrentVersion() != 0
+}
+
+func (message *TCPMessage) Status() statusRaw {
+	return message.status.Status()
+}
+
+func (message *TCPMessage) Time() time.Time {
+	return message.time
+}
+
+func (message *TCPMessage) Direction() TransmittionDirection {
+	return message.direction
+}
+
+// SetProtocol sets the protocol of the message. It must be called before the
+// message is shared with other goroutines.
+func (message *TCPMessage) SetProtocol(protocol Protocol) {
+	message.protocol = protocol
+}
+
+// Protocol returns the protocol of the message, nil if it's unknown.
+func (message *TCPMessage) Protocol() Protocol {
+	return message.protocol
+}
+
+// ConnectionID returns the ID of the connection on which the message was sent.
+func (message *TCPMessage) ConnectionID() int {
+	return message.connectionID
 }
 
 func (message *TCPMessage) String() string {
 	messageState := message.status.String()
-	if message.edited {
+	if message.Edited() {
 		messageState += " " + symbols.CurrentMap[symbols.ScPen]
 	}
 
-	return fmt.Sprintf("[%v] %v %v (%v bytes)", message.time.Format(time.TimeOnly), messageState, message.direction, len(message.content))
+	return fmt.Sprintf("[%v] %v %v (%v bytes)", message.time.Format(time.TimeOnly), messageState, message.direction, len(message.Content()))
 }
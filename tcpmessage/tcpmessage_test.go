@@ -0,0 +1,46 @@
+package tcpmessage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionHistory(t *testing.T) {
+	message := New(0, TRANSMITTION_DIRECTION_TO_SERVER, []byte("a"))
+	assert.Error(t, message.Undo())
+	assert.Error(t, message.Redo())
+
+	assert.NoError(t, message.SetContent([]byte("b")))
+	assert.NoError(t, message.SetContent([]byte("c")))
+	assert.True(t, message.Edited())
+
+	assert.NoError(t, message.Undo())
+	assert.Equal(t, []byte("b"), message.Content())
+	assert.NoError(t, message.Redo())
+	assert.Equal(t, []byte("c"), message.Content())
+
+	assert.NoError(t, message.Revert())
+	assert.Equal(t, []byte("a"), message.Content())
+	assert.False(t, message.Edited())
+	assert.NoError(t, message.Redo())
+	assert.Equal(t, []byte("b"), message.Content())
+
+	// Editing keeps the undone versions in the history
+	history := message.History()
+	assert.NoError(t, message.SetContent([]byte("d")))
+	assert.Error(t, message.Redo())
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}, message.History())
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, history)
+	assert.Equal(t, []byte("a"), message.OriginalContent())
+
+	assert.NoError(t, message.Undo())
+	assert.Equal(t, []byte("b"), message.Content())
+	assert.NoError(t, message.Redo())
+	assert.Equal(t, []byte("d"), message.Content())
+
+	assert.NoError(t, message.MarkAsTransmited())
+	assert.Error(t, message.Undo())
+	assert.Error(t, message.Revert())
+	assert.Equal(t, []byte("d"), message.Content())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/styles"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+	"github.com/Denloob/protocol-proxy/transform"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// TEMPLATE_TABLE_HEADER_LINES is the number of lines rendered before the
+// rows of the template field table.
+const TEMPLATE_TABLE_HEADER_LINES = 2
+
+// LoadTemplate loads the YAML template at path.
+func LoadTemplate(path string) (*dissector.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := dissector.ParseTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return template, nil
+}
+
+type templateRow struct {
+	field *dissector.Field
+	depth int
+}
+
+// flattenTemplateFields returns the fields and their children as table rows,
+// in order.
+func flattenTemplateFields(rows []templateRow, fields []*dissector.Field, depth int) []templateRow {
+	for _, field := range fields {
+		rows = append(rows, templateRow{field, depth})
+		rows = flattenTemplateFields(rows, field.Children, depth+1)
+	}
+
+	return rows
+}
+
+// decodeTemplate decodes the payload of a message by template, returning the
+// rows of the decoded fields.
+func decodeTemplate(payload []byte, template *dissector.Template) ([]templateRow, error) {
+	fields, err := template.Decode(payload)
+	return flattenTemplateFields(nil, fields, 0), err
+}
+
+// RenderTemplate renders the payload of a message decoded by template, as a
+// table of its fields followed by a hexdump of the payload, laid out by
+// hexdump, with the bytes of the selected field highlighted.
+func RenderTemplate(payload []byte, template *dissector.Template, selected int, hexdump HexdumpConfig, width int) string {
+	if template == nil {
+		return "No template, run with -template to decode messages by a template"
+	}
+
+	rows, err := decodeTemplate(payload, template)
+	selected = Clamp(selected, 0, max(len(rows)-1, 0))
+
+	name := template.Name
+	if name == "" {
+		name = "template"
+	}
+
+	lines := []string{
+		styles.FieldName.Render("template: ") + styles.FieldValue.Render(name),
+		fmt.Sprintf("%-8s %-6s %s", "Offset", "Length", "Field"),
+	}
+
+	for i, row := range rows {
+		text := fmt.Sprintf("%08x %-6d %s%s", row.field.Offset, row.field.Length, strings.Repeat(DISSECTION_INDENT, row.depth), row.field.Name)
+		if row.field.Value != "" {
+			text += ": " + row.field.Value
+		}
+
+		// Rows are truncated before they're styled, as the styles break the
+		// truncation
+		text = WrapLine(text, max(width, 1))[0]
+		if i == selected {
+			text = styles.Cursor.Render(text)
+		}
+		lines = append(lines, text)
+	}
+
+	if err != nil {
+		lines = append(lines, styles.Error.Render(fmt.Sprintf("Error: %v", err)))
+	}
+
+	var highlights []HexdumpHighlight
+	if len(rows) > 0 {
+		begin := rows[selected].field.Offset
+		highlights = append(highlights, HexdumpHighlight{begin, begin + rows[selected].field.Length, styles.Cursor})
+	}
+
+	lines = append(lines, "", RenderHexdump(payload, hexdump, width, highlights))
+	return strings.Join(lines, "\n")
+}
+
+type editTemplateFieldInEditorMsg struct {
+	message    *tcpmessage.TCPMessage
+	chain      transform.Chain
+	index      int
+	text       []byte
+	editedText []byte
+	err        error
+}
+
+// editTemplateFieldInEditor opens text, the value of the field at index of
+// the payload of the message decoded by chain, in $EDITOR.
+func editTemplateFieldInEditor(message *tcpmessage.TCPMessage, chain transform.Chain, index int, text []byte) (tea.Cmd, error) {
+	return editBufferInEditor(text, ".txt", func(editedText []byte, err error) tea.Msg {
+		return editTemplateFieldInEditorMsg{message, chain, index, text, editedText, err}
+	})
+}
+
+// editTemplateFieldText returns the edited value of a field, without the
+// error of AddEditError.
+func editTemplateFieldText(editedText []byte) string {
+	var lines []string
+	for _, line := range strings.Split(string(editedText), "\n") {
+		if !strings.HasPrefix(line, EDIT_ERROR_PREFIX) {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.TrimSuffix(strings.Join(lines, "\n"), "\n")
+}
+
+// StartEditTemplateField opens the field at index of the payload of the
+// message, decoded by chain, in $EDITOR.
+func StartEditTemplateField(message *tcpmessage.TCPMessage, template *dissector.Template, chain transform.Chain, index int) tea.Cmd {
+	if message.Status() != tcpmessage.STATUS_PENDING {
+		log.Println("The message can no longer be edited.")
+		return nil
+	}
+
+	payload, err := DecodedPayload(message, chain)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	text, err := template.FieldText(payload, index)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	cmd, err := editTemplateFieldInEditor(message, chain, index, []byte(text+"\n"))
+	if err != nil {
+		log.Printf("Edit in editor error: %s\n", err)
+	}
+
+	return cmd
+}
+
+// FinishEditTemplateField sets the edited field of the message, re-encoding
+// it by template. If the field can't be set, the editor is reopened with the
+// error.
+func FinishEditTemplateField(msg editTemplateFieldInEditorMsg, template *dissector.Template) tea.Cmd {
+	if msg.err != nil {
+		log.Printf("error during field editing: %v\n", msg.err)
+		return nil
+	}
+
+	if IsEditCanceled(msg.text, msg.editedText) {
+		log.Println("Field edit canceled")
+		return nil
+	}
+
+	payload, err := DecodedPayload(msg.message, msg.chain)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	payload, err = template.SetField(payload, msg.index, editTemplateFieldText(msg.editedText))
+	if err != nil {
+		log.Printf("Failed to set the field: %v\n", err)
+
+		cmd, err := editTemplateFieldInEditor(msg.message, msg.chain, msg.index, AddEditError(msg.editedText, err))
+		if err != nil {
+			log.Printf("Edit in editor error: %s\n", err)
+		}
+
+		return cmd
+	}
+
+	if err := SetEditedContent(msg.message, msg.chain, payload); err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
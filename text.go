@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Denloob/protocol-proxy/styles"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// TEXT_HEADERS_END separates the headers of text protocols such as HTTP from
+// their body, which is formatted on its own.
+const TEXT_HEADERS_END = "\r\n\r\n"
+
+// RenderText renders content as text, pretty printing and coloring it if it's
+// JSON, XML or form encoded (and if it has text headers, such as HTTP, its
+// body is). Control characters and invalid UTF-8 are rendered as escapes.
+func RenderText(content []byte) string {
+	format, text, ok := formatText(content)
+	if !ok {
+		if headersEnd := bytes.Index(content, []byte(TEXT_HEADERS_END)); headersEnd != -1 && utf8.Valid(content[:headersEnd]) {
+			body := content[headersEnd+len(TEXT_HEADERS_END):]
+			if bodyFormat, bodyText, ok := formatText(body); ok {
+				format = "text headers, " + bodyFormat + " body"
+				text = renderPlainText(content[:headersEnd+len(TEXT_HEADERS_END)]) + "\n" + bodyText
+			}
+		}
+	}
+
+	return styles.FieldName.Render("text: ") + styles.FieldValue.Render(format) + "\n" + text
+}
+
+// formatText detects the format of content and renders it in the format,
+// returning the name of the format and whether it's JSON, XML or form
+// encoded. Other content is rendered as plain text.
+func formatText(content []byte) (format string, text string, ok bool) {
+	if text, count, ok := renderJSON(content); ok {
+		format = "json"
+		if count != 1 {
+			format += fmt.Sprintf(" (%d values)", count)
+		}
+		return format, text, true
+	}
+
+	if text, ok := renderXML(content); ok {
+		return "xml", text, true
+	}
+
+	if text, ok := renderForm(content); ok {
+		return "form", text, true
+	}
+
+	return "plain", renderPlainText(content), false
+}
+
+// renderPlainText renders text line by line, with control characters other
+// than newlines and invalid UTF-8 escaped.
+func renderPlainText(text []byte) string {
+	lines := strings.Split(string(text), "\n")
+	for i, line := range lines {
+		lines[i] = escapeText(line, styles.Unstyled)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// escapeText renders text in style, with its control characters and invalid
+// UTF-8 as Go escapes.
+func escapeText(text string, style lipgloss.Style) string {
+	var res, run strings.Builder
+	flush := func() {
+		if run.Len() != 0 {
+			res.WriteString(style.Render(run.String()))
+			run.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		char, size := utf8.DecodeRuneInString(text[i:])
+
+		var escape string
+		if char == utf8.RuneError && size == 1 {
+			escape = fmt.Sprintf(`\x%02x`, text[i])
+		} else if !unicode.IsPrint(char) {
+			quoted := strconv.QuoteRune(char)
+			escape = quoted[1 : len(quoted)-1]
+		}
+
+		if escape == "" {
+			run.WriteString(text[i : i+size])
+		} else {
+			flush()
+			res.WriteString(styles.Escape.Render(escape))
+		}
+
+		i += size
+	}
+	flush()
+
+	return res.String()
+}
+
+// renderJSON pretty prints and colors content if it's a sequence of JSON
+// objects or arrays (e.g. newline delimited JSON), returning the number of
+// values.
+func renderJSON(content []byte) (string, int, bool) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return "", 0, false
+	}
+
+	var values []string
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", 0, false
+		}
+
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, value, "", DISSECTION_INDENT); err != nil {
+			return "", 0, false
+		}
+		values = append(values, colorJSON(indented.String()))
+	}
+
+	return strings.Join(values, "\n"), len(values), true
+}
+
+// colorJSON colors the tokens of valid JSON text, escaping the control
+// characters and invalid UTF-8 of its strings.
+func colorJSON(text string) string {
+	var res strings.Builder
+
+	for i := 0; i < len(text); {
+		end := i + 1
+		style := styles.Unstyled
+
+		switch char := text[i]; {
+		case char == '"':
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+
+			style = styles.FieldValue
+			if rest := strings.TrimLeft(text[end:], " \t\r\n"); strings.HasPrefix(rest, ":") {
+				style = styles.FieldName
+			}
+		case char == '-' || ('0' <= char && char <= '9'):
+			for end < len(text) && strings.IndexByte("+-.eE0123456789", text[end]) != -1 {
+				end++
+			}
+			style = styles.Literal
+		case 'a' <= char && char <= 'z': // true, false and null
+			for end < len(text) && 'a' <= text[end] && text[end] <= 'z' {
+				end++
+			}
+			style = styles.Literal
+		default:
+			res.WriteByte(char)
+			i = end
+			continue
+		}
+
+		// Strings may have control characters and invalid UTF-8
+		res.WriteString(escapeText(text[i:end], style))
+		i = end
+	}
+
+	return res.String()
+}
+
+// renderXML pretty prints and colors content if it's an XML document.
+func renderXML(content []byte) (string, bool) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 || trimmed[0] != '<' {
+		return "", false
+	}
+
+	// Token checks that the elements match, RawToken keeps the namespace
+	// prefixes as they're written
+	hasElement := false
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", false
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			hasElement = true
+		}
+	}
+	if !hasElement {
+		return "", false
+	}
+
+	var tokens []xml.Token
+	decoder = xml.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			break
+		}
+		tokens = append(tokens, xml.CopyToken(token))
+	}
+
+	var lines []string
+	depth := 0
+	indent := func() string { return strings.Repeat(DISSECTION_INDENT, depth) }
+
+	for i := 0; i < len(tokens); i++ {
+		switch token := tokens[i].(type) {
+		case xml.StartElement:
+			line := indent() + renderXMLStartElement(token)
+
+			// Empty elements, and elements of only text, are on a single line
+			next, _ := xmlTokenAt(tokens, i+1).(xml.EndElement)
+			if text, ok := xmlTokenAt(tokens, i+1).(xml.CharData); ok {
+				if end, ok := xmlTokenAt(tokens, i+2).(xml.EndElement); ok {
+					lines = append(lines, line+escapeText(string(text), styles.FieldValue)+renderXMLEndElement(end))
+					i += 2
+					continue
+				}
+			} else if next.Name == token.Name {
+				lines = append(lines, strings.TrimSuffix(line, styles.Summary.Render(">"))+styles.Summary.Render("/>"))
+				i++
+				continue
+			}
+
+			lines = append(lines, line)
+			depth++
+		case xml.EndElement:
+			depth = max(depth-1, 0)
+			lines = append(lines, indent()+renderXMLEndElement(token))
+		case xml.CharData:
+			if text := strings.TrimSpace(string(token)); text != "" {
+				lines = append(lines, indent()+escapeText(text, styles.FieldValue))
+			}
+		case xml.Comment:
+			lines = append(lines, indent()+escapeText("<!--"+string(token)+"-->", styles.Summary))
+		case xml.ProcInst:
+			lines = append(lines, indent()+escapeText("<?"+token.Target+" "+string(token.Inst)+"?>", styles.Summary))
+		case xml.Directive:
+			lines = append(lines, indent()+escapeText("<!"+string(token)+">", styles.Summary))
+		}
+	}
+
+	return strings.Join(lines, "\n"), true
+}
+
+func xmlTokenAt(tokens []xml.Token, index int) xml.Token {
+	if index >= len(tokens) {
+		return nil
+	}
+
+	return tokens[index]
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+func renderXMLStartElement(element xml.StartElement) string {
+	res := styles.Summary.Render("<") + styles.FieldName.Render(xmlName(element.Name))
+	for _, attr := range element.Attr {
+		res += " " + styles.FieldName.Render(xmlName(attr.Name)) + styles.Summary.Render("=") +
+			escapeText(strconv.Quote(attr.Value), styles.FieldValue)
+	}
+
+	return res + styles.Summary.Render(">")
+}
+
+func renderXMLEndElement(element xml.EndElement) string {
+	return styles.Summary.Render("</") + styles.FieldName.Render(xmlName(element.Name)) + styles.Summary.Render(">")
+}
+
+// renderForm renders content as a field per line if it's form encoded
+// (application/x-www-form-urlencoded), such as "name=bob&age=42".
+func renderForm(content []byte) (string, bool) {
+	trimmed := string(bytes.TrimSpace(content))
+	if !strings.Contains(trimmed, "=") || strings.ContainsFunc(trimmed, func(r rune) bool { return r <= ' ' || r > '~' }) {
+		return "", false
+	}
+
+	var lines []string
+	for _, pair := range strings.Split(trimmed, "&") {
+		name, value, _ := strings.Cut(pair, "=")
+
+		var err error
+		name, err = url.QueryUnescape(name)
+		if err == nil {
+			value, err = url.QueryUnescape(value)
+		}
+		if err != nil || name == "" || strings.ContainsAny(name, "<>{}\"") {
+			return "", false
+		}
+
+		lines = append(lines, escapeText(name, styles.FieldName)+styles.Summary.Render(" = ")+escapeText(value, styles.FieldValue))
+	}
+
+	return strings.Join(lines, "\n"), true
+}
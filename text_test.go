@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderText(t *testing.T) {
+	assert.Equal(t, "text: json\n{\n  \"a\": [\n    1,\n    true\n  ]\n}", RenderText([]byte(`{"a": [1, true]}`)))
+	assert.Equal(t, "text: json (2 values)\n{}\n[]", RenderText([]byte("{}\n[]\n")))
+	assert.Equal(t, "text: xml\n<?xml version=\"1.0\"?>\n<a x=\"1\">\n  <b>hi</b>\n  <c/>\n</a>", RenderText([]byte(`<?xml version="1.0"?><a x="1"><b>hi</b><c/></a>`)))
+	assert.Equal(t, "text: form\nname = bob smith\nage = 42", RenderText([]byte("name=bob+smith&age=42")))
+	assert.Equal(t, "text: plain\nGET / \\r\n\\x00\\xffé\\t", RenderText([]byte("GET / \r\n\x00\xffé\t")))
+	assert.Equal(t, "text: json\n{\n  \"a\": \"x\\x7fy\\xffz\\u009b31m\"\n}", RenderText([]byte("{\"a\":\"x\x7fy\xffz\u009b31m\"}")))
+	assert.Equal(t, "text: text headers, json body\nPOST / HTTP/1.1\\r\nA: b\\r\n\\r\n\n[\n  null\n]", RenderText([]byte("POST / HTTP/1.1\r\nA: b\r\n\r\n[null]")))
+}
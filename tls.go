@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Denloob/protocol-proxy/dissector"
+	"github.com/Denloob/protocol-proxy/styles"
+	"github.com/Denloob/protocol-proxy/tcpmessage"
+)
+
+// RenderTLSHandshake renders what the TLS handshake messages of the message
+// negotiate.
+func RenderTLSHandshake(message *tcpmessage.TCPMessage, width int) string {
+	decoder, ok := message.Protocol().(dissector.TLSDecoder)
+	if !ok {
+		return "The message isn't a TLS record"
+	}
+
+	fields, err := decoder.Handshake(message.Content())
+	if err != nil {
+		return fmt.Sprintf("Failed to inspect the TLS handshake: %v", err)
+	}
+
+	var lines []string
+	for _, field := range fields {
+		lines = append(lines, styles.FieldName.Render(field.Name))
+		for _, child := range field.Children {
+			lines = appendFieldLines(lines, child, DISSECTION_INDENT, width)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,292 @@
+// Package transform decodes payloads wrapped in encodings and compressions,
+// such as base64 of gzip, and re-encodes them.
+package transform
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// XOR_STEP_PREFIX starts a XOR step, followed by its key in hex.
+const XOR_STEP_PREFIX = "xor:"
+
+// MAX_DECOMPRESSED_LENGTH is the maximal length of data decompressed by a
+// step, so a small payload can't decompress to more than the memory.
+const MAX_DECOMPRESSED_LENGTH = 1 << 26
+
+// Step is a single encoding of a chain, which decodes data and encodes the
+// decoded data back.
+type Step struct {
+	Name   string
+	decode func(data []byte) ([]byte, error)
+	encode func(data []byte) ([]byte, error)
+}
+
+func (s Step) Decode(data []byte) ([]byte, error) {
+	return s.decode(data)
+}
+
+func (s Step) Encode(data []byte) ([]byte, error) {
+	return s.encode(data)
+}
+
+// readAll reads the whole reader of decompressed data, closing it if it's an
+// io.Closer. Data longer than MAX_DECOMPRESSED_LENGTH isn't read.
+func readAll(reader io.Reader, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, MAX_DECOMPRESSED_LENGTH+1))
+	if err == nil && len(data) > MAX_DECOMPRESSED_LENGTH {
+		return nil, fmt.Errorf("the decompressed data is longer than %d bytes", MAX_DECOMPRESSED_LENGTH)
+	}
+
+	return data, err
+}
+
+// writeAll writes data with the writer created by newWriter, returning the
+// written data.
+func writeAll(data []byte, newWriter func(io.Writer) (io.WriteCloser, error)) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := newWriter(&buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func base64Step(name string, encoding *base64.Encoding) Step {
+	return Step{
+		Name: name,
+		decode: func(data []byte) ([]byte, error) {
+			return encoding.DecodeString(string(bytes.TrimSpace(data)))
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return []byte(encoding.EncodeToString(data)), nil
+		},
+	}
+}
+
+// steps are the steps without arguments, by name.
+var steps = map[string]Step{
+	"base64":    base64Step("base64", base64.StdEncoding),
+	"base64url": base64Step("base64url", base64.URLEncoding),
+	"hex": {
+		Name: "hex",
+		decode: func(data []byte) ([]byte, error) {
+			return hex.DecodeString(string(bytes.TrimSpace(data)))
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return []byte(hex.EncodeToString(data)), nil
+		},
+	},
+	"url": {
+		Name: "url",
+		decode: func(data []byte) ([]byte, error) {
+			decoded, err := url.QueryUnescape(string(data))
+			return []byte(decoded), err
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return []byte(url.QueryEscape(string(data))), nil
+		},
+	},
+	"gzip": {
+		Name: "gzip",
+		decode: func(data []byte) ([]byte, error) {
+			return readAll(gzip.NewReader(bytes.NewReader(data)))
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return writeAll(data, func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			})
+		},
+	},
+	"zlib": {
+		Name: "zlib",
+		decode: func(data []byte) ([]byte, error) {
+			return readAll(zlib.NewReader(bytes.NewReader(data)))
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return writeAll(data, func(w io.Writer) (io.WriteCloser, error) {
+				return zlib.NewWriter(w), nil
+			})
+		},
+	},
+	"deflate": {
+		Name: "deflate",
+		decode: func(data []byte) ([]byte, error) {
+			return readAll(flate.NewReader(bytes.NewReader(data)), nil)
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return writeAll(data, func(w io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(w, flate.DefaultCompression)
+			})
+		},
+	},
+	"zstd": {
+		Name: "zstd",
+		decode: func(data []byte) ([]byte, error) {
+			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(MAX_DECOMPRESSED_LENGTH))
+			if err != nil {
+				return nil, err
+			}
+			defer decoder.Close()
+
+			return decoder.DecodeAll(data, nil)
+		},
+		encode: func(data []byte) ([]byte, error) {
+			encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			defer encoder.Close()
+
+			return encoder.EncodeAll(data, nil), nil
+		},
+	},
+	"brotli": {
+		Name: "brotli",
+		decode: func(data []byte) ([]byte, error) {
+			return readAll(brotli.NewReader(bytes.NewReader(data)), nil)
+		},
+		encode: func(data []byte) ([]byte, error) {
+			return writeAll(data, func(w io.Writer) (io.WriteCloser, error) {
+				return brotli.NewWriter(w), nil
+			})
+		},
+	},
+}
+
+// xorStep returns the step XORing data with the key, repeated over the data.
+func xorStep(key []byte) Step {
+	xor := func(data []byte) ([]byte, error) {
+		result := make([]byte, len(data))
+		for i, b := range data {
+			result[i] = b ^ key[i%len(key)]
+		}
+		return result, nil
+	}
+
+	return Step{
+		Name:   XOR_STEP_PREFIX + hex.EncodeToString(key),
+		decode: xor,
+		encode: xor,
+	}
+}
+
+// Names returns the names of the steps, for usage messages.
+func Names() []string {
+	var names []string
+	for name := range steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return append(names, XOR_STEP_PREFIX+"KEY")
+}
+
+// ParseStep parses the name of a step, one of Names, where the KEY of a XOR
+// step is its key in hex (a single byte or a repeating key).
+func ParseStep(name string) (Step, error) {
+	if keyText, ok := strings.CutPrefix(name, XOR_STEP_PREFIX); ok {
+		key, err := hex.DecodeString(strings.TrimPrefix(keyText, "0x"))
+		if err != nil {
+			return Step{}, fmt.Errorf("invalid XOR key %q: %w", keyText, err)
+		}
+		if len(key) == 0 {
+			return Step{}, errors.New("empty XOR key")
+		}
+
+		return xorStep(key), nil
+	}
+
+	step, ok := steps[name]
+	if !ok {
+		return Step{}, fmt.Errorf("unknown decode step %q (expected one of %s)", name, strings.Join(Names(), ", "))
+	}
+
+	return step, nil
+}
+
+// Chain is a sequence of steps, decoded in order (the first step decodes the
+// data, the second step decodes its result and so on) and encoded in reverse.
+type Chain []Step
+
+// ParseChain parses a chain of step names separated by commas or whitespace,
+// such as "base64,gzip".
+func ParseChain(spec string) (Chain, error) {
+	var chain Chain
+	for _, name := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		step, err := ParseStep(strings.ToLower(name))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, step)
+	}
+
+	return chain, nil
+}
+
+// String returns the chain in the format of ParseChain.
+func (c Chain) String() string {
+	names := make([]string, len(c))
+	for i, step := range c {
+		names[i] = step.Name
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Decode decodes data by the steps of the chain, in order.
+func (c Chain) Decode(data []byte) ([]byte, error) {
+	for _, step := range c {
+		var err error
+		data, err = step.Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", step.Name, err)
+		}
+	}
+
+	return data, nil
+}
+
+// Encode encodes data decoded by Decode, by the steps of the chain in
+// reverse.
+func (c Chain) Encode(data []byte) ([]byte, error) {
+	for i := len(c) - 1; i >= 0; i-- {
+		var err error
+		data, err = c[i].Encode(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c[i].Name, err)
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,67 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainRoundTrip(t *testing.T) {
+	data := []byte(`{"user": "bob", "text": "hello hello hello"}`)
+
+	for _, name := range Names() {
+		if name == XOR_STEP_PREFIX+"KEY" {
+			continue
+		}
+
+		chain, err := ParseChain(name + ",xor:5a")
+		require.NoError(t, err, name)
+
+		encoded, err := chain.Encode(data)
+		require.NoError(t, err, name)
+		decoded, err := chain.Decode(encoded)
+		require.NoError(t, err, name)
+		assert.Equal(t, data, decoded, name)
+	}
+}
+
+func TestChainDecode(t *testing.T) {
+	chain, err := ParseChain("url, base64 xor:0x0102")
+	require.NoError(t, err)
+	assert.Equal(t, "url,base64,xor:0102", chain.String())
+
+	// "hi?>" XORed with 01 02, base64 encoded and URL encoded
+	decoded, err := chain.Decode([]byte("aWs%2BPA%3D%3D"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi?>", string(decoded))
+
+	_, err = chain.Decode([]byte("!"))
+	assert.ErrorContains(t, err, "base64: ")
+
+	_, err = ParseChain("base64,rot13")
+	assert.Error(t, err)
+	_, err = ParseChain("xor:")
+	assert.Error(t, err)
+
+	empty, err := ParseChain("")
+	require.NoError(t, err)
+	decoded, err = empty.Decode([]byte("abc"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(decoded))
+}
+
+func TestDecompressionBomb(t *testing.T) {
+	bomb := make([]byte, MAX_DECOMPRESSED_LENGTH+1)
+
+	for _, name := range []string{"gzip", "zlib", "deflate", "brotli", "zstd"} {
+		step, err := ParseStep(name)
+		require.NoError(t, err)
+
+		compressed, err := step.Encode(bomb)
+		require.NoError(t, err, name)
+
+		_, err = step.Decode(compressed)
+		assert.Error(t, err, name)
+	}
+}
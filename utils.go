@@ -3,6 +3,9 @@ package main
 import (
 	"cmp"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
 )
 
 // Must returns the value of T if there's no error and panics otherwise
@@ -19,34 +22,6 @@ func IsCharacter(char byte) bool {
 	return ' ' <= char && char <= '~'
 }
 
-func ExtractStrings(buffer []byte, minStringLength int) []string {
-	var foundStrings []string
-
-	var stringBegin int
-	insideString := false
-
-	for i, char := range buffer {
-		if !IsCharacter(char) {
-			if insideString && i-stringBegin >= minStringLength {
-				foundStrings = append(foundStrings, string(buffer[stringBegin:i]))
-				insideString = false
-			}
-			continue
-		}
-
-		if !insideString {
-			insideString = true
-			stringBegin = i
-		}
-	}
-
-	if insideString && len(buffer)-stringBegin >= minStringLength {
-		foundStrings = append(foundStrings, string(buffer[stringBegin:]))
-	}
-
-	return foundStrings
-}
-
 func CountLines(s string) int {
 	if len(s) == 0 {
 		return 0
@@ -80,17 +55,81 @@ func PutOnTheBottomOfView(view, target string, height int) string {
 	return view + pad + target
 }
 
-// WrapLine splits the given line into many lines so that no line is longer than
-// the given maxLength.
+// ansiSequenceLength returns the length of the ANSI escape sequence at the
+// beginning of s, or 0 if it doesn't begin with one.
+func ansiSequenceLength(s string) int {
+	if len(s) < 2 || s[0] != '\x1b' || s[1] != '[' {
+		return 0
+	}
+
+	for i := 2; i < len(s); i++ {
+		if 0x40 <= s[i] && s[i] <= 0x7e { // The final byte
+			return i + 1
+		}
+	}
+
+	return len(s)
+}
+
+// WrapLine splits the given line into many lines so that no line is wider than
+// the given maxLength, in terminal cells. Runes are never split, and ANSI
+// escape sequences (styles) take no width. If maxLength <= 0, the line isn't
+// wrapped.
 func WrapLine(line string, maxLength int) []string {
+	if maxLength <= 0 {
+		return []string{line}
+	}
+
 	var res []string
 
-	for len(line) > maxLength {
-		res = append(res, line[:maxLength])
-		line = line[maxLength:]
+	lineBegin, lineWidth := 0, 0
+	for i := 0; i < len(line); {
+		if sequenceLength := ansiSequenceLength(line[i:]); sequenceLength != 0 {
+			i += sequenceLength
+			continue
+		}
+
+		char, size := utf8.DecodeRuneInString(line[i:])
+		charWidth := runewidth.RuneWidth(char)
+		if lineWidth+charWidth > maxLength && lineWidth != 0 {
+			res = append(res, line[lineBegin:i])
+			lineBegin, lineWidth = i, 0
+		}
+
+		lineWidth += charWidth
+		i += size
+	}
+
+	res = append(res, line[lineBegin:])
+
+	return res
+}
+
+// WrapWords splits the given line like WrapLine, but between space separated
+// words when possible, indenting the continued lines like the line.
+func WrapWords(line string, maxLength int) []string {
+	if maxLength <= 0 {
+		return []string{line}
+	}
+
+	indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+
+	var lines []string
+	current := indent
+	for _, word := range strings.SplitAfter(line[len(indent):], " ") {
+		if current != indent && runewidth.StringWidth(current+strings.TrimRight(word, " ")) > maxLength {
+			lines = append(lines, strings.TrimRight(current, " "))
+			current = indent
+		}
+		current += word
 	}
+	lines = append(lines, current)
 
-	res = append(res, line)
+	// Words longer than a line are split
+	var res []string
+	for _, line := range lines {
+		res = append(res, WrapLine(line, maxLength)...)
+	}
 
 	return res
 }
@@ -18,3 +18,18 @@ func TestPutOnTheBottomOfView(t *testing.T) {
 	assert.Equal(t, "\n\n3\n4", PutOnTheBottomOfView("", "3\n4", 4))
 	assert.Equal(t, "\n3\n4\n", PutOnTheBottomOfView("", "3\n4\n", 4))
 }
+
+func TestWrapLine(t *testing.T) {
+	assert.Equal(t, []string{"abc", "de"}, WrapLine("abcde", 3))
+	assert.Equal(t, []string{"abcde"}, WrapLine("abcde", 0))
+	assert.Equal(t, []string{""}, WrapLine("", 3))
+	assert.Equal(t, []string{"αβ", "γ"}, WrapLine("αβγ", 2))
+	assert.Equal(t, []string{"日", "本"}, WrapLine("日本", 3))
+	assert.Equal(t, []string{"\x1b[1mab", "c\x1b[0m"}, WrapLine("\x1b[1mabc\x1b[0m", 2))
+}
+
+func TestWrapWords(t *testing.T) {
+	assert.Equal(t, []string{"  0x01, 0x02,", "  0x03"}, WrapWords("  0x01, 0x02, 0x03", 13))
+	assert.Equal(t, []string{"abcd", "ef", "g"}, WrapWords("abcdef g", 4))
+	assert.Equal(t, []string{"a b c"}, WrapWords("a b c", 0))
+}